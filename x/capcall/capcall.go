@@ -0,0 +1,254 @@
+// Package capcall implements the capability-call protocol of
+// x/rfc/draft-promisegrid.md, section 4, as specified in capcall.md:
+// call requests, responses and errors correlated by a call ID, a
+// Server that maps fCIDs to Go functions or executable descriptors,
+// and a Client.  Arguments and results are encoded with the codec
+// package, x/cbor-codec.
+//
+// Generate writes client stubs, so that calling a remote capability
+// looks like calling a local function.
+package capcall
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/ipfs/go-cid"
+	codec "github.com/stevegt/grid-poc/x/cbor-codec"
+	"github.com/stevegt/grid-poc/x/pcid"
+)
+
+//go:embed capcall.md
+var spec []byte
+
+// Spec returns the protocol specification.
+func Spec() []byte {
+	return spec
+}
+
+// PCID is the protocol's pCID, the CID of its specification.
+var PCID = pcid.MustFromSpec(spec)
+
+// GridTag is the tag number of the grid envelope.
+var GridTag = codec.StringToNum("grid")
+
+// Message types.
+const (
+	MTypeRequest  = 0
+	MTypeResponse = 1
+	MTypeError    = 2
+)
+
+// Error codes.
+const (
+	CodeUnknownFunction = 1
+	CodeBadRequest      = 2
+	CodeBadArgs         = 3
+	CodeFailed          = 4
+)
+
+// CallError is an error reply, or a client-side failure to decode a
+// reply's results.
+type CallError struct {
+	CallID  uint64
+	Code    int
+	Message string
+}
+
+func (e *CallError) Error() string {
+	return fmt.Sprintf("call %d: error %d: %s", e.CallID, e.Code, e.Message)
+}
+
+// Is makes errors.Is match CallErrors by code.
+func (e *CallError) Is(target error) bool {
+	t, ok := target.(*CallError)
+	return ok && t.Code == e.Code
+}
+
+// Errors to match with errors.Is.
+var (
+	ErrUnknownFunction = &CallError{Code: CodeUnknownFunction}
+	ErrBadRequest      = &CallError{Code: CodeBadRequest}
+	ErrBadArgs         = &CallError{Code: CodeBadArgs}
+	ErrFailed          = &CallError{Code: CodeFailed}
+)
+
+// Link is a CID encoded as a DAG-CBOR link, CBOR tag 42.
+type Link struct {
+	cid.Cid
+}
+
+// MarshalCBOR encodes l as tag 42 around its bytes with a zero prefix.
+func (l Link) MarshalCBOR() ([]byte, error) {
+	return cbor.Marshal(cbor.Tag{Number: 42, Content: append([]byte{0}, l.Bytes()...)})
+}
+
+// UnmarshalCBOR decodes a tag 42 link.
+func (l *Link) UnmarshalCBOR(data []byte) error {
+	var tag cbor.RawTag
+	err := tag.UnmarshalCBOR(data)
+	if err != nil {
+		return err
+	}
+	if tag.Number != 42 {
+		return fmt.Errorf("tag %d is not a link", tag.Number)
+	}
+	var b []byte
+	err = cbor.Unmarshal(tag.Content, &b)
+	if err != nil {
+		return err
+	}
+	if len(b) == 0 || b[0] != 0 {
+		return errors.New("link without zero prefix")
+	}
+	l.Cid, err = cid.Cast(b[1:])
+	return err
+}
+
+// message is a decoded payload.  Only the fields of its type are set.
+type message struct {
+	mtype     uint64
+	fCID      cid.Cid
+	values    []cbor.RawMessage // args or results
+	callID    uint64
+	hasCallID bool // whether the callID was decoded; a request without one wants no reply
+	code      int
+	message   string
+}
+
+// encode encodes a payload in the grid envelope.
+func encode(c *codec.Codec, payload []interface{}) ([]byte, error) {
+	return c.Encode(GridTag, []interface{}{PCID.Bytes(), payload})
+}
+
+// encodeValues encodes each of vs.
+func encodeValues(c *codec.Codec, vs []interface{}) ([]cbor.RawMessage, error) {
+	out := make([]cbor.RawMessage, len(vs))
+	for i, v := range vs {
+		b, err := c.Em.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("value %d: %w", i, err)
+		}
+		out[i] = b
+	}
+	return out, nil
+}
+
+func encodeRequest(c *codec.Codec, fCID cid.Cid, args []cbor.RawMessage, callID uint64) ([]byte, error) {
+	return encode(c, []interface{}{MTypeRequest, Link{fCID}, args, callID})
+}
+
+func encodeResponse(c *codec.Codec, callID uint64, results []cbor.RawMessage) ([]byte, error) {
+	return encode(c, []interface{}{MTypeResponse, callID, results})
+}
+
+func encodeError(c *codec.Codec, callID uint64, code int, msg string) ([]byte, error) {
+	return encode(c, []interface{}{MTypeError, callID, code, msg})
+}
+
+// decode decodes a capcall message.  If the payload is a list whose
+// message type can be decoded, the message is returned as far as it
+// was decoded, even with an error.
+func decode(c *codec.Codec, data []byte) (*message, error) {
+	tag, content, err := c.DecodeTag(data)
+	if err != nil {
+		return nil, err
+	}
+	if tag != GridTag {
+		return nil, fmt.Errorf("tag %#x is not the grid envelope", tag)
+	}
+	var env []cbor.RawMessage
+	err = c.DecodeRaw(content, &env)
+	if err != nil {
+		return nil, fmt.Errorf("envelope: %w", err)
+	}
+	if len(env) < 2 {
+		return nil, fmt.Errorf("envelope has %d elements", len(env))
+	}
+	var pcid []byte
+	err = c.DecodeRaw(env[0], &pcid)
+	if err != nil {
+		return nil, fmt.Errorf("pCID: %w", err)
+	}
+	if !PCID.Equals(cidOf(pcid)) {
+		return nil, errors.New("not a capcall message")
+	}
+	var fields []cbor.RawMessage
+	err = c.DecodeRaw(env[1], &fields)
+	if err != nil {
+		return nil, fmt.Errorf("payload: %w", err)
+	}
+
+	m := &message{}
+	want := func(n int, ptrs ...interface{}) error {
+		if len(fields) != n {
+			return fmt.Errorf("message type %d has %d elements, want %d", m.mtype, len(fields), n)
+		}
+		for i, p := range ptrs {
+			err := c.DecodeRaw(fields[i+1], p)
+			if err != nil {
+				return fmt.Errorf("message type %d element %d: %w", m.mtype, i+1, err)
+			}
+		}
+		return nil
+	}
+	if len(fields) == 0 {
+		return nil, errors.New("empty payload")
+	}
+	err = c.DecodeRaw(fields[0], &m.mtype)
+	if err != nil {
+		return nil, fmt.Errorf("message type: %w", err)
+	}
+	switch m.mtype {
+	case MTypeRequest:
+		var l Link
+		if len(fields) == 3 {
+			err = want(3, &l, &m.values)
+		} else {
+			// The callID goes first, so that a request it can be
+			// decoded from can be answered even if the rest cannot.
+			m.hasCallID = len(fields) == 4 && c.DecodeRaw(fields[3], &m.callID) == nil
+			err = want(4, &l, &m.values, &m.callID)
+		}
+		m.fCID = l.Cid
+	case MTypeResponse, MTypeError:
+		// As with requests, the callID is decoded first, so that the
+		// call a reply belongs to is known even if the rest is bad.
+		m.hasCallID = len(fields) > 1 && c.DecodeRaw(fields[1], &m.callID) == nil
+		if m.mtype == MTypeResponse {
+			err = want(3, &m.callID, &m.values)
+		} else {
+			err = want(4, &m.callID, &m.code, &m.message)
+		}
+	default:
+		err = fmt.Errorf("unknown message type %d", m.mtype)
+	}
+	if err != nil {
+		return m, err
+	}
+	return m, nil
+}
+
+// cidOf returns the CID in b, or cid.Undef.
+func cidOf(b []byte) cid.Cid {
+	c, err := cid.Cast(b)
+	if err != nil {
+		return cid.Undef
+	}
+	return c
+}
+
+// NewCodec returns a codec.Codec with the core deterministic encoding
+// options, as Servers and Clients use by default.
+func NewCodec() *codec.Codec {
+	c, err := codec.NewCodec(codec.CodecConfig{
+		EncOptions: cbor.CoreDetEncOptions(),
+		DecOptions: cbor.DecOptions{},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
@@ -0,0 +1,322 @@
+package capcall
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/stevegt/grid-poc/x/ipld-schema/gridschema"
+	"github.com/stevegt/grid-poc/x/pcid"
+)
+
+func fcid(t *testing.T, name string) cid.Cid {
+	t.Helper()
+	c, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.SHA2_256, MhLength: -1}.Sum([]byte(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func testServer(t *testing.T) (*Server, map[string]cid.Cid) {
+	t.Helper()
+	s := NewServer(nil)
+	fns := map[string]interface{}{
+		"add": func(a, b int) int { return a + b },
+		"divmod": func(a, b int) (int, int, error) {
+			if b == 0 {
+				return 0, 0, errors.New("division by zero")
+			}
+			return a / b, a % b, nil
+		},
+		"deadline": func(ctx context.Context) bool {
+			_, ok := ctx.Deadline()
+			return ok
+		},
+		"greet": func(p struct{ Name string }) string { return "hello, " + p.Name },
+		"index": func(xs []int, i int) int { return xs[i] },
+	}
+	cids := make(map[string]cid.Cid)
+	for name, fn := range fns {
+		cids[name] = fcid(t, name)
+		err := s.RegisterFunc(cids[name], fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s, cids
+}
+
+func TestPCID(t *testing.T) {
+	err := pcid.Verify(PCID, Spec())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCall(t *testing.T) {
+	s, f := testServer(t)
+	c := NewLocalClient(s)
+	ctx := context.Background()
+
+	var sum int
+	err := c.Call(ctx, f["add"], []interface{}{2, 3}, &sum)
+	if err != nil || sum != 5 {
+		t.Errorf("add: %d, %v", sum, err)
+	}
+	var q, r int
+	err = c.Call(ctx, f["divmod"], []interface{}{7, 2}, &q, &r)
+	if err != nil || q != 3 || r != 1 {
+		t.Errorf("divmod: %d, %d, %v", q, r, err)
+	}
+	var greeting string
+	err = c.Call(ctx, f["greet"], []interface{}{map[string]string{"Name": "Alice"}}, &greeting)
+	if err != nil || greeting != "hello, Alice" {
+		t.Errorf("greet: %q, %v", greeting, err)
+	}
+	dctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	var hasDeadline bool
+	err = c.Call(dctx, f["deadline"], nil, &hasDeadline)
+	if err != nil || !hasDeadline {
+		t.Errorf("deadline: %v, %v", hasDeadline, err)
+	}
+}
+
+func TestCallErrors(t *testing.T) {
+	s, f := testServer(t)
+	c := NewLocalClient(s)
+	ctx := context.Background()
+	var n int
+	tests := []struct {
+		name    string
+		fCID    cid.Cid
+		args    []interface{}
+		results []interface{}
+		want    error
+	}{
+		{"unknown", fcid(t, "nothing"), nil, nil, ErrUnknownFunction},
+		{"arg count", f["add"], []interface{}{1}, []interface{}{&n}, ErrBadArgs},
+		{"arg type", f["add"], []interface{}{1, "two"}, []interface{}{&n}, ErrBadArgs},
+		{"failed", f["divmod"], []interface{}{1, 0}, []interface{}{&n, &n}, ErrFailed},
+		{"result count", f["add"], []interface{}{1, 2}, nil, ErrBadArgs},
+		{"panic", f["index"], []interface{}{[]int{1}, 5}, []interface{}{&n}, ErrFailed},
+	}
+	for _, tt := range tests {
+		err := c.Call(ctx, tt.fCID, tt.args, tt.results...)
+		var ce *CallError
+		if !errors.Is(err, tt.want) || !errors.As(err, &ce) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	err := c.Call(ctx, f["divmod"], []interface{}{1, 0}, &n, &n)
+	if !strings.Contains(err.Error(), "division by zero") {
+		t.Errorf("got %v", err)
+	}
+}
+
+// TestMessages checks the messages against the capcall schema types of
+// x/ipld-schema.
+func TestMessages(t *testing.T) {
+	s, f := testServer(t)
+	codec := NewCodec()
+	raw, _ := encodeValues(codec, []interface{}{2, 3})
+	req, err := encodeRequest(codec, f["add"], raw, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := s.Handle(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req0, _ := encodeRequest(codec, fcid(t, "nothing"), raw, 10)
+	errMsg, err := s.Handle(context.Background(), req0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, msg := range []struct {
+		typ  string
+		data []byte
+	}{{"CallRequest", req}, {"CallResponse", resp}, {"CallError", errMsg}} {
+		env, err := gridschema.UnmarshalEnvelope(msg.data)
+		if err != nil {
+			t.Fatalf("%s: %v", msg.typ, err)
+		}
+		if !PCID.Equals(cidOf(env.PCID)) {
+			t.Errorf("%s: pCID %x", msg.typ, env.PCID)
+		}
+		var payload cbor.RawMessage
+		var parts []cbor.RawMessage
+		_, content, _ := codec.DecodeTag(msg.data)
+		codec.DecodeRaw(content, &parts)
+		payload = parts[1]
+		err = gridschema.Validate(msg.typ, payload)
+		if err != nil {
+			t.Errorf("%s: %v", msg.typ, err)
+		}
+	}
+
+	m, err := decode(codec, resp)
+	if err != nil || m.mtype != MTypeResponse || m.callID != 9 {
+		t.Errorf("response %+v, %v", m, err)
+	}
+	m, err = decode(codec, errMsg)
+	if err != nil || m.mtype != MTypeError || m.callID != 10 || m.code != CodeUnknownFunction {
+		t.Errorf("error %+v, %v", m, err)
+	}
+
+	// A request without callID gets no reply.
+	noReply, _ := encode(codec, []interface{}{MTypeRequest, Link{f["add"]}, raw})
+	reply, err := s.Handle(context.Background(), noReply)
+	if err != nil || reply != nil {
+		t.Errorf("got reply %x, %v", reply, err)
+	}
+
+	must := func(b []byte, err error) []byte {
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	for name, bad := range map[string][]byte{
+		"not tagged":   {0x80},
+		"wrong pCID":   must(codec.Encode(GridTag, []interface{}{[]byte("x"), []interface{}{0}})),
+		"unknown type": must(encode(codec, []interface{}{9})),
+		"short":        must(encode(codec, []interface{}{MTypeError, 1})),
+	} {
+		if _, err := s.Handle(context.Background(), bad); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+
+	// A request whose callID can be decoded gets a bad request reply
+	// however bad the rest of it is.
+	for name, bad := range map[string][]byte{
+		"bad fCID": must(encode(codec, []interface{}{MTypeRequest, "add", raw, 11})),
+		"bad args": must(encode(codec, []interface{}{MTypeRequest, Link{f["add"]}, 2, 11})),
+	} {
+		reply, err := s.Handle(context.Background(), bad)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		m, err := decode(codec, reply)
+		if err != nil || m.mtype != MTypeError || m.callID != 11 || m.code != CodeBadRequest {
+			t.Errorf("%s: reply %+v, %v", name, m, err)
+		}
+	}
+	if _, err := s.Handle(context.Background(), must(encode(codec, []interface{}{MTypeRequest, Link{f["add"]}, raw, "x"}))); err == nil {
+		t.Error("bad callID: no error")
+	}
+}
+
+func TestCancel(t *testing.T) {
+	// A Sender that never replies.
+	c := NewClient(nil, func(ctx context.Context, msg []byte) error { return nil })
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := c.Call(ctx, fcid(t, "add"), nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v", err)
+	}
+	if len(c.pending) != 0 {
+		t.Errorf("%d calls still pending", len(c.pending))
+	}
+	// A late reply is dropped.
+	reply, _ := encodeResponse(c.codec, 1, nil)
+	if err := c.Deliver(reply); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestBadReply checks that a reply whose callID can be decoded but
+// whose rest cannot fails its call at once.
+func TestBadReply(t *testing.T) {
+	var c *Client
+	c = NewClient(nil, func(ctx context.Context, msg []byte) error {
+		m, err := decode(c.codec, msg)
+		if err != nil {
+			return err
+		}
+		reply, err := encode(c.codec, []interface{}{MTypeResponse, m.callID, "not a list"})
+		if err != nil {
+			return err
+		}
+		go c.Deliver(reply)
+		return nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := c.Call(ctx, fcid(t, "add"), nil)
+	if !errors.Is(err, ErrBadRequest) {
+		t.Errorf("got %v", err)
+	}
+	// A bad reply to no call is an error.
+	bad, _ := encode(c.codec, []interface{}{MTypeResponse, "x", nil})
+	if err := c.Deliver(bad); err == nil {
+		t.Error("bad callID: no error")
+	}
+}
+
+func descriptor(t *testing.T, name, script string) []byte {
+	t.Helper()
+	exe := []byte(script)
+	sum := sha256.Sum256(exe)
+	data, err := NewCodec().Em.Marshal(&Descriptor{
+		Name:        name,
+		ContentType: "application/octet-stream",
+		Size:        int64(len(exe)),
+		Executable:  exe,
+		Checksum:    sum[:],
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gridschema.Validate("ExecutableDescriptor", data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDescriptor(t *testing.T) {
+	s := NewServer(nil)
+	c := NewLocalClient(s)
+	ctx := context.Background()
+
+	echo, err := s.RegisterDescriptor(descriptor(t, "echo.sh", "#!/bin/sh\ncat\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var a string
+	var b int
+	err = c.Call(ctx, echo, []interface{}{"x", 42}, &a, &b)
+	if err != nil || a != "x" || b != 42 {
+		t.Errorf("echo: %q, %d, %v", a, b, err)
+	}
+
+	fail, err := s.RegisterDescriptor(descriptor(t, "fail.sh", "#!/bin/sh\necho boom >&2\nexit 3\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Call(ctx, fail, nil)
+	if !errors.Is(err, ErrFailed) || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("fail: %v", err)
+	}
+
+	bad, _ := NewCodec().Em.Marshal(&Descriptor{
+		Name:       "bad.sh",
+		Size:       10,
+		Executable: []byte("#!/bin/sh\n"),
+		Checksum:   make([]byte, sha256.Size),
+	})
+	if _, err := s.RegisterDescriptor(bad); err == nil {
+		t.Error("registered a descriptor with a bad checksum")
+	}
+}
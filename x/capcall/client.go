@@ -0,0 +1,126 @@
+package capcall
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/ipfs/go-cid"
+	codec "github.com/stevegt/grid-poc/x/cbor-codec"
+)
+
+// Sender sends an encoded message to a Server.
+type Sender func(ctx context.Context, msg []byte) error
+
+// Client makes calls through a Sender and matches the replies, handed
+// to Deliver, to the calls waiting for them by call ID.  It is safe for
+// concurrent use.
+type Client struct {
+	codec   *codec.Codec
+	send    Sender
+	mu      sync.Mutex
+	next    uint64
+	pending map[uint64]chan *message
+}
+
+// NewClient returns a Client sending with send and encoding with c, or
+// NewCodec() if c is nil.
+func NewClient(c *codec.Codec, send Sender) *Client {
+	if c == nil {
+		c = NewCodec()
+	}
+	return &Client{codec: c, send: send, pending: make(map[uint64]chan *message)}
+}
+
+// NewLocalClient returns a Client calling s in the same process.
+func NewLocalClient(s *Server) *Client {
+	var c *Client
+	c = NewClient(s.codec, func(ctx context.Context, msg []byte) error {
+		reply, err := s.Handle(ctx, msg)
+		if err != nil || reply == nil {
+			return err
+		}
+		go c.Deliver(reply)
+		return nil
+	})
+	return c
+}
+
+// Deliver hands the Client a reply.  A reply to no waiting call, such
+// as one whose call was cancelled, is dropped.  A reply whose callID
+// can be decoded but whose rest cannot fails its call with a bad
+// request error.
+func (c *Client) Deliver(msg []byte) error {
+	m, err := decode(c.codec, msg)
+	if err != nil {
+		if m == nil || m.mtype == MTypeRequest || !m.hasCallID {
+			return err
+		}
+		m = &message{mtype: MTypeError, callID: m.callID, code: CodeBadRequest, message: err.Error()}
+	}
+	if m.mtype == MTypeRequest {
+		return fmt.Errorf("message type %d is not a reply", m.mtype)
+	}
+	c.mu.Lock()
+	ch := c.pending[m.callID]
+	delete(c.pending, m.callID)
+	c.mu.Unlock()
+	if ch != nil {
+		ch <- m
+	}
+	return nil
+}
+
+// Call calls the capability fCID with args and decodes its results
+// into results, which are pointers, one per result.  An error reply is
+// returned as a *CallError.
+func (c *Client) Call(ctx context.Context, fCID cid.Cid, args []interface{}, results ...interface{}) error {
+	raw, err := encodeValues(c.codec, args)
+	if err != nil {
+		return err
+	}
+	ch := make(chan *message, 1)
+	c.mu.Lock()
+	c.next++
+	id := c.next
+	c.pending[id] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	msg, err := encodeRequest(c.codec, fCID, raw, id)
+	if err != nil {
+		return err
+	}
+	err = c.send(ctx, msg)
+	if err != nil {
+		return err
+	}
+	var m *message
+	select {
+	case m = <-ch:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if m.mtype == MTypeError {
+		return &CallError{CallID: id, Code: m.code, Message: m.message}
+	}
+	return c.decodeResults(id, m.values, results)
+}
+
+func (c *Client) decodeResults(id uint64, values []cbor.RawMessage, results []interface{}) error {
+	if len(values) != len(results) {
+		return &CallError{CallID: id, Code: CodeBadArgs, Message: fmt.Sprintf("got %d results, want %d", len(values), len(results))}
+	}
+	for i, v := range values {
+		err := c.codec.DecodeRaw(v, results[i])
+		if err != nil {
+			return &CallError{CallID: id, Code: CodeBadArgs, Message: fmt.Sprintf("result %d: %v", i, err)}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+// Command capcallgen writes Go client stubs for remote capabilities,
+// so that calling one looks like calling a local function.
+//
+// Each stub is given as name=fCID=signature, for example
+//
+//	capcallgen -pkg mathcaps -o stubs.go 'Add=bafk...=func(a, b int) (int, error)'
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stevegt/grid-poc/x/capcall"
+)
+
+func main() {
+	pkg := flag.String("pkg", "main", "package of the generated file")
+	out := flag.String("o", "-", "output file, - for stdout")
+	imports := flag.String("imports", "", "comma-separated extra import paths")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-pkg name] [-o file] [-imports paths] name=fCID=signature...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var stubs []capcall.Stub
+	for _, arg := range flag.Args() {
+		parts := strings.SplitN(arg, "=", 3)
+		if len(parts) != 3 {
+			fmt.Fprintf(os.Stderr, "Error: %q is not name=fCID=signature\n", arg)
+			os.Exit(1)
+		}
+		fCID, err := cid.Decode(parts[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", parts[0], err)
+			os.Exit(1)
+		}
+		stubs = append(stubs, capcall.Stub{Name: parts[0], FCID: fCID, Signature: parts[2]})
+	}
+	var paths []string
+	if *imports != "" {
+		paths = strings.Split(*imports, ",")
+	}
+	src, err := capcall.Generate(*pkg, paths, stubs...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *out == "-" {
+		os.Stdout.Write(src)
+		return
+	}
+	err = os.WriteFile(*out, src, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,268 @@
+package capcall
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	codec "github.com/stevegt/grid-poc/x/cbor-codec"
+)
+
+// Handler runs a capability.  args are the encoded arguments of a
+// call; the results are values to encode.  A Handler returns a
+// *CallError to choose the error code of its reply; any other error is
+// CodeFailed.
+type Handler interface {
+	Call(ctx context.Context, c *codec.Codec, args []cbor.RawMessage) ([]interface{}, error)
+}
+
+// Server answers call requests with the Handlers registered under their
+// fCIDs.  It is safe for concurrent use.
+type Server struct {
+	codec    *codec.Codec
+	mu       sync.RWMutex
+	handlers map[cid.Cid]Handler
+}
+
+// NewServer returns a Server encoding with c, or NewCodec() if c is
+// nil.
+func NewServer(c *codec.Codec) *Server {
+	if c == nil {
+		c = NewCodec()
+	}
+	return &Server{codec: c, handlers: make(map[cid.Cid]Handler)}
+}
+
+// Register registers h under fCID, replacing any Handler there.
+func (s *Server) Register(fCID cid.Cid, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[fCID] = h
+}
+
+// RegisterFunc registers a Go function under fCID.  See Func.
+func (s *Server) RegisterFunc(fCID cid.Cid, fn interface{}) error {
+	h, err := Func(fn)
+	if err != nil {
+		return err
+	}
+	s.Register(fCID, h)
+	return nil
+}
+
+// RegisterDescriptor registers an executable descriptor, returning the
+// fCID it is registered under: the CIDv1 (DAG-CBOR, sha2-256) of the
+// descriptor's encoding.  See Descriptor.
+func (s *Server) RegisterDescriptor(data []byte) (cid.Cid, error) {
+	d := &Descriptor{}
+	err := s.codec.DecodeRaw(data, d)
+	if err != nil {
+		return cid.Undef, err
+	}
+	err = d.Verify()
+	if err != nil {
+		return cid.Undef, err
+	}
+	fCID, err := cid.Prefix{
+		Version:  1,
+		Codec:    cid.DagCBOR,
+		MhType:   multihash.SHA2_256,
+		MhLength: -1,
+	}.Sum(data)
+	if err != nil {
+		return cid.Undef, err
+	}
+	s.Register(fCID, d)
+	return fCID, nil
+}
+
+// Handle answers an encoded request, returning the encoded reply, or
+// nil if the request has no callID.  A request that cannot be decoded
+// beyond its callID gets a bad request reply.  An error means msg is
+// not a request the Server can reply to.
+func (s *Server) Handle(ctx context.Context, msg []byte) ([]byte, error) {
+	m, err := decode(s.codec, msg)
+	if err != nil {
+		if m != nil && m.mtype == MTypeRequest && m.hasCallID {
+			return encodeError(s.codec, m.callID, CodeBadRequest, err.Error())
+		}
+		return nil, err
+	}
+	if m.mtype != MTypeRequest {
+		return nil, fmt.Errorf("message type %d is not a request", m.mtype)
+	}
+	results, err := s.call(ctx, m)
+	if !m.hasCallID {
+		return nil, nil
+	}
+	if err == nil {
+		var raw []cbor.RawMessage
+		raw, err = encodeValues(s.codec, results)
+		if err == nil {
+			return encodeResponse(s.codec, m.callID, raw)
+		}
+	}
+	ce := &CallError{}
+	if !errors.As(err, &ce) {
+		ce = &CallError{Code: CodeFailed, Message: err.Error()}
+	}
+	return encodeError(s.codec, m.callID, ce.Code, ce.Message)
+}
+
+func (s *Server) call(ctx context.Context, m *message) ([]interface{}, error) {
+	s.mu.RLock()
+	h := s.handlers[m.fCID]
+	s.mu.RUnlock()
+	if h == nil {
+		return nil, &CallError{Code: CodeUnknownFunction, Message: m.fCID.String()}
+	}
+	return h.Call(ctx, s.codec, m.values)
+}
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// funcHandler calls a Go function.
+type funcHandler struct {
+	fn reflect.Value
+	// ctx is whether the first parameter is a context.Context.
+	ctx bool
+	// err is whether the last result is an error.
+	err bool
+}
+
+// Func returns a Handler calling fn, a Go function whose parameters and
+// results the codec can encode.  A first context.Context parameter is
+// given the call's context, and a last error result, if not nil, fails
+// the call.  Variadic functions are not supported.
+func Func(fn interface{}) (Handler, error) {
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+	if t.Kind() != reflect.Func {
+		return nil, fmt.Errorf("%T is not a function", fn)
+	}
+	if t.IsVariadic() {
+		return nil, errors.New("variadic functions are not supported")
+	}
+	return &funcHandler{
+		fn:  v,
+		ctx: t.NumIn() > 0 && t.In(0) == contextType,
+		err: t.NumOut() > 0 && t.Out(t.NumOut()-1) == errorType,
+	}, nil
+}
+
+func (f *funcHandler) Call(ctx context.Context, c *codec.Codec, args []cbor.RawMessage) (results []interface{}, err error) {
+	// A panic fails the call rather than the server.
+	defer func() {
+		if r := recover(); r != nil {
+			results, err = nil, &CallError{Code: CodeFailed, Message: fmt.Sprintf("panic: %v", r)}
+		}
+	}()
+	t := f.fn.Type()
+	var in []reflect.Value
+	if f.ctx {
+		in = append(in, reflect.ValueOf(ctx))
+	}
+	if len(in)+len(args) != t.NumIn() {
+		return nil, &CallError{Code: CodeBadArgs, Message: fmt.Sprintf("got %d args, want %d", len(args), t.NumIn()-len(in))}
+	}
+	for i, arg := range args {
+		p := reflect.New(t.In(len(in)))
+		err := c.DecodeRaw(arg, p.Interface())
+		if err != nil {
+			return nil, &CallError{Code: CodeBadArgs, Message: fmt.Sprintf("arg %d: %v", i, err)}
+		}
+		in = append(in, p.Elem())
+	}
+	out := f.fn.Call(in)
+	if f.err {
+		if err, _ := out[len(out)-1].Interface().(error); err != nil {
+			return nil, err
+		}
+		out = out[:len(out)-1]
+	}
+	results = make([]interface{}, len(out))
+	for i, v := range out {
+		results[i] = v.Interface()
+	}
+	return results, nil
+}
+
+// Descriptor is an executable descriptor, as written by x/descriptors
+// embed; see the ExecutableDescriptor schema in x/ipld-schema.  As a
+// Handler it runs the executable with the call's args, a CBOR array, on
+// its standard input, and reads its results, a CBOR array, from its
+// standard output.  A failing exit fails the call with its standard
+// error as the message.
+type Descriptor struct {
+	Name        string `cbor:"name"`
+	ContentType string `cbor:"contentType"`
+	Size        int64  `cbor:"size"`
+	Executable  []byte `cbor:"executable"`
+	Checksum    []byte `cbor:"checksum"`
+}
+
+// Verify checks the descriptor's size and sha256 checksum.
+func (d *Descriptor) Verify() error {
+	if int64(len(d.Executable)) != d.Size {
+		return fmt.Errorf("%s: size %d, want %d", d.Name, len(d.Executable), d.Size)
+	}
+	sum := sha256.Sum256(d.Executable)
+	if !bytes.Equal(sum[:], d.Checksum) {
+		return fmt.Errorf("%s: checksum mismatch", d.Name)
+	}
+	return nil
+}
+
+func (d *Descriptor) Call(ctx context.Context, c *codec.Codec, args []cbor.RawMessage) ([]interface{}, error) {
+	f, err := os.CreateTemp("", "capcall-*-"+d.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(d.Executable)
+	if err == nil {
+		err = f.Chmod(0700)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	in, err := c.Em.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, f.Name())
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %s", d.Name, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	var results []cbor.RawMessage
+	err = c.DecodeRaw(stdout.Bytes(), &results)
+	if err != nil {
+		return nil, fmt.Errorf("%s: results: %w", d.Name, err)
+	}
+	out := make([]interface{}, len(results))
+	for i, r := range results {
+		out[i] = r
+	}
+	return out, nil
+}
@@ -0,0 +1,172 @@
+package capcall
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"sort"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+)
+
+// Stub describes a client stub for Generate.
+type Stub struct {
+	// Name is the stub function's name.
+	Name string
+	// FCID is the capability the stub calls.
+	FCID cid.Cid
+	// Signature is the Go signature of the remote function, such as
+	// "func(a, b int) (int, error)".  A first context.Context parameter
+	// and a last error result are dropped: every stub takes a context
+	// and returns an error.
+	Signature string
+}
+
+// Generate returns the Go source of package pkg holding a stub for each
+// of stubs.  A stub takes a context and a *Client before the remote
+// function's parameters, and returns its results and an error:
+//
+//	func Add(ctx context.Context, c *capcall.Client, a, b int) (int, error)
+//
+// imports are extra import paths the signatures need.
+func Generate(pkg string, imports []string, stubs ...Stub) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by capcallgen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", pkg)
+	paths := append([]string{
+		"context",
+		"github.com/ipfs/go-cid",
+		"github.com/stevegt/grid-poc/x/capcall",
+	}, imports...)
+	sort.Strings(paths)
+	// Standard library imports first, then the rest.
+	sort.SliceStable(paths, func(i, j int) bool {
+		return !isStd(paths[j]) && isStd(paths[i])
+	})
+	fmt.Fprintf(&buf, "import (\n")
+	for i, path := range paths {
+		if i > 0 && isStd(paths[i-1]) && !isStd(path) {
+			fmt.Fprintf(&buf, "\n")
+		}
+		fmt.Fprintf(&buf, "\t%q\n", path)
+	}
+	fmt.Fprintf(&buf, ")\n")
+
+	for _, stub := range stubs {
+		err := writeStub(&buf, stub)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", stub.Name, err)
+		}
+	}
+	return format.Source(buf.Bytes())
+}
+
+func writeStub(buf *bytes.Buffer, stub Stub) error {
+	if !token.IsIdentifier(stub.Name) {
+		return fmt.Errorf("%q is not an identifier", stub.Name)
+	}
+	if !stub.FCID.Defined() {
+		return fmt.Errorf("no fCID")
+	}
+	expr, err := parser.ParseExpr(stub.Signature)
+	if err != nil {
+		return err
+	}
+	ft, ok := expr.(*ast.FuncType)
+	if !ok {
+		return fmt.Errorf("%q is not a function signature", stub.Signature)
+	}
+
+	// The stub's own names, which parameters must not shadow.
+	taken := map[string]bool{"ctx": true, "c": true, "err": true, "capcall": true, "cid": true, "context": true}
+	var params, args []string
+	for i, p := range fields(ft.Params) {
+		typ := exprString(p.typ)
+		if i == 0 && typ == "context.Context" {
+			continue
+		}
+		if strings.HasPrefix(typ, "...") {
+			return fmt.Errorf("variadic functions are not supported")
+		}
+		name := p.name
+		if name == "" || name == "_" || taken[name] {
+			name = fmt.Sprintf("a%d", len(args))
+		}
+		taken[name] = true
+		params = append(params, name+" "+typ)
+		args = append(args, name)
+	}
+	var types, vars, ptrs []string
+	results := fields(ft.Results)
+	for i, r := range results {
+		typ := exprString(r.typ)
+		if i == len(results)-1 && typ == "error" {
+			break
+		}
+		name := fmt.Sprintf("r%d", i)
+		for taken[name] {
+			name += "_"
+		}
+		types = append(types, typ)
+		vars = append(vars, name)
+		ptrs = append(ptrs, "&"+name)
+	}
+	types = append(types, "error")
+
+	fmt.Fprintf(buf, "\n// %sCID is the capability %s calls.\n", stub.Name, stub.Name)
+	fmt.Fprintf(buf, "var %sCID = cid.MustParse(%q)\n", stub.Name, stub.FCID.String())
+	fmt.Fprintf(buf, "\n// %s calls the capability %sCID through c.\n", stub.Name, stub.Name)
+	fmt.Fprintf(buf, "func %s(%s) (%s) {\n", stub.Name,
+		strings.Join(append([]string{"ctx context.Context", "c *capcall.Client"}, params...), ", "),
+		strings.Join(types, ", "))
+	for i, v := range vars {
+		fmt.Fprintf(buf, "\tvar %s %s\n", v, types[i])
+	}
+	call := fmt.Sprintf("c.Call(ctx, %sCID, []interface{}{%s}", stub.Name, strings.Join(args, ", "))
+	for _, p := range ptrs {
+		call += ", " + p
+	}
+	call += ")"
+	fmt.Fprintf(buf, "\terr := %s\n", call)
+	fmt.Fprintf(buf, "\treturn %s\n}\n", strings.Join(append(vars, "err"), ", "))
+	return nil
+}
+
+// isStd reports whether an import path is in the standard library.
+func isStd(path string) bool {
+	return !strings.Contains(strings.Split(path, "/")[0], ".")
+}
+
+// field is one parameter or result.
+type field struct {
+	name string
+	typ  ast.Expr
+}
+
+// fields flattens a field list to one field per name.
+func fields(fl *ast.FieldList) []field {
+	if fl == nil {
+		return nil
+	}
+	var out []field
+	for _, f := range fl.List {
+		if len(f.Names) == 0 {
+			out = append(out, field{typ: f.Type})
+		}
+		for _, n := range f.Names {
+			out = append(out, field{name: n.Name, typ: f.Type})
+		}
+	}
+	return out
+}
+
+func exprString(e ast.Expr) string {
+	var buf bytes.Buffer
+	printer.Fprint(&buf, token.NewFileSet(), e)
+	return buf.String()
+}
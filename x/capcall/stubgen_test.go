@@ -0,0 +1,44 @@
+package capcall
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	add := fcid(t, "add")
+	src, err := Generate("mathcaps", []string{"time"},
+		Stub{Name: "Add", FCID: add, Signature: "func(a, b int) int"},
+		Stub{Name: "DivMod", FCID: add, Signature: "func(ctx context.Context, c, err int) (q, r int, e error)"},
+		Stub{Name: "Sleep", FCID: add, Signature: "func(time.Duration)"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = parser.ParseFile(token.NewFileSet(), "stubs.go", src, 0)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, src)
+	}
+	for _, want := range []string{
+		"package mathcaps",
+		`var AddCID = cid.MustParse("` + add.String() + `")`,
+		"func Add(ctx context.Context, c *capcall.Client, a int, b int) (int, error) {",
+		"err := c.Call(ctx, AddCID, []interface{}{a, b}, &r0)",
+		"func DivMod(ctx context.Context, c *capcall.Client, a0 int, a1 int) (int, int, error) {",
+		"func Sleep(ctx context.Context, c *capcall.Client, a0 time.Duration) error {",
+		"\t\"time\"\n",
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("no %q in\n%s", want, src)
+		}
+	}
+
+	for _, sig := range []string{"func(a ...int)", "int", "func("} {
+		_, err := Generate("p", nil, Stub{Name: "F", FCID: add, Signature: sig})
+		if err == nil {
+			t.Errorf("%q: no error", sig)
+		}
+	}
+}
@@ -0,0 +1,492 @@
+// Package dagedit implements the DAG edit operations protocol of
+// x/rfc/draft-promisegrid.md, section 3.
+//
+// Each insert, delete or reorder payload is an event: it is stored as a
+// DAG-CBOR block in a grid Store, and its prevHashes link it to the
+// events before it, so that a world line's history is a DAG whose heads
+// are the events nothing links to yet.  Every prevHash must already be
+// in the store; only the first event of a world line may have none.
+//
+// A world line's view is the events inserted into it and not deleted,
+// in order.  Agents editing concurrently branch the DAG; views merge
+// the branches so that every node holding the same events has the same
+// view, as described in merge.go.  The draft leaves the subject of a
+// delete or reorder to the claims; here it is named by criteria:
+//
+//	delete   {"event": CID}                deletes an inserted event
+//	reorder  {"event": CID, "after": CID}  moves it after another one,
+//	                                        or first if "after" is ""
+//	insert   {"after": CID}                optional; inserts after
+//	                                        another event, else last
+//
+// The events named must be in the view at the op's prevHashes.
+//
+// Query and subscribe payloads are not events.  Their criteria select
+// events of their target world line:
+//
+//	since, until  timestamps, inclusive, compared as times
+//	filter, op    the operation, such as "insert"
+//	agent         the issuing agent
+package dagedit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	grid "github.com/stevegt/grid-poc"
+	"github.com/stevegt/grid-poc/x/ipld-schema/gridschema"
+	ipldstore "github.com/stevegt/grid-poc/x/ipld-store"
+)
+
+var (
+	// ErrMissingPrev means a prevHash is not in the store.
+	ErrMissingPrev = errors.New("previous event not found")
+	// ErrNoPrev means an event other than the first of its world line
+	// has no prevHashes.
+	ErrNoPrev = errors.New("no previous events")
+	// ErrNoEvent means a delete or reorder names an event that is not in
+	// the world line's view.
+	ErrNoEvent = errors.New("event not in view")
+	// ErrOp means an op is unknown or was given to the wrong method.
+	ErrOp = errors.New("wrong operation")
+)
+
+// Prefix is the CID prefix events are stored under.
+var Prefix = cid.Prefix{
+	Version:  1,
+	Codec:    cid.DagCBOR,
+	MhType:   multihash.SHA2_256,
+	MhLength: -1,
+}
+
+// Event is a stored edit payload and its CID.
+type Event struct {
+	CID     cid.Cid
+	Payload *gridschema.EditPayload
+}
+
+// worldLine is the state of one world line.
+type worldLine struct {
+	// log holds every event applied, in order.
+	log []Event
+	// heads holds the events no later event links to.
+	heads map[cid.Cid]bool
+}
+
+// subscription is a registered subscribe payload.
+type subscription struct {
+	payload *gridschema.EditPayload
+	fn      func(Event)
+}
+
+// Engine applies edit operations to world lines kept in a grid Store.
+// It learns a world line from the events applied to it and, through
+// their prevHashes, from the events already in the store.  It is safe
+// for concurrent use.
+type Engine struct {
+	store   *ipldstore.Storage
+	mu      sync.Mutex
+	lines   map[string]*worldLine
+	byCID   map[cid.Cid]Event
+	subs    map[*subscription]bool
+	deliver sync.Mutex
+}
+
+// NewEngine returns an Engine keeping events in store.
+func NewEngine(store grid.Store) *Engine {
+	return &Engine{
+		store: &ipldstore.Storage{Store: store},
+		lines: make(map[string]*worldLine),
+		byCID: make(map[cid.Cid]Event),
+		subs:  make(map[*subscription]bool),
+	}
+}
+
+// Decode decodes a DAG-CBOR edit payload.
+func Decode(data []byte) (*gridschema.EditPayload, error) {
+	p := &gridschema.EditPayload{}
+	err := gridschema.Unmarshal(data, p)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Apply applies an insert, delete or reorder payload to its world
+// line, stores it and returns the event, after passing it to every
+// subscription it matches.
+func (e *Engine) Apply(ctx context.Context, p *gridschema.EditPayload) (Event, error) {
+	switch p.Op {
+	case gridschema.OpInsert, gridschema.OpDelete, gridschema.OpReorder:
+	default:
+		return Event{}, fmt.Errorf("%w: cannot apply %q", ErrOp, p.Op)
+	}
+	_, err := time.Parse(time.RFC3339, p.Timestamp)
+	if err != nil {
+		return Event{}, fmt.Errorf("timestamp: %w", err)
+	}
+
+	e.mu.Lock()
+	ev, subs, err := e.apply(ctx, p)
+	if err != nil {
+		e.mu.Unlock()
+		return Event{}, err
+	}
+	// Deliver in apply order: take the delivery lock before letting
+	// the next Apply in.
+	e.deliver.Lock()
+	e.mu.Unlock()
+	defer e.deliver.Unlock()
+	for _, sub := range subs {
+		sub.fn(ev)
+	}
+	return ev, nil
+}
+
+// apply does the work of Apply, returning the subscriptions to deliver
+// the event to.  An event already applied is returned again, with no
+// subscriptions.  e.mu must be held.
+func (e *Engine) apply(ctx context.Context, p *gridschema.EditPayload) (Event, []*subscription, error) {
+	data, err := gridschema.Marshal(p)
+	if err != nil {
+		return Event{}, nil, err
+	}
+	ev := Event{Payload: p}
+	ev.CID, err = Prefix.Sum(data)
+	if err != nil {
+		return Event{}, nil, err
+	}
+	if old, ok := e.byCID[ev.CID]; ok {
+		return old, nil, nil
+	}
+
+	for _, prev := range p.PrevHashes {
+		ok, err := e.store.Has(ctx, prev.KeyString())
+		if err != nil {
+			return Event{}, nil, err
+		}
+		if !ok {
+			return Event{}, nil, fmt.Errorf("%s: %w", prev, ErrMissingPrev)
+		}
+	}
+	err = e.adopt(ctx, p.PrevHashes)
+	if err != nil {
+		return Event{}, nil, err
+	}
+	if line := e.lines[p.Target]; len(p.PrevHashes) == 0 && line != nil && len(line.log) > 0 {
+		return Event{}, nil, fmt.Errorf("%s %s: %w", p.Op, p.Target, ErrNoPrev)
+	}
+	var past []cid.Cid
+	for _, prev := range p.PrevHashes {
+		pev, err := e.load(ctx, prev)
+		if err != nil {
+			return Event{}, nil, err
+		}
+		if pev.Payload.Target == p.Target {
+			past = append(past, prev)
+		}
+	}
+	var view []cid.Cid
+	if len(past) > 0 {
+		view, err = materialize(ctx, e.load, past)
+		if err != nil {
+			return Event{}, nil, err
+		}
+	}
+	err = check(view, p)
+	if err != nil {
+		return Event{}, nil, err
+	}
+	err = e.store.Put(ctx, ev.CID.KeyString(), data)
+	if err != nil {
+		return Event{}, nil, err
+	}
+
+	e.record(ev)
+	return ev, e.matching(ev), nil
+}
+
+// record adds an event to the log and heads of its world line.  e.mu
+// must be held.
+func (e *Engine) record(ev Event) {
+	line := e.lines[ev.Payload.Target]
+	if line == nil {
+		line = &worldLine{heads: make(map[cid.Cid]bool)}
+		e.lines[ev.Payload.Target] = line
+	}
+	line.log = append(line.log, ev)
+	for _, prev := range ev.Payload.PrevHashes {
+		delete(line.heads, prev)
+	}
+	line.heads[ev.CID] = true
+	e.byCID[ev.CID] = ev
+}
+
+// adopt records the events at and below prevs that are in the store
+// but that the Engine did not apply, such as those applied by an
+// earlier Engine over the same store or stored by other nodes, so
+// that the logs and heads of their world lines include them.  Each is
+// recorded after its prevHashes, and none is passed to subscriptions.
+// e.mu must be held.
+func (e *Engine) adopt(ctx context.Context, prevs []cid.Cid) error {
+	load := storeLoader(e.store.Store)
+	seen := make(map[cid.Cid]bool)
+	var order []Event
+	var visit func(c cid.Cid) error
+	visit = func(c cid.Cid) error {
+		if _, ok := e.byCID[c]; ok || seen[c] {
+			return nil
+		}
+		seen[c] = true
+		ev, err := load(ctx, c)
+		if err != nil {
+			return err
+		}
+		for _, prev := range ev.Payload.PrevHashes {
+			err = visit(prev)
+			if err != nil {
+				return err
+			}
+		}
+		order = append(order, ev)
+		return nil
+	}
+	for _, prev := range prevs {
+		err := visit(prev)
+		if err != nil {
+			return err
+		}
+	}
+	for _, ev := range order {
+		e.record(ev)
+	}
+	return nil
+}
+
+// Query returns the events of the query payload's target world line
+// that match its criteria, in the order they were applied.
+func (e *Engine) Query(p *gridschema.EditPayload) ([]Event, error) {
+	if p.Op != gridschema.OpQuery {
+		return nil, fmt.Errorf("%w: %q is not a query", ErrOp, p.Op)
+	}
+	err := checkCriteria(p)
+	if err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var out []Event
+	if line := e.lines[p.Target]; line != nil {
+		for _, ev := range line.log {
+			if matches(p, ev) {
+				out = append(out, ev)
+			}
+		}
+	}
+	return out, nil
+}
+
+// Subscribe registers a subscribe payload: fn is called with every
+// event later applied to the payload's target world line that matches
+// its criteria, in the order they are applied, until cancel is called.
+// fn must not call Apply.
+func (e *Engine) Subscribe(p *gridschema.EditPayload, fn func(Event)) (cancel func(), err error) {
+	if p.Op != gridschema.OpSubscribe {
+		return nil, fmt.Errorf("%w: %q is not a subscription", ErrOp, p.Op)
+	}
+	err = checkCriteria(p)
+	if err != nil {
+		return nil, err
+	}
+	sub := &subscription{payload: p, fn: fn}
+	e.mu.Lock()
+	e.subs[sub] = true
+	e.mu.Unlock()
+	return func() {
+		e.mu.Lock()
+		delete(e.subs, sub)
+		e.mu.Unlock()
+	}, nil
+}
+
+// Get returns the event with the given CID, if the Engine applied it.
+func (e *Engine) Get(c cid.Cid) (Event, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ev, ok := e.byCID[c]
+	return ev, ok
+}
+
+// View returns the events inserted into a world line and not deleted,
+// in order, merging concurrent edits as Materialize does.
+func (e *Engine) View(ctx context.Context, target string) ([]cid.Cid, error) {
+	heads := e.Heads(target)
+	if len(heads) == 0 {
+		return nil, nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return materialize(ctx, e.load, heads)
+}
+
+// load loads an event the Engine applied or adopted, or else from its
+// store.  e.mu must be held.
+func (e *Engine) load(ctx context.Context, c cid.Cid) (Event, error) {
+	if ev, ok := e.byCID[c]; ok {
+		return ev, nil
+	}
+	return storeLoader(e.store.Store)(ctx, c)
+}
+
+// Heads returns the events of a world line that no later event links
+// to, sorted by CID bytes.  New events usually take them as prevHashes.
+func (e *Engine) Heads(target string) []cid.Cid {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	line := e.lines[target]
+	if line == nil {
+		return nil
+	}
+	var heads []cid.Cid
+	for c := range line.heads {
+		heads = append(heads, c)
+	}
+	sort.Slice(heads, func(i, j int) bool {
+		return bytes.Compare(heads[i].Bytes(), heads[j].Bytes()) < 0
+	})
+	return heads
+}
+
+// matching returns the subscriptions ev matches.  e.mu must be held.
+func (e *Engine) matching(ev Event) []*subscription {
+	var out []*subscription
+	for sub := range e.subs {
+		if matches(sub.payload, ev) {
+			out = append(out, sub)
+		}
+	}
+	return out
+}
+
+// checkCriteria checks the criteria of a query or subscribe payload.
+func checkCriteria(p *gridschema.EditPayload) error {
+	if p.Payload.Criteria == nil {
+		return nil
+	}
+	for _, key := range p.Payload.Criteria.Keys {
+		switch key {
+		case "since", "until":
+			_, err := time.Parse(time.RFC3339, criterion(p, key))
+			if err != nil {
+				return fmt.Errorf("criterion %s: %w", key, err)
+			}
+		case "filter", "op", "agent":
+		default:
+			return fmt.Errorf("unknown criterion %q", key)
+		}
+	}
+	return nil
+}
+
+// matches reports whether ev is in the target world line of the query
+// or subscribe payload p and matches its criteria, which have been
+// checked.
+func matches(p *gridschema.EditPayload, ev Event) bool {
+	if ev.Payload.Target != p.Target {
+		return false
+	}
+	if p.Payload.Criteria == nil {
+		return true
+	}
+	at, _ := time.Parse(time.RFC3339, ev.Payload.Timestamp)
+	for key, want := range p.Payload.Criteria.Values {
+		switch key {
+		case "since":
+			since, _ := time.Parse(time.RFC3339, want)
+			if at.Before(since) {
+				return false
+			}
+		case "until":
+			until, _ := time.Parse(time.RFC3339, want)
+			if at.After(until) {
+				return false
+			}
+		case "filter", "op":
+			if ev.Payload.Op != want {
+				return false
+			}
+		case "agent":
+			if ev.Payload.Agent != want {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// check checks that the events an insert, delete or reorder names are
+// in view, the view of its causal past.
+func check(view []cid.Cid, p *gridschema.EditPayload) error {
+	after, err := criterionCID(p, "after")
+	if err != nil {
+		return err
+	}
+	if after.Defined() && index(view, after) < 0 {
+		return fmt.Errorf("after %s: %w", after, ErrNoEvent)
+	}
+	if p.Op == gridschema.OpInsert {
+		return nil
+	}
+	c, err := criterionCID(p, "event")
+	if err != nil {
+		return err
+	}
+	if !c.Defined() {
+		return fmt.Errorf("%s: no event given", p.Op)
+	}
+	if index(view, c) < 0 {
+		return fmt.Errorf("%s %s: %w", p.Op, c, ErrNoEvent)
+	}
+	if c.Equals(after) {
+		return fmt.Errorf("%s %s after itself", p.Op, c)
+	}
+	return nil
+}
+
+func index(view []cid.Cid, c cid.Cid) int {
+	for i, v := range view {
+		if v.Equals(c) {
+			return i
+		}
+	}
+	return -1
+}
+
+// criterion returns the criterion key of p, or "".
+func criterion(p *gridschema.EditPayload, key string) string {
+	if p.Payload.Criteria == nil {
+		return ""
+	}
+	return p.Payload.Criteria.Values[key]
+}
+
+// criterionCID returns the CID in the criterion key of p, or cid.Undef
+// if it is empty or absent.
+func criterionCID(p *gridschema.EditPayload, key string) (cid.Cid, error) {
+	s := criterion(p, key)
+	if s == "" {
+		return cid.Undef, nil
+	}
+	c, err := cid.Decode(s)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("criterion %s: %w", key, err)
+	}
+	return c, nil
+}
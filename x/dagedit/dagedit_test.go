@@ -0,0 +1,249 @@
+package dagedit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stevegt/grid-poc/x/ipld-schema/gridschema"
+	ipldstore "github.com/stevegt/grid-poc/x/ipld-store"
+)
+
+const line = "worldline123"
+
+// payload returns an edit payload on line.  criteria are key, value
+// pairs.
+func payload(op, agent, timestamp string, prev []cid.Cid, criteria ...string) *gridschema.EditPayload {
+	p := &gridschema.EditPayload{
+		Op:         op,
+		Agent:      agent,
+		Timestamp:  timestamp,
+		Target:     line,
+		Payload:    gridschema.Claims{Claims: []gridschema.Claim{{Description: op + " by " + agent}}},
+		PrevHashes: prev,
+	}
+	if len(criteria) > 0 {
+		m := &gridschema.StringMap{Values: make(map[string]string)}
+		for i := 0; i < len(criteria); i += 2 {
+			m.Keys = append(m.Keys, criteria[i])
+			m.Values[criteria[i]] = criteria[i+1]
+		}
+		p.Payload.Criteria = m
+	}
+	return p
+}
+
+func apply(t *testing.T, e *Engine, p *gridschema.EditPayload) cid.Cid {
+	t.Helper()
+	ev, err := e.Apply(context.Background(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ev.CID
+}
+
+func view(t *testing.T, e *Engine) []cid.Cid {
+	t.Helper()
+	v, err := e.View(context.Background(), line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func equal(a, b []cid.Cid) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equals(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestEdit(t *testing.T) {
+	store := ipldstore.NewMemStore()
+	e := NewEngine(store)
+
+	a := apply(t, e, payload("insert", "Alice", "2023-10-01T10:05:00Z", nil))
+	b := apply(t, e, payload("insert", "Bob", "2023-10-01T10:06:00Z", []cid.Cid{a}))
+	// Alice inserts after a without having seen b: b and c are
+	// concurrent, and both go right after a.  The merge puts the later
+	// of the two in the total order first; at equal depth that is the
+	// one with the greater CID bytes, which is b.
+	c := apply(t, e, payload("insert", "Alice", "2023-10-01T10:07:00Z", []cid.Cid{a}, "after", a.String()))
+	if bytes.Compare(b.Bytes(), c.Bytes()) <= 0 {
+		t.Fatalf("b %s sorts before c %s", b, c)
+	}
+	if got, want := view(t, e), []cid.Cid{a, b, c}; !equal(got, want) {
+		t.Errorf("after inserts: got %v, want %v", got, want)
+	}
+	if got, want := e.Heads(line), []cid.Cid{c, b}; !equal(got, want) {
+		t.Errorf("heads: got %v, want %v", got, want)
+	}
+
+	r := apply(t, e, payload("reorder", "Alice", "2023-10-01T10:30:00Z", []cid.Cid{b, c}, "event", b.String(), "after", ""))
+	if got, want := view(t, e), []cid.Cid{b, a, c}; !equal(got, want) {
+		t.Errorf("after reorder: got %v, want %v", got, want)
+	}
+	d := apply(t, e, payload("delete", "Bob", "2023-10-01T10:20:00Z", []cid.Cid{r}, "event", a.String()))
+	if got, want := view(t, e), []cid.Cid{b, c}; !equal(got, want) {
+		t.Errorf("after delete: got %v, want %v", got, want)
+	}
+	if got, want := e.Heads(line), []cid.Cid{d}; !equal(got, want) {
+		t.Errorf("heads: got %v, want %v", got, want)
+	}
+
+	// Events are stored, and decode to what was applied.
+	atom := store.Get(d.Hash())
+	if atom == nil {
+		t.Fatal("delete event not stored")
+	}
+	p, err := Decode(atom.Data())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Op != "delete" || p.Agent != "Bob" || !equal(p.PrevHashes, []cid.Cid{r}) {
+		t.Errorf("stored %+v", p)
+	}
+	if ev, ok := e.Get(d); !ok || ev.Payload.Agent != "Bob" {
+		t.Errorf("Get: %v, %v", ev, ok)
+	}
+
+	// Applying an event again changes nothing.
+	again := apply(t, e, payload("delete", "Bob", "2023-10-01T10:20:00Z", []cid.Cid{r}, "event", a.String()))
+	if !again.Equals(d) || len(e.Heads(line)) != 1 {
+		t.Error("reapplying an event changed the world line")
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	ctx := context.Background()
+	e := NewEngine(ipldstore.NewMemStore())
+	a := apply(t, e, payload("insert", "Alice", "2023-10-01T10:05:00Z", nil))
+	missing, _ := Prefix.Sum([]byte("missing"))
+
+	tests := []struct {
+		name string
+		p    *gridschema.EditPayload
+		want error
+	}{
+		{"missing prev", payload("insert", "Bob", "2023-10-01T10:06:00Z", []cid.Cid{missing}), ErrMissingPrev},
+		{"no prev", payload("insert", "Bob", "2023-10-01T10:06:00Z", nil), ErrNoPrev},
+		{"delete missing", payload("delete", "Bob", "2023-10-01T10:06:00Z", []cid.Cid{a}, "event", missing.String()), ErrNoEvent},
+		{"after missing", payload("insert", "Bob", "2023-10-01T10:06:00Z", []cid.Cid{a}, "after", missing.String()), ErrNoEvent},
+		{"query", payload("query", "Bob", "2023-10-01T10:06:00Z", nil), ErrOp},
+		{"delete nothing", payload("delete", "Bob", "2023-10-01T10:06:00Z", []cid.Cid{a}), nil},
+		{"bad timestamp", payload("insert", "Bob", "yesterday", []cid.Cid{a}), nil},
+	}
+	for _, tt := range tests {
+		_, err := e.Apply(ctx, tt.p)
+		if err == nil || tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	if got, want := view(t, e), []cid.Cid{a}; !equal(got, want) {
+		t.Errorf("failed ops changed the view to %v", got)
+	}
+}
+
+// TestStoredHistory checks that an Engine builds on events in its
+// store that another Engine applied.
+func TestStoredHistory(t *testing.T) {
+	store := ipldstore.NewMemStore()
+	first := NewEngine(store)
+	a := apply(t, first, payload("insert", "Alice", "2023-10-01T10:05:00Z", nil))
+	b := apply(t, first, payload("insert", "Bob", "2023-10-01T10:06:00Z", []cid.Cid{a}))
+
+	e := NewEngine(store)
+	d := apply(t, e, payload("delete", "Carol", "2023-10-01T10:08:00Z", []cid.Cid{b}, "event", a.String()))
+	if got, want := view(t, e), []cid.Cid{b}; !equal(got, want) {
+		t.Errorf("view: got %v, want %v", got, want)
+	}
+	if got, want := e.Heads(line), []cid.Cid{d}; !equal(got, want) {
+		t.Errorf("heads: got %v, want %v", got, want)
+	}
+	evs, err := e.Query(payload("query", "Carol", "2023-10-01T10:10:00Z", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The adopted events come first, in DAG order.
+	if len(evs) != 3 || !evs[0].CID.Equals(a) || !evs[1].CID.Equals(b) || !evs[2].CID.Equals(d) {
+		t.Errorf("query: %v", evs)
+	}
+	_, err = e.Apply(context.Background(), payload("insert", "Carol", "2023-10-01T10:09:00Z", nil))
+	if !errors.Is(err, ErrNoPrev) {
+		t.Errorf("no prev: got %v, want %v", err, ErrNoPrev)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	e := NewEngine(ipldstore.NewMemStore())
+	a := apply(t, e, payload("insert", "Alice", "2023-10-01T09:00:00Z", nil))
+	b := apply(t, e, payload("insert", "Bob", "2023-10-01T10:06:00Z", []cid.Cid{a}))
+	c := apply(t, e, payload("delete", "Alice", "2023-10-01T10:07:00Z", []cid.Cid{b}, "event", a.String()))
+
+	tests := []struct {
+		criteria []string
+		want     []cid.Cid
+	}{
+		{nil, []cid.Cid{a, b, c}},
+		{[]string{"since", "2023-10-01T10:00:00Z"}, []cid.Cid{b, c}},
+		{[]string{"until", "2023-10-01T10:06:00Z"}, []cid.Cid{a, b}},
+		{[]string{"filter", "insert"}, []cid.Cid{a, b}},
+		{[]string{"agent", "Alice", "op", "insert"}, []cid.Cid{a}},
+	}
+	for _, tt := range tests {
+		evs, err := e.Query(payload("query", "Bob", "2023-10-01T10:10:00Z", nil, tt.criteria...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []cid.Cid
+		for _, ev := range evs {
+			got = append(got, ev.CID)
+		}
+		if !equal(got, tt.want) {
+			t.Errorf("%v: got %v, want %v", tt.criteria, got, tt.want)
+		}
+	}
+
+	for _, criteria := range [][]string{{"since", "today"}, {"color", "red"}} {
+		_, err := e.Query(payload("query", "Bob", "2023-10-01T10:10:00Z", nil, criteria...))
+		if err == nil {
+			t.Errorf("%v: no error", criteria)
+		}
+	}
+	other := payload("query", "Bob", "2023-10-01T10:10:00Z", nil)
+	other.Target = "elsewhere"
+	if evs, _ := e.Query(other); len(evs) != 0 {
+		t.Errorf("got %d events of another world line", len(evs))
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	e := NewEngine(ipldstore.NewMemStore())
+	var got []cid.Cid
+	cancel, err := e.Subscribe(payload("subscribe", "Bob", "2023-10-01T10:15:00Z", nil, "filter", "insert"), func(ev Event) {
+		got = append(got, ev.CID)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := apply(t, e, payload("insert", "Alice", "2023-10-01T10:16:00Z", nil))
+	d := apply(t, e, payload("delete", "Alice", "2023-10-01T10:17:00Z", []cid.Cid{a}, "event", a.String()))
+	b := apply(t, e, payload("insert", "Alice", "2023-10-01T10:18:00Z", []cid.Cid{d}))
+	cancel()
+	apply(t, e, payload("insert", "Alice", "2023-10-01T10:19:00Z", []cid.Cid{b}))
+	if want := []cid.Cid{a, b}; !equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	_, err = e.Subscribe(payload("insert", "Bob", "2023-10-01T10:15:00Z", nil), func(Event) {})
+	if !errors.Is(err, ErrOp) {
+		t.Errorf("subscribed with an insert: %v", err)
+	}
+}
@@ -0,0 +1,309 @@
+package dagedit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+	"math/bits"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	grid "github.com/stevegt/grid-poc"
+	"github.com/stevegt/grid-poc/x/ipld-schema/gridschema"
+)
+
+// Views are merged with a replicated growable array (RGA), a sequence
+// CRDT, so that every node holding the same events materializes the
+// same view, whatever order it received them in.
+//
+// Events are first put in a total order that respects the DAG: by
+// depth, one more than the deepest prevHash, then by CID bytes.  Each
+// insert and reorder is then a placement, a node of a tree: it hangs
+// off the placement it goes after, or the root to go first, and
+// siblings are ordered later first.  The view is a preorder walk of the
+// tree, keeping each event's last placement unless it is deleted.
+//
+// What an op goes after is resolved in the op's causal past, the view
+// at its prevHashes, as its agent saw it: "after" names an event whose
+// placement there is used, and an insert with no "after" goes after
+// the last event there.  Ops that are invalid in their causal past,
+// such as deleting an event never inserted, are ignored, so that
+// events received from elsewhere cannot stop a merge.
+
+// loader returns the event with the given CID.
+type loader func(ctx context.Context, c cid.Cid) (Event, error)
+
+// storeLoader loads events from a grid Store.
+func storeLoader(store grid.Store) loader {
+	return func(ctx context.Context, c cid.Cid) (Event, error) {
+		atom := store.Get(c.Hash())
+		if atom == nil {
+			return Event{}, fmt.Errorf("%s: %w", c, ErrMissingPrev)
+		}
+		p, err := Decode(atom.Data())
+		if err != nil {
+			return Event{}, fmt.Errorf("event %s: %w", c, err)
+		}
+		return Event{CID: c, Payload: p}, nil
+	}
+}
+
+// Materialize returns the view of a world line at a frontier, the
+// events of the world line to merge the DAG below, loading events from
+// store.  The frontier's events must all have the same target; events
+// of other targets below them are ignored.
+func Materialize(ctx context.Context, store grid.Store, frontier ...cid.Cid) ([]cid.Cid, error) {
+	return materialize(ctx, storeLoader(store), frontier)
+}
+
+// op is an event in the total order of a merge.
+type op struct {
+	Event
+	depth int
+	// past has bit i set if op i of the order is an ancestor.
+	past *big.Int
+	// anchor is the placement the op goes after; cid.Undef for the
+	// root.  It is resolved when the op is reached in the order.
+	anchor cid.Cid
+	// skip is set for ops invalid in their causal past.
+	skip bool
+}
+
+// later reports whether a comes after b in the total order.
+func (a *op) later(b *op) bool {
+	if a.depth != b.depth {
+		return a.depth > b.depth
+	}
+	return bytes.Compare(a.CID.Bytes(), b.CID.Bytes()) > 0
+}
+
+func materialize(ctx context.Context, load loader, frontier []cid.Cid) ([]cid.Cid, error) {
+	ops, err := collect(ctx, load, frontier)
+	if err != nil {
+		return nil, err
+	}
+	state := newRGA()
+	for i, o := range ops {
+		past := state
+		if popCount(o.past) != i {
+			// Concurrent ops came before this one; replay its past.
+			past = newRGA()
+			for j := 0; j < i; j++ {
+				if o.past.Bit(j) == 1 {
+					past.apply(ops[j])
+				}
+			}
+		}
+		past.resolve(o)
+		state.apply(o)
+	}
+	return state.view(), nil
+}
+
+// collect loads the events below frontier and returns them in merge
+// order with their depths and pasts.
+func collect(ctx context.Context, load loader, frontier []cid.Cid) ([]*op, error) {
+	byCID := make(map[cid.Cid]*op)
+	var pending []cid.Cid
+	target := ""
+	for i, c := range frontier {
+		ev, err := load(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 && ev.Payload.Target != target {
+			return nil, fmt.Errorf("frontier spans world lines %q and %q", target, ev.Payload.Target)
+		}
+		target = ev.Payload.Target
+		byCID[c] = &op{Event: ev}
+		pending = append(pending, ev.Payload.PrevHashes...)
+	}
+	for len(pending) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		c := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if _, ok := byCID[c]; ok {
+			continue
+		}
+		ev, err := load(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		if ev.Payload.Target != target {
+			byCID[c] = nil
+			continue
+		}
+		byCID[c] = &op{Event: ev}
+		pending = append(pending, ev.Payload.PrevHashes...)
+	}
+
+	// Depths, then the order, then pasts by index in the order.
+	var depth func(c cid.Cid) int
+	depth = func(c cid.Cid) int {
+		o := byCID[c]
+		if o == nil {
+			return 0
+		}
+		if o.depth == 0 {
+			o.depth = 1
+			for _, prev := range o.Payload.PrevHashes {
+				if d := depth(prev) + 1; d > o.depth {
+					o.depth = d
+				}
+			}
+		}
+		return o.depth
+	}
+	var ops []*op
+	for c, o := range byCID {
+		if o != nil {
+			depth(c)
+			ops = append(ops, o)
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[j].later(ops[i]) })
+	index := make(map[cid.Cid]int, len(ops))
+	for i, o := range ops {
+		index[o.CID] = i
+		o.past = new(big.Int)
+		for _, prev := range o.Payload.PrevHashes {
+			j, ok := index[prev]
+			if !ok {
+				continue
+			}
+			o.past.Or(o.past, ops[j].past)
+			o.past.SetBit(o.past, j, 1)
+		}
+	}
+	return ops, nil
+}
+
+func popCount(x *big.Int) int {
+	n := 0
+	for _, w := range x.Bits() {
+		n += bits.OnesCount(uint(w))
+	}
+	return n
+}
+
+// placement is a node of an RGA tree.
+type placement struct {
+	*op
+	// event is the inserted event placed.
+	event    cid.Cid
+	children []*placement
+}
+
+// rga is the state of a merge.
+type rga struct {
+	root placement
+	// placements are by the CID of the op that made them.
+	placements map[cid.Cid]*placement
+	// current holds the last placement of each inserted event.
+	current map[cid.Cid]*placement
+	deleted map[cid.Cid]bool
+}
+
+func newRGA() *rga {
+	return &rga{
+		placements: make(map[cid.Cid]*placement),
+		current:    make(map[cid.Cid]*placement),
+		deleted:    make(map[cid.Cid]bool),
+	}
+}
+
+// resolve resolves o's anchor in r, the state of o's causal past, or
+// marks o skipped.
+func (r *rga) resolve(o *op) {
+	p := o.Payload
+	after, err := criterionCID(p, "after")
+	if err != nil {
+		o.skip = true
+		return
+	}
+	switch p.Op {
+	case gridschema.OpInsert:
+		if !after.Defined() {
+			view := r.view()
+			if len(view) > 0 {
+				o.anchor = r.current[view[len(view)-1]].CID
+			}
+			return
+		}
+	case gridschema.OpReorder:
+		c, err := criterionCID(p, "event")
+		if err != nil || !r.visible(c) || c.Equals(after) {
+			o.skip = true
+			return
+		}
+		if !after.Defined() {
+			return
+		}
+	case gridschema.OpDelete:
+		c, err := criterionCID(p, "event")
+		o.skip = err != nil || !r.visible(c)
+		return
+	default:
+		o.skip = true
+		return
+	}
+	if !r.visible(after) {
+		o.skip = true
+		return
+	}
+	o.anchor = r.current[after].CID
+}
+
+// visible reports whether the event c is in r's view.
+func (r *rga) visible(c cid.Cid) bool {
+	return r.current[c] != nil && !r.deleted[c]
+}
+
+// apply applies a resolved op to r.
+func (r *rga) apply(o *op) {
+	if o.skip {
+		return
+	}
+	switch o.Payload.Op {
+	case gridschema.OpDelete:
+		c, _ := criterionCID(o.Payload, "event")
+		r.deleted[c] = true
+		return
+	case gridschema.OpInsert, gridschema.OpReorder:
+	default:
+		return
+	}
+	pl := &placement{op: o, event: o.CID}
+	if o.Payload.Op == gridschema.OpReorder {
+		pl.event, _ = criterionCID(o.Payload, "event")
+	}
+	parent := &r.root
+	if o.anchor.Defined() {
+		parent = r.placements[o.anchor]
+	}
+	// Siblings are later first; o is later than every op applied
+	// before it, so it goes first.
+	parent.children = append([]*placement{pl}, parent.children...)
+	r.placements[o.CID] = pl
+	r.current[pl.event] = pl
+}
+
+// view returns r's view: a preorder walk of the tree, keeping each
+// event's current placement unless it is deleted.
+func (r *rga) view() []cid.Cid {
+	var out []cid.Cid
+	var walk func(pl *placement)
+	walk = func(pl *placement) {
+		for _, child := range pl.children {
+			if r.current[child.event] == child && !r.deleted[child.event] {
+				out = append(out, child.event)
+			}
+			walk(child)
+		}
+	}
+	walk(&r.root)
+	return out
+}
@@ -0,0 +1,194 @@
+package dagedit
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stevegt/grid-poc/x/ipld-schema/gridschema"
+	ipldstore "github.com/stevegt/grid-poc/x/ipld-store"
+)
+
+// history is a world line edited concurrently by Alice and Bob, who
+// branch from the same two events and merge at the end.
+type history struct {
+	payloads []*gridschema.EditPayload
+	events   map[string]cid.Cid
+}
+
+func newHistory(t *testing.T) *history {
+	t.Helper()
+	e := NewEngine(ipldstore.NewMemStore())
+	h := &history{events: make(map[string]cid.Cid)}
+	add := func(name string, p *gridschema.EditPayload) cid.Cid {
+		c := apply(t, e, p)
+		h.payloads = append(h.payloads, p)
+		h.events[name] = c
+		return c
+	}
+	prev := func(names ...string) []cid.Cid {
+		var out []cid.Cid
+		for _, name := range names {
+			out = append(out, h.events[name])
+		}
+		return out
+	}
+
+	a := add("a", payload("insert", "Alice", "2023-10-01T10:00:00Z", nil))
+	b := add("b", payload("insert", "Alice", "2023-10-01T10:01:00Z", prev("a")))
+
+	// Alice's branch.
+	add("alice1", payload("insert", "Alice", "2023-10-01T10:02:00Z", prev("b"), "after", a.String()))
+	add("alice2", payload("insert", "Alice", "2023-10-01T10:03:00Z", prev("alice1")))
+	add("alice3", payload("reorder", "Alice", "2023-10-01T10:04:00Z", prev("alice2"), "event", b.String(), "after", ""))
+
+	// Bob's branch.
+	add("bob1", payload("insert", "Bob", "2023-10-01T10:02:30Z", prev("b"), "after", a.String()))
+	add("bob2", payload("insert", "Bob", "2023-10-01T10:03:30Z", prev("bob1")))
+	add("bob3", payload("delete", "Bob", "2023-10-01T10:04:30Z", prev("bob2"), "event", b.String()))
+
+	add("merge", payload("insert", "Alice", "2023-10-01T10:05:00Z", prev("alice3", "bob3")))
+	return h
+}
+
+// shuffled returns the payloads in a random order in which every
+// payload follows its prevHashes.
+func (h *history) shuffled(rng *rand.Rand) []*gridschema.EditPayload {
+	var out []*gridschema.EditPayload
+	done := make(map[cid.Cid]bool)
+	pending := append([]*gridschema.EditPayload(nil), h.payloads...)
+	for len(pending) > 0 {
+		var ready []int
+		for i, p := range pending {
+			ok := true
+			for _, prev := range p.PrevHashes {
+				ok = ok && done[prev]
+			}
+			if ok {
+				ready = append(ready, i)
+			}
+		}
+		i := ready[rng.Intn(len(ready))]
+		p := pending[i]
+		pending = append(pending[:i], pending[i+1:]...)
+		out = append(out, p)
+		data, _ := gridschema.Marshal(p)
+		c, _ := Prefix.Sum(data)
+		done[c] = true
+	}
+	return out
+}
+
+func TestConvergence(t *testing.T) {
+	h := newHistory(t)
+	var want []cid.Cid
+	rng := rand.New(rand.NewSource(1))
+	for run := 0; run < 20; run++ {
+		store := ipldstore.NewMemStore()
+		e := NewEngine(store)
+		for _, p := range h.shuffled(rng) {
+			apply(t, e, p)
+		}
+		got := view(t, e)
+		if want == nil {
+			want = got
+		}
+		if !equal(got, want) {
+			t.Fatalf("run %d: got %v, want %v", run, got, want)
+		}
+		m, err := Materialize(context.Background(), store, e.Heads(line)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equal(m, want) {
+			t.Fatalf("run %d: Materialize got %v, want %v", run, m, want)
+		}
+	}
+
+	// Both branches' inserts after a survive; b is deleted though it was
+	// also moved; the merge goes last.
+	ev := h.events
+	if len(want) != 6 || !want[0].Equals(ev["a"]) || !want[5].Equals(ev["merge"]) {
+		t.Errorf("view %v", want)
+	}
+	for _, name := range []string{"alice1", "alice2", "bob1", "bob2"} {
+		if index(want, ev[name]) < 0 {
+			t.Errorf("%s lost", name)
+		}
+	}
+	if index(want, ev["b"]) >= 0 {
+		t.Error("b not deleted")
+	}
+	// The inserts after a come before the appends, which followed b.
+	for _, first := range []string{"alice1", "bob1"} {
+		for _, second := range []string{"alice2", "bob2"} {
+			if index(want, ev[first]) > index(want, ev[second]) {
+				t.Errorf("%s after %s in %v", first, second, want)
+			}
+		}
+	}
+}
+
+func TestMaterializeFrontier(t *testing.T) {
+	ctx := context.Background()
+	store := ipldstore.NewMemStore()
+	e := NewEngine(store)
+	h := newHistory(t)
+	for _, p := range h.payloads {
+		apply(t, e, p)
+	}
+	ev := h.events
+
+	tests := []struct {
+		frontier []string
+		want     []string
+	}{
+		{[]string{"b"}, []string{"a", "b"}},
+		{[]string{"alice3"}, []string{"b", "a", "alice1", "alice2"}},
+		{[]string{"bob3"}, []string{"a", "bob1", "bob2"}},
+	}
+	for _, tt := range tests {
+		var frontier, want []cid.Cid
+		for _, name := range tt.frontier {
+			frontier = append(frontier, ev[name])
+		}
+		for _, name := range tt.want {
+			want = append(want, ev[name])
+		}
+		got, err := Materialize(ctx, store, frontier...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equal(got, want) {
+			t.Errorf("at %v: got %v, want %v", tt.frontier, got, want)
+		}
+	}
+
+	other := payload("insert", "Carol", "2023-10-01T10:00:00Z", nil)
+	other.Target = "elsewhere"
+	o := apply(t, e, other)
+	_, err := Materialize(ctx, store, ev["merge"], o)
+	if err == nil {
+		t.Error("materialized a frontier spanning world lines")
+	}
+}
+
+func TestConcurrentReorders(t *testing.T) {
+	store := ipldstore.NewMemStore()
+	e := NewEngine(store)
+	a := apply(t, e, payload("insert", "Alice", "2023-10-01T10:00:00Z", nil))
+	b := apply(t, e, payload("insert", "Alice", "2023-10-01T10:01:00Z", []cid.Cid{a}))
+	c := apply(t, e, payload("insert", "Alice", "2023-10-01T10:02:00Z", []cid.Cid{b}))
+	// Both move a; the one later in the merge order wins.
+	r1 := apply(t, e, payload("reorder", "Alice", "2023-10-01T10:03:00Z", []cid.Cid{c}, "event", a.String(), "after", b.String()))
+	r2 := apply(t, e, payload("reorder", "Bob", "2023-10-01T10:03:00Z", []cid.Cid{c}, "event", a.String(), "after", c.String()))
+	want := []cid.Cid{b, c, a}
+	if bytes.Compare(r1.Bytes(), r2.Bytes()) > 0 {
+		want = []cid.Cid{b, a, c}
+	}
+	if got := view(t, e); !equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
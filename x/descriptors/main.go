@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/sha256"
+	_ "embed"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -8,6 +10,7 @@ import (
 
 	"github.com/fxamacker/cbor/v2"
 	"github.com/spf13/cobra"
+	"github.com/stevegt/grid-poc/x/pcid"
 	"golang.org/x/sys/unix"
 )
 
@@ -43,21 +46,40 @@ type CWTClaims struct {
 	CWTID     []byte `cbor:"7,keyasint"` // cti
 }
 
-// ExecutableDescriptor describes an embedded executable
+// ExecutableDescriptor describes an embedded executable.  Its keys are
+// strings, as DAG-CBOR requires, so that a descriptor is valid IPLD
+// data; see the ExecutableDescriptor schema in x/ipld-schema.
 type ExecutableDescriptor struct {
-	Name        string `cbor:"0,keyasint"`
-	ContentType string `cbor:"1,keyasint"`
-	Size        int64  `cbor:"2,keyasint"`
-	Executable  []byte `cbor:"3,keyasint"`
-	Checksum    []byte `cbor:"4,keyasint"`
+	Name        string `cbor:"name"`
+	ContentType string `cbor:"contentType"`
+	Size        int64  `cbor:"size"`
+	Executable  []byte `cbor:"executable"`
+	Checksum    []byte `cbor:"checksum"`
 }
 
-// example demonstrates CBOR encoding and decoding of PromiseGrid messages
-func example(outputFile string) {
+// messageSpec specifies the example message; its CID is the message's
+// protocol CID.
+//
+//go:embed message.md
+var messageSpec []byte
+
+// example demonstrates CBOR encoding and decoding of PromiseGrid
+// messages.  The protocol CID is computed from specFile, or from
+// messageSpec if specFile is empty.
+func example(outputFile string, specFile string) {
+	protocolCID := pcid.MustFromSpec(messageSpec)
+	if specFile != "" {
+		var err error
+		protocolCID, err = pcid.FromFile(specFile)
+		if err != nil {
+			log.Fatalf("Failed to read spec: %v", err)
+		}
+	}
+
 	// Example: Create a PromiseGrid message
 	msg := PromiseGridMessage{
 		ProtocolTag: "grid",
-		ProtocolCID: "bafyreigmitjgwhpx2vgrzp7knbqdu2ju5ytyibfybll7tfb7eqjqujtd3y",
+		ProtocolCID: protocolCID.String(),
 		GridCID:     "bafyreigmitjgwhpx2vgrzp7knbqdu2ju5ytyibfybll7tfb7eqjqujtd3y",
 		CWTPayload: map[string]interface{}{
 			"iss": "issuer-system",
@@ -115,13 +137,15 @@ func embed(executableName string, outputFile string) {
 		log.Fatalf("Failed to stat file: %v", err)
 	}
 
-	// Create executable descriptor
+	// Create executable descriptor, with the sha256 of the executable
+	// as its checksum
+	sum := sha256.Sum256(data)
 	descriptor := ExecutableDescriptor{
 		Name:        executableName,
 		ContentType: "application/octet-stream",
 		Size:        info.Size(),
 		Executable:  data,
-		Checksum:    []byte("sha256-placeholder"),
+		Checksum:    sum[:],
 	}
 
 	// Encode descriptor to CBOR
@@ -206,7 +230,8 @@ var exampleCmd = &cobra.Command{
 	Long:  "Demonstrates how to create, encode, and decode PromiseGrid 5-element CBOR messages",
 	Run: func(cmd *cobra.Command, args []string) {
 		outputFile, _ := cmd.Flags().GetString("output")
-		example(outputFile)
+		specFile, _ := cmd.Flags().GetString("spec")
+		example(outputFile, specFile)
 	},
 }
 
@@ -244,6 +269,7 @@ var rootCmd = &cobra.Command{
 
 func init() {
 	exampleCmd.Flags().StringP("output", "o", "", "Output file for CBOR-encoded message")
+	exampleCmd.Flags().StringP("spec", "s", "", "Protocol specification to compute the protocol CID from")
 	embedCmd.Flags().StringP("output", "o", "", "Output file for CBOR-encoded descriptor")
 	rootCmd.AddCommand(exampleCmd)
 	rootCmd.AddCommand(embedCmd)
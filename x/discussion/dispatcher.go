@@ -17,18 +17,10 @@ type AgentByte interface {
 	Unsubscribe(subId []byte) (err error)
 }
 
-// Msg type is intended to represent a structured message, such as a
-// COSE-signed CWT. This structure is a placeholder and should be
-// expanded to include actual message fields and validations.
-type Msg struct {
-	// Msg is a COSE-signed CWT
-	// XXX this is a placeholder
-}
-
-// AgentMsg interface uses a pre-parsed message type (Msg) for
-// subscriptions. It enables efficient, type-safe communications at
-// the expense of greater initial complexity and stricter contract
-// enforcement.
+// AgentMsg interface uses a pre-parsed message type (Msg, a verified
+// COSE-signed CWT) for subscriptions. It enables efficient, type-safe
+// communications at the expense of greater initial complexity and
+// stricter contract enforcement.  MsgBus in msg.go implements it.
 type AgentMsg interface {
 	Subscribe(prefix []Msg) (subId []byte, msgbuf chan Msg, err error)
 	Unsubscribe(subId []byte) (err error)
@@ -0,0 +1,551 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+var (
+	// ErrNoQuote is returned by Quote when no chain of order books
+	// connects the symbol to the denomination.
+	ErrNoQuote = errors.New("no quote available")
+	// ErrNoLiquidity is returned by Order when the opposite side of
+	// the book cannot fill the whole amount.
+	ErrNoLiquidity = errors.New("insufficient liquidity")
+	// ErrUnknownAgent is returned when an order names an agent that
+	// is not registered with the exchange.
+	ErrUnknownAgent = errors.New("unknown agent")
+	// ErrInsufficientHoldings is returned when an agent offers to sell
+	// or pay more of a currency than it holds and has not already
+	// committed.
+	ErrInsufficientHoldings = errors.New("insufficient holdings")
+)
+
+// Exchange is an order book market in the style of the sim3.5 Kernel.
+// Agents each issue their own personal currency, and every trade is a
+// balanced double-entry swap recorded on both agents' balance sheets.
+// Unlike the sim3.5 Kernel, the exchange keeps a separate book for
+// every symbol/denomination pair, supports partial fills, and trades
+// at the resting order's price.
+//
+// Agents program against the Market interface returned by
+// Exchange.Market rather than against the exchange itself.
+type Exchange struct {
+	mu                 sync.Mutex
+	agents             map[string]*BalanceSheet
+	personalCurrencies map[string]string
+	books              map[pair]*book
+	seq                uint64
+}
+
+// BalanceSheet holds an agent's double-entry ledgers.  An agent's own
+// personal currency only ever appears as a liability; other currencies
+// appear as assets.
+type BalanceSheet struct {
+	PersonalCurrency string
+	Assets           map[string]float64
+	Liabilities      map[string]float64
+}
+
+// Equity returns assets minus liabilities, counting every currency at
+// face value.
+func (b *BalanceSheet) Equity() float64 {
+	equity := 0.0
+	for _, amt := range b.Assets {
+		equity += amt
+	}
+	for _, amt := range b.Liabilities {
+		equity -= amt
+	}
+	return equity
+}
+
+// credit adds amount units of currency to the balance sheet.  Receiving
+// one's own personal currency retires a liability.
+func (b *BalanceSheet) credit(currency string, amount float64) {
+	if currency == b.PersonalCurrency {
+		b.Liabilities[currency] -= amount
+		return
+	}
+	b.Assets[currency] += amount
+}
+
+// debit removes amount units of currency from the balance sheet.
+// Paying in one's own personal currency issues it as a liability.
+func (b *BalanceSheet) debit(currency string, amount float64) {
+	if currency == b.PersonalCurrency {
+		b.Liabilities[currency] += amount
+		return
+	}
+	b.Assets[currency] -= amount
+}
+
+// pair identifies an order book: the symbol traded and the
+// denomination its price is expressed in.
+type pair struct {
+	symbol string
+	denom  string
+}
+
+// order is a resting limit order.
+type order struct {
+	id    []byte
+	agent string
+	buy   bool
+	qty   float64
+	price float64
+	seq   uint64
+}
+
+// book holds the resting orders for one pair.  Bids are kept sorted
+// best (highest) price first and asks best (lowest) price first; ties
+// are broken by arrival order.
+type book struct {
+	bids []*order
+	asks []*order
+}
+
+// orderRecord is the canonical CBOR form of an order.  Its CID is the
+// order ID.
+type orderRecord struct {
+	_      struct{} `cbor:",toarray"`
+	Seq    uint64
+	Agent  string
+	Buy    bool
+	Symbol string
+	Denom  string
+	Qty    float64
+	Price  float64
+}
+
+// encMode encodes order records deterministically.
+var encMode = func() cbor.EncMode {
+	em, err := cbor.CoreDetEncOptions().EncMode()
+	if err != nil {
+		panic(err)
+	}
+	return em
+}()
+
+// NewExchange creates an exchange with no agents and empty books.
+func NewExchange() *Exchange {
+	return &Exchange{
+		agents:             make(map[string]*BalanceSheet),
+		personalCurrencies: make(map[string]string),
+		books:              make(map[pair]*book),
+	}
+}
+
+// RegisterAgent registers an agent with the exchange.  As in sim3.5,
+// each agent's personal currency must be unique.
+func (e *Exchange) RegisterAgent(agent, personalCurrency string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, exists := e.agents[agent]; exists {
+		return fmt.Errorf("agent %s already registered", agent)
+	}
+	if owner, exists := e.personalCurrencies[personalCurrency]; exists {
+		return fmt.Errorf("personal currency %s already registered to %s",
+			personalCurrency, owner)
+	}
+	e.personalCurrencies[personalCurrency] = agent
+	e.agents[agent] = &BalanceSheet{
+		PersonalCurrency: personalCurrency,
+		Assets:           make(map[string]float64),
+		Liabilities:      make(map[string]float64),
+	}
+	return nil
+}
+
+// BalanceSheet returns a copy of the agent's balance sheet.
+func (e *Exchange) BalanceSheet(agent string) (*BalanceSheet, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	b, ok := e.agents[agent]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAgent, agent)
+	}
+	c := &BalanceSheet{
+		PersonalCurrency: b.PersonalCurrency,
+		Assets:           make(map[string]float64),
+		Liabilities:      make(map[string]float64),
+	}
+	for k, v := range b.Assets {
+		c.Assets[k] = v
+	}
+	for k, v := range b.Liabilities {
+		c.Liabilities[k] = v
+	}
+	return c, nil
+}
+
+// Bid places a limit order to buy qty units of symbol at up to price
+// units of denomination each.  Whatever part of the order crosses the
+// book is filled immediately; the rest rests in the book.  It returns
+// the order's CID.  Unless denomination is the agent's own personal
+// currency, the agent must hold qty*price uncommitted units of it;
+// otherwise ErrInsufficientHoldings is returned.
+func (e *Exchange) Bid(agent string, symbol, denomination []byte, qty, price float64) ([]byte, error) {
+	return e.limit(agent, true, symbol, denomination, qty, price)
+}
+
+// Ask places a limit order to sell qty units of symbol at no less than
+// price units of denomination each.  Whatever part of the order
+// crosses the book is filled immediately; the rest rests in the book.
+// It returns the order's CID.  An agent may sell any amount of its own
+// personal currency, which it issues, but only the units of other
+// currencies that it holds and has not already offered in resting
+// asks; otherwise ErrInsufficientHoldings is returned.
+func (e *Exchange) Ask(agent string, symbol, denomination []byte, qty, price float64) ([]byte, error) {
+	return e.limit(agent, false, symbol, denomination, qty, price)
+}
+
+// limit implements Bid and Ask.
+func (e *Exchange) limit(agent string, buy bool, symbol, denomination []byte, qty, price float64) ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if qty <= 0 || price <= 0 {
+		return nil, fmt.Errorf("qty and price must be positive")
+	}
+	p := pair{symbol: string(symbol), denom: string(denomination)}
+	o, err := e.newOrder(agent, buy, p, qty, price)
+	if err != nil {
+		return nil, err
+	}
+	b := e.book(p)
+	e.match(p, b, o, func(resting *order) bool {
+		if buy {
+			return resting.price <= price
+		}
+		return resting.price >= price
+	})
+	if o.qty > 0 {
+		b.insert(o)
+	}
+	return o.id, nil
+}
+
+// Market returns a view of the exchange that trades on behalf of the
+// given agent.
+func (e *Exchange) Market(agent string) Market {
+	return &agentMarket{exchange: e, agent: agent}
+}
+
+// agentMarket implements Market for one agent.
+type agentMarket struct {
+	exchange *Exchange
+	agent    string
+}
+
+// Quote returns the price of one unit of symbol in units of
+// denomination.  If a book exists for the pair, the price is the
+// midpoint of the best bid and best ask, or whichever of the two
+// exists.  The inverse book is used if only it exists.  Otherwise the
+// price is triangulated through intermediate currencies along the
+// shortest chain of books that connects the two.
+func (m *agentMarket) Quote(symbol, denomination []byte) (price float64, err error) {
+	e := m.exchange
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.quote(string(symbol), string(denomination))
+}
+
+// Order executes a market order for amount units of symbol, paid for
+// in denomination.  A positive amount buys and a negative amount
+// sells.  The order is filled against the best resting orders on the
+// opposite side of the book; if the book cannot fill the whole amount
+// nothing is traded and ErrNoLiquidity is returned.  As with Bid and
+// Ask, a buy costing more than the agent's holdings of denomination,
+// or a sell beyond its holdings of symbol, returns
+// ErrInsufficientHoldings.
+// The returned OrderId is the CID of the order.
+func (m *agentMarket) Order(symbol, denomination []byte, amount float64) (OrderId []byte, err error) {
+	e := m.exchange
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if amount == 0 {
+		return nil, fmt.Errorf("amount must be non-zero")
+	}
+	buy := amount > 0
+	qty := amount
+	if !buy {
+		qty = -amount
+	}
+	p := pair{symbol: string(symbol), denom: string(denomination)}
+	o, err := e.newOrder(m.agent, buy, p, qty, 0)
+	if err != nil {
+		return nil, err
+	}
+	b := e.book(p)
+	// cost is what the fill will pay, walking the book as match will.
+	available, cost := 0.0, 0.0
+	for _, resting := range b.side(!buy) {
+		if resting.agent == m.agent {
+			continue
+		}
+		if available < qty {
+			cost += min(resting.qty, qty-available) * resting.price
+		}
+		available += resting.qty
+	}
+	if available < qty {
+		return nil, fmt.Errorf("%w: %g of %g %s/%s available",
+			ErrNoLiquidity, available, qty, p.symbol, p.denom)
+	}
+	if buy {
+		err = e.checkPayment(m.agent, p.denom, cost)
+		if err != nil {
+			return nil, err
+		}
+	}
+	e.match(p, b, o, func(*order) bool { return true })
+	return o.id, nil
+}
+
+// newOrder assigns a sequence number and CID to a new order, after
+// checking that a seller holds what it sells and that a limit buyer
+// holds what it would pay.  A market buy's cost depends on the book,
+// so Order checks it.
+func (e *Exchange) newOrder(agent string, buy bool, p pair, qty, price float64) (*order, error) {
+	bs, ok := e.agents[agent]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAgent, agent)
+	}
+	if !buy && p.symbol != bs.PersonalCurrency {
+		if free := e.uncommitted(agent, p.symbol); free < qty {
+			return nil, fmt.Errorf("%w: %s holds %g uncommitted %s, selling %g",
+				ErrInsufficientHoldings, agent, free, p.symbol, qty)
+		}
+	}
+	if buy && price > 0 {
+		err := e.checkPayment(agent, p.denom, qty*price)
+		if err != nil {
+			return nil, err
+		}
+	}
+	e.seq++
+	rec := orderRecord{
+		Seq:    e.seq,
+		Agent:  agent,
+		Buy:    buy,
+		Symbol: p.symbol,
+		Denom:  p.denom,
+		Qty:    qty,
+		Price:  price,
+	}
+	buf, err := encMode.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	mh, err := multihash.Sum(buf, multihash.SHA2_256, -1)
+	if err != nil {
+		return nil, err
+	}
+	id := cid.NewCidV1(cid.DagCBOR, mh)
+	return &order{
+		id:    id.Bytes(),
+		agent: agent,
+		buy:   buy,
+		qty:   qty,
+		price: price,
+		seq:   e.seq,
+	}, nil
+}
+
+// checkPayment checks that an agent can pay amount units of denom:
+// that denom is its personal currency, or that it holds at least that
+// much uncommitted.
+func (e *Exchange) checkPayment(agent, denom string, amount float64) error {
+	if denom == e.agents[agent].PersonalCurrency {
+		return nil
+	}
+	if free := e.uncommitted(agent, denom); free < amount {
+		return fmt.Errorf("%w: %s holds %g uncommitted %s, paying %g",
+			ErrInsufficientHoldings, agent, free, denom, amount)
+	}
+	return nil
+}
+
+// uncommitted returns the units of currency an agent holds less those
+// offered in its resting asks and those its resting bids would pay.
+func (e *Exchange) uncommitted(agent, currency string) float64 {
+	free := e.agents[agent].Assets[currency]
+	for p, b := range e.books {
+		if p.symbol == currency {
+			for _, o := range b.asks {
+				if o.agent == agent {
+					free -= o.qty
+				}
+			}
+		}
+		if p.denom == currency {
+			for _, o := range b.bids {
+				if o.agent == agent {
+					free -= o.qty * o.price
+				}
+			}
+		}
+	}
+	return free
+}
+
+// match fills o against the opposite side of b, best price first,
+// while acceptable reports that the resting order's price is good
+// enough.  Orders never match against the same agent's orders.
+func (e *Exchange) match(p pair, b *book, o *order, acceptable func(*order) bool) {
+	opposite := b.side(!o.buy)
+	var remaining []*order
+	for i, resting := range opposite {
+		if o.qty == 0 || !acceptable(resting) {
+			remaining = append(remaining, opposite[i:]...)
+			break
+		}
+		if resting.agent == o.agent {
+			remaining = append(remaining, resting)
+			continue
+		}
+		qty := o.qty
+		if resting.qty < qty {
+			qty = resting.qty
+		}
+		buyer, seller := o.agent, resting.agent
+		if !o.buy {
+			buyer, seller = seller, buyer
+		}
+		e.settle(p, buyer, seller, qty, resting.price)
+		o.qty -= qty
+		resting.qty -= qty
+		if resting.qty > 0 {
+			remaining = append(remaining, resting)
+		}
+	}
+	if o.buy {
+		b.asks = remaining
+	} else {
+		b.bids = remaining
+	}
+}
+
+// settle records a trade of qty units of the pair's symbol at price
+// as a balanced swap: the buyer receives the symbol and pays the
+// denomination, and the seller does the reverse.
+func (e *Exchange) settle(p pair, buyer, seller string, qty, price float64) {
+	value := qty * price
+	b := e.agents[buyer]
+	s := e.agents[seller]
+	b.credit(p.symbol, qty)
+	b.debit(p.denom, value)
+	s.credit(p.denom, value)
+	s.debit(p.symbol, qty)
+}
+
+// book returns the book for a pair, creating it if needed.
+func (e *Exchange) book(p pair) *book {
+	b, ok := e.books[p]
+	if !ok {
+		b = &book{}
+		e.books[p] = b
+	}
+	return b
+}
+
+// side returns the bids if buy is true, otherwise the asks.
+func (b *book) side(buy bool) []*order {
+	if buy {
+		return b.bids
+	}
+	return b.asks
+}
+
+// insert adds a resting order in price-time priority.
+func (b *book) insert(o *order) {
+	orders := b.side(o.buy)
+	i := sort.Search(len(orders), func(i int) bool {
+		if o.buy {
+			return orders[i].price < o.price
+		}
+		return orders[i].price > o.price
+	})
+	orders = append(orders, nil)
+	copy(orders[i+1:], orders[i:])
+	orders[i] = o
+	if o.buy {
+		b.bids = orders
+	} else {
+		b.asks = orders
+	}
+}
+
+// mid returns the price implied by the book: the midpoint of the best
+// bid and ask, or the one that exists.
+func (b *book) mid() (float64, bool) {
+	switch {
+	case len(b.bids) > 0 && len(b.asks) > 0:
+		return (b.bids[0].price + b.asks[0].price) / 2, true
+	case len(b.bids) > 0:
+		return b.bids[0].price, true
+	case len(b.asks) > 0:
+		return b.asks[0].price, true
+	}
+	return 0, false
+}
+
+// quote finds the price of symbol in denom by breadth-first search
+// over the graph of books.  Each book with a price is an edge in both
+// directions, with the inverse edge carrying the reciprocal price.
+// Neighbors are visited in sorted order so the chain chosen among
+// equally short ones is deterministic.
+func (e *Exchange) quote(symbol, denom string) (float64, error) {
+	if symbol == denom {
+		return 1, nil
+	}
+	rates := make(map[string]map[string]float64)
+	addRate := func(from, to string, rate float64) {
+		if rates[from] == nil {
+			rates[from] = make(map[string]float64)
+		}
+		if _, ok := rates[from][to]; !ok {
+			rates[from][to] = rate
+		}
+	}
+	// Direct books take precedence over inverted ones.
+	for p, b := range e.books {
+		if price, ok := b.mid(); ok {
+			addRate(p.symbol, p.denom, price)
+		}
+	}
+	for p, b := range e.books {
+		if price, ok := b.mid(); ok {
+			addRate(p.denom, p.symbol, 1/price)
+		}
+	}
+
+	price := map[string]float64{symbol: 1}
+	queue := []string{symbol}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		var next []string
+		for to := range rates[cur] {
+			next = append(next, to)
+		}
+		sort.Strings(next)
+		for _, to := range next {
+			if _, seen := price[to]; seen {
+				continue
+			}
+			price[to] = price[cur] * rates[cur][to]
+			if to == denom {
+				return price[to], nil
+			}
+			queue = append(queue, to)
+		}
+	}
+	return 0, fmt.Errorf("%w: %s in %s", ErrNoQuote, symbol, denom)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+)
+
+// setupExchange registers Alice, Bob, Carol and Dave, each with a
+// personal currency named after them.
+func setupExchange(t *testing.T) *Exchange {
+	e := NewExchange()
+	for _, name := range []string{"Alice", "Bob", "Carol", "Dave"} {
+		err := e.RegisterAgent(name, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return e
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+// TestQuote checks direct, inverse and triangulated quotes.
+func TestQuote(t *testing.T) {
+	e := setupExchange(t)
+	var m Market = e.Market("Alice")
+
+	// Dave/Bob: bid 9, ask 11 -> mid 10.
+	_, err := e.Bid("Bob", []byte("Dave"), []byte("Bob"), 1, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = e.Ask("Dave", []byte("Dave"), []byte("Bob"), 1, 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Bob/Carol: ask only, 2.
+	_, err = e.Ask("Bob", []byte("Bob"), []byte("Carol"), 5, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		symbol, denom string
+		want          float64
+	}{
+		{"Dave", "Bob", 10},
+		{"Bob", "Dave", 0.1},
+		{"Dave", "Carol", 20},
+		{"Carol", "Dave", 0.05},
+		{"Dave", "Dave", 1},
+	}
+	for _, c := range cases {
+		got, err := m.Quote([]byte(c.symbol), []byte(c.denom))
+		if err != nil {
+			t.Fatalf("%s/%s: %v", c.symbol, c.denom, err)
+		}
+		if !near(got, c.want) {
+			t.Errorf("%s/%s: expected %g, got %g", c.symbol, c.denom, c.want, got)
+		}
+	}
+
+	_, err = m.Quote([]byte("Dave"), []byte("Alice"))
+	if !errors.Is(err, ErrNoQuote) {
+		t.Errorf("expected ErrNoQuote, got %v", err)
+	}
+}
+
+// TestOrder checks that a market order fills against the best resting
+// orders, records the swap on both balance sheets as in sim3.5, and
+// returns a CID order ID.
+func TestOrder(t *testing.T) {
+	e := setupExchange(t)
+	_, err := e.Ask("Dave", []byte("Dave"), []byte("Alice"), 5, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Carol cannot sell Dave until she holds some.
+	_, err = e.Ask("Carol", []byte("Dave"), []byte("Alice"), 5, 3)
+	if !errors.Is(err, ErrInsufficientHoldings) {
+		t.Fatalf("expected ErrInsufficientHoldings, got %v", err)
+	}
+	// She buys 5 Dave at 1 Carol, then offers them.
+	_, err = e.Ask("Dave", []byte("Dave"), []byte("Carol"), 5, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = e.Bid("Carol", []byte("Dave"), []byte("Carol"), 5, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = e.Ask("Carol", []byte("Dave"), []byte("Alice"), 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// All 5 are offered, so she cannot offer more.
+	_, err = e.Ask("Carol", []byte("Dave"), []byte("Bob"), 1, 3)
+	if !errors.Is(err, ErrInsufficientHoldings) {
+		t.Fatalf("expected ErrInsufficientHoldings, got %v", err)
+	}
+
+	m := e.Market("Alice")
+	_, err = m.Order([]byte("Dave"), []byte("Alice"), 20)
+	if !errors.Is(err, ErrNoLiquidity) {
+		t.Fatalf("expected ErrNoLiquidity, got %v", err)
+	}
+
+	id, err := m.Order([]byte("Dave"), []byte("Alice"), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := cid.Cast(id)
+	if err != nil {
+		t.Fatalf("order ID is not a CID: %v", err)
+	}
+	if c.Prefix().Codec != cid.DagCBOR {
+		t.Errorf("expected dag-cbor CID, got codec %x", c.Prefix().Codec)
+	}
+
+	// Alice bought 5 Dave at 2 and 2 Dave at 3 from Carol, paying
+	// 16 in her own currency.
+	alice, err := e.BalanceSheet("Alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !near(alice.Assets["Dave"], 7) || !near(alice.Liabilities["Alice"], 16) {
+		t.Errorf("unexpected Alice balance sheet: %+v", alice)
+	}
+	dave, _ := e.BalanceSheet("Dave")
+	if !near(dave.Assets["Alice"], 10) || !near(dave.Assets["Carol"], 5) || !near(dave.Liabilities["Dave"], 10) {
+		t.Errorf("unexpected Dave balance sheet: %+v", dave)
+	}
+	carol, _ := e.BalanceSheet("Carol")
+	if !near(carol.Assets["Alice"], 6) || !near(carol.Assets["Dave"], 3) || !near(carol.Liabilities["Carol"], 5) {
+		t.Errorf("unexpected Carol balance sheet: %+v", carol)
+	}
+
+	// Carol's remaining 3 Dave at 3 is now the only ask.
+	price, err := m.Quote([]byte("Dave"), []byte("Alice"))
+	if err != nil || !near(price, 3) {
+		t.Errorf("expected quote 3, got %g, %v", price, err)
+	}
+
+	// Selling: Bob holds no Dave, and Alice, who does, has no bids
+	// to hit.
+	_, err = e.Market("Bob").Order([]byte("Dave"), []byte("Alice"), -1)
+	if !errors.Is(err, ErrInsufficientHoldings) {
+		t.Errorf("expected ErrInsufficientHoldings, got %v", err)
+	}
+	_, err = e.Market("Alice").Order([]byte("Dave"), []byte("Bob"), -1)
+	if !errors.Is(err, ErrNoLiquidity) {
+		t.Errorf("expected ErrNoLiquidity, got %v", err)
+	}
+	_, err = e.Market("Nobody").Order([]byte("Dave"), []byte("Alice"), 1)
+	if !errors.Is(err, ErrUnknownAgent) {
+		t.Errorf("expected ErrUnknownAgent, got %v", err)
+	}
+}
+
+// TestLimitCross checks that crossing limit orders trade at the resting
+// price and that identical orders get distinct IDs.
+func TestLimitCross(t *testing.T) {
+	e := setupExchange(t)
+	id1, err := e.Bid("Alice", []byte("Dave"), []byte("Alice"), 10, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id2, err := e.Bid("Alice", []byte("Dave"), []byte("Alice"), 10, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(id1) == string(id2) {
+		t.Errorf("identical orders got the same ID")
+	}
+	_, err = e.Ask("Dave", []byte("Dave"), []byte("Alice"), 10, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, _ := e.BalanceSheet("Alice")
+	if !near(alice.Assets["Dave"], 10) || !near(alice.Liabilities["Alice"], 100) {
+		t.Errorf("unexpected Alice balance sheet: %+v", alice)
+	}
+	if !near(alice.Equity(), -90) {
+		t.Errorf("expected Alice equity -90, got %g", alice.Equity())
+	}
+}
+
+// TestOverspend checks that buys paid in a currency other than the
+// buyer's own are limited to its uncommitted holdings of it.
+func TestOverspend(t *testing.T) {
+	e := setupExchange(t)
+	_, err := e.Ask("Dave", []byte("Dave"), []byte("Bob"), 5, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := e.Market("Alice")
+	_, err = alice.Order([]byte("Dave"), []byte("Bob"), 5)
+	if !errors.Is(err, ErrInsufficientHoldings) {
+		t.Fatalf("market buy: expected ErrInsufficientHoldings, got %v", err)
+	}
+	_, err = e.Bid("Alice", []byte("Dave"), []byte("Bob"), 5, 2)
+	if !errors.Is(err, ErrInsufficientHoldings) {
+		t.Fatalf("limit buy: expected ErrInsufficientHoldings, got %v", err)
+	}
+
+	// Alice buys 6 Bob for 6 Alice, enough for 3 Dave.
+	_, err = e.Ask("Bob", []byte("Bob"), []byte("Alice"), 6, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = alice.Order([]byte("Bob"), []byte("Alice"), 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = alice.Order([]byte("Dave"), []byte("Bob"), 4)
+	if !errors.Is(err, ErrInsufficientHoldings) {
+		t.Fatalf("market buy of 4: expected ErrInsufficientHoldings, got %v", err)
+	}
+	// A resting bid commits what it would pay.
+	_, err = e.Bid("Alice", []byte("Carol"), []byte("Bob"), 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = alice.Order([]byte("Dave"), []byte("Bob"), 3)
+	if !errors.Is(err, ErrInsufficientHoldings) {
+		t.Fatalf("market buy of 3: expected ErrInsufficientHoldings, got %v", err)
+	}
+	_, err = alice.Order([]byte("Dave"), []byte("Bob"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs, _ := e.BalanceSheet("Alice")
+	if !near(bs.Assets["Bob"], 2) || !near(bs.Assets["Dave"], 2) {
+		t.Errorf("unexpected Alice balance sheet: %+v", bs)
+	}
+}
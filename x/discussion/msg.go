@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+const (
+	CWTTag       = 61 // CBOR tag for CWT
+	COSESign1Tag = 18 // CBOR tag for COSE_Sign1
+
+	// COSE algorithm identifiers (RFC 9053).
+	AlgES256 = -7
+	AlgEdDSA = -8
+
+	// COSE header labels (RFC 9052).
+	headerAlg = 1
+	headerKid = 4
+
+	// ClaimPayload is the private-use CWT claim key that carries the
+	// message payload.  RFC 8392 reserves keys below -65536 for
+	// private use.
+	ClaimPayload = -65537
+)
+
+var (
+	// ErrMalformed is returned for bytes that are not a COSE_Sign1
+	// CWT.
+	ErrMalformed = errors.New("malformed message")
+	// ErrUnknownKey is returned when no verification key is known
+	// for the message's key ID.
+	ErrUnknownKey = errors.New("unknown key")
+	// ErrBadSignature is returned when signature verification fails.
+	ErrBadSignature = errors.New("bad signature")
+	// ErrExpired is returned for messages past their exp claim.
+	ErrExpired = errors.New("message expired")
+	// ErrNotYetValid is returned for messages before their nbf or
+	// iat claim.
+	ErrNotYetValid = errors.New("message not yet valid")
+	// ErrAudience is returned when the aud claim does not name the
+	// verifier's audience.
+	ErrAudience = errors.New("wrong audience")
+	// ErrIssuer is returned when the iss claim names an issuer other
+	// than the one the signing key belongs to.
+	ErrIssuer = errors.New("wrong issuer")
+)
+
+// Claims is a CWT claims set (RFC 8392).  Times are NumericDates in
+// seconds since the epoch; zero means the claim is absent.  The
+// audience is a single string.
+type Claims struct {
+	Issuer     string          `cbor:"1,keyasint,omitempty"`
+	Subject    string          `cbor:"2,keyasint,omitempty"`
+	Audience   string          `cbor:"3,keyasint,omitempty"`
+	Expiration int64           `cbor:"4,keyasint,omitempty"`
+	NotBefore  int64           `cbor:"5,keyasint,omitempty"`
+	IssuedAt   int64           `cbor:"6,keyasint,omitempty"`
+	CWTID      []byte          `cbor:"7,keyasint,omitempty"`
+	Payload    cbor.RawMessage `cbor:"-65537,keyasint,omitempty"`
+}
+
+// coseSign1 is the untagged COSE_Sign1 array.
+type coseSign1 struct {
+	_           struct{} `cbor:",toarray"`
+	Protected   []byte
+	Unprotected map[int]any
+	Payload     []byte
+	Signature   []byte
+}
+
+// Msg is a verified message: a COSE_Sign1-signed CWT whose signature
+// and time claims have been checked.  A Msg is only ever produced by
+// Verifier.Verify, so agents that receive one never handle
+// unverified bytes.  Msg values are also used as subscription
+// templates; see Matches.
+type Msg struct {
+	// Alg is the COSE algorithm the message was signed with.
+	Alg int
+	// Kid is the ID of the key that signed the message.
+	Kid []byte
+	// Claims are the validated CWT claims.  Claims.Payload holds the
+	// CBOR-encoded payload.
+	Claims
+	// Raw is the complete signed message as received.
+	Raw []byte
+}
+
+// DecodePayload decodes the message payload into v.
+func (m Msg) DecodePayload(v any) error {
+	if len(m.Payload) == 0 {
+		return fmt.Errorf("%w: no payload", ErrMalformed)
+	}
+	return cbor.Unmarshal(m.Payload, v)
+}
+
+// Expired reports whether the message's exp claim is at or before now.
+func (m Msg) Expired(now time.Time) bool {
+	return m.Expiration != 0 && now.Unix() >= m.Expiration
+}
+
+// Matches reports whether m matches the template t.  Every non-empty
+// claim of the template must equal the message's claim, except the
+// payload, which must be a byte prefix of the message's encoded
+// payload.  The zero Msg matches everything.
+func (m Msg) Matches(t Msg) bool {
+	if len(t.Kid) > 0 && !bytes.Equal(t.Kid, m.Kid) {
+		return false
+	}
+	if t.Issuer != "" && t.Issuer != m.Issuer {
+		return false
+	}
+	if t.Subject != "" && t.Subject != m.Subject {
+		return false
+	}
+	if t.Audience != "" && t.Audience != m.Audience {
+		return false
+	}
+	if len(t.CWTID) > 0 && !bytes.Equal(t.CWTID, m.CWTID) {
+		return false
+	}
+	return bytes.HasPrefix(m.Payload, t.Payload)
+}
+
+// Sign encodes payload and claims as a CWT, signs it as a tagged
+// COSE_Sign1 with the given key, and returns the encoded message.
+// The key must be an *ecdsa.PrivateKey on P-256 (ES256) or an
+// ed25519.PrivateKey (EdDSA).  If claims has no CWT ID a random one
+// is generated.
+func Sign(claims Claims, payload any, kid []byte, key crypto.Signer) ([]byte, error) {
+	var alg int
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		if k.Curve.Params().BitSize != 256 {
+			return nil, fmt.Errorf("ES256 needs a P-256 key")
+		}
+		alg = AlgES256
+	case ed25519.PrivateKey:
+		alg = AlgEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	if payload != nil {
+		buf, err := cbor.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		claims.Payload = buf
+	}
+	if claims.CWTID == nil {
+		claims.CWTID = make([]byte, 16)
+		_, err := rand.Read(claims.CWTID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	claimsBuf, err := cbor.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+	protected, err := cbor.Marshal(map[int]any{headerAlg: alg, headerKid: kid})
+	if err != nil {
+		return nil, err
+	}
+	toBeSigned, err := sigStructure(protected, claimsBuf)
+	if err != nil {
+		return nil, err
+	}
+
+	var sig []byte
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256(toBeSigned)
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			return nil, err
+		}
+		// COSE ECDSA signatures are r and s as fixed-length
+		// big-endian integers.
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, toBeSigned)
+	}
+
+	msg := coseSign1{
+		Protected:   protected,
+		Unprotected: map[int]any{},
+		Payload:     claimsBuf,
+		Signature:   sig,
+	}
+	return cbor.Marshal(cbor.Tag{Number: CWTTag,
+		Content: cbor.Tag{Number: COSESign1Tag, Content: msg}})
+}
+
+// sigStructure returns the COSE Sig_structure for a COSE_Sign1 with
+// no external additional authenticated data.
+func sigStructure(protected, payload []byte) ([]byte, error) {
+	return cbor.Marshal([]any{"Signature1", protected, []byte{}, payload})
+}
+
+// Key is a verification key and the issuer it belongs to.
+type Key struct {
+	// Public is an *ecdsa.PublicKey or ed25519.PublicKey.
+	Public crypto.PublicKey
+	// Issuer is the only iss claim the key may sign.  Messages
+	// without an iss claim are accepted from any key.
+	Issuer string
+}
+
+// Verifier turns signed bytes into verified Msgs.
+type Verifier struct {
+	// Keys maps key IDs to keys.
+	Keys map[string]Key
+	// Audience, if set, must equal the message's aud claim.
+	Audience string
+	// Leeway is the allowed clock skew for time claims.
+	Leeway time.Duration
+	// Now returns the current time.  It defaults to time.Now.
+	Now func() time.Time
+}
+
+// now returns the verifier's notion of the current time.
+func (v *Verifier) now() time.Time {
+	if v.Now != nil {
+		return v.Now()
+	}
+	return time.Now()
+}
+
+// Verify parses a COSE_Sign1 CWT, optionally wrapped in the CWT and
+// COSE_Sign1 tags, checks its signature against the key named by its
+// kid header, checks that its iss claim is the key's issuer, and
+// validates the exp, nbf, iat and aud claims.
+func (v *Verifier) Verify(raw []byte) (*Msg, error) {
+	var tagged cbor.RawTag
+	content := cbor.RawMessage(raw)
+	for {
+		err := cbor.Unmarshal(content, &tagged)
+		if err != nil {
+			break
+		}
+		if tagged.Number != CWTTag && tagged.Number != COSESign1Tag {
+			return nil, fmt.Errorf("%w: unexpected tag %d", ErrMalformed, tagged.Number)
+		}
+		content = tagged.Content
+	}
+	var cs coseSign1
+	err := cbor.Unmarshal(content, &cs)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	var header map[int]any
+	err = cbor.Unmarshal(cs.Protected, &header)
+	if err != nil {
+		return nil, fmt.Errorf("%w: protected header: %v", ErrMalformed, err)
+	}
+	alg, ok := intHeader(header[headerAlg])
+	if !ok {
+		return nil, fmt.Errorf("%w: missing alg", ErrMalformed)
+	}
+	kid, ok := header[headerKid].([]byte)
+	if !ok {
+		kid, ok = cs.Unprotected[headerKid].([]byte)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: missing kid", ErrMalformed)
+	}
+	k, ok := v.Keys[string(kid)]
+	key := k.Public
+	if !ok {
+		return nil, fmt.Errorf("%w: %x", ErrUnknownKey, kid)
+	}
+
+	toBeSigned, err := sigStructure(cs.Protected, cs.Payload)
+	if err != nil {
+		return nil, err
+	}
+	switch alg {
+	case AlgES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(cs.Signature) != 64 {
+			return nil, ErrBadSignature
+		}
+		digest := sha256.Sum256(toBeSigned)
+		r := new(big.Int).SetBytes(cs.Signature[:32])
+		s := new(big.Int).SetBytes(cs.Signature[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return nil, ErrBadSignature
+		}
+	case AlgEdDSA:
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(pub, toBeSigned, cs.Signature) {
+			return nil, ErrBadSignature
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported alg %d", ErrMalformed, alg)
+	}
+
+	m := &Msg{Alg: int(alg), Kid: kid, Raw: raw}
+	err = cbor.Unmarshal(cs.Payload, &m.Claims)
+	if err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrMalformed, err)
+	}
+	if m.Issuer != "" && m.Issuer != k.Issuer {
+		return nil, fmt.Errorf("%w: key %x cannot sign for %q", ErrIssuer, kid, m.Issuer)
+	}
+	now := v.now()
+	if m.Expired(now.Add(-v.Leeway)) {
+		return nil, ErrExpired
+	}
+	if m.NotBefore != 0 && now.Add(v.Leeway).Unix() < m.NotBefore {
+		return nil, ErrNotYetValid
+	}
+	if m.IssuedAt != 0 && now.Add(v.Leeway).Unix() < m.IssuedAt {
+		return nil, ErrNotYetValid
+	}
+	if v.Audience != "" && m.Audience != v.Audience {
+		return nil, fmt.Errorf("%w: %q", ErrAudience, m.Audience)
+	}
+	return m, nil
+}
+
+// intHeader returns a header value decoded as either kind of CBOR
+// integer.
+func intHeader(v any) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case uint64:
+		if n > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(n), true
+	}
+	return 0, false
+}
+
+// MsgBus implements AgentMsg.  Raw messages are handed to Publish,
+// which verifies them and delivers each verified, unexpired message to
+// every subscription with a matching template.  Delivery never blocks:
+// if a subscriber's channel is full the message is dropped for that
+// subscriber.
+type MsgBus struct {
+	verifier *Verifier
+	bufSize  int
+	mu       sync.Mutex
+	subs     map[string]*subscription
+	order    []string
+}
+
+// subscription is one AgentMsg subscription.
+type subscription struct {
+	templates []Msg
+	msgbuf    chan Msg
+	dropped   int
+}
+
+// NewMsgBus creates a bus that verifies messages with v and gives each
+// subscriber a channel buffering up to bufSize messages.
+func NewMsgBus(v *Verifier, bufSize int) *MsgBus {
+	return &MsgBus{
+		verifier: v,
+		bufSize:  bufSize,
+		subs:     make(map[string]*subscription),
+	}
+}
+
+// Subscribe registers a subscription.  A message is delivered if it
+// matches any of the templates in prefix (see Msg.Matches); an empty
+// prefix matches every message.
+func (b *MsgBus) Subscribe(prefix []Msg) (subId []byte, msgbuf chan Msg, err error) {
+	subId = make([]byte, 16)
+	_, err = rand.Read(subId)
+	if err != nil {
+		return nil, nil, err
+	}
+	sub := &subscription{
+		templates: append([]Msg(nil), prefix...),
+		msgbuf:    make(chan Msg, b.bufSize),
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs[string(subId)] = sub
+	b.order = append(b.order, string(subId))
+	return subId, sub.msgbuf, nil
+}
+
+// Unsubscribe removes a subscription and closes its channel.
+func (b *MsgBus) Unsubscribe(subId []byte) (err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub, ok := b.subs[string(subId)]
+	if !ok {
+		return fmt.Errorf("unknown subscription %x", subId)
+	}
+	delete(b.subs, string(subId))
+	for i, id := range b.order {
+		if id == string(subId) {
+			b.order = append(b.order[:i], b.order[i+1:]...)
+			break
+		}
+	}
+	close(sub.msgbuf)
+	return nil
+}
+
+// Publish verifies a raw message and delivers it to matching
+// subscribers.  It returns the number of subscribers the message was
+// delivered to.  Messages that fail verification are not delivered
+// to anyone.
+func (b *MsgBus) Publish(raw []byte) (delivered int, err error) {
+	m, err := b.verifier.Verify(raw)
+	if err != nil {
+		return 0, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, id := range b.order {
+		sub := b.subs[id]
+		if !sub.matches(*m) {
+			continue
+		}
+		select {
+		case sub.msgbuf <- *m:
+			delivered++
+		default:
+			sub.dropped++
+		}
+	}
+	return delivered, nil
+}
+
+// matches reports whether m matches any of the subscription's
+// templates.
+func (s *subscription) matches(m Msg) bool {
+	if len(s.templates) == 0 {
+		return true
+	}
+	for _, t := range s.templates {
+		if m.Matches(t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// testKeys returns an ES256 and an EdDSA key and a verifier that
+// knows both, with its clock fixed at now.
+func testKeys(t *testing.T, now time.Time) (*ecdsa.PrivateKey, ed25519.PrivateKey, *Verifier) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &Verifier{
+		Keys: map[string]Key{
+			"alice": {Public: &ecKey.PublicKey, Issuer: "alice"},
+			"bob":   {Public: edKey.Public(), Issuer: "bob"},
+		},
+		Audience: "grid",
+		Now:      func() time.Time { return now },
+	}
+	return ecKey, edKey, v
+}
+
+// TestSignVerify checks round trips with both algorithms and that
+// tampered, expired, premature, misaddressed and unknown-key messages
+// are rejected.
+func TestSignVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ecKey, edKey, v := testKeys(t, now)
+	claims := Claims{
+		Issuer:     "alice",
+		Subject:    "worldline/1",
+		Audience:   "grid",
+		IssuedAt:   now.Unix(),
+		NotBefore:  now.Unix(),
+		Expiration: now.Add(time.Hour).Unix(),
+	}
+
+	for kid, key := range map[string]crypto.Signer{"alice": ecKey, "bob": edKey} {
+		claims := claims
+		claims.Issuer = kid
+		raw, err := Sign(claims, map[string]string{"op": "insert"}, []byte(kid), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := v.Verify(raw)
+		if err != nil {
+			t.Fatalf("%s: %v", kid, err)
+		}
+		if m.Issuer != kid || m.Subject != "worldline/1" || string(m.Kid) != kid {
+			t.Errorf("%s: unexpected claims %+v", kid, m.Claims)
+		}
+		if len(m.CWTID) != 16 {
+			t.Errorf("%s: expected a generated cti", kid)
+		}
+		var payload map[string]string
+		err = m.DecodePayload(&payload)
+		if err != nil || payload["op"] != "insert" {
+			t.Errorf("%s: unexpected payload %v, %v", kid, payload, err)
+		}
+
+		// Flip a bit in the signature.
+		bad := append([]byte(nil), raw...)
+		bad[len(bad)-1] ^= 1
+		_, err = v.Verify(bad)
+		if !errors.Is(err, ErrBadSignature) {
+			t.Errorf("%s: expected ErrBadSignature, got %v", kid, err)
+		}
+	}
+
+	cases := []struct {
+		name   string
+		mutate func(c *Claims)
+		kid    string
+		want   error
+	}{
+		{"expired", func(c *Claims) { c.Expiration = now.Unix() }, "alice", ErrExpired},
+		{"nbf", func(c *Claims) { c.NotBefore = now.Add(time.Minute).Unix() }, "alice", ErrNotYetValid},
+		{"iat", func(c *Claims) { c.IssuedAt = now.Add(time.Minute).Unix() }, "alice", ErrNotYetValid},
+		{"aud", func(c *Claims) { c.Audience = "elsewhere" }, "alice", ErrAudience},
+		{"kid", func(c *Claims) {}, "carol", ErrUnknownKey},
+		{"iss", func(c *Claims) { c.Issuer = "bob" }, "alice", ErrIssuer},
+	}
+	for _, c := range cases {
+		cl := claims
+		c.mutate(&cl)
+		raw, err := Sign(cl, nil, []byte(c.kid), ecKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = v.Verify(raw)
+		if !errors.Is(err, c.want) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.want, err)
+		}
+	}
+
+	_, err := v.Verify([]byte("not cbor"))
+	if !errors.Is(err, ErrMalformed) {
+		t.Errorf("expected ErrMalformed, got %v", err)
+	}
+
+	// A positive alg decodes as an unsigned integer and must still be
+	// read as an alg.
+	protected, err := cbor.Marshal(map[int]any{headerAlg: 1, headerKid: []byte("alice")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := cbor.Marshal(coseSign1{Protected: protected, Payload: []byte{0xa0}, Signature: []byte{0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = v.Verify(raw)
+	if err == nil || !strings.Contains(err.Error(), "unsupported alg 1") {
+		t.Errorf("expected unsupported alg 1, got %v", err)
+	}
+}
+
+// TestMsgBus checks that the bus delivers verified messages only to
+// subscribers with matching templates, and never delivers messages
+// that fail verification.
+func TestMsgBus(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ecKey, edKey, v := testKeys(t, now)
+	var bus AgentMsg = NewMsgBus(v, 10)
+	b := bus.(*MsgBus)
+
+	allId, all, err := bus.Subscribe(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, fromBob, err := bus.Subscribe([]Msg{{Kid: []byte("bob")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, worldline, err := bus.Subscribe([]Msg{{Claims: Claims{Subject: "worldline/2"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := Claims{Audience: "grid", Subject: "worldline/1",
+		Expiration: now.Add(time.Hour).Unix()}
+	raw, _ := Sign(claims, "hello", []byte("alice"), ecKey)
+	n, err := b.Publish(raw)
+	if err != nil || n != 1 {
+		t.Fatalf("expected delivery to 1 subscriber, got %d, %v", n, err)
+	}
+	claims.Subject = "worldline/2"
+	raw, _ = Sign(claims, "hello", []byte("bob"), edKey)
+	n, err = b.Publish(raw)
+	if err != nil || n != 3 {
+		t.Fatalf("expected delivery to 3 subscribers, got %d, %v", n, err)
+	}
+	claims.Expiration = now.Unix()
+	raw, _ = Sign(claims, "stale", []byte("bob"), edKey)
+	n, err = b.Publish(raw)
+	if !errors.Is(err, ErrExpired) || n != 0 {
+		t.Fatalf("expected expired message to be dropped, got %d, %v", n, err)
+	}
+
+	if len(all) != 2 || len(fromBob) != 1 || len(worldline) != 1 {
+		t.Fatalf("unexpected queue lengths %d %d %d", len(all), len(fromBob), len(worldline))
+	}
+	m := <-fromBob
+	var s string
+	err = m.DecodePayload(&s)
+	if err != nil || s != "hello" || m.Subject != "worldline/2" {
+		t.Errorf("unexpected message %+v", m)
+	}
+
+	err = bus.Unsubscribe(allId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-all
+	<-all
+	if _, ok := <-all; ok {
+		t.Errorf("expected channel to be closed after Unsubscribe")
+	}
+	err = bus.Unsubscribe(allId)
+	if err == nil {
+		t.Errorf("expected error unsubscribing twice")
+	}
+}
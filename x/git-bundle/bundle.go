@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// Bundle is a parsed bundle header followed by its packfile.
+type Bundle struct {
+	Version       int
+	Capabilities  map[string]string
+	Prerequisites []plumbing.Hash
+	References    []*plumbing.Reference
+	// Pack reads the packfile that follows the header.
+	Pack io.Reader
+}
+
+// readBundle parses a v2 or v3 bundle header from r.  Only sha1
+// repositories are supported, and filtered bundles are rejected
+// because the receiver could not tell which objects are missing.
+func readBundle(r io.Reader) (*Bundle, error) {
+	br := bufio.NewReader(r)
+	line, err := readLine(br)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle signature: %w", err)
+	}
+	b := &Bundle{Capabilities: make(map[string]string)}
+	switch line {
+	case "# v2 git bundle":
+		b.Version = 2
+	case "# v3 git bundle":
+		b.Version = 3
+	default:
+		return nil, fmt.Errorf("not a git bundle: %q", line)
+	}
+
+	for {
+		line, err = readLine(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle header: %w", err)
+		}
+		if line == "" {
+			break
+		}
+		switch {
+		case strings.HasPrefix(line, "@"):
+			if b.Version < 3 {
+				return nil, fmt.Errorf("capability in v2 bundle: %q", line)
+			}
+			key, value, _ := strings.Cut(line[1:], "=")
+			b.Capabilities[key] = value
+		case strings.HasPrefix(line, "-"):
+			hex, _, _ := strings.Cut(line[1:], " ")
+			if !plumbing.IsHash(hex) {
+				return nil, fmt.Errorf("invalid prerequisite: %q", line)
+			}
+			b.Prerequisites = append(b.Prerequisites, plumbing.NewHash(hex))
+		default:
+			hex, name, ok := strings.Cut(line, " ")
+			if !ok || !plumbing.IsHash(hex) {
+				return nil, fmt.Errorf("invalid reference: %q", line)
+			}
+			ref := plumbing.NewHashReference(plumbing.ReferenceName(name), plumbing.NewHash(hex))
+			b.References = append(b.References, ref)
+		}
+	}
+
+	for key, value := range b.Capabilities {
+		switch key {
+		case "object-format":
+			if value != "sha1" {
+				return nil, fmt.Errorf("unsupported object format: %s", value)
+			}
+		case "filter":
+			return nil, fmt.Errorf("filtered bundles are not supported")
+		default:
+			return nil, fmt.Errorf("unknown bundle capability: %s", key)
+		}
+	}
+
+	b.Pack = br
+	return b, nil
+}
+
+// readLine reads one newline-terminated header line.
+func readLine(br *bufio.Reader) (string, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\n"), nil
+}
+
+// verifyBundle checks that repo has every commit the bundle depends
+// on, and that the pack is intact and holds every object the bundled
+// references reach that repo lacks, so that unpacking it will leave a
+// complete history.  It reads the whole pack, then resets b.Pack so
+// that the pack can be read again.
+func verifyBundle(repo *git.Repository, b *Bundle) error {
+	var missing []string
+	for _, hash := range b.Prerequisites {
+		_, err := repo.CommitObject(hash)
+		if err != nil {
+			missing = append(missing, hash.String())
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("repository lacks prerequisite commits: %s", strings.Join(missing, ", "))
+	}
+
+	data, err := io.ReadAll(b.Pack)
+	if err != nil {
+		return fmt.Errorf("failed to read pack: %w", err)
+	}
+	b.Pack = bytes.NewReader(data)
+	// The parser does not check the trailing checksum.
+	if len(data) < sha1.Size {
+		return fmt.Errorf("corrupt pack: truncated")
+	}
+	body, sum := data[:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	if got := sha1.Sum(body); !bytes.Equal(got[:], sum) {
+		return fmt.Errorf("corrupt pack: checksum mismatch")
+	}
+	pack := memory.NewStorage()
+	p, err := packfile.NewParserWithStorage(packfile.NewScanner(bytes.NewReader(data)), &thinStorage{pack, repo.Storer})
+	if err == nil {
+		_, err = p.Parse()
+	}
+	if err != nil {
+		return fmt.Errorf("corrupt pack: %w", err)
+	}
+	return checkConnected(repo, pack, b)
+}
+
+// thinStorage keeps the objects of a pack in memory, looking up any
+// it lacks in repo, so that a thin pack's deltas against objects the
+// receiver already has can be resolved.
+type thinStorage struct {
+	*memory.Storage
+	repo storer.EncodedObjectStorer
+}
+
+func (s *thinStorage) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	obj, err := s.Storage.EncodedObject(t, h)
+	if err == plumbing.ErrObjectNotFound {
+		return s.repo.EncodedObject(t, h)
+	}
+	return obj, err
+}
+
+// checkConnected walks from the bundled references through the objects
+// in pack, and fails if it reaches an object that is neither in pack
+// nor in repo.  Objects repo already has, and so the prerequisites, end
+// the walk.
+func checkConnected(repo *git.Repository, pack *memory.Storage, b *Bundle) error {
+	seen := make(map[plumbing.Hash]bool)
+	var todo []plumbing.Hash
+	for _, ref := range b.References {
+		todo = append(todo, ref.Hash())
+	}
+	for len(todo) > 0 {
+		hash := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		if seen[hash] || repo.Storer.HasEncodedObject(hash) == nil {
+			continue
+		}
+		seen[hash] = true
+		obj, err := object.GetObject(pack, hash)
+		if err != nil {
+			return fmt.Errorf("bundle lacks object %s: %w", hash, err)
+		}
+		switch obj := obj.(type) {
+		case *object.Commit:
+			todo = append(todo, obj.TreeHash)
+			todo = append(todo, obj.ParentHashes...)
+		case *object.Tree:
+			for _, e := range obj.Entries {
+				if e.Mode != filemode.Submodule {
+					todo = append(todo, e.Hash)
+				}
+			}
+		case *object.Tag:
+			todo = append(todo, obj.Target)
+		}
+	}
+	return nil
+}
+
+// unbundle verifies the bundle read from r, stores its objects in
+// repo and points its references at the bundled commits.  A reference
+// is only created or fast-forwarded unless force is set, in which case
+// it is moved wherever the bundle says.  As with git fetch from a
+// bundle, HEAD and any other name outside refs/ is skipped.  No
+// reference is set unless every one can be.  It returns the references
+// it set.
+func unbundle(repo *git.Repository, r io.Reader, force bool) ([]*plumbing.Reference, error) {
+	b, err := readBundle(r)
+	if err != nil {
+		return nil, err
+	}
+	err = verifyBundle(repo, b)
+	if err != nil {
+		return nil, err
+	}
+
+	err = packfile.UpdateObjectStorage(repo.Storer, b.Pack)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unpack bundle: %w", err)
+	}
+
+	// Check every reference before setting any of them
+	var set []*plumbing.Reference
+	for _, ref := range b.References {
+		if !strings.HasPrefix(ref.Name().String(), "refs/") {
+			continue
+		}
+		update, err := checkUpdate(repo, ref, force)
+		if err != nil {
+			return nil, err
+		}
+		if update {
+			set = append(set, ref)
+		}
+	}
+	for _, ref := range set {
+		err = repo.Storer.SetReference(ref)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set reference '%s': %w", ref.Name(), err)
+		}
+	}
+	return set, nil
+}
+
+// checkUpdate checks that ref may be set in repo, and reports whether
+// it changes anything.  The name must be valid, the object must be
+// present, and an existing reference may only be
+// fast-forwarded, unless force is set.
+func checkUpdate(repo *git.Repository, ref *plumbing.Reference, force bool) (bool, error) {
+	name := ref.Name()
+	if name.Validate() != nil {
+		return false, fmt.Errorf("invalid reference name '%s'", name)
+	}
+	_, err := repo.Storer.EncodedObject(plumbing.AnyObject, ref.Hash())
+	if err != nil {
+		return false, fmt.Errorf("bundle lacks object %s for '%s': %w", ref.Hash(), name, err)
+	}
+	old, err := repo.Storer.Reference(name)
+	switch {
+	case err == plumbing.ErrReferenceNotFound:
+		return true, nil
+	case err != nil:
+		return false, err
+	case old.Type() == plumbing.HashReference && old.Hash() == ref.Hash():
+		return false, nil
+	case force:
+		return true, nil
+	}
+	ff, err := isFastForward(repo, old, ref.Hash())
+	if err != nil {
+		return false, err
+	}
+	if !ff {
+		return false, fmt.Errorf("reference '%s' update from %s to %s is not a fast-forward", name, old.Hash(), ref.Hash())
+	}
+	return true, nil
+}
+
+// isFastForward reports whether moving old to hash is a fast-forward.
+// Symbolic references and references to anything but commits never
+// are.
+func isFastForward(repo *git.Repository, old *plumbing.Reference, hash plumbing.Hash) (bool, error) {
+	if old.Type() != plumbing.HashReference {
+		return false, nil
+	}
+	from, err := peelCommit(repo, old.Hash())
+	if err != nil {
+		return false, nil
+	}
+	to, err := peelCommit(repo, hash)
+	if err != nil {
+		return false, nil
+	}
+	return from.IsAncestor(to)
+}
+
+// peelCommit returns the commit an object is or, through annotated
+// tags, points at.
+func peelCommit(repo *git.Repository, hash plumbing.Hash) (*object.Commit, error) {
+	for {
+		tag, err := repo.TagObject(hash)
+		if err != nil {
+			return repo.CommitObject(hash)
+		}
+		hash = tag.Target
+	}
+}
+
+// readBundleFile verifies or unpacks a bundle file, which may be
+// wrapped in a grid envelope, against the repository at repoPath.
+// Unpacking prints the references it set; force lets it move them
+// backwards or sideways.
+func readBundleFile(repoPath, verifyFile, unbundleFile string, force bool) error {
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	file := verifyFile
+	if file == "" {
+		file = unbundleFile
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read bundle file: %w", err)
+	}
+	if isEnvelope(data) {
+		data, err = unwrapBundle(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	if verifyFile != "" {
+		b, err := readBundle(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		err = verifyBundle(repo, b)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s is okay\n", file)
+		for _, ref := range b.References {
+			fmt.Printf("%s %s\n", ref.Hash(), ref.Name())
+		}
+		return nil
+	}
+
+	refs, err := unbundle(repo, bytes.NewReader(data), force)
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		fmt.Printf("%s %s\n", ref.Hash(), ref.Name())
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	ipldstore "github.com/stevegt/grid-poc/x/ipld-store"
+)
+
+// gridTagNum is the CBOR tag that marks a grid message: 'grid' as a
+// 4-byte big-endian integer.  The tag wraps [protocol CID, payload].
+const gridTagNum uint64 = 0x67726964
+
+// bundleProtocolSpec describes the bundle protocol.  Its CID is the
+// protocol CID of every bundle envelope.
+const bundleProtocolSpec = `grid git-bundle protocol v1
+
+The payload is a git bundle, version 2 or 3, as written by
+"git bundle create": a header listing prerequisites and references,
+followed by a packfile.  A receiver that has every prerequisite
+commit may unpack the bundle and set the listed references.
+`
+
+// bundleProtocol is the CID of bundleProtocolSpec.
+var bundleProtocol = mustRawCID([]byte(bundleProtocolSpec))
+
+var encMode cbor.EncMode
+
+func init() {
+	var err error
+	encMode, err = cbor.CoreDetEncOptions().EncMode()
+	if err != nil {
+		panic(fmt.Sprintf("failed to create CBOR enc mode: %v", err))
+	}
+}
+
+// mustRawCID returns the CIDv1 of data using the raw codec and
+// sha2-256.
+func mustRawCID(data []byte) cid.Cid {
+	c, err := cid.V1Builder{Codec: cid.Raw, MhType: multihash.SHA2_256}.Sum(data)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// wrapBundle wraps bundle bytes in a grid envelope.
+func wrapBundle(bundle []byte) ([]byte, error) {
+	tag := cbor.Tag{
+		Number:  gridTagNum,
+		Content: []interface{}{bundleProtocol.Bytes(), bundle},
+	}
+	return encMode.Marshal(tag)
+}
+
+// isEnvelope reports whether data starts with the grid tag rather
+// than a bundle signature.
+func isEnvelope(data []byte) bool {
+	return bytes.HasPrefix(data, []byte{0xda, 'g', 'r', 'i', 'd'})
+}
+
+// unwrapBundle returns the bundle in a grid envelope, checking that
+// the envelope names the bundle protocol.
+func unwrapBundle(data []byte) ([]byte, error) {
+	var tag cbor.RawTag
+	err := cbor.Unmarshal(data, &tag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode envelope: %w", err)
+	}
+	if tag.Number != gridTagNum {
+		return nil, fmt.Errorf("invalid grid tag number: %d", tag.Number)
+	}
+	var parts [][]byte
+	err = cbor.Unmarshal(tag.Content, &parts)
+	if err != nil || len(parts) != 2 {
+		return nil, fmt.Errorf("invalid envelope content, expected [protocol, payload]")
+	}
+	pcid, err := cid.Cast(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid protocol CID: %w", err)
+	}
+	if !pcid.Equals(bundleProtocol) {
+		return nil, fmt.Errorf("envelope protocol %s is not the bundle protocol %s", pcid, bundleProtocol)
+	}
+	return parts[1], nil
+}
+
+// BundleAtom is a bundle wrapped in a grid envelope, so that it can
+// be stored and passed around like any other Atom.  Its hashes are of
+// the whole envelope.
+type BundleAtom struct {
+	*ipldstore.Block
+}
+
+// NewBundleAtom wraps bundle bytes in a grid envelope and hashes the
+// result with sha2-256.
+func NewBundleAtom(bundle []byte) (*BundleAtom, error) {
+	data, err := wrapBundle(bundle)
+	if err != nil {
+		return nil, err
+	}
+	a := &BundleAtom{ipldstore.NewBlock(data)}
+	a.HashAdd(multihash.SHA2_256)
+	return a, nil
+}
+
+// cborCodec is the multicodec code for plain CBOR.  The envelope is
+// not DAG-CBOR, since DAG-CBOR allows no tag but 42.
+const cborCodec = 0x51
+
+// CID returns the CIDv1 of the envelope, using the cbor codec.
+func (a *BundleAtom) CID() cid.Cid {
+	return cid.NewCidV1(cborCodec, a.HashGet(multihash.SHA2_256))
+}
+
+// Bundle returns the bundle bytes inside the envelope.
+func (a *BundleAtom) Bundle() ([]byte, error) {
+	return unwrapBundle(a.Data())
+}
@@ -1,18 +1,24 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/format/packfile"
-	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/revlist"
 )
 
-func createBundle(repoPath, outputFile string, refs []string) error {
+// createBundle writes a v3 bundle of the given refs to outputFile.
+// If bases are given, the bundle is incremental: objects reachable
+// from the bases are left out and the bases are listed as
+// prerequisites the receiver must already have.
+func createBundle(repoPath, outputFile string, refs, bases []string, envelope bool) error {
 	// Open the repository
 	repo, err := git.PlainOpen(repoPath)
 	if err != nil {
@@ -26,13 +32,52 @@ func createBundle(repoPath, outputFile string, refs []string) error {
 	}
 	defer f.Close()
 
+	if !envelope {
+		return writeBundle(repo, f, refs, bases)
+	}
+	var buf bytes.Buffer
+	err = writeBundle(repo, &buf, refs, bases)
+	if err != nil {
+		return err
+	}
+	msg, err := wrapBundle(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to wrap bundle: %w", err)
+	}
+	_, err = f.Write(msg)
+	return err
+}
+
+// writeBundle writes a v3 bundle of the given refs to w, leaving out
+// everything reachable from bases.
+func writeBundle(repo *git.Repository, w io.Writer, refs, bases []string) error {
 	// Write bundle header
-	_, err = f.WriteString("# v3 git bundle\n")
+	_, err := io.WriteString(w, "# v3 git bundle\n")
 	if err != nil {
 		return fmt.Errorf("failed to write bundle header: %w", err)
 	}
 
+	// Resolve and write prerequisites
+	var ignore []plumbing.Hash
+	for _, base := range bases {
+		hash, err := repo.ResolveRevision(plumbing.Revision(base))
+		if err != nil {
+			return fmt.Errorf("failed to resolve basis '%s': %w", base, err)
+		}
+		commit, err := repo.CommitObject(*hash)
+		if err != nil {
+			return fmt.Errorf("basis '%s' is not a commit: %w", base, err)
+		}
+		subject, _, _ := strings.Cut(commit.Message, "\n")
+		_, err = fmt.Fprintf(w, "-%s %s\n", commit.Hash, subject)
+		if err != nil {
+			return fmt.Errorf("failed to write prerequisite '%s': %w", base, err)
+		}
+		ignore = append(ignore, commit.Hash)
+	}
+
 	// Resolve and write specified references to bundle
+	var tips []plumbing.Hash
 	for _, refName := range refs {
 		ref, err := repo.Reference(plumbing.ReferenceName(refName), true)
 		if err != nil {
@@ -41,48 +86,28 @@ func createBundle(repoPath, outputFile string, refs []string) error {
 		if ref.Type() != plumbing.HashReference {
 			return fmt.Errorf("reference '%s' is not a hash reference", refName)
 		}
-		_, err = fmt.Fprintf(f, "%s %s\n", ref.Hash(), ref.Name())
+		_, err = fmt.Fprintf(w, "%s %s\n", ref.Hash(), ref.Name())
 		if err != nil {
 			return fmt.Errorf("failed to write reference '%s': %w", refName, err)
 		}
+		tips = append(tips, ref.Hash())
 	}
 
 	// Write delimiter
-	_, err = f.WriteString("\n")
+	_, err = io.WriteString(w, "\n")
 	if err != nil {
 		return fmt.Errorf("failed to write delimiter: %w", err)
 	}
 
-	// Create packfile encoder
-	pw := packfile.NewEncoder(f, repo.Storer, false)
-
-	// Collect object hashes reachable from the specified refs
-	var hashes []plumbing.Hash
-	seen := make(map[plumbing.Hash]bool)
-	for _, refName := range refs {
-		ref, err := repo.Reference(plumbing.ReferenceName(refName), true)
-		if err != nil {
-			return fmt.Errorf("failed to resolve reference '%s': %w", refName, err)
-		}
-
-		commitIter, err := repo.Log(&git.LogOptions{From: ref.Hash()})
-		if err != nil {
-			return fmt.Errorf("failed to get commit iterator for reference '%s': %w", refName, err)
-		}
-
-		err = commitIter.ForEach(func(c *object.Commit) error {
-			if !seen[c.Hash] {
-				seen[c.Hash] = true
-				hashes = append(hashes, c.Hash)
-			}
-			return nil
-		})
-		if err != nil {
-			return fmt.Errorf("failed to iterate commits for reference '%s': %w", refName, err)
-		}
+	// Collect every object reachable from the refs but not from the
+	// bases: commits, trees, blobs and tags
+	hashes, err := revlist.Objects(repo.Storer, tips, ignore)
+	if err != nil {
+		return fmt.Errorf("failed to list objects: %w", err)
 	}
 
 	// Encode all reachable objects into the packfile
+	pw := packfile.NewEncoder(w, repo.Storer, false)
 	_, err = pw.Encode(hashes, 10)
 	if err != nil {
 		return fmt.Errorf("failed to write packfile: %w", err)
@@ -95,6 +120,11 @@ func main() {
 	repoPath := flag.String("repo", "", "Path to the Git repository")
 	outputFile := flag.String("out", "repo.bundle", "Output bundle file")
 	refs := flag.String("refs", "", "Comma-separated list of references (e.g., refs/heads/main,refs/tags/v1.0)")
+	basis := flag.String("basis", "", "Comma-separated list of revisions the receiver already has; makes an incremental bundle")
+	envelope := flag.Bool("envelope", false, "Wrap the bundle in a grid envelope")
+	verify := flag.String("verify", "", "Bundle file to check against the repository")
+	unbundle := flag.String("unbundle", "", "Bundle file to unpack into the repository")
+	force := flag.Bool("force", false, "Let -unbundle make reference updates that are not fast-forwards")
 
 	flag.Parse()
 
@@ -104,6 +134,15 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *verify != "" || *unbundle != "" {
+		err := readBundleFile(*repoPath, *verify, *unbundle, *force)
+		if err != nil {
+			fmt.Printf("Error reading bundle: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if *refs == "" {
 		fmt.Println("Error: at least one reference is required")
 		flag.Usage()
@@ -111,8 +150,9 @@ func main() {
 	}
 
 	refList := parseRefs(*refs)
+	basisList := parseRefs(*basis)
 
-	err := createBundle(*repoPath, *outputFile, refList)
+	err := createBundle(*repoPath, *outputFile, refList, basisList, *envelope)
 	if err != nil {
 		fmt.Printf("Error creating bundle: %v\n", err)
 		return
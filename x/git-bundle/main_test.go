@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/multiformats/go-multihash"
+)
+
+// testRepo is an in-memory repository with a worktree.
+type testRepo struct {
+	t    *testing.T
+	repo *git.Repository
+	wt   *git.Worktree
+	when time.Time
+}
+
+// newTestRepo creates an empty in-memory repository.
+func newTestRepo(t *testing.T) *testRepo {
+	repo, err := git.Init(memory.NewStorage(), memfs.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testRepo{t: t, repo: repo, wt: wt, when: time.Unix(1700000000, 0).UTC()}
+}
+
+// commit writes files to the worktree and commits them.
+func (r *testRepo) commit(msg string, files map[string]string) plumbing.Hash {
+	r.t.Helper()
+	for name, content := range files {
+		f, err := r.wt.Filesystem.Create(name)
+		if err != nil {
+			r.t.Fatal(err)
+		}
+		_, err = f.Write([]byte(content))
+		if err != nil {
+			r.t.Fatal(err)
+		}
+		f.Close()
+		_, err = r.wt.Add(name)
+		if err != nil {
+			r.t.Fatal(err)
+		}
+	}
+	r.when = r.when.Add(time.Minute)
+	sig := &object.Signature{Name: "Alice", Email: "alice@example.com", When: r.when}
+	h, err := r.wt.Commit(msg, &git.CommitOptions{Author: sig, Committer: sig})
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return h
+}
+
+// emptyRepo creates an empty in-memory repository with no worktree.
+func emptyRepo(t *testing.T) *git.Repository {
+	repo, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+// checkTree checks that every file in the tree of commit h in repo
+// can be read.
+func checkTree(t *testing.T, repo *git.Repository, h plumbing.Hash, want map[string]string) {
+	t.Helper()
+	c, err := repo.CommitObject(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range want {
+		f, err := c.File(name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, err := f.Contents()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got != content {
+			t.Fatalf("%s: got %q, want %q", name, got, content)
+		}
+	}
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	src := newTestRepo(t)
+	src.commit("first", map[string]string{"a.txt": "hello\n"})
+	h2 := src.commit("second", map[string]string{"dir/b.txt": "world\n"})
+
+	var buf bytes.Buffer
+	err := writeBundle(src.repo, &buf, []string{"refs/heads/master"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := readBundle(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Version != 3 || len(b.Prerequisites) != 0 || len(b.References) != 1 {
+		t.Fatalf("unexpected header: %+v", b)
+	}
+	if b.References[0].Hash() != h2 || b.References[0].Name() != "refs/heads/master" {
+		t.Fatalf("unexpected reference: %v", b.References[0])
+	}
+
+	dst := emptyRepo(t)
+	_, err = unbundle(dst, bytes.NewReader(buf.Bytes()), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := dst.Reference("refs/heads/master", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.Hash() != h2 {
+		t.Fatalf("got %s, want %s", ref.Hash(), h2)
+	}
+	checkTree(t, dst, h2, map[string]string{"a.txt": "hello\n", "dir/b.txt": "world\n"})
+}
+
+func TestBundleIncremental(t *testing.T) {
+	src := newTestRepo(t)
+	h1 := src.commit("first", map[string]string{"a.txt": "hello\n"})
+
+	var full bytes.Buffer
+	err := writeBundle(src.repo, &full, []string{"refs/heads/master"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h2 := src.commit("second\n\nwith a body", map[string]string{"b.txt": "world\n"})
+	var incr bytes.Buffer
+	err = writeBundle(src.repo, &incr, []string{"refs/heads/master"}, []string{h1.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(incr.String(), "-"+h1.String()+" first\n") {
+		t.Fatalf("missing prerequisite line in %q", incr.String()[:200])
+	}
+
+	// Without the basis the incremental bundle is refused.
+	dst := emptyRepo(t)
+	b, err := readBundle(bytes.NewReader(incr.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verifyBundle(dst, b) == nil {
+		t.Fatal("expected missing prerequisite error")
+	}
+	_, err = unbundle(dst, bytes.NewReader(incr.Bytes()), false)
+	if err == nil {
+		t.Fatal("expected unbundle to fail")
+	}
+
+	// With it, the two bundles together give the full history.
+	_, err = unbundle(dst, bytes.NewReader(full.Bytes()), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = unbundle(dst, bytes.NewReader(incr.Bytes()), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTree(t, dst, h2, map[string]string{"a.txt": "hello\n", "b.txt": "world\n"})
+}
+
+// TestUnbundleRefs checks that unbundling only creates and
+// fast-forwards references unless forced, skips HEAD and rejects
+// invalid names.
+func TestUnbundleRefs(t *testing.T) {
+	src := newTestRepo(t)
+	h1 := src.commit("first", map[string]string{"a.txt": "hello\n"})
+	var old bytes.Buffer
+	err := writeBundle(src.repo, &old, []string{"refs/heads/master"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2 := src.commit("second", map[string]string{"b.txt": "world\n"})
+	var cur bytes.Buffer
+	err = writeBundle(src.repo, &cur, []string{"refs/heads/master"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := emptyRepo(t)
+	master := func() plumbing.Hash {
+		ref, err := dst.Reference("refs/heads/master", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ref.Hash()
+	}
+
+	cases := []struct {
+		bundle []byte
+		force  bool
+		ok     bool
+		set    int
+		want   plumbing.Hash
+	}{
+		{old.Bytes(), false, true, 1, h1},
+		{cur.Bytes(), false, true, 1, h2},
+		{cur.Bytes(), false, true, 0, h2},
+		{old.Bytes(), false, false, 0, h2},
+		{old.Bytes(), true, true, 1, h1},
+		{bytes.Replace(cur.Bytes(), []byte(" refs/heads/master\n"), []byte(" HEAD\n"), 1), true, true, 0, h1},
+		{bytes.Replace(cur.Bytes(), []byte(" refs/heads/master\n"), []byte(" refs/heads/a..b\n"), 1), true, false, 0, h1},
+	}
+	for i, c := range cases {
+		refs, err := unbundle(dst, bytes.NewReader(c.bundle), c.force)
+		if (err == nil) != c.ok {
+			t.Errorf("case %d: %v", i, err)
+		}
+		if len(refs) != c.set {
+			t.Errorf("case %d: set %v", i, refs)
+		}
+		if got := master(); got != c.want {
+			t.Errorf("case %d: master is %s, want %s", i, got, c.want)
+		}
+	}
+}
+
+// TestVerifyPack checks that verifying a bundle checks its pack as
+// well as its prerequisites.
+func TestVerifyPack(t *testing.T) {
+	src := newTestRepo(t)
+	h1 := src.commit("first", map[string]string{"a.txt": "hello\n"})
+	src.commit("second", map[string]string{"b.txt": "world\n"})
+	var full, incr bytes.Buffer
+	err := writeBundle(src.repo, &full, []string{"refs/heads/master"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writeBundle(src.repo, &incr, []string{"refs/heads/master"}, []string{h1.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string][]byte{
+		"truncated": full.Bytes()[:full.Len()-10],
+		"corrupt":   append(append([]byte(nil), full.Bytes()[:full.Len()-1]...), full.Bytes()[full.Len()-1]^1),
+		// Without its prerequisite line, the incremental bundle
+		// refers to objects it does not hold.
+		"incomplete": bytes.Replace(incr.Bytes(), []byte("-"+h1.String()+" first\n"), nil, 1),
+	}
+	for name, data := range cases {
+		b, err := readBundle(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if verifyBundle(emptyRepo(t), b) == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	b, err := readBundle(bytes.NewReader(full.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = verifyBundle(emptyRepo(t), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadBundleErrors(t *testing.T) {
+	h := strings.Repeat("a", 40)
+	cases := map[string]string{
+		"signature":  "# v4 git bundle\n\n",
+		"truncated":  "# v3 git bundle\n" + h + " refs/heads/master\n",
+		"filter":     "# v3 git bundle\n@filter=blob:none\n\n",
+		"format":     "# v3 git bundle\n@object-format=sha256\n\n",
+		"v2 cap":     "# v2 git bundle\n@object-format=sha1\n\n",
+		"bad prereq": "# v3 git bundle\n-xyz\n\n",
+		"bad ref":    "# v3 git bundle\n" + h + "\n\n",
+	}
+	for name, data := range cases {
+		_, err := readBundle(strings.NewReader(data))
+		if err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	b, err := readBundle(strings.NewReader("# v3 git bundle\n@object-format=sha1\n" + h + " refs/heads/x\n\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Capabilities["object-format"] != "sha1" {
+		t.Fatalf("unexpected capabilities: %v", b.Capabilities)
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	src := newTestRepo(t)
+	h := src.commit("first", map[string]string{"a.txt": "hello\n"})
+	var buf bytes.Buffer
+	err := writeBundle(src.repo, &buf, []string{"refs/heads/master"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	atom, err := NewBundleAtom(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isEnvelope(atom.Data()) || isEnvelope(buf.Bytes()) {
+		t.Fatal("isEnvelope does not tell envelopes from bundles")
+	}
+	want, _ := multihash.Sum(atom.Data(), multihash.SHA2_256, -1)
+	if !bytes.Equal(atom.HashMRU(), want) || !bytes.Equal(atom.HashGetName("sha2-256"), want) {
+		t.Fatal("unexpected atom hash")
+	}
+	if atom.CID().Hash().String() != want.String() {
+		t.Fatal("CID does not carry the atom hash")
+	}
+
+	got, err := atom.Bundle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, buf.Bytes()) {
+		t.Fatal("bundle changed in envelope")
+	}
+	dst := emptyRepo(t)
+	_, err = unbundle(dst, bytes.NewReader(got), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTree(t, dst, h, map[string]string{"a.txt": "hello\n"})
+
+	// Envelopes for other protocols are refused.
+	other, err := encMode.Marshal(struct {
+		_ struct{} `cbor:",toarray"`
+		P []byte
+		B []byte
+	}{P: mustRawCID([]byte("other")).Bytes(), B: buf.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = unwrapBundle(append([]byte{0xda, 'g', 'r', 'i', 'd'}, other...))
+	if err == nil {
+		t.Fatal("expected protocol mismatch error")
+	}
+}
+
+// TestBundleGit checks bundles against git itself, when it is
+// installed.
+func TestBundleGit(t *testing.T) {
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	run := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command(gitPath, args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Alice", "GIT_AUTHOR_EMAIL=alice@example.com",
+			"GIT_COMMITTER_NAME=Alice", "GIT_COMMITTER_EMAIL=alice@example.com")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return string(out)
+	}
+	run("init", "-q", "-b", "main")
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello\n"), 0644)
+	run("add", "a.txt")
+	run("commit", "-q", "-m", "first")
+	base := strings.TrimSpace(run("rev-parse", "HEAD"))
+	os.WriteFile(filepath.Join(dir, "b.txt"), []byte("world\n"), 0644)
+	run("add", "b.txt")
+	run("commit", "-q", "-m", "second")
+
+	// git accepts our incremental bundle.
+	out := filepath.Join(dir, "incr.bundle")
+	err = createBundle(dir, out, []string{"refs/heads/main"}, []string{base}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run("bundle", "verify", out)
+
+	// We read git's, whose incremental bundles have thin packs.
+	run("branch", "old", base)
+	baseBundle := filepath.Join(dir, "base.bundle")
+	run("bundle", "create", baseBundle, "old")
+	gitBundle := filepath.Join(dir, "git.bundle")
+	run("bundle", "create", gitBundle, "main", "^old")
+	dst := emptyRepo(t)
+	var refs []*plumbing.Reference
+	for _, name := range []string{baseBundle, gitBundle} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		refs, err = unbundle(dst, bytes.NewReader(data), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(refs) != 1 || refs[0].Name() != "refs/heads/main" {
+		t.Fatalf("unexpected refs: %v", refs)
+	}
+	checkTree(t, dst, refs[0].Hash(), map[string]string{"a.txt": "hello\n", "b.txt": "world\n"})
+
+	// A --all bundle lists HEAD too, which is skipped.
+	allBundle := filepath.Join(dir, "all.bundle")
+	run("bundle", "create", allBundle, "--all")
+	data, err := os.ReadFile(allBundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(" HEAD\n")) {
+		t.Fatal("git's --all bundle has no HEAD")
+	}
+	dst = emptyRepo(t)
+	refs, err = unbundle(dst, bytes.NewReader(data), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[plumbing.ReferenceName]bool)
+	for _, ref := range refs {
+		names[ref.Name()] = true
+	}
+	if len(refs) != 2 || !names["refs/heads/main"] || !names["refs/heads/old"] {
+		t.Fatalf("unexpected refs: %v", refs)
+	}
+}
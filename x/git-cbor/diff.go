@@ -0,0 +1,587 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// objectStore holds the commits, trees and blobs read from CBOR
+// commit documents and history streams, so that commits can be
+// compared and merged without a git repository.
+type objectStore struct {
+	commits map[plumbing.Hash]CommitData
+	trees   map[plumbing.Hash][]TreeEntry
+	blobs   map[plumbing.Hash][]byte
+}
+
+// newObjectStore returns an empty objectStore.
+func newObjectStore() *objectStore {
+	return &objectStore{
+		commits: make(map[plumbing.Hash]CommitData),
+		trees:   make(map[plumbing.Hash][]TreeEntry),
+		blobs:   make(map[plumbing.Hash][]byte),
+	}
+}
+
+// load reads a commit document or a history stream from r into the
+// store and returns the hash of the commit it stands for: the
+// document's commit, or the last commit in the stream.
+func (s *objectStore) load(r io.Reader) (plumbing.Hash, error) {
+	dec := cbor.NewDecoder(bufio.NewReader(r))
+	var tip plumbing.Hash
+	for {
+		var raw cbor.RawMessage
+		err := dec.Decode(&raw)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("error reading CBOR data: %w", err)
+		}
+		var obj StreamObject
+		if cbor.Unmarshal(raw, &obj) == nil && obj.Type != "" {
+			h, err := s.addStreamObject(obj)
+			if err != nil {
+				return plumbing.ZeroHash, err
+			}
+			if obj.Type == TypeCommit {
+				tip = h
+			}
+			continue
+		}
+		var commitData CommitData
+		err = cbor.Unmarshal(raw, &commitData)
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("error decoding CBOR data: %w", err)
+		}
+		tip, err = s.addCommit(commitData)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+	if tip.IsZero() {
+		return plumbing.ZeroHash, fmt.Errorf("no commit in input")
+	}
+	return tip, nil
+}
+
+// addCommit adds a commit document and the trees and blobs it carries.
+func (s *objectStore) addCommit(commitData CommitData) (plumbing.Hash, error) {
+	for _, treeData := range commitData.Trees {
+		h, err := treeData.Hash.GitHash()
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		s.trees[h] = treeData.Entries
+	}
+	for _, blobData := range commitData.Blobs {
+		h, err := blobData.Hash.GitHash()
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		s.blobs[h] = blobData.Content
+	}
+	h, err := commitData.Hash.GitHash()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	s.commits[h] = commitData
+	return h, nil
+}
+
+// addStreamObject adds one object from a history stream.
+func (s *objectStore) addStreamObject(obj StreamObject) (plumbing.Hash, error) {
+	h, err := obj.Hash.GitHash()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	switch obj.Type {
+	case TypeBlob:
+		s.blobs[h] = obj.Content
+	case TypeTree:
+		s.trees[h] = obj.Entries
+	case TypeCommit:
+		if obj.Commit == nil {
+			return plumbing.ZeroHash, fmt.Errorf("commit object has no commit data")
+		}
+		s.commits[h] = *obj.Commit
+	}
+	return h, nil
+}
+
+// commitTree returns the root tree hash of a commit in the store.
+func (s *objectStore) commitTree(h plumbing.Hash) (plumbing.Hash, error) {
+	commitData, ok := s.commits[h]
+	if !ok {
+		return plumbing.ZeroHash, fmt.Errorf("commit '%s' is not in the input", h)
+	}
+	return commitData.Tree.GitHash()
+}
+
+// entries returns the entries of a tree by name.  The zero hash
+// stands for an empty tree.
+func (s *objectStore) entries(h plumbing.Hash) (map[string]TreeEntry, error) {
+	m := make(map[string]TreeEntry)
+	if h.IsZero() {
+		return m, nil
+	}
+	entries, ok := s.trees[h]
+	if !ok {
+		return nil, fmt.Errorf("tree '%s' is not in the input", h)
+	}
+	for _, entry := range entries {
+		m[entry.Name] = entry
+	}
+	return m, nil
+}
+
+// isDir reports whether a tree entry is a subtree.
+func isDir(entry TreeEntry) bool {
+	mode, err := getFileMode(entry.Mode)
+	return err == nil && mode == filemode.Dir
+}
+
+// entryHash returns the git hash of a tree entry.
+func entryHash(entry TreeEntry) plumbing.Hash {
+	h, _ := entry.Hash.GitHash()
+	return h
+}
+
+// sameEntry reports whether two possibly missing entries are equal.
+func sameEntry(a, b *TreeEntry) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Mode == b.Mode && a.Hash.Equals(b.Hash.Cid)
+}
+
+// unionNames returns the sorted names present in any of the trees.
+func unionNames(trees ...map[string]TreeEntry) []string {
+	set := make(map[string]bool)
+	for _, t := range trees {
+		for name := range t {
+			set[name] = true
+		}
+	}
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookup returns a pointer to the named entry, or nil if it is absent.
+func lookup(t map[string]TreeEntry, name string) *TreeEntry {
+	entry, ok := t[name]
+	if !ok {
+		return nil
+	}
+	return &entry
+}
+
+// dirOrNone reports whether an entry is missing or a subtree.
+func dirOrNone(entry *TreeEntry) bool {
+	return entry == nil || isDir(*entry)
+}
+
+// dirHash returns the hash of a subtree entry, or the zero hash for
+// the empty tree if the entry is missing.
+func dirHash(entry *TreeEntry) plumbing.Hash {
+	if entry == nil {
+		return plumbing.ZeroHash
+	}
+	return entryHash(*entry)
+}
+
+// Change is one file-level difference between two trees.  Action is
+// "A" for an added file, "D" for a deleted file and "M" for a file
+// whose content or mode changed.
+type Change struct {
+	Action string
+	Path   string
+	From   *TreeEntry
+	To     *TreeEntry
+}
+
+// diffTrees returns the file-level changes from one tree to another.
+// Subtrees with the same hash on both sides are not descended into,
+// so only the trees that changed need to be in the store.
+func (s *objectStore) diffTrees(from, to plumbing.Hash, prefix string) ([]Change, error) {
+	if from == to {
+		return nil, nil
+	}
+	a, err := s.entries(from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := s.entries(to)
+	if err != nil {
+		return nil, err
+	}
+	var changes []Change
+	for _, name := range unionNames(a, b) {
+		ea, eb := lookup(a, name), lookup(b, name)
+		if sameEntry(ea, eb) {
+			continue
+		}
+		path := prefix + name
+		switch {
+		case ea != nil && eb != nil && isDir(*ea) && isDir(*eb):
+			more, err := s.diffTrees(entryHash(*ea), entryHash(*eb), path+"/")
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, more...)
+			continue
+		case ea != nil && eb != nil && !isDir(*ea) && !isDir(*eb):
+			changes = append(changes, Change{Action: "M", Path: path, From: ea, To: eb})
+			continue
+		}
+		// One side is missing, or a file replaced a directory.
+		if ea != nil {
+			if isDir(*ea) {
+				more, err := s.diffTrees(entryHash(*ea), plumbing.ZeroHash, path+"/")
+				if err != nil {
+					return nil, err
+				}
+				changes = append(changes, more...)
+			} else {
+				changes = append(changes, Change{Action: "D", Path: path, From: ea})
+			}
+		}
+		if eb != nil {
+			if isDir(*eb) {
+				more, err := s.diffTrees(plumbing.ZeroHash, entryHash(*eb), path+"/")
+				if err != nil {
+					return nil, err
+				}
+				changes = append(changes, more...)
+			} else {
+				changes = append(changes, Change{Action: "A", Path: path, To: eb})
+			}
+		}
+	}
+	return changes, nil
+}
+
+// diffCommits returns the file-level changes between two commits.
+func (s *objectStore) diffCommits(from, to plumbing.Hash) ([]Change, error) {
+	fromTree, err := s.commitTree(from)
+	if err != nil {
+		return nil, err
+	}
+	toTree, err := s.commitTree(to)
+	if err != nil {
+		return nil, err
+	}
+	return s.diffTrees(fromTree, toTree, "")
+}
+
+// writeDiff writes changes to w, one "action<TAB>path" line each.
+// Modified files whose content is in the store are followed by their
+// changed lines, prefixed with "-" and "+".
+func (s *objectStore) writeDiff(w io.Writer, changes []Change) error {
+	for _, c := range changes {
+		_, err := fmt.Fprintf(w, "%s\t%s\n", c.Action, c.Path)
+		if err != nil {
+			return err
+		}
+		if c.Action != "M" {
+			continue
+		}
+		if c.From.Mode != c.To.Mode {
+			_, err = fmt.Fprintf(w, "  mode %s -> %s\n", c.From.Mode, c.To.Mode)
+			if err != nil {
+				return err
+			}
+		}
+		src, okFrom := s.blobs[entryHash(*c.From)]
+		dst, okTo := s.blobs[entryHash(*c.To)]
+		if !okFrom || !okTo {
+			continue
+		}
+		for _, d := range diff.Do(string(src), string(dst)) {
+			var sign string
+			switch d.Type {
+			case diffmatchpatch.DiffDelete:
+				sign = "-"
+			case diffmatchpatch.DiffInsert:
+				sign = "+"
+			default:
+				continue
+			}
+			for _, line := range strings.SplitAfter(d.Text, "\n") {
+				if line == "" {
+					continue
+				}
+				_, err = fmt.Fprintf(w, "%s%s\n", sign, strings.TrimSuffix(line, "\n"))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// mergeTrees does a three-way merge of the ours and theirs trees
+// against base, and returns the hash of the merged tree.  Trees that
+// the merge creates are added to the store and appended to out.  Paths
+// changed differently on both sides are appended to conflicts; the
+// merged tree keeps our side of them.
+func (s *objectStore) mergeTrees(base, ours, theirs plumbing.Hash, prefix string, out *[]TreeData, conflicts *[]string) (plumbing.Hash, error) {
+	switch {
+	case ours == theirs, base == theirs:
+		return ours, nil
+	case base == ours:
+		return theirs, nil
+	}
+	b, err := s.entries(base)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	o, err := s.entries(ours)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	t, err := s.entries(theirs)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	var merged []TreeEntry
+	for _, name := range unionNames(b, o, t) {
+		eb, eo, et := lookup(b, name), lookup(o, name), lookup(t, name)
+		var keep *TreeEntry
+		switch {
+		case sameEntry(eo, et), sameEntry(eb, et):
+			keep = eo
+		case sameEntry(eb, eo):
+			keep = et
+		case dirOrNone(eb) && dirOrNone(eo) && dirOrNone(et):
+			// A directory changed on both sides, or emptied on one
+			// side and changed on the other.
+			sub, err := s.mergeTrees(dirHash(eb), dirHash(eo), dirHash(et), prefix+name+"/", out, conflicts)
+			if err != nil {
+				return plumbing.ZeroHash, err
+			}
+			if sub.IsZero() {
+				continue
+			}
+			keep = &TreeEntry{Mode: filemode.Dir.String(), Name: name, Hash: gitLink(sub)}
+		default:
+			*conflicts = append(*conflicts, prefix+name)
+			keep = eo
+			if keep == nil {
+				keep = et
+			}
+		}
+		if keep != nil {
+			merged = append(merged, TreeEntry{Mode: keep.Mode, Name: keep.Name, Hash: keep.Hash})
+		}
+	}
+	if len(merged) == 0 {
+		return plumbing.ZeroHash, nil
+	}
+
+	treeData, err := newTreeData(merged)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	h, _ := treeData.Hash.GitHash()
+	if _, ok := s.trees[h]; !ok {
+		s.trees[h] = treeData.Entries
+		*out = append(*out, treeData)
+	}
+	return h, nil
+}
+
+// newTreeData sorts tree entries into git order and computes the
+// tree's hash.
+func newTreeData(entries []TreeEntry) (TreeData, error) {
+	sortKey := func(e TreeEntry) string {
+		if isDir(e) {
+			return e.Name + "/"
+		}
+		return e.Name
+	}
+	sort.Slice(entries, func(i, j int) bool { return sortKey(entries[i]) < sortKey(entries[j]) })
+
+	var tree object.Tree
+	for _, entry := range entries {
+		mode, err := getFileMode(entry.Mode)
+		if err != nil {
+			return TreeData{}, fmt.Errorf("invalid mode '%s' for entry '%s': %w", entry.Mode, entry.Name, err)
+		}
+		tree.Entries = append(tree.Entries, object.TreeEntry{Name: entry.Name, Mode: mode, Hash: entryHash(entry)})
+	}
+	mo := plumbing.MemoryObject{}
+	err := tree.Encode(&mo)
+	if err != nil {
+		return TreeData{}, fmt.Errorf("failed to encode tree object: %w", err)
+	}
+	return TreeData{Hash: gitLink(mo.Hash()), Entries: entries}, nil
+}
+
+// mergeCommits merges the theirs commit into the ours commit, using
+// base as the common ancestor.  The result is a commit document with
+// both commits as parents that carries the trees the merge created;
+// blobs are not carried because every merged blob already exists on
+// one side.  Conflicting paths are returned alongside it.
+func (s *objectStore) mergeCommits(base, ours, theirs plumbing.Hash, author, committer object.Signature, message string) (CommitData, []string, error) {
+	var trees [3]plumbing.Hash
+	for i, h := range []plumbing.Hash{base, ours, theirs} {
+		var err error
+		trees[i], err = s.commitTree(h)
+		if err != nil {
+			return CommitData{}, nil, err
+		}
+	}
+	var conflicts []string
+	commitData := CommitData{
+		Parents:        gitLinks([]plumbing.Hash{ours, theirs}),
+		AuthorName:     author.Name,
+		AuthorEmail:    author.Email,
+		AuthorDate:     author.When,
+		CommitterName:  committer.Name,
+		CommitterEmail: committer.Email,
+		CommitterDate:  committer.When,
+		Message:        message,
+		Trees:          []TreeData{},
+		Blobs:          []BlobData{},
+		Branches:       make(map[string]Link),
+		Tags:           make(map[string]Link),
+	}
+	tree, err := s.mergeTrees(trees[0], trees[1], trees[2], "", &commitData.Trees, &conflicts)
+	if err != nil {
+		return CommitData{}, nil, err
+	}
+	if tree.IsZero() {
+		// Everything was deleted; git's empty tree is still a tree.
+		treeData, _ := newTreeData(nil)
+		commitData.Trees = append(commitData.Trees, treeData)
+		tree, _ = treeData.Hash.GitHash()
+	}
+	commitData.Tree = gitLink(tree)
+
+	commit, err := buildCommit(commitData)
+	if err != nil {
+		return CommitData{}, nil, err
+	}
+	h, err := recalculateCommitHash(commit)
+	if err != nil {
+		return CommitData{}, nil, err
+	}
+	commitData.Hash = gitLink(h)
+	s.commits[h] = commitData
+	return commitData, conflicts, nil
+}
+
+// loadFiles reads each named CBOR file into one store and returns the
+// commit each file stands for.
+func loadFiles(paths ...string) (*objectStore, []plumbing.Hash, error) {
+	s := newObjectStore()
+	var tips []plumbing.Hash
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		tip, err := s.load(f)
+		f.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+		tips = append(tips, tip)
+	}
+	return s, tips, nil
+}
+
+// cbordiff shows the file changes between the commits in two CBOR
+// files, each a commit document or a history stream.  Trees and blobs
+// are looked up in both files.
+func cbordiff(oldPath, newPath string) {
+	s, tips, err := loadFiles(oldPath, newPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading CBOR files: %v\n", err)
+		os.Exit(1)
+	}
+	changes, err := s.diffCommits(tips[0], tips[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error diffing commits: %v\n", err)
+		os.Exit(1)
+	}
+	w := bufio.NewWriter(os.Stdout)
+	err = s.writeDiff(w, changes)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing diff: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// cbormerge merges the commits in three CBOR files -- the common
+// ancestor, ours and theirs -- and writes the merge commit document
+// to stdout.  The committer is taken from GIT_COMMITTER_NAME and
+// GIT_COMMITTER_EMAIL, falling back to our commit's committer.  It
+// exits with status 1 and lists the conflicting paths if the trees
+// do not merge cleanly.
+func cbormerge(basePath, oursPath, theirsPath string) {
+	s, tips, err := loadFiles(basePath, oursPath, theirsPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading CBOR files: %v\n", err)
+		os.Exit(1)
+	}
+
+	ours := s.commits[tips[1]]
+	sig := object.Signature{
+		Name:  os.Getenv("GIT_COMMITTER_NAME"),
+		Email: os.Getenv("GIT_COMMITTER_EMAIL"),
+		When:  time.Now(),
+	}
+	if sig.Name == "" {
+		sig.Name = ours.CommitterName
+	}
+	if sig.Email == "" {
+		sig.Email = ours.CommitterEmail
+	}
+	message := fmt.Sprintf("Merge commit '%s'\n", tips[2])
+
+	commitData, conflicts, err := s.mergeCommits(tips[0], tips[1], tips[2], sig, sig, message)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error merging commits: %v\n", err)
+		os.Exit(1)
+	}
+	if len(conflicts) > 0 {
+		for _, path := range conflicts {
+			fmt.Fprintf(os.Stderr, "CONFLICT\t%s\n", path)
+		}
+		os.Exit(1)
+	}
+
+	cborBytes, err := encMode.Marshal(commitData)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error encoding commit data to CBOR: %v\n", err)
+		os.Exit(1)
+	}
+	_, err = os.Stdout.Write(cborBytes)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing CBOR to stdout: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// forkRepo makes a base commit and two divergent commits on top of
+// it, and returns a store loaded from the history streams of both
+// tips along with the three commit hashes.
+func forkRepo(t *testing.T, ours, theirs map[string]string) (*testRepo, *objectStore, [3]plumbing.Hash) {
+	r := newTestRepo(t)
+	var h [3]plumbing.Hash
+	h[0] = r.commit("base", map[string]string{"a.txt": "a\nb\nc\n", "dir/b.txt": "b", "dir/sub/c.txt": "c"})
+	h[1] = r.commit("ours", ours)
+	err := r.wt.Reset(&git.ResetOptions{Commit: h[0], Mode: git.HardReset})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h[2] = r.commit("theirs", theirs)
+
+	s := newObjectStore()
+	for _, tip := range h[1:] {
+		var buf bytes.Buffer
+		err = exportHistory(r.repo, tip.String(), &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.load(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tip {
+			t.Fatalf("expected stream to stand for %s, got %s", tip, got)
+		}
+	}
+	return r, s, h
+}
+
+// TestDiff checks file changes between two commits, including
+// directory deletion and line changes.
+func TestDiff(t *testing.T) {
+	_, s, h := forkRepo(t,
+		map[string]string{"a.txt": "a\nB\nc\n", "dir/sub/c.txt": "", "new.txt": "n"},
+		map[string]string{"dir/b.txt": "b2"})
+	changes, err := s.diffCommits(h[0], h[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range changes {
+		got = append(got, c.Action+" "+c.Path)
+	}
+	want := []string{"M a.txt", "D dir/sub/c.txt", "A new.txt"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected changes %v, got %v", want, got)
+	}
+
+	var buf bytes.Buffer
+	err = s.writeDiff(&buf, changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "M\ta.txt\n-b\n+B\n") {
+		t.Errorf("unexpected diff output:\n%s", buf.String())
+	}
+}
+
+// TestMerge merges non-overlapping changes, imports the merge commit
+// next to both sides, and checks the merged files.
+func TestMerge(t *testing.T) {
+	r, s, h := forkRepo(t,
+		map[string]string{"a.txt": "a\nB\nc\n", "dir/sub/d.txt": "d"},
+		map[string]string{"dir/b.txt": "b2", "dir/sub/c.txt": "", "e.txt": "e"})
+	sig := object.Signature{Name: "Bob", Email: "bob@example.com", When: r.when}
+	merge, conflicts, err := s.mergeCommits(h[0], h[1], h[2], sig, sig, "merge\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 0 {
+		t.Fatalf("unexpected conflicts %v", conflicts)
+	}
+	for _, treeData := range merge.Trees {
+		err = writeTreeToRepo(r.repo, treeData)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	mergeHash, err := importCommit(r.repo, merge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := r.repo.CommitObject(mergeHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.ParentHashes) != 2 || c.ParentHashes[0] != h[1] || c.ParentHashes[1] != h[2] {
+		t.Errorf("unexpected parents %v", c.ParentHashes)
+	}
+	want := map[string]string{
+		"a.txt":         "a\nB\nc\n",
+		"dir/b.txt":     "b2",
+		"dir/sub/d.txt": "d",
+		"e.txt":         "e",
+	}
+	got := make(map[string]string)
+	files, _ := c.Files()
+	files.ForEach(func(f *object.File) error {
+		got[f.Name], _ = f.Contents()
+		return nil
+	})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected files %v, got %v", want, got)
+	}
+}
+
+// TestMergeConflict checks that paths changed on both sides are
+// reported.
+func TestMergeConflict(t *testing.T) {
+	r, s, h := forkRepo(t,
+		map[string]string{"a.txt": "ours", "e.txt": "e"},
+		map[string]string{"a.txt": "theirs", "e.txt": "e", "dir/b.txt": ""})
+	sig := object.Signature{Name: "Bob", Email: "bob@example.com", When: r.when}
+	_, conflicts, err := s.mergeCommits(h[0], h[1], h[2], sig, sig, "merge\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(conflicts, []string{"a.txt"}) {
+		t.Errorf("expected conflict on a.txt, got %v", conflicts)
+	}
+}
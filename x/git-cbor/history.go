@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Object types in a history stream.
+const (
+	TypeBlob   = "blob"
+	TypeTree   = "tree"
+	TypeCommit = "commit"
+	TypeTag    = "tag"
+	TypeRef    = "ref"
+)
+
+// StreamObject is one item in a history stream.  A history stream is
+// a CBOR sequence (RFC 8742) of StreamObjects in dependency order:
+// every object appears after all of the objects it refers to, so a
+// receiver can write each object as it arrives.  Each object appears
+// at most once.
+//
+// Every StreamObject except a ref is a DAG-CBOR wrapper node for one
+// git object.  Hash is the git-raw CID of the git object, which is
+// what cbor2git uses to rebuild it.  Wrapper nodes also link to each
+// other by DAG-CBOR CID -- a commit to its root tree through
+// CommitData.TreeNode, a tree to its entries through TreeEntry.Node,
+// and a tag or ref to its target through Node -- so that IPLD tools can
+// traverse from a commit into its trees and blobs.
+type StreamObject struct {
+	Type string `cbor:"type"`
+	Hash Link   `cbor:"hash"`
+	// Content is the content of a blob.
+	Content []byte `cbor:"content,omitempty"`
+	// Entries are the entries of a tree.
+	Entries []TreeEntry `cbor:"entries,omitempty"`
+	// Commit is the metadata of a commit.  Its Trees, Blobs,
+	// Branches, Tags and TagObjects fields are unused.
+	Commit *CommitData `cbor:"commit,omitempty"`
+	// Tag is an annotated tag object.
+	Tag *TagData `cbor:"tag,omitempty"`
+	// Name is the full name of a ref, such as refs/heads/main.
+	Name string `cbor:"name,omitempty"`
+	// Node links a tag or ref to the wrapper node of its target.
+	Node *Link `cbor:"node,omitempty"`
+}
+
+// exportHistory writes a history stream for rev to w.  rev is either
+// a single revision, meaning its whole history, or a range
+// "base..tip", meaning the commits reachable from tip but not from
+// base.  Objects reachable from base are assumed to be present at the
+// receiver and are not emitted.  Refs that point at exported commits
+// are emitted last.
+func exportHistory(repo *git.Repository, rev string, w io.Writer) error {
+	base, tip, isRange := strings.Cut(rev, "..")
+	if !isRange {
+		tip, base = rev, ""
+	}
+	tipHash, err := repo.ResolveRevision(plumbing.Revision(tip))
+	if err != nil {
+		return fmt.Errorf("error resolving ref '%s': %w", tip, err)
+	}
+
+	exclude := make(map[plumbing.Hash]bool)
+	if base != "" {
+		baseHash, err := repo.ResolveRevision(plumbing.Revision(base))
+		if err != nil {
+			return fmt.Errorf("error resolving ref '%s': %w", base, err)
+		}
+		err = walkCommits(repo, *baseHash, nil, func(c *object.Commit) error {
+			exclude[c.Hash] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var commits []*object.Commit
+	err = walkCommits(repo, *tipHash, exclude, func(c *object.Commit) error {
+		commits = append(commits, c)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	ex := &exporter{
+		repo:  repo,
+		w:     w,
+		seen:  make(map[plumbing.Hash]bool),
+		nodes: make(map[plumbing.Hash]Link),
+	}
+	// Objects in the trees of excluded parents are already at the
+	// receiver.
+	for _, c := range commits {
+		for _, ph := range c.ParentHashes {
+			if !exclude[ph] || ex.seen[ph] {
+				continue
+			}
+			ex.seen[ph] = true
+			parent, err := repo.CommitObject(ph)
+			if err != nil {
+				return fmt.Errorf("error retrieving parent commit '%s': %w", ph, err)
+			}
+			err = ex.markTree(parent.TreeHash)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	exported := make(map[plumbing.Hash]bool)
+	for _, c := range commits {
+		err = ex.emitCommit(c)
+		if err != nil {
+			return err
+		}
+		exported[c.Hash] = true
+	}
+	return ex.emitRefs(exported)
+}
+
+// walkCommits calls fn for every commit reachable from start that is
+// not in exclude, parents before children.  Parents are visited in
+// order, so the result is deterministic.
+func walkCommits(repo *git.Repository, start plumbing.Hash, exclude map[plumbing.Hash]bool, fn func(*object.Commit) error) error {
+	type frame struct {
+		commit *object.Commit
+		next   int
+	}
+	visited := make(map[plumbing.Hash]bool)
+	push := func(stack []*frame, h plumbing.Hash) ([]*frame, error) {
+		if visited[h] || exclude[h] {
+			return stack, nil
+		}
+		visited[h] = true
+		c, err := repo.CommitObject(h)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving commit '%s': %w", h, err)
+		}
+		return append(stack, &frame{commit: c}), nil
+	}
+	stack, err := push(nil, start)
+	if err != nil {
+		return err
+	}
+	for len(stack) > 0 {
+		top := stack[len(stack)-1]
+		if top.next < len(top.commit.ParentHashes) {
+			ph := top.commit.ParentHashes[top.next]
+			top.next++
+			stack, err = push(stack, ph)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		stack = stack[:len(stack)-1]
+		err = fn(top.commit)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exporter writes StreamObjects, skipping objects it has already
+// written or that the receiver already has.  It remembers the
+// DAG-CBOR CID of every wrapper node it builds, whether or not the
+// node was written, so that new nodes can link to unchanged ones.
+type exporter struct {
+	repo  *git.Repository
+	w     io.Writer
+	seen  map[plumbing.Hash]bool
+	nodes map[plumbing.Hash]Link
+}
+
+// markTree records a tree and everything under it as present at the
+// receiver.
+func (ex *exporter) markTree(treeHash plumbing.Hash) error {
+	if ex.seen[treeHash] {
+		return nil
+	}
+	tree, err := ex.repo.TreeObject(treeHash)
+	if err != nil {
+		return fmt.Errorf("error retrieving tree object '%s': %w", treeHash, err)
+	}
+	ex.seen[treeHash] = true
+	for _, entry := range tree.Entries {
+		if entry.Mode == filemode.Dir {
+			err = ex.markTree(entry.Hash)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		ex.seen[entry.Hash] = true
+	}
+	return nil
+}
+
+// emit encodes a wrapper node for the git object h, writes it unless
+// the receiver already has it, and returns its DAG-CBOR CID.
+func (ex *exporter) emit(h plumbing.Hash, obj StreamObject) (Link, error) {
+	buf, err := encMode.Marshal(obj)
+	if err != nil {
+		return Link{}, err
+	}
+	link := nodeLink(buf)
+	ex.nodes[h] = link
+	if !ex.seen[h] {
+		ex.seen[h] = true
+		_, err = ex.w.Write(buf)
+		if err != nil {
+			return Link{}, err
+		}
+	}
+	return link, nil
+}
+
+// emitCommit writes the new blobs and trees of a commit, followed by
+// the commit itself.
+func (ex *exporter) emitCommit(c *object.Commit) error {
+	treeNode, err := ex.emitTree(c.TreeHash)
+	if err != nil {
+		return err
+	}
+	commitData, err := newCommitData(ex.repo, c)
+	if err != nil {
+		return err
+	}
+	commitData.TreeNode = &treeNode
+	_, err = ex.emit(c.Hash, StreamObject{
+		Type:   TypeCommit,
+		Hash:   gitLink(c.Hash),
+		Commit: &commitData,
+	})
+	return err
+}
+
+// emitTree writes a tree after any of its blobs and subtrees that
+// have not been written yet, and returns the tree's node link.
+func (ex *exporter) emitTree(treeHash plumbing.Hash) (Link, error) {
+	if link, ok := ex.nodes[treeHash]; ok {
+		return link, nil
+	}
+	tree, err := ex.repo.TreeObject(treeHash)
+	if err != nil {
+		return Link{}, fmt.Errorf("error retrieving tree object '%s': %w", treeHash, err)
+	}
+	obj := StreamObject{Type: TypeTree, Hash: gitLink(treeHash), Entries: []TreeEntry{}}
+	for _, entry := range tree.Entries {
+		treeEntry := TreeEntry{
+			Mode: entry.Mode.String(),
+			Name: entry.Name,
+			Hash: gitLink(entry.Hash),
+		}
+		var node Link
+		switch {
+		case entry.Mode == filemode.Dir:
+			node, err = ex.emitTree(entry.Hash)
+			treeEntry.Node = &node
+		case entry.Mode.IsFile() || entry.Mode == filemode.Symlink:
+			node, err = ex.emitBlob(entry.Hash)
+			treeEntry.Node = &node
+		}
+		if err != nil {
+			return Link{}, err
+		}
+		obj.Entries = append(obj.Entries, treeEntry)
+	}
+	return ex.emit(treeHash, obj)
+}
+
+// emitBlob writes a blob if it has not been written yet, and returns
+// the blob's node link.
+func (ex *exporter) emitBlob(blobHash plumbing.Hash) (Link, error) {
+	if link, ok := ex.nodes[blobHash]; ok {
+		return link, nil
+	}
+	blob, err := ex.repo.BlobObject(blobHash)
+	if err != nil {
+		return Link{}, fmt.Errorf("error retrieving blob object '%s': %w", blobHash, err)
+	}
+	return ex.emit(blobHash, StreamObject{
+		Type:    TypeBlob,
+		Hash:    gitLink(blobHash),
+		Content: getBlobContent(blob),
+	})
+}
+
+// emitRefs writes every branch and tag that points at an exported
+// commit, in sorted order.  Annotated tag objects are written just
+// before the first ref that needs them.
+func (ex *exporter) emitRefs(exported map[plumbing.Hash]bool) error {
+	refs, err := ex.repo.References()
+	if err != nil {
+		return fmt.Errorf("error retrieving references: %w", err)
+	}
+	var names []plumbing.ReferenceName
+	hashes := make(map[plumbing.ReferenceName]plumbing.Hash)
+	tags := make(map[plumbing.ReferenceName][]TagData)
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		if !ref.Name().IsBranch() && !ref.Name().IsTag() {
+			return nil
+		}
+		target := ref.Hash()
+		tagObjects, err := collectTagObjects(ex.repo, target)
+		if err != nil {
+			return err
+		}
+		if len(tagObjects) > 0 {
+			target, err = tagObjects[len(tagObjects)-1].Target.GitHash()
+			if err != nil {
+				return err
+			}
+		}
+		if !exported[target] {
+			return nil
+		}
+		names = append(names, ref.Name())
+		hashes[ref.Name()] = ref.Hash()
+		tags[ref.Name()] = tagObjects
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error iterating references: %w", err)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	for _, name := range names {
+		// Tags of tags are written innermost first.
+		tagObjects := tags[name]
+		for i := len(tagObjects) - 1; i >= 0; i-- {
+			tagData := tagObjects[i]
+			target, err := tagData.Target.GitHash()
+			if err != nil {
+				return err
+			}
+			hash, err := tagData.Hash.GitHash()
+			if err != nil {
+				return err
+			}
+			if _, ok := ex.nodes[hash]; ok {
+				continue
+			}
+			node := ex.nodes[target]
+			_, err = ex.emit(hash, StreamObject{
+				Type: TypeTag,
+				Hash: tagData.Hash,
+				Tag:  &tagData,
+				Node: &node,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		node := ex.nodes[hashes[name]]
+		buf, err := encMode.Marshal(StreamObject{
+			Type: TypeRef,
+			Hash: gitLink(hashes[name]),
+			Name: name.String(),
+			Node: &node,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = ex.w.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importStream reads a history stream from r and writes each object
+// to repo as it arrives, verifying that every object hashes to the
+// hash it was sent under.  Refs are only moved forward unless force
+// is set; see importRef.  It returns the number of objects imported.
+func importStream(repo *git.Repository, r io.Reader, force bool) (int, error) {
+	dec := cbor.NewDecoder(bufio.NewReader(r))
+	n := 0
+	for {
+		var obj StreamObject
+		err := dec.Decode(&obj)
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, fmt.Errorf("error decoding stream object %d: %w", n, err)
+		}
+		err = importObject(repo, obj, force)
+		if err != nil {
+			return n, fmt.Errorf("error importing %s '%s': %w", obj.Type, obj.Hash.Hex(), err)
+		}
+		n++
+	}
+}
+
+// importObject writes a single StreamObject to repo.  Objects whose
+// content does not hash to obj.Hash are rejected before they are
+// stored.
+func importObject(repo *git.Repository, obj StreamObject, force bool) error {
+	switch obj.Type {
+	case TypeBlob:
+		return writeBlobToRepo(repo, BlobData{Hash: obj.Hash, Content: obj.Content})
+	case TypeTree:
+		return writeTreeToRepo(repo, TreeData{Hash: obj.Hash, Entries: obj.Entries})
+	case TypeCommit:
+		if obj.Commit == nil {
+			return fmt.Errorf("commit object has no commit data")
+		}
+		if !obj.Commit.Hash.Equals(obj.Hash.Cid) {
+			return fmt.Errorf("commit data hash '%s' does not match", obj.Commit.Hash.Hex())
+		}
+		_, err := importCommit(repo, *obj.Commit)
+		return err
+	case TypeTag:
+		if obj.Tag == nil {
+			return fmt.Errorf("tag object has no tag data")
+		}
+		if !obj.Tag.Hash.Equals(obj.Hash.Cid) {
+			return fmt.Errorf("tag data hash '%s' does not match", obj.Tag.Hash.Hex())
+		}
+		return writeTagToRepo(repo, *obj.Tag)
+	case TypeRef:
+		hash, err := obj.Hash.GitHash()
+		if err != nil {
+			return err
+		}
+		return importRef(repo, plumbing.ReferenceName(obj.Name), hash, force)
+	}
+	return fmt.Errorf("unknown object type '%s'", obj.Type)
+}
+
+// importRef points the ref name at hash.  The name must be a valid
+// ref under refs/ and the object must already be in the repository.
+// An existing ref is only moved if the update is a fast-forward: both
+// old and new values peel to commits and the old commit is an
+// ancestor of the new one.  force skips the fast-forward check.
+func importRef(repo *git.Repository, name plumbing.ReferenceName, hash plumbing.Hash, force bool) error {
+	if !strings.HasPrefix(name.String(), "refs/") || name.Validate() != nil {
+		return fmt.Errorf("invalid ref name '%s'", name)
+	}
+	if repo.Storer.HasEncodedObject(hash) != nil {
+		return fmt.Errorf("ref '%s' points at missing object '%s'", name, hash)
+	}
+	old, err := repo.Storer.Reference(name)
+	switch {
+	case err == plumbing.ErrReferenceNotFound:
+	case err != nil:
+		return err
+	case force || old.Hash() == hash:
+	default:
+		ff, err := isFastForward(repo, old, hash)
+		if err != nil {
+			return err
+		}
+		if !ff {
+			return fmt.Errorf("ref '%s' update from '%s' to '%s' is not a fast-forward", name, old.Hash(), hash)
+		}
+	}
+	return repo.Storer.SetReference(plumbing.NewHashReference(name, hash))
+}
+
+// isFastForward reports whether moving old to hash is a fast-forward.
+// Symbolic refs and refs to anything but commits never are.
+func isFastForward(repo *git.Repository, old *plumbing.Reference, hash plumbing.Hash) (bool, error) {
+	if old.Type() != plumbing.HashReference {
+		return false, nil
+	}
+	from, err := peelCommit(repo, old.Hash())
+	if err != nil {
+		return false, nil
+	}
+	to, err := peelCommit(repo, hash)
+	if err != nil {
+		return false, nil
+	}
+	return from.IsAncestor(to)
+}
+
+// peelCommit returns the commit an object is or, through annotated
+// tags, points at.
+func peelCommit(repo *git.Repository, hash plumbing.Hash) (*object.Commit, error) {
+	for {
+		tag, err := repo.TagObject(hash)
+		if err != nil {
+			return repo.CommitObject(hash)
+		}
+		hash = tag.Target
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+// CIDTag is the CBOR tag for an IPLD link (CID) in DAG-CBOR.
+const CIDTag = 42
+
+// Link is an IPLD link.  In CBOR it is encoded the DAG-CBOR way, as
+// tag 42 wrapping the binary CID prefixed with a zero byte; in JSON
+// it is encoded the DAG-JSON way, as {"/": "<cid>"}.
+//
+// Git objects are linked by CIDs with the git-raw multicodec and a
+// sha1 multihash, so the CID carries the git object hash.  The CBOR
+// wrapper nodes that git2cbor emits are linked by DAG-CBOR CIDs.
+type Link struct {
+	cid.Cid
+}
+
+// gitLink returns the git-raw CID for a git object hash.
+func gitLink(h plumbing.Hash) Link {
+	mh, err := multihash.Encode(h[:], multihash.SHA1)
+	if err != nil {
+		// Only possible for an unknown multihash code.
+		panic(err)
+	}
+	return Link{cid.NewCidV1(cid.GitRaw, mh)}
+}
+
+// gitLinks returns the git-raw CIDs for a list of git object hashes.
+func gitLinks(hs []plumbing.Hash) []Link {
+	links := make([]Link, len(hs))
+	for i, h := range hs {
+		links[i] = gitLink(h)
+	}
+	return links
+}
+
+// nodeLink returns the DAG-CBOR CID of an encoded wrapper node.
+func nodeLink(buf []byte) Link {
+	mh, err := multihash.Sum(buf, multihash.SHA2_256, -1)
+	if err != nil {
+		panic(err)
+	}
+	return Link{cid.NewCidV1(cid.DagCBOR, mh)}
+}
+
+// GitHash returns the git object hash carried by a git-raw CID.
+func (l Link) GitHash() (plumbing.Hash, error) {
+	if !l.Defined() {
+		return plumbing.ZeroHash, fmt.Errorf("undefined link")
+	}
+	if l.Prefix().Codec != cid.GitRaw {
+		return plumbing.ZeroHash, fmt.Errorf("link %s is not a git-raw CID", l)
+	}
+	dmh, err := multihash.Decode(l.Hash())
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if dmh.Code != multihash.SHA1 || len(dmh.Digest) != len(plumbing.ZeroHash) {
+		return plumbing.ZeroHash, fmt.Errorf("link %s is not a sha1 multihash", l)
+	}
+	var h plumbing.Hash
+	copy(h[:], dmh.Digest)
+	return h, nil
+}
+
+// Hex returns the git object hash in hex for git-raw links and the
+// CID string otherwise.  It is meant for display.
+func (l Link) Hex() string {
+	h, err := l.GitHash()
+	if err != nil {
+		return l.String()
+	}
+	return h.String()
+}
+
+// MarshalCBOR implements cbor.Marshaler.
+func (l Link) MarshalCBOR() ([]byte, error) {
+	if !l.Defined() {
+		return nil, fmt.Errorf("cannot encode undefined link")
+	}
+	return cbor.Marshal(cbor.Tag{Number: CIDTag, Content: append([]byte{0}, l.Bytes()...)})
+}
+
+// UnmarshalCBOR implements cbor.Unmarshaler.
+func (l *Link) UnmarshalCBOR(buf []byte) error {
+	var tag cbor.Tag
+	err := cbor.Unmarshal(buf, &tag)
+	if err != nil {
+		return err
+	}
+	content, ok := tag.Content.([]byte)
+	if tag.Number != CIDTag || !ok || len(content) == 0 || content[0] != 0 {
+		return fmt.Errorf("not a DAG-CBOR link")
+	}
+	c, err := cid.Cast(content[1:])
+	if err != nil {
+		return err
+	}
+	l.Cid = c
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (l Link) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{"/": l.String()})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (l *Link) UnmarshalJSON(buf []byte) error {
+	var m map[string]string
+	err := json.Unmarshal(buf, &m)
+	if err != nil {
+		return err
+	}
+	c, err := cid.Decode(m["/"])
+	if err != nil {
+		return err
+	}
+	l.Cid = c
+	return nil
+}
+
+// encMode encodes wrapper nodes as DAG-CBOR: map keys are sorted
+// length-first, and times are RFC 3339 strings so that the committer's
+// time zone, which is part of the git commit hash, survives the round
+// trip.
+var encMode = func() cbor.EncMode {
+	opts := cbor.CanonicalEncOptions()
+	opts.Time = cbor.TimeRFC3339Nano
+	em, err := opts.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	return em
+}()
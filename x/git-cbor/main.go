@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -19,26 +21,43 @@ import (
 )
 
 // CommitData represents the structure of a commit in CBOR format.
+// References to git objects are IPLD links holding git-raw CIDs; see
+// Link.
 type CommitData struct {
-	Hash           string            `cbor:"hash"`
-	Tree           string            `cbor:"tree"`
-	Parents        []string          `cbor:"parents"`
-	AuthorName     string            `cbor:"author_name"`
-	AuthorEmail    string            `cbor:"author_email"`
-	AuthorDate     time.Time         `cbor:"author_date"`
-	CommitterName  string            `cbor:"committer_name"`
-	CommitterEmail string            `cbor:"committer_email"`
-	CommitterDate  time.Time         `cbor:"committer_date"`
-	Message        string            `cbor:"message"`
-	Trees          []TreeData        `cbor:"trees"`
-	Blobs          []BlobData        `cbor:"blobs"`
-	Branches       map[string]string `cbor:"branches"`
-	Tags           map[string]string `cbor:"tags"`
+	Hash           Link            `cbor:"hash"`
+	Tree           Link            `cbor:"tree"`
+	Parents        []Link          `cbor:"parents"`
+	AuthorName     string          `cbor:"author_name"`
+	AuthorEmail    string          `cbor:"author_email"`
+	AuthorDate     time.Time       `cbor:"author_date"`
+	CommitterName  string          `cbor:"committer_name"`
+	CommitterEmail string          `cbor:"committer_email"`
+	CommitterDate  time.Time       `cbor:"committer_date"`
+	Message        string          `cbor:"message"`
+	Trees          []TreeData      `cbor:"trees"`
+	Blobs          []BlobData      `cbor:"blobs"`
+	Branches       map[string]Link `cbor:"branches"`
+	Tags           map[string]Link `cbor:"tags"`
+	// MergeTag, PGPSignature and Encoding hold the mergetag, gpgsig
+	// and encoding headers of a commit, if it has them.
+	MergeTag     string `cbor:"mergetag,omitempty"`
+	PGPSignature string `cbor:"gpgsig,omitempty"`
+	Encoding     string `cbor:"encoding,omitempty"`
+	// Raw is the exact content of a commit that cannot be rebuilt
+	// from the fields above, such as one with unknown headers.  When
+	// present, cbor2git stores it as is.
+	Raw []byte `cbor:"raw,omitempty"`
+	// TagObjects are the annotated tag objects that Tags point at,
+	// including tags of tags.
+	TagObjects []TagData `cbor:"tag_objects,omitempty"`
+	// TreeNode links to the DAG-CBOR wrapper node of the root tree
+	// in a history stream.
+	TreeNode *Link `cbor:"tree_node,omitempty"`
 }
 
 // TreeData represents the structure of a tree object in CBOR format.
 type TreeData struct {
-	Hash    string      `cbor:"hash"`
+	Hash    Link        `cbor:"hash"`
 	Entries []TreeEntry `cbor:"entries"`
 }
 
@@ -46,12 +65,15 @@ type TreeData struct {
 type TreeEntry struct {
 	Mode string `cbor:"mode"`
 	Name string `cbor:"name"`
-	Hash string `cbor:"hash"`
+	Hash Link   `cbor:"hash"`
+	// Node links to the DAG-CBOR wrapper node of the entry in a
+	// history stream.
+	Node *Link `cbor:"node,omitempty"`
 }
 
 // BlobData represents the structure of a blob object in CBOR format.
 type BlobData struct {
-	Hash    string `cbor:"hash"`
+	Hash    Link   `cbor:"hash"`
 	Content []byte `cbor:"content"`
 }
 
@@ -60,10 +82,13 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Usage: %s <subcommand> [options]\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "Subcommands:\n")
 		fmt.Fprintf(os.Stderr, "  git2cbor <ref>\n")
-		fmt.Fprintf(os.Stderr, "  cbor2git\n")
+		fmt.Fprintf(os.Stderr, "  git2cbor --history <ref>|<base>..<tip>\n")
+		fmt.Fprintf(os.Stderr, "  cbor2git [--force]\n")
 		fmt.Fprintf(os.Stderr, "  cbor2diag\n")
 		fmt.Fprintf(os.Stderr, "  cbor2json\n")
 		fmt.Fprintf(os.Stderr, "  cbor2dot\n")
+		fmt.Fprintf(os.Stderr, "  cbordiff <old.cbor> <new.cbor>\n")
+		fmt.Fprintf(os.Stderr, "  cbormerge <base.cbor> <ours.cbor> <theirs.cbor>\n")
 		os.Exit(1)
 	}
 
@@ -71,19 +96,39 @@ func main() {
 
 	switch subcommand {
 	case "git2cbor":
-		if len(os.Args) < 3 {
-			fmt.Fprintf(os.Stderr, "Usage: %s git2cbor <ref>\n", os.Args[0])
+		if len(os.Args) == 4 && os.Args[2] == "--history" {
+			git2cborHistory(os.Args[3])
+			break
+		}
+		if len(os.Args) != 3 {
+			fmt.Fprintf(os.Stderr, "Usage: %s git2cbor [--history] <ref>\n", os.Args[0])
 			os.Exit(1)
 		}
 		git2cbor(os.Args[2])
 	case "cbor2git":
-		cbor2git()
+		if len(os.Args) > 3 || (len(os.Args) == 3 && os.Args[2] != "--force") {
+			fmt.Fprintf(os.Stderr, "Usage: %s cbor2git [--force]\n", os.Args[0])
+			os.Exit(1)
+		}
+		cbor2git(len(os.Args) == 3)
 	case "cbor2diag":
 		cbor2diag()
 	case "cbor2json":
 		cbor2json()
 	case "cbor2dot":
 		cbor2dot()
+	case "cbordiff":
+		if len(os.Args) != 4 {
+			fmt.Fprintf(os.Stderr, "Usage: %s cbordiff <old.cbor> <new.cbor>\n", os.Args[0])
+			os.Exit(1)
+		}
+		cbordiff(os.Args[2], os.Args[3])
+	case "cbormerge":
+		if len(os.Args) != 5 {
+			fmt.Fprintf(os.Stderr, "Usage: %s cbormerge <base.cbor> <ours.cbor> <theirs.cbor>\n", os.Args[0])
+			os.Exit(1)
+		}
+		cbormerge(os.Args[2], os.Args[3], os.Args[4])
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown subcommand: %s\n", subcommand)
 		os.Exit(1)
@@ -120,67 +165,64 @@ func git2cbor(ref string) {
 		os.Exit(1)
 	}
 
+	commitData, err := exportDocument(repo, commit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error exporting commit: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Encode the CommitData to CBOR
+	cborBytes, err := encMode.Marshal(commitData)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error encoding commit data to CBOR: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Write the CBOR data to stdout
+	_, err = os.Stdout.Write(cborBytes)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing CBOR to stdout: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// exportDocument builds the single commit document for commit: the
+// commit, its new and changed trees and blobs, and every branch and
+// tag in repo.
+func exportDocument(repo *git.Repository, commit *object.Commit) (CommitData, error) {
 	// Populate the CommitData struct
-	commitData := CommitData{
-		Hash:           hash.String(),
-		Tree:           commit.TreeHash.String(),
-		Parents:        make([]string, len(commit.ParentHashes)),
-		AuthorName:     commit.Author.Name,
-		AuthorEmail:    commit.Author.Email,
-		AuthorDate:     commit.Author.When,
-		CommitterName:  commit.Committer.Name,
-		CommitterEmail: commit.Committer.Email,
-		CommitterDate:  commit.Committer.When,
-		Message:        commit.Message,
-		Trees:          []TreeData{},
-		Blobs:          []BlobData{},
-		Branches:       make(map[string]string),
-		Tags:           make(map[string]string),
-	}
-
-	for i, parentHash := range commit.ParentHashes {
-		commitData.Parents[i] = parentHash.String()
+	commitData, err := newCommitData(repo, commit)
+	if err != nil {
+		return commitData, fmt.Errorf("error reading commit: %w", err)
 	}
+	commitData.Trees = []TreeData{}
+	commitData.Blobs = []BlobData{}
+	commitData.Branches = make(map[string]Link)
+	commitData.Tags = make(map[string]Link)
 
 	// Collect branches and tags
 	err = collectBranchesAndTags(repo, &commitData)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error collecting branches and tags: %v\n", err)
-		os.Exit(1)
+		return commitData, err
 	}
 
 	// Collect trees from parent commits
 	parentTrees, err := collectParentTrees(repo, commit)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error collecting parent trees: %v\n", err)
-		os.Exit(1)
+		return commitData, fmt.Errorf("error collecting parent trees: %w", err)
 	}
 
 	// Collect new and changed tree objects
 	if err := collectNewAndChangedTrees(repo, commit.TreeHash, &commitData.Trees, parentTrees); err != nil {
-		fmt.Fprintf(os.Stderr, "Error collecting tree objects: %v\n", err)
-		os.Exit(1)
+		return commitData, fmt.Errorf("error collecting tree objects: %w", err)
 	}
 
 	// Collect blob objects from the new and changed trees
 	if err := collectBlobs(repo, commit.TreeHash, &commitData.Blobs); err != nil {
-		fmt.Fprintf(os.Stderr, "Error collecting blob objects: %v\n", err)
-		os.Exit(1)
-	}
-
-	// Encode the CommitData to CBOR
-	cborBytes, err := cbor.Marshal(commitData)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error encoding commit data to CBOR: %v\n", err)
-		os.Exit(1)
+		return commitData, fmt.Errorf("error collecting blob objects: %w", err)
 	}
 
-	// Write the CBOR data to stdout
-	_, err = os.Stdout.Write(cborBytes)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error writing CBOR to stdout: %v\n", err)
-		os.Exit(1)
-	}
+	return commitData, nil
 }
 
 // collectParentTrees collects all tree hashes from parent commits.
@@ -232,7 +274,7 @@ func collectNewAndChangedTrees(repo *git.Repository, currentTreeHash plumbing.Ha
 	}
 
 	treeData := TreeData{
-		Hash:    tree.Hash.String(),
+		Hash:    gitLink(tree.Hash),
 		Entries: []TreeEntry{},
 	}
 
@@ -240,7 +282,7 @@ func collectNewAndChangedTrees(repo *git.Repository, currentTreeHash plumbing.Ha
 		treeEntry := TreeEntry{
 			Mode: entry.Mode.String(),
 			Name: entry.Name,
-			Hash: entry.Hash.String(),
+			Hash: gitLink(entry.Hash),
 		}
 		treeData.Entries = append(treeData.Entries, treeEntry)
 
@@ -275,7 +317,7 @@ func collectBlobs(repo *git.Repository, treeHash plumbing.Hash, blobs *[]BlobDat
 				return fmt.Errorf("error retrieving blob object '%s': %w", entry.Hash.String(), err)
 			}
 			blobData := BlobData{
-				Hash:    blob.Hash.String(),
+				Hash:    gitLink(blob.Hash),
 				Content: getBlobContent(blob),
 			}
 			// Avoid duplicate blobs
@@ -302,32 +344,49 @@ func getBlobContent(blob *object.Blob) (content []byte) {
 }
 
 // blobExists checks if a blob with the given hash already exists in the blobs slice.
-func blobExists(blobs []BlobData, hash string) bool {
+func blobExists(blobs []BlobData, hash Link) bool {
 	for _, b := range blobs {
-		if b.Hash == hash {
+		if b.Hash.Equals(hash.Cid) {
 			return true
 		}
 	}
 	return false
 }
 
-// cbor2git converts CBOR data to a git commit object, including tree and blob objects.
-func cbor2git() {
-	// Read CBOR data from stdin
-	cborData, err := io.ReadAll(os.Stdin)
+// git2cborHistory writes a history stream for a revision or
+// revision range to stdout.  See exportHistory.
+func git2cborHistory(rev string) {
+	// Find the Git repository directory by walking up the directory tree
+	repoPath, err := findGitRepo()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading CBOR data: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error finding .git directory: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Decode CBOR data into CommitData
-	var commitData CommitData
-	err = cbor.Unmarshal(cborData, &commitData)
+	// Open the Git repository
+	repo, err := git.PlainOpen(repoPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error decoding CBOR data: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error opening repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	err = exportHistory(repo, rev, w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error exporting history: %v\n", err)
 		os.Exit(1)
 	}
+}
 
+// cbor2git converts CBOR data to a git commit object, including tree
+// and blob objects.  If stdin holds a history stream rather than a
+// single commit document, the objects in the stream are imported one
+// at a time as they are read.  Either way, refs are only moved forward
+// unless force is set.
+func cbor2git(force bool) {
 	// Find the Git repository directory by walking up the directory tree
 	repoPath, err := findGitRepo()
 	if err != nil {
@@ -342,64 +401,115 @@ func cbor2git() {
 		os.Exit(1)
 	}
 
+	// Read the first CBOR data item from stdin to tell a history
+	// stream from a single commit document
+	in := bufio.NewReader(os.Stdin)
+	dec := cbor.NewDecoder(in)
+	var first cbor.RawMessage
+	err = dec.Decode(&first)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading CBOR data: %v\n", err)
+		os.Exit(1)
+	}
+	var obj StreamObject
+	if cbor.Unmarshal(first, &obj) == nil && obj.Type != "" {
+		rest := io.MultiReader(bytes.NewReader(first), dec.Buffered(), in)
+		n, err := importStream(repo, rest, force)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error importing stream: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Successfully stored %d objects\n", n)
+		return
+	}
+
+	// Decode CBOR data into CommitData
+	var commitData CommitData
+	err = cbor.Unmarshal(first, &commitData)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error decoding CBOR data: %v\n", err)
+		os.Exit(1)
+	}
+
+	computedHash, err := importDocument(repo, commitData, force)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error importing commit: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Successfully stored commit %s\n", computedHash.String())
+}
+
+// importDocument writes the objects of a single commit document to
+// repo and recreates its branches and tags, which are only moved
+// forward unless force is set.  It returns the commit's hash.
+func importDocument(repo *git.Repository, commitData CommitData, force bool) (plumbing.Hash, error) {
 	// Write blob objects to the repository
 	for _, blobData := range commitData.Blobs {
 		if err := writeBlobToRepo(repo, blobData); err != nil {
-			fmt.Fprintf(os.Stderr, "Error writing blob '%s' to repository: %v\n", blobData.Hash, err)
-			os.Exit(1)
+			return plumbing.ZeroHash, fmt.Errorf("error writing blob '%s' to repository: %w", blobData.Hash, err)
 		}
 	}
 
 	// Write tree objects to the repository
 	for _, treeData := range commitData.Trees {
 		if err := writeTreeToRepo(repo, treeData); err != nil {
-			fmt.Fprintf(os.Stderr, "Error writing tree '%s' to repository: %v\n", treeData.Hash, err)
-			os.Exit(1)
+			return plumbing.ZeroHash, fmt.Errorf("error writing tree '%s' to repository: %w", treeData.Hash, err)
 		}
 	}
 
-	// Prepare parent commits
-	var parentCommits []*object.Commit
-	for _, ph := range commitData.Parents {
-		pHash := plumbing.NewHash(ph)
-		parentCommit, err := repo.CommitObject(pHash)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error retrieving parent commit '%s': %v\n", ph, err)
-			os.Exit(1)
+	// Write the commit object to the repository
+	computedHash, err := importCommit(repo, commitData)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("error storing commit: %w", err)
+	}
+
+	// Write annotated tag objects
+	for _, tagData := range commitData.TagObjects {
+		if err := writeTagToRepo(repo, tagData); err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("error writing tag '%s' to repository: %w", tagData.Name, err)
 		}
-		parentCommits = append(parentCommits, parentCommit)
 	}
 
-	// Prepare the tree
-	treeHash := plumbing.NewHash(commitData.Tree)
-	_, err = repo.TreeObject(treeHash)
+	// Recreate branches and tags
+	err = recreateBranchesAndTags(repo, commitData.Branches, commitData.Tags, force)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error retrieving tree object: %v\n", err)
-		os.Exit(1)
+		return plumbing.ZeroHash, fmt.Errorf("error recreating branches and tags: %w", err)
 	}
 
-	// Create author and committer signatures
-	author := &object.Signature{
-		Name:  commitData.AuthorName,
-		Email: commitData.AuthorEmail,
-		When:  commitData.AuthorDate,
-	}
-	committer := &object.Signature{
-		Name:  commitData.CommitterName,
-		Email: commitData.CommitterEmail,
-		When:  commitData.CommitterDate,
+	return computedHash, nil
+}
+
+// importCommit rebuilds a commit object from commitData, verifies
+// that it hashes to commitData.Hash, and writes it to the repository.
+// The commit's tree and parents must already be in the repository.
+func importCommit(repo *git.Repository, commitData CommitData) (hash plumbing.Hash, err error) {
+	defer Return(&err)
+
+	commit, err := buildCommit(commitData)
+	if err != nil {
+		return plumbing.ZeroHash, err
 	}
 
-	// Prepare parent hashes
-	parentHashes := parentCommitHashes(parentCommits)
+	// Check that the tree and parents are present
+	_, err = repo.TreeObject(commit.TreeHash)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("error retrieving tree object: %w", err)
+	}
+	for _, pHash := range commit.ParentHashes {
+		_, err = repo.CommitObject(pHash)
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("error retrieving parent commit '%s': %w", pHash, err)
+		}
+	}
 
-	// Create the commit object
-	commit := &object.Commit{
-		Author:       *author,
-		Committer:    *committer,
-		Message:      commitData.Message,
-		TreeHash:     treeHash,
-		ParentHashes: parentHashes,
+	// Commits that cannot be rebuilt from their fields are stored as is
+	if commitData.Raw != nil {
+		err = writeRawObject(repo, plumbing.CommitObject, commitData.Raw, commitData.Hash)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		return commitData.Hash.GitHash()
 	}
 
 	// Compute the commit hash to verify
@@ -407,26 +517,16 @@ func cbor2git() {
 	Ck(err)
 
 	// Verify that the computed hash matches the provided hash
-	if computedHash.String() != commitData.Hash {
-		fmt.Fprintf(os.Stderr, "Computed hash '%s' does not match provided hash '%s'\n", computedHash.String(), commitData.Hash)
-		os.Exit(1)
+	if !gitLink(computedHash).Equals(commitData.Hash.Cid) {
+		return plumbing.ZeroHash, fmt.Errorf("computed hash '%s' does not match provided hash '%s'", computedHash.String(), commitData.Hash.Hex())
 	}
 
 	// Write the commit object to the repository
 	err = writeCommitToRepo(repo, commit)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error writing commit to repository: %v\n", err)
-		os.Exit(1)
-	}
-
-	// Recreate branches and tags
-	err = recreateBranchesAndTags(repo, commitData.Branches, commitData.Tags)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error recreating branches and tags: %v\n", err)
-		os.Exit(1)
+		return plumbing.ZeroHash, fmt.Errorf("error writing commit to repository: %w", err)
 	}
-
-	fmt.Printf("Successfully stored commit %s\n", computedHash.String())
+	return computedHash, nil
 }
 
 // writeTreeToRepo writes a tree object to the repository.
@@ -437,10 +537,14 @@ func writeTreeToRepo(repo *git.Repository, treeData TreeData) error {
 		if err != nil {
 			return fmt.Errorf("invalid mode '%s' for entry '%s': %w", entry.Mode, entry.Name, err)
 		}
+		hash, err := entry.Hash.GitHash()
+		if err != nil {
+			return fmt.Errorf("invalid link for entry '%s': %w", entry.Name, err)
+		}
 		tree.Entries = append(tree.Entries, object.TreeEntry{
 			Name: entry.Name,
 			Mode: mode,
-			Hash: plumbing.NewHash(entry.Hash),
+			Hash: hash,
 		})
 	}
 
@@ -452,6 +556,11 @@ func writeTreeToRepo(repo *git.Repository, treeData TreeData) error {
 		return fmt.Errorf("failed to encode tree object: %w", err)
 	}
 
+	// Refuse to store a tree that does not match its hash
+	if !gitLink(obj.Hash()).Equals(treeData.Hash.Cid) {
+		return fmt.Errorf("computed tree hash '%s' does not match provided hash '%s'", obj.Hash(), treeData.Hash.Hex())
+	}
+
 	// Store the tree in the repository
 	_, err := repo.Storer.SetEncodedObject(obj)
 	if err != nil {
@@ -485,6 +594,11 @@ func writeBlobToRepo(repo *git.Repository, blobData BlobData) error {
 		return fmt.Errorf("failed to close writer for blob object: %w", err)
 	}
 
+	// Refuse to store a blob that does not match its hash
+	if !gitLink(obj.Hash()).Equals(blobData.Hash.Cid) {
+		return fmt.Errorf("computed blob hash '%s' does not match provided hash '%s'", obj.Hash(), blobData.Hash.Hex())
+	}
+
 	// Store the blob in the repository
 	_, err = repo.Storer.SetEncodedObject(obj)
 	if err != nil {
@@ -503,6 +617,8 @@ func getFileMode(modeStr string) (filemode.FileMode, error) {
 		return filemode.Executable, nil
 	case "0040000":
 		return filemode.Dir, nil
+	case "0120000":
+		return filemode.Symlink, nil
 	case "0160000":
 		return filemode.Submodule, nil
 	default:
@@ -584,7 +700,8 @@ func cbor2dot() {
 	fmt.Println("  node [fontname=\"Helvetica\"];")
 
 	// Short hash helper
-	shortHash := func(fullHash string) string {
+	shortHash := func(link Link) string {
+		fullHash := link.Hex()
 		if len(fullHash) >= 8 {
 			return fullHash[:8]
 		}
@@ -618,7 +735,7 @@ func cbor2dot() {
 	// Branches
 	for branchName, branchHash := range commitData.Branches {
 		branchNodeID := fmt.Sprintf("branch_%s", escapeDotString(branchName))
-		branchLabel := fmt.Sprintf("Branch: %s\\n%s", escapeDotString(branchName), shortHash(string(branchHash)))
+		branchLabel := fmt.Sprintf("Branch: %s\\n%s", escapeDotString(branchName), shortHash(branchHash))
 		fmt.Printf("  %s [label=\"%s\", shape=ellipse, style=filled, color=lightcoral];\n", branchNodeID, branchLabel)
 		fmt.Printf("  %s -> %s;\n", branchNodeID, commitMsgNode)
 	}
@@ -626,7 +743,7 @@ func cbor2dot() {
 	// Tags
 	for tagName, tagHash := range commitData.Tags {
 		tagNodeID := fmt.Sprintf("tag_%s", escapeDotString(tagName))
-		tagLabel := fmt.Sprintf("Tag: %s\\n%s", escapeDotString(tagName), shortHash(string(tagHash)))
+		tagLabel := fmt.Sprintf("Tag: %s\\n%s", escapeDotString(tagName), shortHash(tagHash))
 		fmt.Printf("  %s [label=\"%s\", shape=diamond, style=filled, color=gold];\n", tagNodeID, tagLabel)
 		fmt.Printf("  %s -> %s;\n", tagNodeID, commitMsgNode)
 	}
@@ -704,15 +821,6 @@ func findGitRepo() (string, error) {
 	return "", fmt.Errorf(".git directory not found in any parent directories")
 }
 
-// parentCommitHashes extracts the hashes from parent commit objects.
-func parentCommitHashes(parents []*object.Commit) []plumbing.Hash {
-	hashes := make([]plumbing.Hash, len(parents))
-	for i, parent := range parents {
-		hashes[i] = parent.Hash
-	}
-	return hashes
-}
-
 // writeCommitToRepo writes the commit object to the repository's object store.
 func writeCommitToRepo(repo *git.Repository, commit *object.Commit) error {
 	// Create a new encoded object for the commit
@@ -753,8 +861,7 @@ func collectBranchesAndTags(repo *git.Repository, commitData *CommitData) error
 
 	err = branches.ForEach(func(b *plumbing.Reference) error {
 		branchName := b.Name().Short()
-		commitHash := b.Hash().String()
-		commitData.Branches[branchName] = commitHash
+		commitData.Branches[branchName] = gitLink(b.Hash())
 		return nil
 	})
 	if err != nil {
@@ -768,17 +875,13 @@ func collectBranchesAndTags(repo *git.Repository, commitData *CommitData) error
 
 	err = tags.ForEach(func(t *plumbing.Reference) error {
 		tagName := t.Name().Short()
-		target, err := repo.Tag(tagName)
+		commitData.Tags[tagName] = gitLink(t.Hash())
+		// Annotated tags point at tag objects, which are carried along
+		tagObjects, err := collectTagObjects(repo, t.Hash())
 		if err != nil {
-			// It might not be an annotated tag; try to resolve to commit
-			commit, err := repo.CommitObject(t.Hash())
-			if err != nil {
-				return fmt.Errorf("error resolving tag '%s': %w", tagName, err)
-			}
-			commitData.Tags[tagName] = commit.Hash.String()
-			return nil
+			return fmt.Errorf("error resolving tag '%s': %w", tagName, err)
 		}
-		commitData.Tags[tagName] = string(target.Target())
+		commitData.TagObjects = append(commitData.TagObjects, tagObjects...)
 		return nil
 	})
 	if err != nil {
@@ -788,25 +891,32 @@ func collectBranchesAndTags(repo *git.Repository, commitData *CommitData) error
 	return nil
 }
 
-// recreateBranchesAndTags recreates branches and tags in the repository from the provided maps.
-func recreateBranchesAndTags(repo *git.Repository, branches map[string]string, tags map[string]string) error {
+// recreateBranchesAndTags recreates branches and tags in the
+// repository from the provided maps.  Each is set with importRef, so
+// the objects must be present and existing refs are only moved
+// forward unless force is set.
+func recreateBranchesAndTags(repo *git.Repository, branches map[string]Link, tags map[string]Link, force bool) error {
 	// Recreate branches
-	for branchName, commitHash := range branches {
+	for branchName, commitLink := range branches {
 		refName := plumbing.NewBranchReferenceName(branchName)
-		hash := plumbing.NewHash(commitHash)
-		ref := plumbing.NewHashReference(refName, hash)
-		err := repo.Storer.SetReference(ref)
+		hash, err := commitLink.GitHash()
+		if err != nil {
+			return fmt.Errorf("invalid link for branch '%s': %w", branchName, err)
+		}
+		err = importRef(repo, refName, hash, force)
 		if err != nil {
 			return fmt.Errorf("error recreating branch '%s': %w", branchName, err)
 		}
 	}
 
 	// Recreate tags
-	for tagName, targetHash := range tags {
+	for tagName, targetLink := range tags {
 		refName := plumbing.NewTagReferenceName(tagName)
-		hash := plumbing.NewHash(targetHash)
-		ref := plumbing.NewHashReference(refName, hash)
-		err := repo.Storer.SetReference(ref)
+		hash, err := targetLink.GitHash()
+		if err != nil {
+			return fmt.Errorf("invalid link for tag '%s': %w", tagName, err)
+		}
+		err = importRef(repo, refName, hash, force)
 		if err != nil {
 			return fmt.Errorf("error recreating tag '%s': %w", tagName, err)
 		}
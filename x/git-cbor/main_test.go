@@ -0,0 +1,514 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// testRepo is an in-memory repository with a worktree.
+type testRepo struct {
+	t    *testing.T
+	repo *git.Repository
+	wt   *git.Worktree
+	when time.Time
+}
+
+// newTestRepo creates an empty in-memory repository.
+func newTestRepo(t *testing.T) *testRepo {
+	repo, err := git.Init(memory.NewStorage(), memfs.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testRepo{t: t, repo: repo, wt: wt, when: time.Unix(1700000000, 0).UTC()}
+}
+
+// commit writes files to the worktree, removing those with empty
+// content, and commits them.
+func (r *testRepo) commit(msg string, files map[string]string) plumbing.Hash {
+	r.t.Helper()
+	for name, content := range files {
+		if content == "" {
+			_, err := r.wt.Remove(name)
+			if err != nil {
+				r.t.Fatal(err)
+			}
+			continue
+		}
+		f, err := r.wt.Filesystem.Create(name)
+		if err != nil {
+			r.t.Fatal(err)
+		}
+		_, err = f.Write([]byte(content))
+		if err != nil {
+			r.t.Fatal(err)
+		}
+		f.Close()
+		_, err = r.wt.Add(name)
+		if err != nil {
+			r.t.Fatal(err)
+		}
+	}
+	r.when = r.when.Add(time.Minute)
+	sig := &object.Signature{Name: "Alice", Email: "alice@example.com", When: r.when}
+	h, err := r.wt.Commit(msg, &git.CommitOptions{Author: sig, Committer: sig})
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return h
+}
+
+// emptyRepo creates an empty in-memory repository with no worktree.
+func emptyRepo(t *testing.T) *git.Repository {
+	repo, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+// decodeStream decodes every object in a history stream.
+func decodeStream(t *testing.T, buf []byte) []StreamObject {
+	var objs []StreamObject
+	dec := cbor.NewDecoder(bytes.NewReader(buf))
+	for {
+		var obj StreamObject
+		if dec.Decode(&obj) != nil {
+			break
+		}
+		objs = append(objs, obj)
+	}
+	return objs
+}
+
+// TestHistoryRoundTrip exports a whole history, imports it into an
+// empty repository, and checks that each object is emitted once.
+func TestHistoryRoundTrip(t *testing.T) {
+	src := newTestRepo(t)
+	src.commit("first", map[string]string{"a.txt": "a", "dir/b.txt": "b"})
+	src.commit("second", map[string]string{"a.txt": "a2"})
+	tip := src.commit("third", map[string]string{"dir/c.txt": "c", "a.txt": "a"})
+
+	var buf bytes.Buffer
+	err := exportHistory(src.repo, "master", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objs := decodeStream(t, buf.Bytes())
+	seen := make(map[string]bool)
+	counts := make(map[string]int)
+	for _, obj := range objs {
+		if obj.Type != TypeRef && seen[obj.Hash.String()] {
+			t.Errorf("object %s emitted twice", obj.Hash)
+		}
+		seen[obj.Hash.String()] = true
+		counts[obj.Type]++
+	}
+	// Blobs a, b, a2 and c; "a" reappears in the third commit but is
+	// not emitted again.
+	if counts[TypeBlob] != 4 || counts[TypeCommit] != 3 || counts[TypeRef] != 1 {
+		t.Errorf("unexpected object counts %v", counts)
+	}
+
+	dst := emptyRepo(t)
+	n, err := importStream(dst, &buf, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(objs) {
+		t.Errorf("imported %d of %d objects", n, len(objs))
+	}
+	ref, err := dst.Reference(plumbing.NewBranchReferenceName("master"), true)
+	if err != nil || ref.Hash() != tip {
+		t.Fatalf("expected master at %s, got %v, %v", tip, ref, err)
+	}
+	c, err := dst.CommitObject(tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := c.File("dir/c.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := f.Contents()
+	if content != "c" {
+		t.Errorf("unexpected content %q", content)
+	}
+}
+
+// TestHistoryIncremental exports a range on top of a base that the
+// receiver already has, and checks that only new objects are sent.
+func TestHistoryIncremental(t *testing.T) {
+	src := newTestRepo(t)
+	base := src.commit("first", map[string]string{"a.txt": "a", "dir/b.txt": "b"})
+
+	var buf bytes.Buffer
+	err := exportHistory(src.repo, "master", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := emptyRepo(t)
+	_, err = importStream(dst, &buf, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tip := src.commit("second", map[string]string{"a.txt": "a2"})
+	buf.Reset()
+	err = exportHistory(src.repo, base.String()+"..master", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	for _, obj := range decodeStream(t, buf.Bytes()) {
+		counts[obj.Type]++
+	}
+	// Only the new blob, the new root tree and the new commit; the
+	// unchanged dir tree and its blob are already at the receiver.
+	if counts[TypeBlob] != 1 || counts[TypeTree] != 1 || counts[TypeCommit] != 1 {
+		t.Errorf("unexpected object counts %v", counts)
+	}
+	_, err = importStream(dst, &buf, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = dst.CommitObject(tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestImportRejectsBadHash checks that an object whose content does
+// not match its hash is not stored.
+func TestImportRejectsBadHash(t *testing.T) {
+	dst := emptyRepo(t)
+	obj := StreamObject{Type: TypeBlob, Hash: gitLink(plumbing.ZeroHash), Content: []byte("x")}
+	err := importObject(dst, obj, false)
+	if err == nil {
+		t.Fatal("expected hash mismatch error")
+	}
+	count := 0
+	iter, _ := dst.BlobObjects()
+	iter.ForEach(func(*object.Blob) error { count++; return nil })
+	if count != 0 {
+		t.Errorf("expected no blobs to be stored, got %d", count)
+	}
+}
+
+// TestImportRefs checks that imported refs must be valid, must point
+// at objects the receiver has, and only move forward unless forced.
+func TestImportRefs(t *testing.T) {
+	src := newTestRepo(t)
+	base := src.commit("first", map[string]string{"a.txt": "a"})
+	tip := src.commit("second", map[string]string{"a.txt": "a2"})
+	var buf bytes.Buffer
+	err := exportHistory(src.repo, "master", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := emptyRepo(t)
+	_, err = importStream(dst, &buf, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	master := plumbing.NewBranchReferenceName("master")
+	head := func() plumbing.Hash {
+		ref, err := dst.Reference(master, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ref.Hash()
+	}
+
+	cases := []struct {
+		name  plumbing.ReferenceName
+		hash  plumbing.Hash
+		force bool
+		ok    bool
+	}{
+		{master, base, false, false},
+		{"refs/heads/bad..name", tip, false, false},
+		{"HEAD", tip, false, false},
+		{"refs/heads/other", plumbing.NewHash("0123456789012345678901234567890123456789"), false, false},
+		{"refs/heads/other", base, false, true},
+		{"refs/heads/other", tip, false, true},
+		{master, base, true, true},
+		{master, tip, false, true},
+	}
+	for _, c := range cases {
+		err := importRef(dst, c.name, c.hash, c.force)
+		if (err == nil) != c.ok {
+			t.Errorf("%s -> %s (force %v): %v", c.name, c.hash, c.force, err)
+		}
+		if c.name == master && !c.ok && head() != tip {
+			t.Errorf("refused update moved master to %s", head())
+		}
+	}
+	if head() != tip {
+		t.Errorf("master is %s, want %s", head(), tip)
+	}
+}
+
+// TestImportDocumentRefs checks that a single commit document's
+// branches, like a stream's refs, only move forward unless forced.
+func TestImportDocumentRefs(t *testing.T) {
+	src := newTestRepo(t)
+	base := src.commit("first", map[string]string{"a.txt": "a"})
+	export := func(h plumbing.Hash) CommitData {
+		commit, err := src.repo.CommitObject(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc, err := exportDocument(src.repo, commit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return doc
+	}
+	old := export(base)
+	tip := src.commit("second", map[string]string{"a.txt": "a2"})
+	cur := export(tip)
+
+	dst := emptyRepo(t)
+	master := func() plumbing.Hash {
+		ref, err := dst.Reference(plumbing.NewBranchReferenceName("master"), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ref.Hash()
+	}
+	cases := []struct {
+		doc   CommitData
+		force bool
+		ok    bool
+		want  plumbing.Hash
+	}{
+		{old, false, true, base},
+		{cur, false, true, tip},
+		{old, false, false, tip},
+		{old, true, true, base},
+	}
+	for i, c := range cases {
+		_, err := importDocument(dst, c.doc, c.force)
+		if (err == nil) != c.ok {
+			t.Errorf("case %d: %v", i, err)
+		}
+		if got := master(); got != c.want {
+			t.Errorf("case %d: master is %s, want %s", i, got, c.want)
+		}
+	}
+}
+
+// TestHistoryNodeLinks checks that a commit's blobs can be reached
+// from its wrapper node by following DAG-CBOR links alone, and that a
+// committer time zone survives the round trip.
+func TestHistoryNodeLinks(t *testing.T) {
+	src := newTestRepo(t)
+	src.when = src.when.In(time.FixedZone("", -7*3600))
+	tip := src.commit("first", map[string]string{"a.txt": "a", "dir/b.txt": "b"})
+
+	var buf bytes.Buffer
+	err := exportHistory(src.repo, "master", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Index the stream by the DAG-CBOR CID of each encoded object.
+	nodes := make(map[string]StreamObject)
+	dec := cbor.NewDecoder(bytes.NewReader(buf.Bytes()))
+	for {
+		var raw cbor.RawMessage
+		if dec.Decode(&raw) != nil {
+			break
+		}
+		var obj StreamObject
+		err = cbor.Unmarshal(raw, &obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes[nodeLink(raw).String()] = obj
+	}
+
+	var ref StreamObject
+	for _, obj := range nodes {
+		if obj.Type == TypeRef {
+			ref = obj
+		}
+	}
+	if ref.Node == nil {
+		t.Fatal("expected a ref with a node link")
+	}
+	commit, ok := nodes[ref.Node.String()]
+	if !ok || commit.Type != TypeCommit || commit.Commit.TreeNode == nil {
+		t.Fatalf("ref node link does not resolve to a commit: %+v", commit)
+	}
+	h, err := commit.Hash.GitHash()
+	if err != nil || h != tip {
+		t.Errorf("expected commit %s, got %s, %v", tip, h, err)
+	}
+
+	// Walk the trees down to the blobs.
+	var contents []string
+	var walk func(l Link)
+	walk = func(l Link) {
+		obj, ok := nodes[l.String()]
+		if !ok {
+			t.Fatalf("dangling link %s", l)
+		}
+		switch obj.Type {
+		case TypeBlob:
+			contents = append(contents, string(obj.Content))
+		case TypeTree:
+			for _, e := range obj.Entries {
+				if e.Node == nil {
+					t.Fatalf("entry %s has no node link", e.Name)
+				}
+				walk(*e.Node)
+			}
+		}
+	}
+	walk(*commit.Commit.TreeNode)
+	if len(contents) != 2 || contents[0] != "a" || contents[1] != "b" {
+		t.Errorf("unexpected blob contents %q", contents)
+	}
+
+	dst := emptyRepo(t)
+	_, err = importStream(dst, &buf, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = dst.CommitObject(tip)
+	if err != nil {
+		t.Fatalf("commit hash changed in the round trip: %v", err)
+	}
+}
+
+// storeRaw writes a git object with the given content to repo and
+// returns its hash.
+func storeRaw(t *testing.T, repo *git.Repository, typ plumbing.ObjectType, content string) plumbing.Hash {
+	t.Helper()
+	obj := repo.Storer.NewEncodedObject()
+	obj.SetType(typ)
+	w, _ := obj.Writer()
+	w.Write([]byte(content))
+	w.Close()
+	h, err := repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// TestHistorySignedAndTagged round-trips a signed commit, a commit
+// with a header go-git does not know, and a signed annotated tag of a
+// tag, and checks that every object is byte-identical at the receiver.
+func TestHistorySignedAndTagged(t *testing.T) {
+	src := newTestRepo(t)
+	first := src.commit("first", map[string]string{"a.txt": "a"})
+	c, err := src.repo.CommitObject(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := "-----BEGIN PGP SIGNATURE-----\n\niQEzBAABCAAdFiEE\n-----END PGP SIGNATURE-----\n"
+
+	signed := &object.Commit{
+		Author:       c.Author,
+		Committer:    c.Committer,
+		Message:      "signed\n",
+		TreeHash:     c.TreeHash,
+		ParentHashes: []plumbing.Hash{first},
+		PGPSignature: sig,
+		Encoding:     "ISO-8859-1",
+	}
+	obj := src.repo.Storer.NewEncodedObject()
+	err = signed.Encode(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedHash, err := src.repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	odd := storeRaw(t, src.repo, plumbing.CommitObject, "tree "+c.TreeHash.String()+"\n"+
+		"parent "+signedHash.String()+"\n"+
+		"author Alice <alice@example.com> 1700000000 -0000\n"+
+		"committer Alice <alice@example.com> 1700000000 -0000\n"+
+		"x-custom-header some value\n\nodd\n")
+	inner := storeRaw(t, src.repo, plumbing.TagObject, "object "+odd.String()+"\ntype commit\ntag v1\n"+
+		"tagger Alice <alice@example.com> 1700000000 +0200\n\nrelease 1\n"+sig)
+	outer := storeRaw(t, src.repo, plumbing.TagObject, "object "+inner.String()+"\ntype tag\ntag v1-outer\n"+
+		"tagger Alice <alice@example.com> 1700000060 +0200\n\nwrapped\n")
+	for name, h := range map[plumbing.ReferenceName]plumbing.Hash{
+		plumbing.NewBranchReferenceName("master"): odd,
+		plumbing.NewTagReferenceName("v1"):        inner,
+		plumbing.NewTagReferenceName("v1-outer"):  outer,
+	} {
+		err = src.repo.Storer.SetReference(plumbing.NewHashReference(name, h))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	err = exportHistory(src.repo, "master", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	for _, obj := range decodeStream(t, buf.Bytes()) {
+		counts[obj.Type]++
+		h, _ := obj.Hash.GitHash()
+		switch {
+		case obj.Type == TypeCommit && h == signedHash:
+			if obj.Commit.PGPSignature != sig || obj.Commit.Raw != nil {
+				t.Errorf("expected signed commit to be kept as fields: %+v", obj.Commit)
+			}
+		case obj.Type == TypeCommit && h == odd:
+			if obj.Commit.Raw == nil {
+				t.Errorf("expected commit with unknown header to be kept raw")
+			}
+		case obj.Type == TypeTag && h == inner:
+			if obj.Tag.PGPSignature != sig || obj.Tag.Raw != nil {
+				t.Errorf("expected signed tag to be kept as fields: %+v", obj.Tag)
+			}
+		}
+	}
+	if counts[TypeTag] != 2 || counts[TypeRef] != 3 {
+		t.Errorf("unexpected object counts %v", counts)
+	}
+
+	dst := emptyRepo(t)
+	_, err = importStream(dst, &buf, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range []plumbing.Hash{signedHash, odd, inner, outer} {
+		want, err := readRawObject(src.repo, plumbing.AnyObject, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := readRawObject(dst, plumbing.AnyObject, h)
+		if err != nil {
+			t.Fatalf("object %s missing at receiver: %v", h, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("object %s differs:\n%s\n%s", h, got, want)
+		}
+	}
+	ref, err := dst.Reference(plumbing.NewTagReferenceName("v1-outer"), false)
+	if err != nil || ref.Hash() != outer {
+		t.Errorf("expected v1-outer at %s, got %v, %v", outer, ref, err)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// TagData represents an annotated tag object in CBOR format.
+type TagData struct {
+	Hash         Link      `cbor:"hash"`
+	Name         string    `cbor:"name"`
+	TaggerName   string    `cbor:"tagger_name"`
+	TaggerEmail  string    `cbor:"tagger_email"`
+	TaggerDate   time.Time `cbor:"tagger_date"`
+	Message      string    `cbor:"message"`
+	PGPSignature string    `cbor:"gpgsig,omitempty"`
+	TargetType   string    `cbor:"target_type"`
+	Target       Link      `cbor:"target"`
+	// Raw is the exact content of a tag that cannot be rebuilt from
+	// the fields above, such as one without a tagger line.
+	Raw []byte `cbor:"raw,omitempty"`
+}
+
+// newCommitData returns the CommitData for a commit, leaving the
+// Trees, Blobs, Branches and Tags fields empty.  If the fields do not
+// rebuild to the same commit hash -- because of headers go-git does
+// not know about, or an unusual time zone encoding -- the commit's
+// exact content is kept in Raw.
+func newCommitData(repo *git.Repository, c *object.Commit) (CommitData, error) {
+	commitData := CommitData{
+		Hash:           gitLink(c.Hash),
+		Tree:           gitLink(c.TreeHash),
+		Parents:        gitLinks(c.ParentHashes),
+		AuthorName:     c.Author.Name,
+		AuthorEmail:    c.Author.Email,
+		AuthorDate:     c.Author.When,
+		CommitterName:  c.Committer.Name,
+		CommitterEmail: c.Committer.Email,
+		CommitterDate:  c.Committer.When,
+		Message:        c.Message,
+		MergeTag:       c.MergeTag,
+		PGPSignature:   c.PGPSignature,
+	}
+	if c.Encoding != "UTF-8" {
+		commitData.Encoding = string(c.Encoding)
+	}
+	commit, err := buildCommit(commitData)
+	if err != nil {
+		return CommitData{}, err
+	}
+	hash, err := recalculateCommitHash(commit)
+	if err != nil {
+		return CommitData{}, err
+	}
+	if hash != c.Hash {
+		commitData.Raw, err = readRawObject(repo, plumbing.CommitObject, c.Hash)
+		if err != nil {
+			return CommitData{}, err
+		}
+	}
+	return commitData, nil
+}
+
+// buildCommit rebuilds a commit object from the fields of commitData.
+func buildCommit(commitData CommitData) (*object.Commit, error) {
+	treeHash, err := commitData.Tree.GitHash()
+	if err != nil {
+		return nil, fmt.Errorf("invalid tree link: %w", err)
+	}
+	var parentHashes []plumbing.Hash
+	for _, ph := range commitData.Parents {
+		pHash, err := ph.GitHash()
+		if err != nil {
+			return nil, fmt.Errorf("invalid parent link: %w", err)
+		}
+		parentHashes = append(parentHashes, pHash)
+	}
+	return &object.Commit{
+		Author: object.Signature{
+			Name:  commitData.AuthorName,
+			Email: commitData.AuthorEmail,
+			When:  commitData.AuthorDate,
+		},
+		Committer: object.Signature{
+			Name:  commitData.CommitterName,
+			Email: commitData.CommitterEmail,
+			When:  commitData.CommitterDate,
+		},
+		MergeTag:     commitData.MergeTag,
+		PGPSignature: commitData.PGPSignature,
+		Message:      commitData.Message,
+		TreeHash:     treeHash,
+		ParentHashes: parentHashes,
+		Encoding:     object.MessageEncoding(commitData.Encoding),
+	}, nil
+}
+
+// newTagData returns the TagData for an annotated tag, keeping the
+// tag's exact content in Raw if the fields do not rebuild to the same
+// hash.
+func newTagData(repo *git.Repository, t *object.Tag) (TagData, error) {
+	tagData := TagData{
+		Hash:         gitLink(t.Hash),
+		Name:         t.Name,
+		TaggerName:   t.Tagger.Name,
+		TaggerEmail:  t.Tagger.Email,
+		TaggerDate:   t.Tagger.When,
+		Message:      t.Message,
+		PGPSignature: t.PGPSignature,
+		TargetType:   t.TargetType.String(),
+		Target:       gitLink(t.Target),
+	}
+	tag, err := buildTag(tagData)
+	if err != nil {
+		return TagData{}, err
+	}
+	mo := plumbing.MemoryObject{}
+	err = tag.Encode(&mo)
+	if err != nil {
+		return TagData{}, err
+	}
+	if mo.Hash() != t.Hash {
+		tagData.Raw, err = readRawObject(repo, plumbing.TagObject, t.Hash)
+		if err != nil {
+			return TagData{}, err
+		}
+	}
+	return tagData, nil
+}
+
+// buildTag rebuilds an annotated tag object from the fields of
+// tagData.
+func buildTag(tagData TagData) (*object.Tag, error) {
+	target, err := tagData.Target.GitHash()
+	if err != nil {
+		return nil, fmt.Errorf("invalid target link: %w", err)
+	}
+	targetType, err := plumbing.ParseObjectType(tagData.TargetType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target type '%s': %w", tagData.TargetType, err)
+	}
+	return &object.Tag{
+		Name: tagData.Name,
+		Tagger: object.Signature{
+			Name:  tagData.TaggerName,
+			Email: tagData.TaggerEmail,
+			When:  tagData.TaggerDate,
+		},
+		Message:      tagData.Message,
+		PGPSignature: tagData.PGPSignature,
+		TargetType:   targetType,
+		Target:       target,
+	}, nil
+}
+
+// collectTagObjects returns the annotated tag object that hash names,
+// followed by any tags it in turn points at.  It returns nothing if
+// hash does not name a tag object.
+func collectTagObjects(repo *git.Repository, hash plumbing.Hash) ([]TagData, error) {
+	var tags []TagData
+	for {
+		tag, err := repo.TagObject(hash)
+		if err == plumbing.ErrObjectNotFound {
+			return tags, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving tag object '%s': %w", hash, err)
+		}
+		tagData, err := newTagData(repo, tag)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tagData)
+		if tag.TargetType != plumbing.TagObject {
+			return tags, nil
+		}
+		hash = tag.Target
+	}
+}
+
+// writeTagToRepo rebuilds an annotated tag object from tagData,
+// verifies its hash, and writes it to the repository.
+func writeTagToRepo(repo *git.Repository, tagData TagData) error {
+	if tagData.Raw != nil {
+		return writeRawObject(repo, plumbing.TagObject, tagData.Raw, tagData.Hash)
+	}
+	tag, err := buildTag(tagData)
+	if err != nil {
+		return err
+	}
+	obj := repo.Storer.NewEncodedObject()
+	err = tag.Encode(obj)
+	if err != nil {
+		return fmt.Errorf("failed to encode tag object: %w", err)
+	}
+	if !gitLink(obj.Hash()).Equals(tagData.Hash.Cid) {
+		return fmt.Errorf("computed tag hash '%s' does not match provided hash '%s'", obj.Hash(), tagData.Hash.Hex())
+	}
+	_, err = repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		return fmt.Errorf("failed to store tag object: %w", err)
+	}
+	return nil
+}
+
+// readRawObject returns the content of a git object, without its
+// type and length header.
+func readRawObject(repo *git.Repository, t plumbing.ObjectType, hash plumbing.Hash) ([]byte, error) {
+	obj, err := repo.Storer.EncodedObject(t, hash)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving %s object '%s': %w", t, hash, err)
+	}
+	r, err := obj.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// writeRawObject writes a git object with the given content to the
+// repository after verifying that it hashes to want.
+func writeRawObject(repo *git.Repository, t plumbing.ObjectType, content []byte, want Link) error {
+	obj := repo.Storer.NewEncodedObject()
+	obj.SetType(t)
+	w, err := obj.Writer()
+	if err != nil {
+		return fmt.Errorf("failed to get writer for %s object: %w", t, err)
+	}
+	_, err = w.Write(content)
+	if err != nil {
+		w.Close()
+		return fmt.Errorf("failed to write %s content: %w", t, err)
+	}
+	err = w.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close writer for %s object: %w", t, err)
+	}
+	if !gitLink(obj.Hash()).Equals(want.Cid) {
+		return fmt.Errorf("computed %s hash '%s' does not match provided hash '%s'", t, obj.Hash(), want.Hex())
+	}
+	_, err = repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		return fmt.Errorf("failed to store %s object: %w", t, err)
+	}
+	return nil
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	gitstore "github.com/stevegt/grid-poc/x/interfaces-git"
+)
+
+// Backend is a repository the scenarios run against: go-git, or one
+// of the grid Store implementations plus a directory of refs.  Every
+// commit holds a single file, example.txt.
+type Backend interface {
+	// Commit commits example.txt with the given content and returns
+	// the commit hash.  If parents is nil the commit's parent is the
+	// current head, if any.  The commit becomes the new head.
+	Commit(content string, parents []string, when time.Time) (string, error)
+	// Blob stores an unreferenced blob and returns its hash.
+	Blob(content string) (string, error)
+	// SetRef points a ref, such as "refs/heads/main", at a hash.
+	SetRef(name, hash string) error
+	// Head returns the hash of the head commit.
+	Head() (string, error)
+	// Versions walks the first-parent history from head, reading
+	// example.txt at each commit, and returns the number of commits.
+	Versions() (int, error)
+	// Objects returns the number of objects stored.
+	Objects() (int, error)
+	// Close flushes anything buffered to disk.
+	Close() error
+	// Dir is the directory holding the stored data.
+	Dir() string
+}
+
+// Backends maps backend names to constructors.  Each constructor
+// creates an empty backend in dir.
+var Backends = map[string]func(dir string) (Backend, error){
+	"go-git": newGoGitBackend,
+	"loose": func(dir string) (Backend, error) {
+		return newGridBackend(dir, gitstore.NewLooseStore(filepath.Join(dir, "objects"))), nil
+	},
+	"pack": func(dir string) (Backend, error) {
+		s, err := gitstore.NewPackStore(filepath.Join(dir, "objects", "pack"))
+		if err != nil {
+			return nil, err
+		}
+		return newGridBackend(dir, s), nil
+	},
+	"cbor": func(dir string) (Backend, error) {
+		s, err := gitstore.NewCBORStore(filepath.Join(dir, "objects"))
+		if err != nil {
+			return nil, err
+		}
+		return newGridBackend(dir, s), nil
+	},
+}
+
+// BackendNames lists the backends in the order they are reported.
+var BackendNames = []string{"go-git", "loose", "pack", "cbor"}
+
+// goGitBackend is a go-git repository with a worktree.  Commits go
+// through the worktree, as a user's would.
+type goGitBackend struct {
+	dir  string
+	repo *git.Repository
+	wt   *git.Worktree
+}
+
+func newGoGitBackend(dir string) (Backend, error) {
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		return nil, err
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	return &goGitBackend{dir: dir, repo: repo, wt: wt}, nil
+}
+
+func (g *goGitBackend) Commit(content string, parents []string, when time.Time) (string, error) {
+	err := os.WriteFile(filepath.Join(g.dir, "example.txt"), []byte(content), 0644)
+	if err != nil {
+		return "", err
+	}
+	_, err = g.wt.Add("example.txt")
+	if err != nil {
+		return "", err
+	}
+	sig := &object.Signature{Name: "John Doe", Email: "john@example.com", When: when}
+	opts := &git.CommitOptions{Author: sig, Committer: sig}
+	for _, p := range parents {
+		opts.Parents = append(opts.Parents, plumbing.NewHash(p))
+	}
+	hash, err := g.wt.Commit("example.txt", opts)
+	if err != nil {
+		return "", err
+	}
+	return hash.String(), nil
+}
+
+func (g *goGitBackend) Blob(content string) (string, error) {
+	blob := g.repo.Storer.NewEncodedObject()
+	blob.SetType(plumbing.BlobObject)
+	w, err := blob.Writer()
+	if err != nil {
+		return "", err
+	}
+	w.Write([]byte(content))
+	err = w.Close()
+	if err != nil {
+		return "", err
+	}
+	hash, err := g.repo.Storer.SetEncodedObject(blob)
+	if err != nil {
+		return "", err
+	}
+	return hash.String(), nil
+}
+
+func (g *goGitBackend) SetRef(name, hash string) error {
+	ref := plumbing.NewHashReference(plumbing.ReferenceName(name), plumbing.NewHash(hash))
+	return g.repo.Storer.SetReference(ref)
+}
+
+func (g *goGitBackend) Head() (string, error) {
+	ref, err := g.repo.Head()
+	if err != nil {
+		return "", err
+	}
+	return ref.Hash().String(), nil
+}
+
+func (g *goGitBackend) Versions() (int, error) {
+	ref, err := g.repo.Head()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for hash := ref.Hash(); ; {
+		commit, err := g.repo.CommitObject(hash)
+		if err != nil {
+			return n, err
+		}
+		file, err := commit.File("example.txt")
+		if err != nil {
+			return n, err
+		}
+		_, err = file.Contents()
+		if err != nil {
+			return n, err
+		}
+		n++
+		if len(commit.ParentHashes) == 0 {
+			return n, nil
+		}
+		hash = commit.ParentHashes[0]
+	}
+}
+
+func (g *goGitBackend) Objects() (int, error) {
+	iter, err := g.repo.Storer.IterEncodedObjects(plumbing.AnyObject)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	err = iter.ForEach(func(plumbing.EncodedObject) error {
+		n++
+		return nil
+	})
+	return n, err
+}
+
+func (g *goGitBackend) Close() error {
+	return nil
+}
+
+func (g *goGitBackend) Dir() string {
+	return filepath.Join(g.dir, ".git")
+}
+
+// gridBackend is a grid Store.  Stores hold only objects, so refs
+// are files under dir/refs, as in a git repository, and the head is
+// the last commit made.
+type gridBackend struct {
+	dir   string
+	store gitstore.Store
+	head  string
+	seen  map[string]bool
+}
+
+func newGridBackend(dir string, store gitstore.Store) *gridBackend {
+	return &gridBackend{dir: dir, store: store, seen: make(map[string]bool)}
+}
+
+// put stores an object and counts it.
+func (g *gridBackend) put(obj gitstore.Object) (string, error) {
+	hash, err := g.store.Put(obj)
+	if err != nil {
+		return "", err
+	}
+	g.seen[hash] = true
+	return hash, nil
+}
+
+func (g *gridBackend) Commit(content string, parents []string, when time.Time) (string, error) {
+	blob, err := g.put(gitstore.NewBlob([]byte(content)))
+	if err != nil {
+		return "", err
+	}
+	tree := gitstore.NewTree()
+	tree.AddEntry(gitstore.ModeFile, "example.txt", blob)
+	treeHash, err := g.put(tree)
+	if err != nil {
+		return "", err
+	}
+	if parents == nil && g.head != "" {
+		parents = []string{g.head}
+	}
+	sig := gitstore.Signature{Name: "John Doe", Email: "john@example.com", When: when.Unix(), Zone: "+0000"}
+	commit := &gitstore.Commit{
+		Tree:      treeHash,
+		Parents:   parents,
+		Author:    sig,
+		Committer: sig,
+		Message:   "example.txt",
+	}
+	hash, err := g.put(commit)
+	if err != nil {
+		return "", err
+	}
+	g.head = hash
+	return hash, nil
+}
+
+func (g *gridBackend) Blob(content string) (string, error) {
+	return g.put(gitstore.NewBlob([]byte(content)))
+}
+
+func (g *gridBackend) SetRef(name, hash string) error {
+	fn := filepath.Join(g.dir, filepath.FromSlash(name))
+	err := os.MkdirAll(filepath.Dir(fn), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fn, []byte(hash+"\n"), 0644)
+}
+
+func (g *gridBackend) Head() (string, error) {
+	if g.head == "" {
+		return "", fmt.Errorf("no commits")
+	}
+	return g.head, nil
+}
+
+func (g *gridBackend) Versions() (int, error) {
+	n := 0
+	for hash := g.head; hash != ""; n++ {
+		obj, err := g.store.Get(hash)
+		if err != nil {
+			return n, err
+		}
+		commit, ok := obj.(*gitstore.Commit)
+		if !ok {
+			return n, fmt.Errorf("%s is a %s, not a commit", hash, obj.Type())
+		}
+		obj, err = g.store.Get(commit.Tree)
+		if err != nil {
+			return n, err
+		}
+		tree, ok := obj.(*gitstore.Tree)
+		if !ok || len(tree.Entries) != 1 {
+			return n, fmt.Errorf("%s: unexpected tree", commit.Tree)
+		}
+		_, err = g.store.Get(tree.Entries[0].Hash)
+		if err != nil {
+			return n, err
+		}
+		hash = ""
+		if len(commit.Parents) > 0 {
+			hash = commit.Parents[0]
+		}
+	}
+	return n, nil
+}
+
+func (g *gridBackend) Objects() (int, error) {
+	return len(g.seen), nil
+}
+
+func (g *gridBackend) Close() error {
+	if c, ok := g.store.(interface{ Close() error }); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (g *gridBackend) Dir() string {
+	return g.dir
+}
+
+// diskUsage returns the total size of the files under dir.
+func diskUsage(dir string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	return total, err
+}
@@ -1,11 +1,11 @@
-module main
+module git-performance
 
-go 1.22.1
+go 1.24.0
 
 require (
-	github.com/go-git/go-git v4.7.0+incompatible
 	github.com/go-git/go-git/v5 v5.12.0
 	github.com/stevegt/goadapt v0.7.0
+	github.com/stevegt/grid-poc/x/interfaces-git v0.0.0-00010101000000-000000000000
 )
 
 require (
@@ -15,19 +15,44 @@ require (
 	github.com/cloudflare/circl v1.3.7 // indirect
 	github.com/cyphar/filepath-securejoin v0.2.4 // indirect
 	github.com/emirpasic/gods v1.18.1 // indirect
+	github.com/fxamacker/cbor/v2 v2.7.0 // indirect
 	github.com/go-git/gcfg v1.5.1-0.20230307220236-3a3c6141e376 // indirect
 	github.com/go-git/go-billy/v5 v5.5.0 // indirect
 	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da // indirect
+	github.com/ipfs/go-cid v0.5.0 // indirect
+	github.com/ipld/go-ipld-prime v0.21.0 // indirect
 	github.com/jbenet/go-context v0.0.0-20150711004518-d14ea06fba99 // indirect
 	github.com/kevinburke/ssh_config v1.2.0 // indirect
+	github.com/klauspost/cpuid/v2 v2.0.9 // indirect
+	github.com/minio/sha256-simd v1.0.0 // indirect
+	github.com/mr-tron/base58 v1.2.0 // indirect
+	github.com/multiformats/go-base32 v0.0.3 // indirect
+	github.com/multiformats/go-base36 v0.1.0 // indirect
+	github.com/multiformats/go-multibase v0.2.0 // indirect
+	github.com/multiformats/go-multihash v0.2.3 // indirect
+	github.com/multiformats/go-varint v0.0.7 // indirect
 	github.com/pjbgf/sha1cd v0.3.0 // indirect
+	github.com/polydawn/refmt v0.89.0 // indirect
 	github.com/sergi/go-diff v1.3.2-0.20230802210424-5b0b94c5c0d3 // indirect
 	github.com/skeema/knownhosts v1.2.2 // indirect
+	github.com/spaolacci/murmur3 v1.1.0 // indirect
+	github.com/stevegt/grid-poc v0.0.0-00010101000000-000000000000 // indirect
+	github.com/stevegt/grid-poc/x/cbor-codec v0.0.0-00010101000000-000000000000 // indirect
+	github.com/stevegt/grid-poc/x/ipld-store v0.0.0-00010101000000-000000000000 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
 	github.com/xanzy/ssh-agent v0.3.3 // indirect
-	golang.org/x/crypto v0.21.0 // indirect
+	golang.org/x/crypto v0.31.0 // indirect
 	golang.org/x/mod v0.12.0 // indirect
 	golang.org/x/net v0.22.0 // indirect
-	golang.org/x/sys v0.18.0 // indirect
+	golang.org/x/sys v0.28.0 // indirect
 	golang.org/x/tools v0.13.0 // indirect
 	gopkg.in/warnings.v0 v0.1.2 // indirect
+	lukechampine.com/blake3 v1.1.6 // indirect
+)
+
+replace (
+	github.com/stevegt/grid-poc => ../..
+	github.com/stevegt/grid-poc/x/cbor-codec => ../cbor-codec
+	github.com/stevegt/grid-poc/x/interfaces-git => ../interfaces-git
+	github.com/stevegt/grid-poc/x/ipld-store => ../ipld-store
 )
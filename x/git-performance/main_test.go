@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// BenchmarkScenarios runs every scenario on every backend with b.N
+// operations.  Besides ns/op it reports the bytes on disk and objects
+// stored per operation.
+func BenchmarkScenarios(b *testing.B) {
+	for _, s := range Scenarios {
+		for _, backend := range BackendNames {
+			b.Run(s.Name+"/"+backend, func(b *testing.B) {
+				res, err := runScenario(b.TempDir(), s, backend, b.N)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.ReportMetric(float64(res.BytesOnDisk)/float64(b.N), "disk-B/op")
+				b.ReportMetric(float64(res.Objects)/float64(b.N), "objects/op")
+			})
+		}
+	}
+}
+
+// TestScenarios runs every scenario once on every backend and checks
+// the object counts, which are the same for every git-compatible
+// backend.
+func TestScenarios(t *testing.T) {
+	const n = 5
+	want := map[string]int{
+		"commits":           3 * n, // a blob, tree and commit each
+		"branches":          3,
+		"objects":           n,
+		"referenced":        n,
+		"commitWithParents": 3 * (n + 1),
+		"repeatability":     3 * n,
+	}
+	for _, s := range Scenarios {
+		for _, backend := range BackendNames {
+			t.Run(s.Name+"/"+backend, func(t *testing.T) {
+				res, err := runScenario(t.TempDir(), s, backend, n)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if res.Objects != want[s.Name] {
+					t.Errorf("got %d objects, want %d", res.Objects, want[s.Name])
+				}
+				if res.BytesOnDisk <= 0 {
+					t.Errorf("got %d bytes on disk", res.BytesOnDisk)
+				}
+			})
+		}
+	}
+}
+
+// TestSameHashes checks that the git-compatible backends agree on
+// the hash of every commit.
+func TestSameHashes(t *testing.T) {
+	var want []string
+	for _, backend := range []string{"go-git", "loose", "pack"} {
+		b, err := Backends[backend](t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for i := 0; i < 3; i++ {
+			h, err := commitN(b, i, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, h)
+		}
+		b.Close()
+		if want == nil {
+			want = got
+		} else if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("%s: got %v, want %v", backend, got, want)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	base := &Report{Results: []Result{
+		{Scenario: "commits", Backend: "loose", N: 10, NsPerOp: 1000, BytesOnDisk: 500, Objects: 30},
+		{Scenario: "objects", Backend: "loose", N: 10, NsPerOp: 1000, BytesOnDisk: 500, Objects: 10},
+		{Scenario: "branches", Backend: "loose", N: 10, NsPerOp: 1000, BytesOnDisk: 500, Objects: 3},
+	}}
+	cur := &Report{Results: []Result{
+		// Within the threshold.
+		{Scenario: "commits", Backend: "loose", N: 10, NsPerOp: 1100, BytesOnDisk: 500, Objects: 30},
+		// Slower and bigger.
+		{Scenario: "objects", Backend: "loose", N: 10, NsPerOp: 1300, BytesOnDisk: 700, Objects: 11},
+		// Different n: not comparable.
+		{Scenario: "branches", Backend: "loose", N: 20, NsPerOp: 9000, BytesOnDisk: 900, Objects: 3},
+		// No baseline.
+		{Scenario: "objects", Backend: "pack", N: 10, NsPerOp: 9000, BytesOnDisk: 900, Objects: 10},
+	}}
+	regs := Compare(base, cur, 0.2)
+	if len(regs) != 3 || regs[0].Metric != "ns/op" || regs[1].Metric != "bytes" || regs[2].Metric != "objects" {
+		t.Fatalf("unexpected regressions: %v", regs)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Result is the measurement of one scenario on one backend.
+type Result struct {
+	Scenario string  `json:"scenario"`
+	Backend  string  `json:"backend"`
+	N        int     `json:"n"`
+	NsPerOp  float64 `json:"ns_per_op"`
+	// BytesOnDisk is the size of the backend's stored data after
+	// the run.
+	BytesOnDisk int64 `json:"bytes_on_disk"`
+	// Objects is the number of objects stored after the run.
+	Objects int `json:"objects"`
+}
+
+// Report is a set of results, as saved to and read from a baseline
+// file.
+type Report struct {
+	GoVersion string    `json:"go_version,omitempty"`
+	Time      time.Time `json:"time"`
+	Results   []Result  `json:"results"`
+}
+
+// runScenario runs s with n operations on a new backend in a
+// subdirectory of root and measures it.
+func runScenario(root string, s Scenario, backend string, n int) (Result, error) {
+	res := Result{Scenario: s.Name, Backend: backend, N: n}
+	newBackend, ok := Backends[backend]
+	if !ok {
+		return res, fmt.Errorf("unknown backend %q", backend)
+	}
+	dir := filepath.Join(root, s.Name+"-"+backend)
+	b, err := newBackend(dir)
+	if err != nil {
+		return res, err
+	}
+	fresh := func() (Backend, error) {
+		return newBackend(dir + "-fresh")
+	}
+
+	start := time.Now()
+	err = s.Run(b, n, fresh)
+	if err != nil {
+		b.Close()
+		return res, fmt.Errorf("%s on %s: %w", s.Name, backend, err)
+	}
+	err = b.Close()
+	elapsed := time.Since(start)
+	if err != nil {
+		return res, err
+	}
+
+	res.NsPerOp = float64(elapsed.Nanoseconds()) / float64(max(n, 1))
+	res.Objects, err = b.Objects()
+	if err != nil {
+		return res, err
+	}
+	res.BytesOnDisk, err = diskUsage(b.Dir())
+	return res, err
+}
+
+// Regression is a metric that got worse than the baseline allows.
+type Regression struct {
+	Scenario string
+	Backend  string
+	Metric   string
+	Baseline float64
+	Current  float64
+}
+
+func (r Regression) String() string {
+	return fmt.Sprintf("%s/%s: %s %.0f -> %.0f (%+.1f%%)",
+		r.Scenario, r.Backend, r.Metric, r.Baseline, r.Current, 100*(r.Current/r.Baseline-1))
+}
+
+// Compare returns the metrics in cur that exceed their baseline by
+// more than threshold, a fraction such as 0.2 for 20%.  Timings vary
+// from run to run, and so do pack sizes, since objects are packed in
+// no fixed order, so ns/op and bytes get the threshold.  Object counts
+// should not change at all for the same n, so any increase in them is
+// a regression.  Results with no baseline, or a different n, are
+// skipped.
+func Compare(base, cur *Report, threshold float64) []Regression {
+	type key struct{ scenario, backend string }
+	old := make(map[key]Result)
+	for _, r := range base.Results {
+		old[key{r.Scenario, r.Backend}] = r
+	}
+	var regs []Regression
+	for _, r := range cur.Results {
+		b, ok := old[key{r.Scenario, r.Backend}]
+		if !ok || b.N != r.N {
+			continue
+		}
+		add := func(metric string, was, is float64, limit float64) {
+			if is > was*(1+limit) {
+				regs = append(regs, Regression{r.Scenario, r.Backend, metric, was, is})
+			}
+		}
+		add("ns/op", b.NsPerOp, r.NsPerOp, threshold)
+		add("bytes", float64(b.BytesOnDisk), float64(r.BytesOnDisk), threshold)
+		add("objects", float64(b.Objects), float64(r.Objects), 0)
+	}
+	return regs
+}
+
+// readReport reads a report saved as JSON.
+func readReport(fn string) (*Report, error) {
+	buf, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	var rep Report
+	err = json.Unmarshal(buf, &rep)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+	return &rep, nil
+}
+
+// writeReport writes a report as indented JSON.
+func writeReport(w io.Writer, rep *Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rep)
+}
+
+// writeTable writes results as an aligned text table.
+func writeTable(w io.Writer, rep *Report) {
+	fmt.Fprintf(w, "%-18s %-7s %8s %14s %12s %8s\n", "scenario", "backend", "n", "ns/op", "bytes", "objects")
+	for _, r := range rep.Results {
+		fmt.Fprintf(w, "%-18s %-7s %8d %14.0f %12d %8d\n",
+			r.Scenario, r.Backend, r.N, r.NsPerOp, r.BytesOnDisk, r.Objects)
+	}
+}
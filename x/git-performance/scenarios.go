@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Scenario is one workload.  Run performs n operations against b.
+// fresh creates another empty backend of the same kind, for
+// scenarios that compare two repositories.
+type Scenario struct {
+	Name string
+	Run  func(b Backend, n int, fresh func() (Backend, error)) error
+}
+
+// Scenarios lists the workloads in the order they are reported.
+var Scenarios = []Scenario{
+	{"commits", runCommits},
+	{"branches", runBranches},
+	{"objects", runObjects},
+	{"referenced", runReferenced},
+	{"commitWithParents", runCommitWithParents},
+	{"repeatability", runRepeatability},
+}
+
+// findScenario returns the scenario with the given name.
+func findScenario(name string) (Scenario, error) {
+	for _, s := range Scenarios {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	return Scenario{}, fmt.Errorf("unknown scenario %q", name)
+}
+
+// epoch is the time of the first commit.  Commits are one second
+// apart, so that every run writes the same objects.
+var epoch = time.Unix(1700000000, 0).UTC()
+
+// commitN makes commit i of a run.
+func commitN(b Backend, i int, parents []string) (string, error) {
+	return b.Commit(fmt.Sprintf("time: %d", i), parents, epoch.Add(time.Duration(i)*time.Second))
+}
+
+// runCommits makes n commits in a line, then reads every version of
+// example.txt back.
+func runCommits(b Backend, n int, _ func() (Backend, error)) error {
+	for i := 0; i < n; i++ {
+		_, err := commitN(b, i, nil)
+		if err != nil {
+			return err
+		}
+	}
+	got, err := b.Versions()
+	if err != nil {
+		return err
+	}
+	if got != n {
+		return fmt.Errorf("read %d versions, want %d", got, n)
+	}
+	return nil
+}
+
+// runBranches makes one commit and n branches pointing at it.
+func runBranches(b Backend, n int, _ func() (Backend, error)) error {
+	_, err := commitN(b, 0, nil)
+	if err != nil {
+		return err
+	}
+	head, err := b.Head()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		err = b.SetRef(fmt.Sprintf("refs/heads/branch-%d", i), head)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runObjects stores n unreferenced blobs.
+func runObjects(b Backend, n int, _ func() (Backend, error)) error {
+	for i := 0; i < n; i++ {
+		_, err := b.Blob(fmt.Sprintf("blob content %d", i))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runReferenced stores n blobs, each with a ref pointing at it.
+func runReferenced(b Backend, n int, _ func() (Backend, error)) error {
+	for i := 0; i < n; i++ {
+		hash, err := b.Blob(fmt.Sprintf("blob content %d", i))
+		if err != nil {
+			return err
+		}
+		err = b.SetRef(fmt.Sprintf("refs/heads/ref-%d", i), hash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runCommitWithParents makes n commits in a line and then a merge
+// commit with all of them as parents.
+func runCommitWithParents(b Backend, n int, _ func() (Backend, error)) error {
+	parents := make([]string, n)
+	for i := 0; i < n; i++ {
+		hash, err := commitN(b, i, nil)
+		if err != nil {
+			return err
+		}
+		parents[i] = hash
+	}
+	_, err := commitN(b, n, parents)
+	return err
+}
+
+// runRepeatability makes n commits in two fresh repositories and
+// checks that both give the same hashes.
+func runRepeatability(b Backend, n int, fresh func() (Backend, error)) error {
+	other, err := fresh()
+	if err != nil {
+		return err
+	}
+	defer other.Close()
+	for i := 0; i < n; i++ {
+		h1, err := commitN(b, i, nil)
+		if err != nil {
+			return err
+		}
+		h2, err := commitN(other, i, nil)
+		if err != nil {
+			return err
+		}
+		if h1 != h2 {
+			return fmt.Errorf("commit %d: hashes do not match: %s %s", i, h1, h2)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// descriptorFile is the name of a subcommand's descriptor in its
+// directory under the grid path.
+const descriptorFile = "descriptor.cbor"
+
+// Descriptor represents a grid subcommand's execution specification.
+// Its keys are strings, as DAG-CBOR requires, so that a descriptor is
+// valid IPLD data.
+type Descriptor struct {
+	APIVersion string   `json:"apiVersion" cbor:"apiVersion"`
+	Kind       string   `json:"kind" cbor:"kind"`
+	Metadata   Metadata `json:"metadata" cbor:"metadata"`
+	Spec       Spec     `json:"spec" cbor:"spec"`
+}
+
+// Metadata names a subcommand and gives its help text.
+type Metadata struct {
+	Name string `json:"name" cbor:"name"`
+	// Usage follows the name in the usage line, as in "[flags] file".
+	Usage       string            `json:"usage,omitempty" cbor:"usage,omitempty"`
+	Short       string            `json:"short,omitempty" cbor:"short,omitempty"`
+	Long        string            `json:"long,omitempty" cbor:"long,omitempty"`
+	Example     string            `json:"example,omitempty" cbor:"example,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty" cbor:"annotations,omitempty"`
+}
+
+// Spec says how a subcommand is run.
+type Spec struct {
+	// Runtime names the runtime that runs the subcommand.
+	Runtime string `json:"runtime" cbor:"runtime"`
+	// Entry is what the runtime runs, such as an executable path,
+	// relative to the descriptor's directory unless absolute.
+	Entry string `json:"entry,omitempty" cbor:"entry,omitempty"`
+	// Args come before the command line's arguments.
+	Args []string `json:"args,omitempty" cbor:"args,omitempty"`
+	// Env holds environment variables to set, as name=value.
+	Env []string `json:"env,omitempty" cbor:"env,omitempty"`
+	// Flags are the subcommand's flags, parsed by grid and passed on
+	// to the runtime.
+	Flags []Flag `json:"flags,omitempty" cbor:"flags,omitempty"`
+	// Executable is an embedded executable, run in place of Entry,
+	// with the sha256 Checksum as written by x/descriptors embed.
+	Executable []byte `json:"executable,omitempty" cbor:"executable,omitempty"`
+	Checksum   []byte `json:"checksum,omitempty" cbor:"checksum,omitempty"`
+	// Wasm says how the wasm runtime runs the subcommand.
+	Wasm *WasmSpec `json:"wasm,omitempty" cbor:"wasm,omitempty"`
+}
+
+// Flag declares a subcommand flag.
+type Flag struct {
+	Name      string `json:"name" cbor:"name"`
+	Shorthand string `json:"shorthand,omitempty" cbor:"shorthand,omitempty"`
+	// Type is "string", the default, "bool" or "int".
+	Type    string `json:"type,omitempty" cbor:"type,omitempty"`
+	Default string `json:"default,omitempty" cbor:"default,omitempty"`
+	Usage   string `json:"usage,omitempty" cbor:"usage,omitempty"`
+}
+
+// loadDescriptor reads the descriptor in dir.  A descriptor without a
+// name is named after dir.
+func loadDescriptor(dir string) (*Descriptor, error) {
+	data, err := os.ReadFile(filepath.Join(dir, descriptorFile))
+	if err != nil {
+		return nil, err
+	}
+	var desc Descriptor
+	err = cbor.Unmarshal(data, &desc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Join(dir, descriptorFile), err)
+	}
+	if desc.Metadata.Name == "" {
+		desc.Metadata.Name = filepath.Base(dir)
+	}
+	return &desc, nil
+}
+
+// discoverDescriptors finds the subcommand descriptors in gridpath, a
+// list of directories separated like PATH.  Each subdirectory of a
+// directory holding a descriptor.cbor is a subcommand; as with PATH,
+// the first directory giving a name wins.  It returns the descriptors
+// and the directories they were found in, and the errors of
+// descriptors that could not be loaded.
+func discoverDescriptors(gridpath string) (descs []*Descriptor, dirs []string, errs []error) {
+	seen := make(map[string]bool)
+	for _, root := range filepath.SplitList(gridpath) {
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		for _, e := range entries {
+			if !e.IsDir() {
+				continue
+			}
+			dir := filepath.Join(root, e.Name())
+			desc, err := loadDescriptor(dir)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if seen[desc.Metadata.Name] {
+				continue
+			}
+			seen[desc.Metadata.Name] = true
+			descs = append(descs, desc)
+			dirs = append(dirs, dir)
+		}
+	}
+	return descs, dirs, errs
+}
+
+// registerDescriptors adds a command to root for each descriptor in
+// gridpath whose name is not taken by an existing command.
+func registerDescriptors(root *cobra.Command, gridpath string) []error {
+	descs, dirs, errs := discoverDescriptors(gridpath)
+	for i, desc := range descs {
+		if hasCommand(root, desc.Metadata.Name) {
+			continue
+		}
+		cmd, err := descriptorCmd(desc, dirs[i], root.PersistentFlags())
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		root.AddCommand(cmd)
+	}
+	return errs
+}
+
+// hasCommand reports whether root has a command called name.
+func hasCommand(root *cobra.Command, name string) bool {
+	for _, c := range root.Commands() {
+		if c.Name() == name || c.HasAlias(name) {
+			return true
+		}
+	}
+	return name == "help" || name == "completion"
+}
+
+// descriptorCmd returns a command that runs desc, found in dir.  Its
+// flags may not reuse the names or shorthands of inherited flags.
+func descriptorCmd(desc *Descriptor, dir string, inherited *pflag.FlagSet) (*cobra.Command, error) {
+	use := desc.Metadata.Name
+	if desc.Metadata.Usage != "" {
+		use += " " + desc.Metadata.Usage
+	}
+	short := desc.Metadata.Short
+	if short == "" {
+		short = fmt.Sprintf("Run %s (%s runtime)", desc.Metadata.Name, desc.Spec.Runtime)
+	}
+	cmd := &cobra.Command{
+		Use:          use,
+		Short:        short,
+		Long:         desc.Metadata.Long,
+		Example:      desc.Metadata.Example,
+		SilenceUsage: true,
+		// main reports errors, except for a failed process, which
+		// reports its own
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return executeDescriptor(cmd, desc, dir, append(flagArgs(cmd, desc), args...))
+		},
+	}
+	for _, f := range desc.Spec.Flags {
+		err := addFlag(cmd, f, inherited)
+		if err != nil {
+			return nil, fmt.Errorf("%s: flag %s: %w", desc.Metadata.Name, f.Name, err)
+		}
+	}
+	return cmd, nil
+}
+
+// addFlag declares f on cmd.
+func addFlag(cmd *cobra.Command, f Flag, inherited *pflag.FlagSet) error {
+	if f.Name == "" || f.Name == "help" || len(f.Shorthand) > 1 {
+		return fmt.Errorf("invalid name %q or shorthand %q", f.Name, f.Shorthand)
+	}
+	flags := cmd.Flags()
+	if inherited.Lookup(f.Name) != nil || flags.Lookup(f.Name) != nil {
+		return fmt.Errorf("name %q already in use", f.Name)
+	}
+	if f.Shorthand != "" && (inherited.ShorthandLookup(f.Shorthand) != nil || flags.ShorthandLookup(f.Shorthand) != nil) {
+		return fmt.Errorf("shorthand %q already in use", f.Shorthand)
+	}
+	switch f.Type {
+	case "", "string":
+		flags.StringP(f.Name, f.Shorthand, f.Default, f.Usage)
+	case "bool":
+		flags.BoolP(f.Name, f.Shorthand, f.Default == "true", f.Usage)
+	case "int":
+		def := 0
+		if f.Default != "" {
+			var err error
+			def, err = strconv.Atoi(f.Default)
+			if err != nil {
+				return err
+			}
+		}
+		flags.IntP(f.Name, f.Shorthand, def, f.Usage)
+	default:
+		return fmt.Errorf("unknown type %q", f.Type)
+	}
+	return nil
+}
+
+// flagArgs returns the declared flags set on the command line as
+// --name=value arguments, in declaration order.
+func flagArgs(cmd *cobra.Command, desc *Descriptor) []string {
+	var args []string
+	for _, f := range desc.Spec.Flags {
+		flag := cmd.Flags().Lookup(f.Name)
+		if flag == nil || !flag.Changed {
+			continue
+		}
+		args = append(args, "--"+f.Name+"="+flag.Value.String())
+	}
+	return args
+}
+
+// descriptorCommand returns the command that manages descriptors.
+func descriptorCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "descriptor",
+		Short: "Manage subcommand descriptors",
+	}
+
+	var output string
+	encodeCmd := &cobra.Command{
+		Use:   "encode [descriptor.json]",
+		Short: "Encode a JSON descriptor as descriptor.cbor",
+		Long: "Encode a JSON descriptor as CBOR, by default into descriptor.cbor " +
+			"beside it, so that a directory of the grid path can serve it as a subcommand",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			var desc Descriptor
+			err = json.Unmarshal(data, &desc)
+			if err != nil {
+				return fmt.Errorf("%s: %w", args[0], err)
+			}
+			em, err := cbor.CoreDetEncOptions().EncMode()
+			if err != nil {
+				return err
+			}
+			encoded, err := em.Marshal(desc)
+			if err != nil {
+				return err
+			}
+			if output == "" {
+				output = filepath.Join(filepath.Dir(args[0]), descriptorFile)
+			}
+			err = os.WriteFile(output, encoded, 0644)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Written to: %s\n", output)
+			return nil
+		},
+	}
+	encodeCmd.Flags().StringVarP(&output, "output", "o", "", "Output file")
+
+	lsCmd := &cobra.Command{
+		Use:   "ls",
+		Short: "List the descriptors found in the grid path",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			descs, dirs, errs := discoverDescriptors(viper.GetString("gridpath"))
+			for i, desc := range descs {
+				fmt.Fprintf(cmd.OutOrStdout(), "%-16s %-8s %s\n", desc.Metadata.Name, desc.Spec.Runtime, dirs[i])
+			}
+			for _, err := range errs {
+				fmt.Fprintln(cmd.ErrOrStderr(), err)
+			}
+			return nil
+		},
+	}
+
+	cmd.AddCommand(encodeCmd)
+	cmd.AddCommand(lsCmd)
+	return cmd
+}
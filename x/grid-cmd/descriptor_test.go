@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/spf13/cobra"
+)
+
+// writeDescriptor writes desc as root/name/descriptor.cbor and returns
+// the directory.
+func writeDescriptor(t *testing.T, root, name string, desc Descriptor) string {
+	t.Helper()
+	dir := filepath.Join(root, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	data, err := cbor.Marshal(desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, descriptorFile), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// echoScript is a native executable that prints its arguments.
+const echoScript = "#!/bin/sh\necho \"$@\"\n"
+
+func nativeDescriptor(name, entry string, flags ...Flag) Descriptor {
+	desc := Descriptor{APIVersion: "grid.io/v1", Kind: "Subcommand"}
+	desc.Metadata.Name = name
+	desc.Metadata.Short = name + " help"
+	desc.Spec.Runtime = "native"
+	desc.Spec.Entry = entry
+	desc.Spec.Flags = flags
+	return desc
+}
+
+// run runs root with args and returns its output.
+func run(t *testing.T, root *cobra.Command, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	root.SetOut(&out)
+	root.SetErr(&out)
+	root.SetArgs(args)
+	err := root.Execute()
+	return out.String(), err
+}
+
+func newRoot() *cobra.Command {
+	root := &cobra.Command{Use: "grid"}
+	root.PersistentFlags().StringP("gridpath", "g", "", "")
+	root.AddCommand(&cobra.Command{Use: "cd", Run: func(*cobra.Command, []string) {}})
+	return root
+}
+
+func TestDiscoverDescriptors(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+	writeDescriptor(t, a, "print", nativeDescriptor("print", "print.sh"))
+	writeDescriptor(t, b, "print", nativeDescriptor("print", "other.sh"))
+	// Named after its directory.
+	writeDescriptor(t, b, "quote", nativeDescriptor("", "quote.sh"))
+	if err := os.MkdirAll(filepath.Join(b, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(b, "bad"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(b, "bad", descriptorFile), []byte{0xff}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	gridpath := strings.Join([]string{a, filepath.Join(a, "missing"), b}, string(filepath.ListSeparator))
+	descs, dirs, errs := discoverDescriptors(gridpath)
+	if len(descs) != 2 {
+		t.Fatalf("found %d descriptors, want 2", len(descs))
+	}
+	if descs[0].Metadata.Name != "print" || dirs[0] != filepath.Join(a, "print") {
+		t.Errorf("first descriptor %s in %s", descs[0].Metadata.Name, dirs[0])
+	}
+	if descs[1].Metadata.Name != "quote" {
+		t.Errorf("second descriptor %q", descs[1].Metadata.Name)
+	}
+	if len(errs) != 1 {
+		t.Errorf("errors %v, want one for the bad descriptor", errs)
+	}
+}
+
+func TestDescriptorDispatch(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+	gridpath := t.TempDir()
+	dir := writeDescriptor(t, gridpath, "print", nativeDescriptor("print", "print.sh",
+		Flag{Name: "printer", Shorthand: "P", Default: "default", Usage: "Printer path"},
+		Flag{Name: "copies", Type: "int", Default: "1"},
+		Flag{Name: "duplex", Type: "bool"},
+	))
+	if err := os.WriteFile(filepath.Join(dir, "print.sh"), []byte(echoScript), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// Built-in commands are not replaced.
+	writeDescriptor(t, gridpath, "cd", nativeDescriptor("cd", "cd.sh"))
+	// Flags may not shadow inherited ones.
+	writeDescriptor(t, gridpath, "pos", nativeDescriptor("pos", "pos.sh", Flag{Name: "motor", Shorthand: "g"}))
+
+	root := newRoot()
+	errs := registerDescriptors(root, gridpath)
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "pos") {
+		t.Fatalf("registration errors %v, want one for pos", errs)
+	}
+
+	out, err := run(t, root, "print", "-P", "ship2", "--duplex", "--copies", "2", "procedures/674-ND-16X.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "--printer=ship2 --copies=2 --duplex=true procedures/674-ND-16X.md\n"
+	if out != want {
+		t.Errorf("output %q, want %q", out, want)
+	}
+
+	out, err = run(t, root, "help", "print")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"print help", "--printer", "Printer path", "--copies"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("help lacks %q:\n%s", s, out)
+		}
+	}
+
+	if _, err := run(t, root, "print", "--copies", "two"); err == nil {
+		t.Error("int flag accepted a non-int")
+	}
+}
+
+func TestEmbeddedExecutable(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+	gridpath := t.TempDir()
+	desc := nativeDescriptor("hello", "")
+	desc.Spec.Args = []string{"hello"}
+	desc.Spec.Executable = []byte(echoScript)
+	sum := sha256.Sum256(desc.Spec.Executable)
+	desc.Spec.Checksum = sum[:]
+	writeDescriptor(t, gridpath, "hello", desc)
+
+	desc.Metadata.Name = "tampered"
+	desc.Spec.Executable = []byte(echoScript + "echo tampered\n")
+	writeDescriptor(t, gridpath, "tampered", desc)
+
+	desc = nativeDescriptor("vm", "vm.bin")
+	desc.Spec.Runtime = "vm"
+	writeDescriptor(t, gridpath, "vm", desc)
+
+	root := newRoot()
+	if errs := registerDescriptors(root, gridpath); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	out, err := run(t, root, "hello", "world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "hello world\n" {
+		t.Errorf("output %q", out)
+	}
+	if _, err := run(t, root, "tampered"); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("tampered executable: %v", err)
+	}
+	if _, err := run(t, root, "vm"); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("unknown runtime: %v", err)
+	}
+}
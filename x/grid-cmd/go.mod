@@ -1,10 +1,32 @@
 module github.com/promisegrid/grid-poc/x/grid-cmd
 
-go 1.23.4
+go 1.24.0
 
 require (
+	github.com/fxamacker/cbor/v2 v2.9.0
+	github.com/ipfs/go-cid v0.5.0
+	github.com/ipld/go-ipld-prime v0.21.0
 	github.com/spf13/cobra v1.10.1
+	github.com/spf13/pflag v1.0.10
 	github.com/spf13/viper v1.21.0
+	github.com/stevegt/grid-poc/x/ipld-path v0.0.0-00010101000000-000000000000
+	github.com/stevegt/grid-poc/x/pcid v0.0.0-00010101000000-000000000000
+	github.com/tetratelabs/wazero v1.10.1
+)
+
+require (
+	github.com/klauspost/cpuid/v2 v2.0.9 // indirect
+	github.com/minio/sha256-simd v1.0.0 // indirect
+	github.com/mr-tron/base58 v1.2.0 // indirect
+	github.com/multiformats/go-base32 v0.0.3 // indirect
+	github.com/multiformats/go-base36 v0.1.0 // indirect
+	github.com/multiformats/go-multibase v0.2.0 // indirect
+	github.com/multiformats/go-multihash v0.2.3
+	github.com/multiformats/go-varint v0.0.7 // indirect
+	github.com/polydawn/refmt v0.89.0 // indirect
+	github.com/spaolacci/murmur3 v1.1.0 // indirect
+	golang.org/x/crypto v0.31.0 // indirect
+	lukechampine.com/blake3 v1.1.6 // indirect
 )
 
 require (
@@ -16,9 +38,19 @@ require (
 	github.com/sourcegraph/conc v0.3.1-0.20240121214520-5f936abd7ae8 // indirect
 	github.com/spf13/afero v1.15.0 // indirect
 	github.com/spf13/cast v1.10.0 // indirect
-	github.com/spf13/pflag v1.0.10 // indirect
+	github.com/stevegt/grid-poc v0.0.0-00010101000000-000000000000 // indirect
+	github.com/stevegt/grid-poc/x/ipld-store v0.0.0-00010101000000-000000000000 // indirect
 	github.com/subosito/gotenv v1.6.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
 	go.yaml.in/yaml/v3 v3.0.4 // indirect
 	golang.org/x/sys v0.29.0 // indirect
 	golang.org/x/text v0.28.0 // indirect
 )
+
+replace github.com/stevegt/grid-poc/x/ipld-path => ../ipld-path
+
+replace github.com/stevegt/grid-poc/x/pcid => ../pcid
+
+replace github.com/stevegt/grid-poc => ../..
+
+replace github.com/stevegt/grid-poc/x/ipld-store => ../ipld-store
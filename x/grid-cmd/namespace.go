@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	// Blocks may be in any of these codecs.
+	_ "github.com/ipld/go-ipld-prime/codec/dagcbor"
+	_ "github.com/ipld/go-ipld-prime/codec/dagjson"
+	_ "github.com/ipld/go-ipld-prime/codec/raw"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	ipldpath "github.com/stevegt/grid-poc/x/ipld-path"
+)
+
+// ErrNoRoot is returned when a path names no CID to start from and
+// there is no root.
+var ErrNoRoot = errors.New("no root: start the path with a CID or set --root")
+
+// Namespace resolves grid paths.  A grid path is a slash-separated
+// list of segments, walked through IPLD data: each segment is a map
+// key or list index, except that a segment that is a CID starts the
+// walk again at that block.  An absolute path that has no CID starts
+// at Root.
+type Namespace struct {
+	Resolver ipldpath.Resolver
+	Root     cid.Cid
+}
+
+// newNamespace returns a Namespace reading blocks from dir, where each
+// block is a file named by its CID, as gridcar unpack writes them.
+func newNamespace(dir string, root cid.Cid) *Namespace {
+	ls := cidlink.DefaultLinkSystem()
+	ls.SetReadStorage(&dirStorage{dir: dir})
+	return &Namespace{Resolver: ipldpath.Resolver{LinkSystem: ls}, Root: root}
+}
+
+// Abs returns p as a clean absolute path, relative to cwd unless it
+// is absolute already.
+func Abs(cwd, p string) string {
+	if !strings.HasPrefix(p, "/") {
+		p = cwd + "/" + p
+	}
+	return path.Clean("/" + p)
+}
+
+// split returns the CID an absolute path starts from, its last CID
+// segment or else the root, and the segments after it.
+func (ns *Namespace) split(p string) (cid.Cid, []string, error) {
+	var segs []string
+	for _, s := range strings.Split(p, "/") {
+		if s != "" {
+			segs = append(segs, s)
+		}
+	}
+	for i := len(segs) - 1; i >= 0; i-- {
+		c, err := cid.Decode(segs[i])
+		if err == nil {
+			return c, segs[i+1:], nil
+		}
+	}
+	if !ns.Root.Defined() {
+		return cid.Undef, nil, ErrNoRoot
+	}
+	return ns.Root, segs, nil
+}
+
+// Resolve returns the node at an absolute path.
+func (ns *Namespace) Resolve(ctx context.Context, p string) (datamodel.Node, error) {
+	start, segs, err := ns.split(p)
+	if err != nil {
+		return nil, err
+	}
+	res, err := ns.Resolver.Resolve(ctx, cidlink.Link{Cid: start}, datamodel.NewPath(pathSegments(segs)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p, err)
+	}
+	return res.Node, nil
+}
+
+func pathSegments(segs []string) []datamodel.PathSegment {
+	out := make([]datamodel.PathSegment, len(segs))
+	for i, s := range segs {
+		out[i] = datamodel.PathSegmentOfString(s)
+	}
+	return out
+}
+
+// isDir reports whether n can be listed and changed into.
+func isDir(n datamodel.Node) bool {
+	return n.Kind() == datamodel.Kind_Map || n.Kind() == datamodel.Kind_List
+}
+
+// Entry is a child of a node.
+type Entry struct {
+	Name string
+	// Node is the child, unresolved: a link is returned as a link.
+	Node datamodel.Node
+}
+
+// List returns the children of the node at an absolute path: a map's
+// entries in key order, or a list's items by index.
+func (ns *Namespace) List(ctx context.Context, p string) ([]Entry, error) {
+	n, err := ns.Resolve(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if !isDir(n) {
+		return nil, fmt.Errorf("%s: not a map or list", p)
+	}
+	var entries []Entry
+	it := n.MapIterator()
+	if it != nil {
+		for !it.Done() {
+			k, v, err := it.Next()
+			if err != nil {
+				return nil, err
+			}
+			name, err := k.AsString()
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, Entry{Name: name, Node: v})
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+		return entries, nil
+	}
+	li := n.ListIterator()
+	for !li.Done() {
+		i, v, err := li.Next()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, Entry{Name: strconv.FormatInt(i, 10), Node: v})
+	}
+	return entries, nil
+}
+
+// describe returns a short description of a child node.
+func describe(n datamodel.Node) string {
+	switch n.Kind() {
+	case datamodel.Kind_Link:
+		lnk, _ := n.AsLink()
+		return "-> " + lnk.String()
+	case datamodel.Kind_Map:
+		return fmt.Sprintf("map (%d entries)", n.Length())
+	case datamodel.Kind_List:
+		return fmt.Sprintf("list (%d items)", n.Length())
+	case datamodel.Kind_Bytes:
+		b, _ := n.AsBytes()
+		return fmt.Sprintf("bytes (%d)", len(b))
+	case datamodel.Kind_String:
+		s, _ := n.AsString()
+		return strconv.Quote(s)
+	case datamodel.Kind_Int:
+		i, _ := n.AsInt()
+		return strconv.FormatInt(i, 10)
+	case datamodel.Kind_Bool:
+		b, _ := n.AsBool()
+		return strconv.FormatBool(b)
+	default:
+		return n.Kind().String()
+	}
+}
+
+// Complete returns the completions of a partial path typed in cwd: the
+// children of the directory it names that start with its last
+// segment.  Children that can be walked into end with a slash.
+func (ns *Namespace) Complete(ctx context.Context, cwd, partial string) []string {
+	dir, prefix := "", partial
+	if i := strings.LastIndex(partial, "/"); i >= 0 {
+		dir, prefix = partial[:i+1], partial[i+1:]
+	}
+	entries, err := ns.List(ctx, Abs(cwd, dir))
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name, prefix) {
+			continue
+		}
+		name := dir + e.Name
+		if e.Node.Kind() == datamodel.Kind_Link || isDir(e.Node) {
+			name += "/"
+		}
+		out = append(out, name)
+	}
+	return out
+}
+
+// dirStorage is IPLD read storage keeping each block in a file named
+// by its CID.
+type dirStorage struct {
+	dir string
+}
+
+func (d *dirStorage) file(key string) (string, error) {
+	_, c, err := cid.CidFromBytes([]byte(key))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(d.dir, c.String()), nil
+}
+
+func (d *dirStorage) Has(ctx context.Context, key string) (bool, error) {
+	f, err := d.file(key)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(f)
+	return err == nil, nil
+}
+
+func (d *dirStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	f, err := d.file(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(f)
+}
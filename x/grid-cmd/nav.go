@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// gridHome returns the directory grid keeps its files in by default.
+func gridHome() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".grid"
+	}
+	return filepath.Join(home, ".grid")
+}
+
+// session loads the session named by the configuration.
+func session() (*Session, error) {
+	return loadSession(viper.GetString("session"))
+}
+
+// saveSession saves s to the session file named by the configuration.
+func saveSession(s *Session) error {
+	return s.save(viper.GetString("session"))
+}
+
+// namespace returns the Namespace named by the configuration.
+func namespace() (*Namespace, error) {
+	root := cid.Undef
+	if r := viper.GetString("root"); r != "" {
+		var err error
+		root, err = cid.Decode(r)
+		if err != nil {
+			return nil, fmt.Errorf("root %q: %w", r, err)
+		}
+	}
+	return newNamespace(viper.GetString("store"), root), nil
+}
+
+// completePath completes a grid path argument.
+func completePath(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	directive := cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+	s, err := session()
+	if err != nil {
+		return nil, directive
+	}
+	ns, err := namespace()
+	if err != nil {
+		return nil, directive
+	}
+	return ns.Complete(cmd.Context(), s.Cwd, toComplete), directive
+}
+
+func cdCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:               "cd [path]",
+		Short:             "Navigate grid path",
+		Long:              "Change the current grid path, kept in the session file; with no path, go to /",
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completePath,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			s, err := session()
+			if err != nil {
+				return err
+			}
+			target := "/"
+			if len(args) > 0 {
+				target = Abs(s.Cwd, args[0])
+			}
+			if target != "/" {
+				ns, err := namespace()
+				if err != nil {
+					return err
+				}
+				n, err := ns.Resolve(cmd.Context(), target)
+				if err != nil {
+					return err
+				}
+				if !isDir(n) {
+					return fmt.Errorf("%s: not a map or list", target)
+				}
+			}
+			s.Cwd = target
+			return saveSession(s)
+		},
+	}
+}
+
+func pwdCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "pwd",
+		Short: "Print the current grid path",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			s, err := session()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), s.Cwd)
+			return nil
+		},
+	}
+}
+
+func lsCmd() *cobra.Command {
+	var long bool
+	cmd := &cobra.Command{
+		Use:               "ls [path]...",
+		Short:             "List the entries at grid paths",
+		ValidArgsFunction: completePath,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			s, err := session()
+			if err != nil {
+				return err
+			}
+			ns, err := namespace()
+			if err != nil {
+				return err
+			}
+			if len(args) == 0 {
+				args = []string{"."}
+			}
+			out := cmd.OutOrStdout()
+			for i, arg := range args {
+				p := Abs(s.Cwd, arg)
+				entries, err := ns.List(cmd.Context(), p)
+				if err != nil {
+					return err
+				}
+				if len(args) > 1 {
+					if i > 0 {
+						fmt.Fprintln(out)
+					}
+					fmt.Fprintf(out, "%s:\n", p)
+				}
+				for _, e := range entries {
+					if long {
+						fmt.Fprintf(out, "%-24s %s\n", e.Name, describe(e.Node))
+					} else {
+						fmt.Fprintln(out, e.Name)
+					}
+				}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVarP(&long, "long", "l", false, "Describe each entry")
+	return cmd
+}
+
+func catCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:               "cat [path]...",
+		Short:             "Print the data at grid paths",
+		Long:              "Print bytes and strings as they are, and other data as DAG-JSON",
+		Args:              cobra.MinimumNArgs(1),
+		ValidArgsFunction: completePath,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			s, err := session()
+			if err != nil {
+				return err
+			}
+			ns, err := namespace()
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			for _, arg := range args {
+				n, err := ns.Resolve(cmd.Context(), Abs(s.Cwd, arg))
+				if err != nil {
+					return err
+				}
+				switch n.Kind() {
+				case datamodel.Kind_Bytes:
+					b, _ := n.AsBytes()
+					_, err = out.Write(b)
+				case datamodel.Kind_String:
+					str, _ := n.AsString()
+					_, err = fmt.Fprint(out, str)
+				default:
+					err = dagjson.Encode(n, out)
+					if err == nil {
+						_, err = fmt.Fprintln(out)
+					}
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func envCmd() *cobra.Command {
+	subcmd := &cobra.Command{
+		Use:   "env",
+		Short: "Manage environment",
+		Long: "List the variables in effect at the current grid path: those set on it " +
+			"and on the paths above it, the nearest winning.  Descriptor commands run with them.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			s, err := session()
+			if err != nil {
+				return err
+			}
+			for _, v := range s.Environ(s.Cwd) {
+				fmt.Fprintln(cmd.OutOrStdout(), v)
+			}
+			return nil
+		},
+	}
+
+	setCmd := &cobra.Command{
+		Use:   "set [name] [value]",
+		Short: "Set variable",
+		Long:  "Set a variable on the current grid path, given as name value or name=value",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, value, ok := args[0], "", len(args) == 2
+			if ok {
+				value = args[1]
+			} else {
+				name, value, ok = strings.Cut(args[0], "=")
+			}
+			if !ok || name == "" || strings.Contains(name, "=") {
+				return fmt.Errorf("name and value required")
+			}
+			s, err := session()
+			if err != nil {
+				return err
+			}
+			s.Setenv(s.Cwd, name, value)
+			return saveSession(s)
+		},
+	}
+
+	unsetCmd := &cobra.Command{
+		Use:   "unset [name]",
+		Short: "Unset variable",
+		Long:  "Remove a variable set on the current grid path",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			s, err := session()
+			if err != nil {
+				return err
+			}
+			s.Unsetenv(s.Cwd, args[0])
+			return saveSession(s)
+		},
+	}
+	subcmd.AddCommand(setCmd)
+	subcmd.AddCommand(unsetCmd)
+	return subcmd
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/multiformats/go-multihash"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// testDAG writes blocks to a temporary directory, as gridcar unpack
+// would, and returns the directory and the CIDs of the root and user
+// blocks:
+//
+//	root: {"did:key:abc": user, "readme": "hello"}
+//	user: {"bin": {"tool": "x"}, "data": bytes, "procedures": ["step 1", doc]}
+//	doc:  {"title": "674-ND-16X"}
+func testDAG(t *testing.T) (dir string, root, user cid.Cid) {
+	t.Helper()
+	dir = t.TempDir()
+	ls := cidlink.DefaultLinkSystem()
+	ls.StorageWriteOpener = func(linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+		var buf bytes.Buffer
+		return &buf, func(lnk datamodel.Link) error {
+			return os.WriteFile(filepath.Join(dir, lnk.String()), buf.Bytes(), 0644)
+		}, nil
+	}
+	lp := cidlink.LinkPrototype{Prefix: cid.Prefix{Version: 1, Codec: cid.DagCBOR, MhType: multihash.SHA2_256, MhLength: -1}}
+	store := func(n datamodel.Node, err error) cid.Cid {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		lnk, err := ls.Store(linking.LinkContext{}, lp, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lnk.(cidlink.Link).Cid
+	}
+
+	doc := store(qp.BuildMap(basicnode.Prototype.Map, 1, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "title", qp.String("674-ND-16X"))
+	}))
+	user = store(qp.BuildMap(basicnode.Prototype.Map, 3, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "procedures", qp.List(2, func(la datamodel.ListAssembler) {
+			qp.ListEntry(la, qp.String("step 1"))
+			qp.ListEntry(la, qp.Link(cidlink.Link{Cid: doc}))
+		}))
+		qp.MapEntry(ma, "bin", qp.Map(1, func(ma datamodel.MapAssembler) {
+			qp.MapEntry(ma, "tool", qp.String("x"))
+		}))
+		qp.MapEntry(ma, "data", qp.Bytes([]byte{1, 2, 3}))
+	}))
+	root = store(qp.BuildMap(basicnode.Prototype.Map, 2, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "readme", qp.String("hello"))
+		qp.MapEntry(ma, "did:key:abc", qp.Link(cidlink.Link{Cid: user}))
+	}))
+	return dir, root, user
+}
+
+func TestAbs(t *testing.T) {
+	cases := []struct{ cwd, p, want string }{
+		{"/", "a/b", "/a/b"},
+		{"/a/b", "..", "/a"},
+		{"/a/b", "../../..", "/"},
+		{"/a", "/c/./d/", "/c/d"},
+		{"/a", "", "/a"},
+	}
+	for _, c := range cases {
+		if got := Abs(c.cwd, c.p); got != c.want {
+			t.Errorf("Abs(%q, %q) = %q, want %q", c.cwd, c.p, got, c.want)
+		}
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	ctx := context.Background()
+	dir, root, user := testDAG(t)
+
+	ns := newNamespace(dir, cid.Undef)
+	if _, err := ns.Resolve(ctx, "/did:key:abc"); !errors.Is(err, ErrNoRoot) {
+		t.Fatalf("no root: %v", err)
+	}
+	// A CID segment needs no root.
+	n, err := ns.Resolve(ctx, "/"+user.String()+"/bin/tool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := n.AsString(); s != "x" {
+		t.Errorf("tool is %q", s)
+	}
+
+	ns.Root = root
+	for p, want := range map[string]string{
+		"/readme":                                     "hello",
+		"/did:key:abc/procedures/0":                   "step 1",
+		"/did:key:abc/procedures/1/title":             "674-ND-16X",
+		"/did:key:abc/" + user.String() + "/bin/tool": "x",
+	} {
+		n, err := ns.Resolve(ctx, p)
+		if err != nil {
+			t.Fatalf("%s: %v", p, err)
+		}
+		if s, _ := n.AsString(); s != want {
+			t.Errorf("%s is %q, want %q", p, s, want)
+		}
+	}
+	if _, err := ns.Resolve(ctx, "/did:key:abc/missing"); err == nil {
+		t.Error("missing segment resolved")
+	}
+
+	entries, err := ns.List(ctx, "/did:key:abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"bin", "data", "procedures"}) {
+		t.Errorf("entries %v", names)
+	}
+	if _, err := ns.List(ctx, "/readme"); err == nil {
+		t.Error("listed a string")
+	}
+
+	for _, c := range []struct {
+		cwd, partial string
+		want         []string
+	}{
+		{"/", "did", []string{"did:key:abc/"}},
+		{"/", "", []string{"did:key:abc/", "readme"}},
+		{"/did:key:abc", "procedures/", []string{"procedures/0", "procedures/1/"}},
+		{"/did:key:abc", "b", []string{"bin/"}},
+		{"/did:key:abc", "zzz", nil},
+	} {
+		got := ns.Complete(ctx, c.cwd, c.partial)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Complete(%q, %q) = %v, want %v", c.cwd, c.partial, got, c.want)
+		}
+	}
+}
+
+func TestSessionEnv(t *testing.T) {
+	s := &Session{Cwd: "/"}
+	s.Setenv("/", "A", "root")
+	s.Setenv("/", "B", "root")
+	s.Setenv("/x", "B", "x")
+	s.Setenv("/x/y", "C", "y")
+	s.Setenv("/xy", "D", "xy")
+	got := s.Environ("/x/y")
+	want := []string{"A=root", "B=x", "C=y"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Environ = %v, want %v", got, want)
+	}
+	s.Unsetenv("/x", "B")
+	if got := s.Environ("/x"); !reflect.DeepEqual(got, []string{"A=root", "B=root"}) {
+		t.Errorf("after unset, Environ = %v", got)
+	}
+	if _, ok := s.Env["/x"]; ok {
+		t.Error("empty path kept")
+	}
+
+	file := filepath.Join(t.TempDir(), "grid", "session.cbor")
+	if err := s.save(file); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadSession(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, s) {
+		t.Errorf("loaded %+v, saved %+v", loaded, s)
+	}
+}
+
+func TestNavCommands(t *testing.T) {
+	dir, root, _ := testDAG(t)
+	viper.Set("store", dir)
+	viper.Set("root", root.String())
+	viper.Set("session", filepath.Join(t.TempDir(), "session.cbor"))
+	t.Cleanup(func() {
+		for _, key := range []string{"store", "root", "session"} {
+			viper.Set(key, "")
+		}
+	})
+	newNavRoot := func() *cobra.Command {
+		r := &cobra.Command{Use: "grid"}
+		r.AddCommand(cdCmd(), pwdCmd(), lsCmd(), catCmd(), envCmd())
+		return r
+	}
+	expect := func(want string, args ...string) {
+		t.Helper()
+		out, err := run(t, newNavRoot(), args...)
+		if err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		if out != want {
+			t.Errorf("%v: output %q, want %q", args, out, want)
+		}
+	}
+
+	expect("/\n", "pwd")
+	expect("did:key:abc\nreadme\n", "ls")
+	expect("", "cd", "did:key:abc/procedures")
+	expect("/did:key:abc/procedures\n", "pwd")
+	expect("0                        \"step 1\"\n1                        -> "+mustLink(t, dir, root, "/did:key:abc/procedures/1")+"\n", "ls", "-l")
+	expect("{\"title\":\"674-ND-16X\"}\n", "cat", "1")
+	expect("hello", "cat", "/readme")
+	if _, err := run(t, newNavRoot(), "cd", "/readme"); err == nil {
+		t.Error("cd into a string")
+	}
+	if _, err := run(t, newNavRoot(), "cd", "nowhere"); err == nil {
+		t.Error("cd into a missing path")
+	}
+	expect("", "cd", "..")
+	expect("/did:key:abc\n", "pwd")
+
+	expect("", "env", "set", "PRINTER=ship2")
+	expect("", "cd", "bin")
+	expect("", "env", "set", "MOTOR", "servo1")
+	expect("MOTOR=servo1\nPRINTER=ship2\n", "env")
+	expect("", "cd", "/")
+	expect("", "env")
+	if _, err := run(t, newNavRoot(), "env", "set", "NOVALUE"); err == nil {
+		t.Error("env set without a value")
+	}
+
+	out, err := run(t, newNavRoot(), cobra.ShellCompRequestCmd, "cd", "did:key:abc/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out, "did:key:abc/bin/\n:") {
+		t.Errorf("completion output %q", out)
+	}
+}
+
+// mustLink returns the CID string of the link at path p.
+func mustLink(t *testing.T, dir string, root cid.Cid, p string) string {
+	t.Helper()
+	ns := newNamespace(dir, root)
+	entries, err := ns.List(context.Background(), p[:strings.LastIndex(p, "/")])
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := p[strings.LastIndex(p, "/")+1:]
+	for _, e := range entries {
+		if e.Name == name {
+			lnk, err := e.Node.AsLink()
+			if err != nil {
+				t.Fatal(err)
+			}
+			return lnk.String()
+		}
+	}
+	t.Fatalf("no %s", p)
+	return ""
+}
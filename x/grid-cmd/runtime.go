@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Runtime runs the descriptors that name it in spec.runtime.
+type Runtime interface {
+	Run(ctx context.Context, inv *Invocation) error
+}
+
+// RuntimeFunc adapts a function to a Runtime.
+type RuntimeFunc func(ctx context.Context, inv *Invocation) error
+
+func (f RuntimeFunc) Run(ctx context.Context, inv *Invocation) error {
+	return f(ctx, inv)
+}
+
+// Invocation is one run of a descriptor.
+type Invocation struct {
+	Descriptor *Descriptor
+	// Dir is the directory the descriptor was found in.
+	Dir string
+	// Args are the descriptor's args, then the declared flags set on
+	// the command line, then the command line's arguments.
+	Args []string
+	// Env holds the grid variables in effect at the current grid path,
+	// and GRID_CWD, the path itself, as name=value.
+	Env    []string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// runtimes holds the runtimes by name.
+var runtimes = map[string]Runtime{}
+
+// registerRuntime makes rt run the descriptors naming it.
+func registerRuntime(name string, rt Runtime) {
+	runtimes[name] = rt
+}
+
+func init() {
+	registerRuntime("native", RuntimeFunc(runNative))
+}
+
+// executeDescriptor runs desc, found in dir, with the given arguments
+// on the runtime it names.
+func executeDescriptor(cmd *cobra.Command, desc *Descriptor, dir string, args []string) error {
+	rt, ok := runtimes[desc.Spec.Runtime]
+	if !ok {
+		return fmt.Errorf("%s: runtime %q not supported", desc.Metadata.Name, desc.Spec.Runtime)
+	}
+	ctx := cmd.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	s, err := session()
+	if err != nil {
+		return err
+	}
+	inv := &Invocation{
+		Descriptor: desc,
+		Dir:        dir,
+		Args:       append(append([]string{}, desc.Spec.Args...), args...),
+		Env:        append(s.Environ(s.Cwd), "GRID_CWD="+s.Cwd),
+		Stdin:      cmd.InOrStdin(),
+		Stdout:     cmd.OutOrStdout(),
+		Stderr:     cmd.ErrOrStderr(),
+	}
+	return rt.Run(ctx, inv)
+}
+
+// runNative runs the descriptor's embedded executable, or else its
+// entry, as a child process.  The child's environment is grid's, then
+// the descriptor's env, then the invocation's, and GRID_DESCRIPTOR_DIR,
+// the descriptor's directory.
+func runNative(ctx context.Context, inv *Invocation) error {
+	spec := inv.Descriptor.Spec
+	entry := resolveEntry(inv.Dir, spec.Entry)
+	if len(spec.Executable) > 0 {
+		path, err := writeExecutable(spec.Executable, spec.Checksum)
+		if err != nil {
+			return fmt.Errorf("%s: %w", inv.Descriptor.Metadata.Name, err)
+		}
+		defer os.Remove(path)
+		entry = path
+	}
+	if entry == "" {
+		return fmt.Errorf("%s: no entry or executable", inv.Descriptor.Metadata.Name)
+	}
+	cmd := exec.CommandContext(ctx, entry, inv.Args...)
+	cmd.Env = append(os.Environ(), spec.Env...)
+	cmd.Env = append(cmd.Env, inv.Env...)
+	cmd.Env = append(cmd.Env, "GRID_DESCRIPTOR_DIR="+inv.Dir)
+	cmd.Stdin = inv.Stdin
+	cmd.Stdout = inv.Stdout
+	cmd.Stderr = inv.Stderr
+	return cmd.Run()
+}
+
+// resolveEntry returns entry, relative to dir unless absolute.  A bare
+// name not found in dir is left to be looked up in PATH.
+func resolveEntry(dir, entry string) string {
+	if entry == "" || filepath.IsAbs(entry) {
+		return entry
+	}
+	path := filepath.Join(dir, entry)
+	if strings.ContainsRune(entry, filepath.Separator) {
+		return path
+	}
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+	return entry
+}
+
+// writeExecutable verifies an embedded executable against its sha256
+// checksum and writes it to a temporary file, returning the file's
+// path.
+func writeExecutable(data, checksum []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	if !bytes.Equal(sum[:], checksum) {
+		return "", fmt.Errorf("executable checksum mismatch")
+	}
+	f, err := os.CreateTemp("", "grid-exec-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(0700)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Session is the grid path context that persists between grid
+// commands: the current path, and environment variables set on paths.
+type Session struct {
+	// Cwd is the current grid path, absolute and clean.
+	Cwd string `cbor:"cwd"`
+	// Env holds variables by the path they were set on.
+	Env map[string]map[string]string `cbor:"env,omitempty"`
+}
+
+// loadSession reads the session file at file, returning a session at
+// "/" if there is none.
+func loadSession(file string) (*Session, error) {
+	s := &Session{Cwd: "/"}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = cbor.Unmarshal(data, s)
+	if err != nil {
+		return nil, err
+	}
+	s.Cwd = Abs("/", s.Cwd)
+	return s, nil
+}
+
+// save writes the session to file, replacing it whole.
+func (s *Session) save(file string) error {
+	em, err := cbor.CoreDetEncOptions().EncMode()
+	if err != nil {
+		return err
+	}
+	data, err := em.Marshal(s)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".session-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// Setenv sets a variable on path p.
+func (s *Session) Setenv(p, name, value string) {
+	if s.Env == nil {
+		s.Env = make(map[string]map[string]string)
+	}
+	if s.Env[p] == nil {
+		s.Env[p] = make(map[string]string)
+	}
+	s.Env[p][name] = value
+}
+
+// Unsetenv removes a variable from path p.
+func (s *Session) Unsetenv(p, name string) {
+	delete(s.Env[p], name)
+	if len(s.Env[p]) == 0 {
+		delete(s.Env, p)
+	}
+}
+
+// Environ returns the variables in effect at path p: those set on p
+// and its ancestors, the nearest winning, as sorted name=value
+// strings.
+func (s *Session) Environ(p string) []string {
+	vars := make(map[string]string)
+	for _, a := range ancestors(p) {
+		for name, value := range s.Env[a] {
+			vars[name] = value
+		}
+	}
+	var out []string
+	for name, value := range vars {
+		out = append(out, name+"="+value)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ancestors returns "/" and each path above p, then p, outermost first.
+func ancestors(p string) []string {
+	out := []string{"/"}
+	for i := 1; i < len(p); i++ {
+		if p[i] == '/' {
+			out = append(out, p[:i])
+		}
+	}
+	if p != "/" {
+		out = append(out, strings.TrimSuffix(p, "/"))
+	}
+	return out
+}
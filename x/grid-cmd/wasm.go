@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/spf13/viper"
+	"github.com/stevegt/grid-poc/x/pcid"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
+)
+
+// wasmABI specifies the host ABI of wasm descriptors.
+//
+//go:embed wasm-abi.md
+var wasmABI []byte
+
+// wasmPCID is the pCID of the input envelope of wasm modules.
+var wasmPCID = pcid.MustFromSpec(wasmABI)
+
+// gridTag is the tag number of the grid envelope, "grid" in ASCII.
+const gridTag = 0x67726964
+
+// Defaults for wasm limits a descriptor leaves unset.
+const (
+	defaultMaxMemoryPages = 256
+	defaultMaxCalls       = 10000000
+	defaultTimeout        = 10 * time.Second
+)
+
+var (
+	// ErrCalls is returned when a wasm module makes more calls than
+	// its budget allows.
+	ErrCalls = errors.New("wasm module made too many calls")
+	// ErrTimeout is returned when a wasm module runs out of time.
+	ErrTimeout = errors.New("wasm module ran out of time")
+)
+
+// WasmSpec says how a wasm descriptor is run; see wasm-abi.md.
+type WasmSpec struct {
+	Module         string `json:"module,omitempty" cbor:"module,omitempty"`
+	Function       string `json:"function,omitempty" cbor:"function,omitempty"`
+	MaxMemoryPages uint32 `json:"maxMemoryPages,omitempty" cbor:"maxMemoryPages,omitempty"`
+	MaxCalls       uint64 `json:"maxCalls,omitempty" cbor:"maxCalls,omitempty"`
+	Timeout        string `json:"timeout,omitempty" cbor:"timeout,omitempty"`
+}
+
+// Kernel answers the kernel calls of wasm modules.
+type Kernel interface {
+	Call(ctx context.Context, req []byte) ([]byte, error)
+}
+
+// wasmRuntime runs descriptors as WebAssembly modules, with wazero.
+type wasmRuntime struct {
+	// Load returns a block from the store.  Nil means the block
+	// directory named by the configuration.
+	Load func(ctx context.Context, c cid.Cid) ([]byte, error)
+	// Kernel answers kernel calls.  Nil means a storeKernel over Load.
+	Kernel Kernel
+}
+
+func init() {
+	registerRuntime("wasm", &wasmRuntime{})
+}
+
+// exitError is the nonzero exit status of a wasm module.
+type exitError struct {
+	code int
+}
+
+func (e *exitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+func (e *exitError) ExitCode() int {
+	return e.code
+}
+
+// loadStore loads a block from the block directory named by the
+// configuration, checking it against its CID.
+func loadStore(ctx context.Context, c cid.Cid) ([]byte, error) {
+	ns := newNamespace(viper.GetString("store"), cid.Undef)
+	return ns.Resolver.LinkSystem.LoadRaw(linking.LinkContext{Ctx: ctx}, cidlink.Link{Cid: c})
+}
+
+// storeKernel answers a kernel call holding a binary CID with the
+// block of that CID.
+type storeKernel struct {
+	load func(ctx context.Context, c cid.Cid) ([]byte, error)
+}
+
+func (k storeKernel) Call(ctx context.Context, req []byte) ([]byte, error) {
+	c, err := cid.Cast(req)
+	if err != nil {
+		return nil, fmt.Errorf("kernel call: %w", err)
+	}
+	return k.load(ctx, c)
+}
+
+// limits returns a wasm spec's limits, with defaults for those unset.
+func limits(spec *WasmSpec) (pages uint32, calls uint64, timeout time.Duration, err error) {
+	pages, calls, timeout = defaultMaxMemoryPages, defaultMaxCalls, defaultTimeout
+	if spec.MaxMemoryPages != 0 {
+		pages = spec.MaxMemoryPages
+	}
+	if spec.MaxCalls != 0 {
+		calls = spec.MaxCalls
+	}
+	if spec.Timeout != "" {
+		timeout, err = time.ParseDuration(spec.Timeout)
+		if err == nil && timeout <= 0 {
+			err = fmt.Errorf("timeout %s is not positive", spec.Timeout)
+		}
+	}
+	return pages, calls, timeout, err
+}
+
+// input returns the input envelope of an invocation.
+func input(inv *Invocation) ([]byte, error) {
+	em, err := cbor.CoreDetEncOptions().EncMode()
+	if err != nil {
+		return nil, err
+	}
+	args := inv.Args
+	if args == nil {
+		args = []string{}
+	}
+	env := inv.Env
+	if env == nil {
+		env = []string{}
+	}
+	payload := map[string]interface{}{
+		"name": inv.Descriptor.Metadata.Name,
+		"args": args,
+		"env":  env,
+	}
+	return em.Marshal(cbor.Tag{Number: gridTag, Content: []interface{}{wasmPCID.Bytes(), payload}})
+}
+
+func (w *wasmRuntime) Run(ctx context.Context, inv *Invocation) error {
+	name := inv.Descriptor.Metadata.Name
+	spec := inv.Descriptor.Spec.Wasm
+	if spec == nil {
+		spec = &WasmSpec{}
+	}
+	moduleCID := spec.Module
+	if moduleCID == "" {
+		moduleCID = inv.Descriptor.Spec.Entry
+	}
+	c, err := cid.Decode(moduleCID)
+	if err != nil {
+		return fmt.Errorf("%s: module %q: %w", name, moduleCID, err)
+	}
+	pages, calls, timeout, err := limits(spec)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	function := spec.Function
+	if function == "" {
+		function = "run"
+	}
+	load := w.Load
+	if load == nil {
+		load = loadStore
+	}
+	kernel := w.Kernel
+	if kernel == nil {
+		kernel = storeKernel{load: load}
+	}
+	code, err := load(ctx, c)
+	if err != nil {
+		return fmt.Errorf("%s: module %s: %w", name, c, err)
+	}
+	in, err := input(inv)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	// Each function call, host functions included, spends one call
+	// of the budget; overspending cancels the run, which the module
+	// notices at its next call or loop.  Loops that make no calls are
+	// not counted and are bounded only by the timeout.
+	var left atomic.Int64
+	left.Store(int64(calls))
+	var overspent atomic.Bool
+	count := experimental.FunctionListenerFunc(func(context.Context, api.Module, api.FunctionDefinition, []uint64, experimental.StackIterator) {
+		if left.Add(-1) < 0 {
+			overspent.Store(true)
+			cancel()
+		}
+	})
+	lctx := experimental.WithFunctionListenerFactory(ctx,
+		experimental.FunctionListenerFactoryFunc(func(api.FunctionDefinition) experimental.FunctionListener { return count }))
+
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(pages).
+		WithCloseOnContextDone(true))
+	defer r.Close(context.Background())
+
+	_, err = r.NewHostModuleBuilder("grid").
+		NewFunctionBuilder().WithFunc(func() int32 {
+		return int32(len(in))
+	}).Export("input_size").
+		NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, ptr, n uint32) int32 {
+		if int(n) > len(in) {
+			n = uint32(len(in))
+		}
+		if !m.Memory().Write(ptr, in[:n]) {
+			return -1
+		}
+		return int32(n)
+	}).Export("input_read").
+		NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, ptr, n uint32) int32 {
+		data, ok := m.Memory().Read(ptr, n)
+		if !ok {
+			return -1
+		}
+		_, err := inv.Stdout.Write(data)
+		if err != nil {
+			return -1
+		}
+		return int32(n)
+	}).Export("output_write").
+		NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, reqPtr, reqLen, respPtr, respCap uint32) int32 {
+		req, ok := m.Memory().Read(reqPtr, reqLen)
+		if !ok {
+			return -1
+		}
+		resp, err := kernel.Call(ctx, append([]byte(nil), req...))
+		if err != nil {
+			fmt.Fprintf(inv.Stderr, "%s: %v\n", name, err)
+			return -1
+		}
+		if len(resp) <= int(respCap) && !m.Memory().Write(respPtr, resp) {
+			return -1
+		}
+		return int32(len(resp))
+	}).Export("kernel_call").
+		Instantiate(lctx)
+	if err != nil {
+		return err
+	}
+
+	compiled, err := r.CompileModule(lctx, code)
+	if err != nil {
+		return fmt.Errorf("%s: module %s: %w", name, c, err)
+	}
+	mod, err := r.InstantiateModule(ctx, compiled, wazero.NewModuleConfig().WithName(name).WithStartFunctions())
+	if err != nil {
+		return fmt.Errorf("%s: module %s: %w", name, c, err)
+	}
+	fn := mod.ExportedFunction(function)
+	if fn == nil {
+		return fmt.Errorf("%s: module %s does not export %s", name, c, function)
+	}
+	results, err := fn.Call(ctx)
+	switch {
+	case overspent.Load():
+		return fmt.Errorf("%s: %w", name, ErrCalls)
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("%s: %w after %s", name, ErrTimeout, timeout)
+	case err != nil:
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if len(results) > 0 && int32(results[0]) != 0 {
+		return &exitError{code: int(int32(results[0]))}
+	}
+	return nil
+}
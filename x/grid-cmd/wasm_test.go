@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/ipfs/go-cid"
+	"github.com/spf13/viper"
+	"github.com/stevegt/grid-poc/x/pcid"
+)
+
+// uleb returns n as unsigned LEB128.
+func uleb(n uint32) []byte {
+	var b []byte
+	for {
+		c := byte(n & 0x7f)
+		n >>= 7
+		if n == 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+// vec returns items as a wasm vector.
+func vec(items ...[]byte) []byte {
+	b := uleb(uint32(len(items)))
+	for _, item := range items {
+		b = append(b, item...)
+	}
+	return b
+}
+
+// wasmName returns s as a wasm name.
+func wasmName(s string) []byte {
+	return append(uleb(uint32(len(s))), s...)
+}
+
+func section(id byte, content []byte) []byte {
+	return append(append([]byte{id}, uleb(uint32(len(content)))...), content...)
+}
+
+// testModule assembles a wasm module importing the grid host functions,
+// with memory of the given minimum pages holding data at offset 0, and
+// exporting run, a function of one i32 local returning an i32 with the
+// given body.  The host functions are functions 0 to 3, in the order
+// input_size, input_read, output_write, kernel_call.
+func testModule(pages uint32, data []byte, body ...byte) []byte {
+	i32 := byte(0x7f)
+	types := vec(
+		[]byte{0x60, 0, 1, i32},
+		[]byte{0x60, 2, i32, i32, 1, i32},
+		[]byte{0x60, 4, i32, i32, i32, i32, 1, i32},
+	)
+	imp := func(field string, typ byte) []byte {
+		return append(append(wasmName("grid"), wasmName(field)...), 0x00, typ)
+	}
+	code := append([]byte{1, 1, i32}, body...)
+	code = append(code, 0x0b)
+	m := []byte{0, 'a', 's', 'm', 1, 0, 0, 0}
+	m = append(m, section(1, types)...)
+	m = append(m, section(2, vec(imp("input_size", 0), imp("input_read", 1), imp("output_write", 1), imp("kernel_call", 2)))...)
+	m = append(m, section(3, vec([]byte{0}))...)
+	m = append(m, section(5, vec(append([]byte{0}, uleb(pages)...)))...)
+	m = append(m, section(7, vec(
+		append(wasmName("memory"), 0x02, 0),
+		append(wasmName("run"), 0x00, 4),
+	))...)
+	m = append(m, section(10, vec(append(uleb(uint32(len(code))), code...)))...)
+	if data != nil {
+		seg := append([]byte{0, 0x41, 0, 0x0b}, uleb(uint32(len(data)))...)
+		seg = append(seg, data...)
+		m = append(m, section(11, vec(seg))...)
+	}
+	return m
+}
+
+// Instructions used by the test modules.
+var (
+	// echo writes the input envelope to the output.
+	echo = []byte{
+		0x10, 0, 0x21, 0, // local0 = input_size()
+		0x41, 0, 0x20, 0, 0x10, 1, 0x1a, // input_read(0, local0)
+		0x41, 0, 0x20, 0, 0x10, 2, 0x1a, // output_write(0, local0)
+		0x41, 0, // 0
+	}
+	// spin loops forever.
+	spin = []byte{0x03, 0x40, 0x0c, 0, 0x0b, 0x41, 0}
+	// callForever calls input_size forever.
+	callForever = []byte{0x03, 0x40, 0x10, 0, 0x1a, 0x0c, 0, 0x0b, 0x41, 0}
+	// grow grows memory by 16 pages, returning 1 if that fails.
+	grow = []byte{0x41, 16, 0x40, 0, 0x41, 0x7f, 0x46}
+)
+
+// kernelCall sends the n bytes at offset 0 to the kernel, with a
+// response buffer at offset 64, and writes the response.
+func kernelCall(n byte) []byte {
+	return []byte{
+		0x41, 0, 0x41, n, 0x41, 0xc0, 0, 0x41, 0xc0, 0, 0x10, 3, 0x21, 0, // local0 = kernel_call(0, n, 64, 64)
+		0x41, 0xc0, 0, 0x20, 0, 0x10, 2, 0x1a, // output_write(64, local0)
+		0x41, 0,
+	}
+}
+
+type stubKernel struct{}
+
+func (stubKernel) Call(ctx context.Context, req []byte) ([]byte, error) {
+	if string(req) == "fail" {
+		return nil, errors.New("refused")
+	}
+	return append([]byte("re: "), req...), nil
+}
+
+// runWasm runs module with spec on a wasmRuntime with a stub kernel,
+// returning what it wrote.
+func runWasm(t *testing.T, module []byte, spec WasmSpec, args ...string) (string, error) {
+	t.Helper()
+	c := pcid.MustFromSpec(module)
+	spec.Module = c.String()
+	w := &wasmRuntime{
+		Load: func(ctx context.Context, got cid.Cid) ([]byte, error) {
+			if !got.Equals(c) {
+				t.Fatalf("loaded %s, want %s", got, c)
+			}
+			return module, nil
+		},
+		Kernel: stubKernel{},
+	}
+	var out bytes.Buffer
+	desc := &Descriptor{Metadata: Metadata{Name: "mod"}, Spec: Spec{Runtime: "wasm", Wasm: &spec}}
+	err := w.Run(context.Background(), &Invocation{
+		Descriptor: desc,
+		Args:       args,
+		Env:        []string{"GRID_CWD=/"},
+		Stdout:     &out,
+		Stderr:     &out,
+	})
+	return out.String(), err
+}
+
+func TestWasmInput(t *testing.T) {
+	out, err := runWasm(t, testModule(1, nil, echo...), WasmSpec{}, "a", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tag cbor.Tag
+	if err := cbor.Unmarshal([]byte(out), &tag); err != nil {
+		t.Fatal(err)
+	}
+	if tag.Number != gridTag {
+		t.Fatalf("tag %x", tag.Number)
+	}
+	content := tag.Content.([]interface{})
+	if !bytes.Equal(content[0].([]byte), wasmPCID.Bytes()) {
+		t.Errorf("pCID %x", content[0])
+	}
+	payload := content[1].(map[interface{}]interface{})
+	if payload["name"] != "mod" {
+		t.Errorf("name %v", payload["name"])
+	}
+	if !reflect.DeepEqual(payload["args"], []interface{}{"a", "b"}) {
+		t.Errorf("args %v", payload["args"])
+	}
+	if !reflect.DeepEqual(payload["env"], []interface{}{"GRID_CWD=/"}) {
+		t.Errorf("env %v", payload["env"])
+	}
+}
+
+func TestWasmKernelCall(t *testing.T) {
+	out, err := runWasm(t, testModule(1, []byte("ping"), kernelCall(4)...), WasmSpec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "re: ping" {
+		t.Errorf("output %q", out)
+	}
+	out, err = runWasm(t, testModule(1, []byte("fail"), kernelCall(4)...), WasmSpec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "mod: refused\n" {
+		t.Errorf("failed call output %q", out)
+	}
+}
+
+func TestWasmLimits(t *testing.T) {
+	_, err := runWasm(t, testModule(1, nil, spin...), WasmSpec{Timeout: "50ms"})
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("spin: %v", err)
+	}
+	_, err = runWasm(t, testModule(1, nil, callForever...), WasmSpec{MaxCalls: 1000})
+	if !errors.Is(err, ErrCalls) {
+		t.Errorf("calls: %v", err)
+	}
+	if _, err := runWasm(t, testModule(4, nil, echo...), WasmSpec{MaxMemoryPages: 2}); err == nil {
+		t.Error("memory above the limit instantiated")
+	}
+	var exitErr *exitError
+	_, err = runWasm(t, testModule(1, nil, grow...), WasmSpec{MaxMemoryPages: 8})
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+		t.Errorf("grow past limit: %v", err)
+	}
+	if _, err := runWasm(t, testModule(1, nil, grow...), WasmSpec{MaxMemoryPages: 32}); err != nil {
+		t.Errorf("grow within limit: %v", err)
+	}
+	if _, err := runWasm(t, testModule(1, nil, echo...), WasmSpec{Timeout: "soon"}); err == nil {
+		t.Error("bad timeout accepted")
+	}
+	if _, err := runWasm(t, testModule(1, nil, echo...), WasmSpec{Function: "main"}); err == nil {
+		t.Error("missing function called")
+	}
+}
+
+func TestWasmDescriptor(t *testing.T) {
+	store := t.TempDir()
+	viper.Set("store", store)
+	t.Cleanup(func() { viper.Set("store", "") })
+	module := testModule(1, nil, echo...)
+	c := pcid.MustFromSpec(module)
+	if err := os.WriteFile(filepath.Join(store, c.String()), module, 0644); err != nil {
+		t.Fatal(err)
+	}
+	gridpath := t.TempDir()
+	writeDescriptor(t, gridpath, "echo", Descriptor{
+		Metadata: Metadata{Name: "echo", Short: "echo the input envelope"},
+		Spec:     Spec{Runtime: "wasm", Entry: c.String()},
+	})
+	missing := pcid.MustFromSpec([]byte("missing"))
+	writeDescriptor(t, gridpath, "missing", Descriptor{
+		Metadata: Metadata{Name: "missing"},
+		Spec:     Spec{Runtime: "wasm", Wasm: &WasmSpec{Module: missing.String()}},
+	})
+
+	root := newRoot()
+	if errs := registerDescriptors(root, gridpath); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	out, err := run(t, root, "echo", "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "echo") || !strings.Contains(out, "GRID_CWD=/") {
+		t.Errorf("output %q", out)
+	}
+	if _, err := run(t, root, "missing"); err == nil {
+		t.Error("ran a module missing from the store")
+	}
+}
@@ -0,0 +1,165 @@
+package interfaces_git
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/multiformats/go-multihash"
+	grid "github.com/stevegt/grid-poc"
+	ipldstore "github.com/stevegt/grid-poc/x/ipld-store"
+)
+
+// atomIndexName is the file in a pack directory that maps Atom
+// multihashes to the git hashes of the blobs holding them.
+const atomIndexName = "atoms.idx"
+
+// AtomStore is a grid Store over a PackStore.  Each Atom is packed as
+// a git blob holding its data.  Git names the blob by the SHA-1 of its
+// header and data, while a grid Store names an Atom by its most recent
+// multihash, so AtomStore keeps an index from one to the other in the
+// pack directory, one "<multihash> <git hash>" line per Atom.  Lines
+// are only written by Flush, after the blobs they name are packed, so
+// that a crash cannot leave the index naming blobs that were never
+// written.  A grid Store cannot report errors, so the most recent one
+// is kept in Err.
+type AtomStore struct {
+	Packs *PackStore
+
+	mu    sync.Mutex
+	index map[string]string
+	// unflushed are the index keys not yet written to file.
+	unflushed []string
+	file      *os.File
+	err       error
+}
+
+var _ grid.Store = (*AtomStore)(nil)
+
+// NewAtomStore returns an AtomStore over packs, reading the index
+// left in the pack directory by earlier AtomStores.
+func NewAtomStore(packs *PackStore) (*AtomStore, error) {
+	name := filepath.Join(packs.dir, atomIndexName)
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &AtomStore{Packs: packs, index: make(map[string]string), file: f}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		mh, hash, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			f.Close()
+			return nil, fmt.Errorf("%s: invalid line %q", name, scanner.Text())
+		}
+		s.index[mh] = hash
+	}
+	err = scanner.Err()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Flush flushes the PackStore and then writes the index lines of the
+// Atoms put since the last Flush.
+func (s *AtomStore) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.Packs.Flush()
+	if err != nil {
+		return err
+	}
+	for len(s.unflushed) > 0 {
+		key := s.unflushed[0]
+		_, err = fmt.Fprintf(s.file, "%s %s\n", key, s.index[key])
+		if err != nil {
+			return err
+		}
+		s.unflushed = s.unflushed[1:]
+	}
+	return s.file.Sync()
+}
+
+// Close flushes the store and closes the index.  The PackStore is left
+// open.
+func (s *AtomStore) Close() error {
+	err := s.Flush()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cerr := s.file.Close()
+	if err != nil {
+		return err
+	}
+	return cerr
+}
+
+// Err returns the most recent error, if any.
+func (s *AtomStore) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Put packs an Atom and returns its multihash, or nil on error.  An
+// Atom with no hash yet is hashed with sha2-256; one with a hash must
+// match it.  The Atom is not in the index file until the next Flush.
+func (s *AtomStore) Put(atom grid.Atom) multihash.Multihash {
+	data := bytes.Clone(atom.Data())
+	mh := atom.HashMRU()
+	if mh == nil {
+		mh = atom.HashAdd(multihash.SHA2_256)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := ipldstore.Verify(data, mh)
+	if err != nil {
+		s.err = fmt.Errorf("atom %s: %w", mh.B58String(), err)
+		return nil
+	}
+	key := hex.EncodeToString(mh)
+	if _, ok := s.index[key]; ok {
+		return mh
+	}
+	hash, err := s.Packs.Put(NewBlob(data))
+	if err != nil {
+		s.err = err
+		return nil
+	}
+	s.index[key] = hash
+	s.unflushed = append(s.unflushed, key)
+	return mh
+}
+
+// Get returns the Atom with the given multihash, or nil if there is
+// none.
+func (s *AtomStore) Get(mh multihash.Multihash) grid.Atom {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hash, ok := s.index[hex.EncodeToString(mh)]
+	if !ok {
+		return nil
+	}
+	obj, err := s.Packs.Get(hash)
+	if err != nil {
+		s.err = err
+		return nil
+	}
+	blob, ok := obj.(*Blob)
+	if !ok {
+		s.err = fmt.Errorf("atom %s: object %s is a %s", mh.B58String(), hash, obj.Type())
+		return nil
+	}
+	atom, err := ipldstore.Verify(blob.Content, mh)
+	if err != nil {
+		s.err = fmt.Errorf("atom %s: %w", mh.B58String(), err)
+		return nil
+	}
+	return atom
+}
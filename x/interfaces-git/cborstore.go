@@ -0,0 +1,83 @@
+package interfaces_git
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fxamacker/cbor/v2"
+	codec "github.com/stevegt/grid-poc/x/cbor-codec"
+)
+
+// CBORStore is a grid-native Store.  Each object is stored as CBOR,
+// using core deterministic encoding and wrapped in a tag whose number
+// spells the object type (see codec.StringToNum), in a file named by
+// the sha-256 Hash of the encoded bytes.
+type CBORStore struct {
+	dir   string
+	codec *codec.Codec
+}
+
+// NewCBORStore creates a CBORStore that keeps objects in dir,
+// creating dir if needed.
+func NewCBORStore(dir string) (*CBORStore, error) {
+	c, err := codec.NewCodec(codec.CodecConfig{
+		EncOptions: cbor.CoreDetEncOptions(),
+		DecOptions: cbor.DecOptions{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &CBORStore{dir: dir, codec: c}, nil
+}
+
+// Put stores an object and returns the hash of its encoding.
+func (s *CBORStore) Put(obj Object) (string, error) {
+	buf, err := s.codec.Encode(codec.StringToNum(obj.Type()), obj)
+	if err != nil {
+		return "", err
+	}
+	hash := Hash(buf)
+	err = os.WriteFile(filepath.Join(s.dir, hash), buf, 0644)
+	if err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// Get reads, verifies and decodes the object with the given hash.
+func (s *CBORStore) Get(hash string) (Object, error) {
+	buf, err := os.ReadFile(filepath.Join(s.dir, hash))
+	if err != nil {
+		return nil, err
+	}
+	if got := Hash(buf); got != hash {
+		return nil, fmt.Errorf("object %s: content hashes to %s", hash, got)
+	}
+	tagNum, inner, err := s.codec.DecodeTag(buf)
+	if err != nil {
+		return nil, err
+	}
+	var obj Object
+	switch codec.NumToString(tagNum) {
+	case BlobTagName:
+		obj = &Blob{}
+	case TreeTagName:
+		obj = &Tree{}
+	case CommitTagName:
+		obj = &Commit{}
+	case TagTagName:
+		obj = &Tag{}
+	default:
+		return nil, fmt.Errorf("unknown tag name: %s", codec.NumToString(tagNum))
+	}
+	err = s.codec.DecodeRaw(inner, obj)
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
@@ -0,0 +1,159 @@
+package interfaces_git
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Deltas use git's pack delta format: the source and target sizes as
+// little-endian base-128 varints, followed by instructions that
+// either copy a range of the source or insert literal bytes.
+
+// deltaBlock is the length of the source blocks that makeDelta
+// indexes.  Matches shorter than this are not found.
+const deltaBlock = 16
+
+// maxCopy is the largest range a single copy instruction can hold.
+const maxCopy = 0x10000
+
+// makeDelta returns a delta that turns src into dst.
+func makeDelta(src, dst []byte) []byte {
+	var out bytes.Buffer
+	out.Write(binary.AppendUvarint(nil, uint64(len(src))))
+	out.Write(binary.AppendUvarint(nil, uint64(len(dst))))
+
+	// Index the source by the content of each aligned block.  Later
+	// blocks win, which favors copies close to the end of the source.
+	index := make(map[string]int)
+	for i := 0; i+deltaBlock <= len(src); i += deltaBlock {
+		index[string(src[i:i+deltaBlock])] = i
+	}
+
+	var insert []byte
+	flush := func() {
+		for len(insert) > 0 {
+			n := min(len(insert), 0x7f)
+			out.WriteByte(byte(n))
+			out.Write(insert[:n])
+			insert = insert[n:]
+		}
+	}
+	for i := 0; i < len(dst); {
+		start, ok := -1, false
+		if i+deltaBlock <= len(dst) {
+			start, ok = index[string(dst[i:i+deltaBlock])]
+		}
+		if !ok {
+			insert = append(insert, dst[i])
+			i++
+			continue
+		}
+		// Extend the match backward into pending literals and then
+		// forward as far as it goes.
+		for start > 0 && len(insert) > 0 && src[start-1] == insert[len(insert)-1] {
+			start--
+			i--
+			insert = insert[:len(insert)-1]
+		}
+		n := 0
+		for i+n < len(dst) && start+n < len(src) && dst[i+n] == src[start+n] {
+			n++
+		}
+		flush()
+		for n > 0 {
+			size := min(n, maxCopy)
+			writeCopy(&out, start, size)
+			start += size
+			i += size
+			n -= size
+		}
+	}
+	flush()
+	return out.Bytes()
+}
+
+// writeCopy writes a copy instruction.  Only the nonzero bytes of the
+// offset and size are written; a size of maxCopy is written as zero.
+func writeCopy(out *bytes.Buffer, offset, size int) {
+	if size == maxCopy {
+		size = 0
+	}
+	var args []byte
+	cmd := byte(0x80)
+	for i := 0; i < 4; i++ {
+		if b := byte(offset >> (8 * i)); b != 0 {
+			cmd |= 1 << i
+			args = append(args, b)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if b := byte(size >> (8 * i)); b != 0 {
+			cmd |= 0x10 << i
+			args = append(args, b)
+		}
+	}
+	out.WriteByte(cmd)
+	out.Write(args)
+}
+
+// applyDelta applies a delta to src and returns the target.
+func applyDelta(src, delta []byte) ([]byte, error) {
+	srcSize, n := binary.Uvarint(delta)
+	if n <= 0 || srcSize != uint64(len(src)) {
+		return nil, fmt.Errorf("delta source size mismatch")
+	}
+	delta = delta[n:]
+	dstSize, n := binary.Uvarint(delta)
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid delta target size")
+	}
+	delta = delta[n:]
+
+	dst := make([]byte, 0, dstSize)
+	for len(delta) > 0 {
+		cmd := delta[0]
+		delta = delta[1:]
+		switch {
+		case cmd&0x80 != 0:
+			var offset, size int
+			for i := 0; i < 4; i++ {
+				if cmd&(1<<i) != 0 {
+					if len(delta) == 0 {
+						return nil, fmt.Errorf("truncated delta")
+					}
+					offset |= int(delta[0]) << (8 * i)
+					delta = delta[1:]
+				}
+			}
+			for i := 0; i < 3; i++ {
+				if cmd&(0x10<<i) != 0 {
+					if len(delta) == 0 {
+						return nil, fmt.Errorf("truncated delta")
+					}
+					size |= int(delta[0]) << (8 * i)
+					delta = delta[1:]
+				}
+			}
+			if size == 0 {
+				size = maxCopy
+			}
+			if offset+size > len(src) {
+				return nil, fmt.Errorf("delta copy out of range")
+			}
+			dst = append(dst, src[offset:offset+size]...)
+		case cmd != 0:
+			if int(cmd) > len(delta) {
+				return nil, fmt.Errorf("truncated delta")
+			}
+			dst = append(dst, delta[:cmd]...)
+			delta = delta[cmd:]
+		default:
+			return nil, fmt.Errorf("invalid delta instruction")
+		}
+	}
+	if uint64(len(dst)) != dstSize {
+		return nil, fmt.Errorf("delta target size mismatch")
+	}
+	return dst, nil
+}
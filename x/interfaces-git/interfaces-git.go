@@ -1,5 +1,10 @@
 package interfaces_git
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
 // . "github.com/stevegt/goadapt"
 
 // Object is an interface for objects that can be stored in a repository.
@@ -15,3 +20,21 @@ type Store interface {
 	// Get retrieves an object from disk given its hash.
 	Get(string) (Object, error)
 }
+
+// Object type names.  These are git's names for its object types,
+// and they double as CBOR tag names in CBOR-native stores.
+const (
+	BlobTagName   = "blob"
+	TreeTagName   = "tree"
+	CommitTagName = "commit"
+	TagTagName    = "tag"
+)
+
+// Hash returns the hash of the object as a hex string.  The hash is
+// a sha-256 hash of the entire CBOR serialized object.  We use RFC
+// 8949 section 4.2.1 core deterministic encoding for CBOR serialization.
+func Hash(buf []byte) (strhash string) {
+	binhash := sha256.Sum256(buf)
+	strhash = hex.EncodeToString(binhash[:])
+	return
+}
@@ -34,22 +34,6 @@ bar.foo()
 // git at all.  For instance, it uses CBOR serialization instead of
 // the git object serialization format.
 
-// Hash returns the hash of the object as a hex string.  The hash is
-// a sha-256 hash of the entire CBOR serialized object.  We use RFC
-// 8949 section 4.2.1 core deterministic encoding for CBOR serialization.
-func Hash(buf []byte) (strhash string) {
-	binhash := sha256.Sum256(buf)
-	strhash = hex.EncodeToString(binhash[:])
-	return
-}
-
-// XXX use these
-const (
-	BlobTagName   = "blob"
-	TreeTagName   = "tree"
-	CommitTagName = "commit"
-)
-
 // setupCodecForGit creates a codec instance and registers the appropriate types.
 func setupCodecForGit() *codec.Codec {
 	config := codec.CodecConfig{
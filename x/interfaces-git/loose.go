@@ -0,0 +1,139 @@
+package interfaces_git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// LooseStore is a Store that keeps each object as a zlib-compressed
+// loose object file, exactly as git does under .git/objects.  Point
+// it at a repository's objects directory to read and write that
+// repository.  Hashes are git's sha-1 hashes in hex.
+type LooseStore struct {
+	dir string
+}
+
+// NewLooseStore creates a LooseStore rooted at dir, such as
+// ".git/objects".
+func NewLooseStore(dir string) *LooseStore {
+	return &LooseStore{dir: dir}
+}
+
+// path returns the file name of the object with the given hash.
+func (s *LooseStore) path(hash string) (string, error) {
+	if len(hash) != 40 {
+		return "", fmt.Errorf("invalid hash %q", hash)
+	}
+	return filepath.Join(s.dir, hash[:2], hash[2:]), nil
+}
+
+// Put stores a GitObject and returns its git hash.  Objects that are
+// already present are not rewritten.
+func (s *LooseStore) Put(obj Object) (string, error) {
+	gobj, ok := obj.(GitObject)
+	if !ok {
+		return "", fmt.Errorf("cannot store %T as a git object", obj)
+	}
+	content, err := gobj.MarshalGit()
+	if err != nil {
+		return "", err
+	}
+	hash := hashContent(obj.Type(), content)
+	fn, err := s.path(hash)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(fn); err == nil {
+		return hash, nil
+	}
+
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	fmt.Fprintf(zw, "%s %d\x00", obj.Type(), len(content))
+	zw.Write(content)
+	err = zw.Close()
+	if err != nil {
+		return "", err
+	}
+
+	// Write to a temporary file and rename it into place so that
+	// readers never see a partial object.
+	err = os.MkdirAll(filepath.Dir(fn), 0755)
+	if err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(fn), "tmp_obj_")
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.Write(buf.Bytes())
+	if err == nil {
+		err = tmp.Chmod(0444)
+	}
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), fn)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return hash, nil
+}
+
+// Get reads, verifies and parses the object with the given hash.
+func (s *LooseStore) Get(hash string) (Object, error) {
+	typ, content, err := s.GetRaw(hash)
+	if err != nil {
+		return nil, err
+	}
+	return ParseObject(typ, content)
+}
+
+// GetRaw returns the type and content of the object with the given
+// hash after checking that they hash to it.
+func (s *LooseStore) GetRaw(hash string) (typ string, content []byte, err error) {
+	fn, err := s.path(hash)
+	if err != nil {
+		return "", nil, err
+	}
+	fh, err := os.Open(fn)
+	if err != nil {
+		return "", nil, err
+	}
+	defer fh.Close()
+	zr, err := zlib.NewReader(fh)
+	if err != nil {
+		return "", nil, fmt.Errorf("object %s: %w", hash, err)
+	}
+	defer zr.Close()
+	buf, err := io.ReadAll(zr)
+	if err != nil {
+		return "", nil, fmt.Errorf("object %s: %w", hash, err)
+	}
+
+	nul := bytes.IndexByte(buf, 0)
+	sp := bytes.IndexByte(buf, ' ')
+	if nul < 0 || sp < 0 || sp > nul {
+		return "", nil, fmt.Errorf("object %s: invalid header", hash)
+	}
+	typ = string(buf[:sp])
+	size, err := strconv.Atoi(string(buf[sp+1 : nul]))
+	content = buf[nul+1:]
+	if err != nil || size != len(content) {
+		return "", nil, fmt.Errorf("object %s: size mismatch", hash)
+	}
+	if got := hashContent(typ, content); got != hash {
+		return "", nil, fmt.Errorf("object %s: content hashes to %s", hash, got)
+	}
+	return typ, content, nil
+}
@@ -0,0 +1,414 @@
+package interfaces_git
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GitObject is an Object that can be serialized the way git
+// serializes it.  MarshalGit returns the object's content without
+// git's "<type> <size>\x00" header.
+type GitObject interface {
+	Object
+	MarshalGit() ([]byte, error)
+}
+
+// GitHash returns git's sha-1 hash of the object as a hex string.
+// This is the hash `git hash-object` prints.
+func GitHash(obj GitObject) (string, error) {
+	content, err := obj.MarshalGit()
+	if err != nil {
+		return "", err
+	}
+	return hashContent(obj.Type(), content), nil
+}
+
+// hashContent returns the git hash of an object's content.
+func hashContent(typ string, content []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s %d\x00", typ, len(content))
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ParseObject decodes the content of a git object of the given type.
+// Commits and tags that would not serialize back to the same bytes
+// are rejected, so that a parsed object always keeps its hash.
+func ParseObject(typ string, content []byte) (GitObject, error) {
+	var obj interface {
+		GitObject
+		unmarshalGit([]byte) error
+	}
+	switch typ {
+	case BlobTagName:
+		obj = &Blob{}
+	case TreeTagName:
+		obj = &Tree{}
+	case CommitTagName:
+		obj = &Commit{}
+	case TagTagName:
+		obj = &Tag{}
+	default:
+		return nil, fmt.Errorf("unknown object type: %s", typ)
+	}
+	err := obj.unmarshalGit(content)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", typ, err)
+	}
+	if typ == CommitTagName || typ == TagTagName {
+		again, err := obj.MarshalGit()
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(again, content) {
+			return nil, fmt.Errorf("parsing %s: object does not round-trip", typ)
+		}
+	}
+	return obj, nil
+}
+
+// Blob is a file's content.
+type Blob struct {
+	Content []byte `cbor:"content"`
+}
+
+// NewBlob creates a new Blob with the given content.
+func NewBlob(content []byte) *Blob {
+	return &Blob{Content: content}
+}
+
+// Type returns the type of the blob.
+func (b *Blob) Type() string {
+	return BlobTagName
+}
+
+// MarshalGit returns the blob's content.
+func (b *Blob) MarshalGit() ([]byte, error) {
+	return b.Content, nil
+}
+
+func (b *Blob) unmarshalGit(content []byte) error {
+	b.Content = content
+	return nil
+}
+
+// Tree mode strings as git writes them.
+const (
+	ModeFile       = "100644"
+	ModeExecutable = "100755"
+	ModeSymlink    = "120000"
+	ModeDir        = "40000"
+	ModeSubmodule  = "160000"
+)
+
+// TreeEntry is one entry in a tree.  Hash is a hex git hash.
+type TreeEntry struct {
+	Mode string `cbor:"mode"`
+	Name string `cbor:"name"`
+	Hash string `cbor:"hash"`
+}
+
+// Tree is a directory listing.
+type Tree struct {
+	Entries []TreeEntry `cbor:"entries"`
+}
+
+// NewTree creates a new, empty Tree.
+func NewTree() *Tree {
+	return &Tree{Entries: make([]TreeEntry, 0)}
+}
+
+// Type returns the type of the tree.
+func (t *Tree) Type() string {
+	return TreeTagName
+}
+
+// AddEntry adds an entry to the tree.
+func (t *Tree) AddEntry(mode, name, hash string) {
+	t.Entries = append(t.Entries, TreeEntry{Mode: mode, Name: name, Hash: hash})
+}
+
+// Sort sorts the entries into git's order, in which a subtree sorts
+// as if its name ended with a slash.
+func (t *Tree) Sort() {
+	key := func(e TreeEntry) string {
+		if e.Mode == ModeDir {
+			return e.Name + "/"
+		}
+		return e.Name
+	}
+	sort.SliceStable(t.Entries, func(i, j int) bool {
+		return key(t.Entries[i]) < key(t.Entries[j])
+	})
+}
+
+// MarshalGit returns the tree in git's binary format.  Entries are
+// written in the order they are in; call Sort first for a tree that
+// git would write.
+func (t *Tree) MarshalGit() ([]byte, error) {
+	var buf bytes.Buffer
+	for _, e := range t.Entries {
+		bin, err := hex.DecodeString(e.Hash)
+		if err != nil || len(bin) != sha1.Size {
+			return nil, fmt.Errorf("invalid hash %q for entry %q", e.Hash, e.Name)
+		}
+		if e.Name == "" || strings.ContainsAny(e.Name, "/\x00") {
+			return nil, fmt.Errorf("invalid entry name %q", e.Name)
+		}
+		fmt.Fprintf(&buf, "%s %s\x00", e.Mode, e.Name)
+		buf.Write(bin)
+	}
+	return buf.Bytes(), nil
+}
+
+func (t *Tree) unmarshalGit(content []byte) error {
+	t.Entries = make([]TreeEntry, 0)
+	for len(content) > 0 {
+		sp := bytes.IndexByte(content, ' ')
+		nul := bytes.IndexByte(content, 0)
+		if sp < 0 || nul < sp || len(content) < nul+1+sha1.Size {
+			return fmt.Errorf("truncated tree entry")
+		}
+		t.Entries = append(t.Entries, TreeEntry{
+			Mode: string(content[:sp]),
+			Name: string(content[sp+1 : nul]),
+			Hash: hex.EncodeToString(content[nul+1 : nul+1+sha1.Size]),
+		})
+		content = content[nul+1+sha1.Size:]
+	}
+	return nil
+}
+
+// Signature identifies the author, committer or tagger of an object
+// and when they acted.  Zone is the UTC offset as git writes it, such
+// as "+0200"; it is kept as a string so that odd zones such as "-0000"
+// survive.
+type Signature struct {
+	Name  string `cbor:"name"`
+	Email string `cbor:"email"`
+	When  int64  `cbor:"when"`
+	Zone  string `cbor:"zone"`
+}
+
+// String returns the signature as git writes it.
+func (s Signature) String() string {
+	return fmt.Sprintf("%s <%s> %d %s", s.Name, s.Email, s.When, s.Zone)
+}
+
+// parseSignature parses a signature as git writes it.
+func parseSignature(s string) (sig Signature, err error) {
+	lt := strings.IndexByte(s, '<')
+	gt := strings.LastIndexByte(s, '>')
+	if lt < 1 || gt < lt || s[lt-1] != ' ' {
+		return sig, fmt.Errorf("invalid signature %q", s)
+	}
+	sig.Name = s[:lt-1]
+	sig.Email = s[lt+1 : gt]
+	when, zone, ok := strings.Cut(strings.TrimPrefix(s[gt+1:], " "), " ")
+	if !ok {
+		return sig, fmt.Errorf("invalid signature time in %q", s)
+	}
+	sig.When, err = strconv.ParseInt(when, 10, 64)
+	if err != nil {
+		return sig, fmt.Errorf("invalid signature time in %q", s)
+	}
+	sig.Zone = zone
+	return sig, nil
+}
+
+// Header is a commit or tag header that has no field of its own,
+// such as gpgsig, mergetag or encoding.  Multi-line values are stored
+// with their continuation lines joined by newlines.
+type Header struct {
+	Key   string `cbor:"key"`
+	Value string `cbor:"value"`
+}
+
+// writeHeader writes a header, indenting continuation lines.
+func writeHeader(buf *bytes.Buffer, key, value string) {
+	buf.WriteString(key)
+	buf.WriteByte(' ')
+	buf.WriteString(strings.ReplaceAll(value, "\n", "\n "))
+	buf.WriteByte('\n')
+}
+
+// parseHeaders splits an object into its headers, in order, and its
+// message.
+func parseHeaders(content []byte) (headers []Header, message string, err error) {
+	s := string(content)
+	for {
+		if s == "" {
+			return nil, "", fmt.Errorf("missing message separator")
+		}
+		if s[0] == '\n' {
+			return headers, s[1:], nil
+		}
+		line, rest, ok := strings.Cut(s, "\n")
+		if !ok {
+			return nil, "", fmt.Errorf("truncated header")
+		}
+		s = rest
+		if line[0] == ' ' {
+			if len(headers) == 0 {
+				return nil, "", fmt.Errorf("continuation line before first header")
+			}
+			headers[len(headers)-1].Value += "\n" + line[1:]
+			continue
+		}
+		key, value, _ := strings.Cut(line, " ")
+		headers = append(headers, Header{Key: key, Value: value})
+	}
+}
+
+// Commit is a snapshot of a tree with its history.  Tree and Parents
+// are hex git hashes.  Headers holds any headers that follow the
+// committer, such as a gpgsig signature, in the order they appear.
+type Commit struct {
+	Tree      string    `cbor:"tree"`
+	Parents   []string  `cbor:"parents"`
+	Author    Signature `cbor:"author"`
+	Committer Signature `cbor:"committer"`
+	Headers   []Header  `cbor:"headers,omitempty"`
+	Message   string    `cbor:"message"`
+}
+
+// Type returns the type of the commit.
+func (c *Commit) Type() string {
+	return CommitTagName
+}
+
+// MarshalGit returns the commit as git writes it.
+func (c *Commit) MarshalGit() ([]byte, error) {
+	var buf bytes.Buffer
+	writeHeader(&buf, "tree", c.Tree)
+	for _, p := range c.Parents {
+		writeHeader(&buf, "parent", p)
+	}
+	writeHeader(&buf, "author", c.Author.String())
+	writeHeader(&buf, "committer", c.Committer.String())
+	for _, h := range c.Headers {
+		writeHeader(&buf, h.Key, h.Value)
+	}
+	buf.WriteByte('\n')
+	buf.WriteString(c.Message)
+	return buf.Bytes(), nil
+}
+
+func (c *Commit) unmarshalGit(content []byte) error {
+	headers, message, err := parseHeaders(content)
+	if err != nil {
+		return err
+	}
+	*c = Commit{Parents: make([]string, 0), Message: message}
+	i := 0
+	next := func(key string) (string, bool) {
+		if i < len(headers) && headers[i].Key == key {
+			i++
+			return headers[i-1].Value, true
+		}
+		return "", false
+	}
+	var ok bool
+	c.Tree, ok = next("tree")
+	if !ok {
+		return fmt.Errorf("missing tree header")
+	}
+	for {
+		p, ok := next("parent")
+		if !ok {
+			break
+		}
+		c.Parents = append(c.Parents, p)
+	}
+	author, ok := next("author")
+	if !ok {
+		return fmt.Errorf("missing author header")
+	}
+	c.Author, err = parseSignature(author)
+	if err != nil {
+		return err
+	}
+	committer, ok := next("committer")
+	if !ok {
+		return fmt.Errorf("missing committer header")
+	}
+	c.Committer, err = parseSignature(committer)
+	if err != nil {
+		return err
+	}
+	if len(headers) > i {
+		c.Headers = headers[i:]
+	}
+	return nil
+}
+
+// Tag is an annotated tag.  Object is the hex git hash of the tagged
+// object and ObjectType its type.  Tagger is nil for the few old tags
+// that have none.  A tag's PGP signature is part of its message, as
+// it is in git.
+type Tag struct {
+	Object     string     `cbor:"object"`
+	ObjectType string     `cbor:"type"`
+	Name       string     `cbor:"tag"`
+	Tagger     *Signature `cbor:"tagger,omitempty"`
+	Headers    []Header   `cbor:"headers,omitempty"`
+	Message    string     `cbor:"message"`
+}
+
+// Type returns the type of the tag.
+func (t *Tag) Type() string {
+	return TagTagName
+}
+
+// MarshalGit returns the tag as git writes it.
+func (t *Tag) MarshalGit() ([]byte, error) {
+	var buf bytes.Buffer
+	writeHeader(&buf, "object", t.Object)
+	writeHeader(&buf, "type", t.ObjectType)
+	writeHeader(&buf, "tag", t.Name)
+	if t.Tagger != nil {
+		writeHeader(&buf, "tagger", t.Tagger.String())
+	}
+	for _, h := range t.Headers {
+		writeHeader(&buf, h.Key, h.Value)
+	}
+	buf.WriteByte('\n')
+	buf.WriteString(t.Message)
+	return buf.Bytes(), nil
+}
+
+func (t *Tag) unmarshalGit(content []byte) error {
+	headers, message, err := parseHeaders(content)
+	if err != nil {
+		return err
+	}
+	*t = Tag{Message: message}
+	want := []string{"object", "type", "tag"}
+	if len(headers) < len(want) {
+		return fmt.Errorf("missing tag headers")
+	}
+	for i, key := range want {
+		if headers[i].Key != key {
+			return fmt.Errorf("expected %s header, got %s", key, headers[i].Key)
+		}
+	}
+	t.Object, t.ObjectType, t.Name = headers[0].Value, headers[1].Value, headers[2].Value
+	headers = headers[3:]
+	if len(headers) > 0 && headers[0].Key == "tagger" {
+		sig, err := parseSignature(headers[0].Value)
+		if err != nil {
+			return err
+		}
+		t.Tagger = &sig
+		headers = headers[1:]
+	}
+	if len(headers) > 0 {
+		t.Headers = headers
+	}
+	return nil
+}
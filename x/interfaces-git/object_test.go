@@ -0,0 +1,166 @@
+package interfaces_git
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// The hashes below were computed with `git hash-object` and `git mktree`.
+const (
+	helloBlobHash = "b45ef6fec89518d314f546fd6c3025367b721684"
+	helloTreeHash = "79321ed405490e585cd9a2b79f18a915d6d68a96"
+)
+
+// signedCommit returns a commit with an odd time zone and a
+// multi-line gpgsig header.
+func signedCommit() *Commit {
+	return &Commit{
+		Tree:      helloTreeHash,
+		Parents:   []string{},
+		Author:    Signature{Name: "Alice", Email: "alice@example.com", When: 1700000000, Zone: "+0200"},
+		Committer: Signature{Name: "Alice", Email: "alice@example.com", When: 1700000000, Zone: "-0000"},
+		Headers: []Header{{
+			Key:   "gpgsig",
+			Value: "-----BEGIN PGP SIGNATURE-----\n\niQEzBAABCAAdFiEE\n-----END PGP SIGNATURE-----",
+		}},
+		Message: "hello\n",
+	}
+}
+
+// TestGitHash checks that each object type hashes as git hashes it.
+func TestGitHash(t *testing.T) {
+	tree := NewTree()
+	tree.AddEntry(ModeFile, "hello.txt", helloBlobHash)
+	tagger := Signature{Name: "Alice", Email: "alice@example.com", When: 1700000000, Zone: "+0200"}
+	cases := []struct {
+		obj  GitObject
+		want string
+	}{
+		{NewBlob([]byte("Hello, World!")), helloBlobHash},
+		{tree, helloTreeHash},
+		{signedCommit(), "a02ac06d0845446c2f7e57e9ed3022a4ef5e8e11"},
+		{&Tag{Object: helloBlobHash, ObjectType: "blob", Name: "v1", Tagger: &tagger, Message: "release\n"},
+			"bbc319b428023e8b75861bed9d751433f76def4e"},
+	}
+	for _, c := range cases {
+		got, err := GitHash(c.obj)
+		Tassert(t, err == nil, "%s: %v", c.obj.Type(), err)
+		Tassert(t, got == c.want, "%s: expected %s, got %s", c.obj.Type(), c.want, got)
+
+		// Parsing the content gives back an equal object.
+		content, err := c.obj.MarshalGit()
+		Tassert(t, err == nil, "%s: %v", c.obj.Type(), err)
+		parsed, err := ParseObject(c.obj.Type(), content)
+		Tassert(t, err == nil, "%s: %v", c.obj.Type(), err)
+		Tassert(t, reflect.DeepEqual(parsed, c.obj), "%s: expected %+v, got %+v", c.obj.Type(), c.obj, parsed)
+	}
+}
+
+// TestTreeSort checks git's ordering of a file and a subtree whose
+// names share a prefix.
+func TestTreeSort(t *testing.T) {
+	tree := NewTree()
+	tree.AddEntry(ModeDir, "a", helloTreeHash)
+	tree.AddEntry(ModeFile, "a.txt", helloBlobHash)
+	tree.AddEntry(ModeFile, "a-b", helloBlobHash)
+	tree.Sort()
+	var names []string
+	for _, e := range tree.Entries {
+		names = append(names, e.Name)
+	}
+	want := []string{"a-b", "a.txt", "a"}
+	Tassert(t, reflect.DeepEqual(names, want), "expected %v, got %v", want, names)
+}
+
+// TestLooseStoreGit reads a commit that git wrote and writes a blob
+// that git reads back.
+func TestLooseStoreGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	run := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Alice", "GIT_AUTHOR_EMAIL=alice@example.com",
+			"GIT_COMMITTER_NAME=Alice", "GIT_COMMITTER_EMAIL=alice@example.com",
+			"GIT_AUTHOR_DATE=1700000000 +0200", "GIT_COMMITTER_DATE=1700000000 +0200")
+		out, err := cmd.CombinedOutput()
+		Tassert(t, err == nil, "git %v: %v\n%s", args, err, out)
+		return strings.TrimSpace(string(out))
+	}
+	run("init", "-q")
+	err := os.WriteFile(filepath.Join(dir, "hello.txt"), []byte("Hello, World!"), 0644)
+	Ck(err)
+	run("add", "hello.txt")
+	run("commit", "-q", "-m", "first")
+	head := run("rev-parse", "HEAD")
+
+	store := NewLooseStore(filepath.Join(dir, ".git", "objects"))
+	obj, err := store.Get(head)
+	Tassert(t, err == nil, "%v", err)
+	commit := obj.(*Commit)
+	Tassert(t, commit.Tree == helloTreeHash, "unexpected tree %s", commit.Tree)
+	Tassert(t, commit.Committer.Zone == "+0200", "unexpected zone %s", commit.Committer.Zone)
+	obj, err = store.Get(commit.Tree)
+	Tassert(t, err == nil, "%v", err)
+	entry := obj.(*Tree).Entries[0]
+	obj, err = store.Get(entry.Hash)
+	Tassert(t, err == nil, "%v", err)
+	Tassert(t, string(obj.(*Blob).Content) == "Hello, World!", "unexpected blob %+v", obj)
+
+	hash, err := store.Put(NewBlob([]byte("from the grid\n")))
+	Tassert(t, err == nil, "%v", err)
+	Tassert(t, run("cat-file", "-p", hash) == "from the grid", "git cannot read blob %s", hash)
+	hash, err = store.Put(signedCommit())
+	Tassert(t, err == nil, "%v", err)
+	Tassert(t, run("cat-file", "-t", hash) == "commit", "git cannot read commit %s", hash)
+
+	_, err = store.Get(strings.Repeat("0", 40))
+	Tassert(t, err != nil, "expected error for missing object")
+}
+
+// TestCBORStore round-trips each object type through a CBORStore and
+// checks that tampered objects are rejected.
+func TestCBORStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewCBORStore(dir)
+	Ck(err)
+	tree := NewTree()
+	tree.AddEntry(ModeFile, "hello.txt", helloBlobHash)
+	tagger := Signature{Name: "Alice", Email: "alice@example.com", When: 1700000000, Zone: "+0200"}
+	objs := []Object{
+		NewBlob([]byte("Hello, World!")),
+		tree,
+		signedCommit(),
+		&Tag{Object: helloBlobHash, ObjectType: "blob", Name: "v1", Tagger: &tagger, Message: "release\n"},
+	}
+	for _, obj := range objs {
+		hash, err := store.Put(obj)
+		Tassert(t, err == nil, "%s: %v", obj.Type(), err)
+		got, err := store.Get(hash)
+		Tassert(t, err == nil, "%s: %v", obj.Type(), err)
+		Tassert(t, reflect.DeepEqual(got, obj), "%s: expected %+v, got %+v", obj.Type(), obj, got)
+		// The git hash survives the CBOR round trip.
+		want, _ := GitHash(obj.(GitObject))
+		gotHash, _ := GitHash(got.(GitObject))
+		Tassert(t, want == gotHash, "%s: git hash changed", obj.Type())
+	}
+
+	hash, err := store.Put(NewBlob([]byte("x")))
+	Ck(err)
+	fn := filepath.Join(dir, hash)
+	buf, err := os.ReadFile(fn)
+	Ck(err)
+	buf[len(buf)-1] = 'y'
+	Ck(os.WriteFile(fn, buf, 0644))
+	_, err = store.Get(hash)
+	Tassert(t, err != nil, "expected hash mismatch error")
+}
@@ -0,0 +1,345 @@
+package interfaces_git
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PackStore is a Store that keeps objects in git pack files, so that
+// a large world line is a handful of files rather than millions of
+// loose ones.  Put buffers objects in memory and writes them out as a
+// new pack every BatchSize objects or on Flush.  Each pack is written
+// with OFS_DELTA compression between similar objects of the same type
+// and a version 2 index, so the directory can double as a
+// repository's .git/objects/pack.  Lookups are a binary search of
+// each pack's index; Repack merges packs, and StartRepacking does so
+// in the background, to keep the number of packs small.
+type PackStore struct {
+	dir string
+	// BatchSize is the number of buffered objects that triggers a
+	// flush.
+	BatchSize int
+	// Window is the number of preceding objects tried as delta
+	// bases for each object.
+	Window int
+	// MaxDepth is the longest delta chain written.
+	MaxDepth int
+	// RepackError is called with any error from background
+	// repacking.  Nil means log it.
+	RepackError func(error)
+
+	mu      sync.RWMutex
+	packs   []*packFile
+	pending map[string]rawObject
+
+	// repackMu keeps Flush and Repack from interleaving.
+	repackMu sync.Mutex
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewPackStore opens the packs in dir, creating dir if needed.
+func NewPackStore(dir string) (*PackStore, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	s := &PackStore{
+		dir:       dir,
+		BatchSize: 1000,
+		Window:    10,
+		MaxDepth:  50,
+		pending:   make(map[string]rawObject),
+	}
+	names, err := listPacks(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		p, err := openPack(name)
+		if err != nil {
+			s.closePacks()
+			return nil, err
+		}
+		s.packs = append(s.packs, p)
+	}
+	return s, nil
+}
+
+// Put buffers a GitObject for the next pack and returns its git hash.
+// Objects that are already stored are ignored.
+func (s *PackStore) Put(obj Object) (string, error) {
+	gobj, ok := obj.(GitObject)
+	if !ok {
+		return "", fmt.Errorf("cannot store %T as a git object", obj)
+	}
+	content, err := gobj.MarshalGit()
+	if err != nil {
+		return "", err
+	}
+	hash := hashContent(obj.Type(), content)
+
+	s.mu.Lock()
+	_, pending := s.pending[hash]
+	if !pending && !s.inPacks(hash) {
+		s.pending[hash] = rawObject{typ: obj.Type(), content: content, hash: hash}
+	}
+	full := len(s.pending) >= s.BatchSize
+	s.mu.Unlock()
+
+	if full {
+		err = s.Flush()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hash, nil
+}
+
+// Get reads, verifies and parses the object with the given hash.
+func (s *PackStore) Get(hash string) (Object, error) {
+	typ, content, err := s.GetRaw(hash)
+	if err != nil {
+		return nil, err
+	}
+	return ParseObject(typ, content)
+}
+
+// GetRaw returns the type and content of the object with the given
+// hash after checking that they hash to it.
+func (s *PackStore) GetRaw(hash string) (string, []byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	typ, content, err := s.getLocked(hash)
+	if err != nil {
+		return "", nil, err
+	}
+	if got := hashContent(typ, content); got != hash {
+		return "", nil, fmt.Errorf("object %s: content hashes to %s", hash, got)
+	}
+	return typ, content, nil
+}
+
+// getLocked finds an object in the pending buffer or the packs.  The
+// caller holds mu.
+func (s *PackStore) getLocked(hash string) (string, []byte, error) {
+	if obj, ok := s.pending[hash]; ok {
+		return obj.typ, obj.content, nil
+	}
+	bin, ok := parseHash(hash)
+	if !ok {
+		return "", nil, fmt.Errorf("invalid hash %q", hash)
+	}
+	for _, p := range s.packs {
+		if off, ok := p.lookup(bin); ok {
+			return p.read(off, s.getLocked)
+		}
+	}
+	return "", nil, fmt.Errorf("object %s: %w", hash, os.ErrNotExist)
+}
+
+// inPacks reports whether an object is in any pack.  The caller holds
+// mu.
+func (s *PackStore) inPacks(hash string) bool {
+	bin, ok := parseHash(hash)
+	if !ok {
+		return false
+	}
+	for _, p := range s.packs {
+		if _, ok := p.lookup(bin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHash decodes a hex git hash.
+func parseHash(hash string) (bin [20]byte, ok bool) {
+	n, err := hex.Decode(bin[:], []byte(hash))
+	return bin, err == nil && n == len(bin) && len(hash) == 2*len(bin)
+}
+
+// Packs returns the number of pack files in the store.
+func (s *PackStore) Packs() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.packs)
+}
+
+// Flush writes any buffered objects out as a new pack.
+func (s *PackStore) Flush() error {
+	s.repackMu.Lock()
+	defer s.repackMu.Unlock()
+
+	s.mu.RLock()
+	objs := make([]rawObject, 0, len(s.pending))
+	for _, obj := range s.pending {
+		objs = append(objs, obj)
+	}
+	s.mu.RUnlock()
+	if len(objs) == 0 {
+		return nil
+	}
+
+	p, err := s.writeAndOpen(objs)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.packs = append(s.packs, p)
+	for _, obj := range objs {
+		delete(s.pending, obj.hash)
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// Repack merges every pack and any buffered objects into one pack,
+// searching for deltas across all of them, and deletes the old packs.
+// Gets and Puts proceed while the new pack is written.
+func (s *PackStore) Repack() error {
+	s.repackMu.Lock()
+	defer s.repackMu.Unlock()
+
+	s.mu.RLock()
+	old := append([]*packFile(nil), s.packs...)
+	var objs []rawObject
+	for _, obj := range s.pending {
+		objs = append(objs, obj)
+	}
+	seen := make(map[string]bool)
+	for _, p := range old {
+		for i, h := range p.hashes {
+			hash := hex.EncodeToString(h[:])
+			if seen[hash] {
+				continue
+			}
+			seen[hash] = true
+			typ, content, err := p.read(p.offsets[i], s.getLocked)
+			if err != nil {
+				s.mu.RUnlock()
+				return fmt.Errorf("repacking %s: %w", hash, err)
+			}
+			objs = append(objs, rawObject{typ: typ, content: content, hash: hash})
+		}
+	}
+	s.mu.RUnlock()
+	if len(old) < 2 && len(objs) == len(seen) {
+		// A single pack and nothing buffered; nothing to merge.
+		return nil
+	}
+
+	p, err := s.writeAndOpen(objs)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	var keep []*packFile
+	for _, q := range s.packs {
+		if !containsPack(old, q) && q.name != p.name {
+			keep = append(keep, q)
+		}
+	}
+	s.packs = append(keep, p)
+	for _, obj := range objs {
+		delete(s.pending, obj.hash)
+	}
+	s.mu.Unlock()
+
+	for _, q := range old {
+		if q.name == p.name {
+			// Repacking the same objects gave the same pack.
+			q.close()
+			continue
+		}
+		err = q.remove()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeAndOpen writes objs as a new pack and opens it.  Objects are
+// written in hash order, so that the same objects always make the same
+// pack.
+func (s *PackStore) writeAndOpen(objs []rawObject) (*packFile, error) {
+	sort.Slice(objs, func(i, j int) bool { return objs[i].hash < objs[j].hash })
+	name, err := writePack(s.dir, objs, s.Window, s.MaxDepth)
+	if err != nil {
+		return nil, err
+	}
+	return openPack(name)
+}
+
+// containsPack reports whether packs includes p.
+func containsPack(packs []*packFile, p *packFile) bool {
+	for _, q := range packs {
+		if q == p {
+			return true
+		}
+	}
+	return false
+}
+
+// StartRepacking starts a goroutine that checks every interval and
+// repacks once there are more than maxPacks packs, passing any error
+// to RepackError.  Stop it with Close.
+func (s *PackStore) StartRepacking(interval time.Duration, maxPacks int) {
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				if s.Packs() <= maxPacks {
+					continue
+				}
+				// An error leaves the old packs in place, and the
+				// next tick tries again.
+				err := s.Repack()
+				if err == nil {
+					continue
+				}
+				if s.RepackError != nil {
+					s.RepackError(err)
+				} else {
+					log.Printf("repacking %s: %v", s.dir, err)
+				}
+			}
+		}
+	}()
+}
+
+// Close stops background repacking, flushes buffered objects and
+// closes the pack files.
+func (s *PackStore) Close() error {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+		s.stop = nil
+	}
+	err := s.Flush()
+	s.mu.Lock()
+	s.closePacks()
+	s.mu.Unlock()
+	return err
+}
+
+// closePacks closes every open pack.
+func (s *PackStore) closePacks() {
+	for _, p := range s.packs {
+		p.close()
+	}
+	s.packs = nil
+}
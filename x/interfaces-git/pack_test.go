@@ -0,0 +1,295 @@
+package interfaces_git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/multiformats/go-multihash"
+	. "github.com/stevegt/goadapt"
+	ipldstore "github.com/stevegt/grid-poc/x/ipld-store"
+)
+
+// TestDelta round-trips deltas between related and unrelated inputs.
+func TestDelta(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	big := make([]byte, 200000)
+	rng.Read(big)
+	edited := append(append(append([]byte{}, big[:1000]...), "inserted"...), big[5000:]...)
+	cases := []struct{ src, dst []byte }{
+		{nil, nil},
+		{nil, []byte("hello")},
+		{[]byte("hello"), nil},
+		{big, edited},
+		{edited, big},
+		{big[:100], big[50:150]},
+		{[]byte("unrelated"), big[:300]},
+	}
+	for i, c := range cases {
+		d := makeDelta(c.src, c.dst)
+		got, err := applyDelta(c.src, d)
+		Tassert(t, err == nil, "case %d: %v", i, err)
+		Tassert(t, bytes.Equal(got, c.dst), "case %d: round trip failed", i)
+	}
+	d := makeDelta(big, edited)
+	Tassert(t, len(d) < 100, "expected a small delta, got %d bytes", len(d))
+}
+
+// similarBlobs returns n blobs that differ by one line each.
+func similarBlobs(n int) []*Blob {
+	var base bytes.Buffer
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(&base, "line %d of a file that changes a little in each version\n", i)
+	}
+	blobs := make([]*Blob, n)
+	for i := range blobs {
+		content := append([]byte(fmt.Sprintf("version %d\n", i)), base.Bytes()...)
+		blobs[i] = NewBlob(content)
+	}
+	return blobs
+}
+
+// TestPackStore stores similar blobs in batches, checks that deltas
+// keep the packs small, and that Repack merges them without losing
+// objects.
+func TestPackStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewPackStore(dir)
+	Ck(err)
+	store.BatchSize = 10
+	blobs := similarBlobs(35)
+	var hashes []string
+	total := 0
+	for _, b := range blobs {
+		h, err := store.Put(b)
+		Tassert(t, err == nil, "%v", err)
+		hashes = append(hashes, h)
+		total += len(b.Content)
+	}
+	Tassert(t, store.Packs() == 3, "expected 3 packs, got %d", store.Packs())
+
+	// Objects are found whether buffered or packed.
+	for i, h := range hashes {
+		obj, err := store.Get(h)
+		Tassert(t, err == nil, "%v", err)
+		Tassert(t, bytes.Equal(obj.(*Blob).Content, blobs[i].Content), "blob %d differs", i)
+	}
+
+	Ck(store.Repack())
+	Tassert(t, store.Packs() == 1, "expected 1 pack after repack, got %d", store.Packs())
+	packs, _ := filepath.Glob(filepath.Join(dir, "*.pack"))
+	Tassert(t, len(packs) == 1, "expected old packs to be deleted, got %v", packs)
+	fi, err := os.Stat(packs[0])
+	Ck(err)
+	Tassert(t, fi.Size() < int64(total/10), "expected deltas to shrink %d bytes, got %d", total, fi.Size())
+	Ck(store.Close())
+
+	// A reopened store finds everything.
+	store, err = NewPackStore(dir)
+	Ck(err)
+	defer store.Close()
+	for i, h := range hashes {
+		obj, err := store.Get(h)
+		Tassert(t, err == nil, "%v", err)
+		Tassert(t, bytes.Equal(obj.(*Blob).Content, blobs[i].Content), "blob %d differs", i)
+	}
+	_, err = store.Get(helloBlobHash)
+	Tassert(t, errors.Is(err, os.ErrNotExist), "expected not-exist error, got %v", err)
+}
+
+// TestPackStoreBackground checks that background repacking merges
+// packs while objects are being added.
+func TestPackStoreBackground(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewPackStore(dir)
+	Ck(err)
+	store.BatchSize = 5
+	store.StartRepacking(time.Millisecond, 2)
+	var hashes []string
+	for _, b := range similarBlobs(50) {
+		h, err := store.Put(b)
+		Ck(err)
+		hashes = append(hashes, h)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for store.Packs() > 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	Tassert(t, store.Packs() <= 2, "expected at most 2 packs, got %d", store.Packs())
+	Ck(store.Close())
+
+	store, err = NewPackStore(dir)
+	Ck(err)
+	defer store.Close()
+	for _, h := range hashes {
+		_, _, err = store.GetRaw(h)
+		Tassert(t, err == nil, "%v", err)
+	}
+}
+
+// TestPackDeterministic checks that the same objects make the same
+// pack whatever order they were put in.
+func TestPackDeterministic(t *testing.T) {
+	blobs := similarBlobs(20)
+	var names []string
+	for _, reverse := range []bool{false, true} {
+		dir := t.TempDir()
+		store, err := NewPackStore(dir)
+		Ck(err)
+		for i := range blobs {
+			if reverse {
+				i = len(blobs) - 1 - i
+			}
+			_, err = store.Put(blobs[i])
+			Ck(err)
+		}
+		Ck(store.Close())
+		packs, _ := filepath.Glob(filepath.Join(dir, "*.pack"))
+		Tassert(t, len(packs) == 1, "expected one pack, got %v", packs)
+		names = append(names, filepath.Base(packs[0]))
+	}
+	Tassert(t, names[0] == names[1], "packs differ: %v", names)
+}
+
+// misfiledAtom is an Atom that claims a hash its data does not have.
+type misfiledAtom struct {
+	*ipldstore.Block
+	mh multihash.Multihash
+}
+
+func (a misfiledAtom) HashMRU() multihash.Multihash {
+	return a.mh
+}
+
+// TestAtomStore round-trips Atoms through a PackStore, across a Flush,
+// a Repack and a reopen, and checks that the index is only written
+// once the Atoms are packed.
+func TestAtomStore(t *testing.T) {
+	dir := t.TempDir()
+	packs, err := NewPackStore(dir)
+	Ck(err)
+	packs.BatchSize = 4
+	store, err := NewAtomStore(packs)
+	Ck(err)
+	blobs := similarBlobs(10)
+	var mhs []multihash.Multihash
+	for _, b := range blobs {
+		mh := store.Put(ipldstore.NewBlock(b.Content))
+		Tassert(t, mh != nil, "%v", store.Err())
+		mhs = append(mhs, mh)
+	}
+	// An Atom keeps the hash it was given.
+	blake := ipldstore.NewBlock([]byte("blake3"))
+	mh := blake.HashAdd(multihash.BLAKE3)
+	Tassert(t, bytes.Equal(store.Put(blake), mh), "blake3 Atom filed under another hash")
+	blobs = append(blobs, NewBlob(blake.Data()))
+	mhs = append(mhs, mh)
+	// So does one with a truncated digest.
+	short := ipldstore.NewBlock([]byte("short"))
+	mh = short.HashAddLength(multihash.SHA2_512, 32)
+	Tassert(t, bytes.Equal(store.Put(short), mh), "truncated Atom filed under another hash: %v", store.Err())
+	blobs = append(blobs, NewBlob(short.Data()))
+	mhs = append(mhs, mh)
+
+	check := func(when string) {
+		for i, mh := range mhs {
+			atom := store.Get(mh)
+			Tassert(t, atom != nil, "%s: atom %d missing: %v", when, i, store.Err())
+			Tassert(t, bytes.Equal(atom.Data(), blobs[i].Content), "%s: atom %d differs", when, i)
+		}
+		missing := ipldstore.NewBlock([]byte("missing")).HashAdd(multihash.SHA2_256)
+		Tassert(t, store.Get(missing) == nil, "%s: found a missing atom", when)
+	}
+	check("buffered")
+
+	// An Atom whose hash is not of its data is refused.
+	lie := ipldstore.NewBlock([]byte("truth")).HashAdd(multihash.SHA2_256)
+	liar := misfiledAtom{ipldstore.NewBlock([]byte("lie")), lie}
+	Tassert(t, store.Put(liar) == nil && store.Err() != nil, "Atom with a wrong hash stored")
+	Tassert(t, store.Get(lie) == nil, "Atom with a wrong hash found")
+
+	// Nothing is indexed on disk until the Atoms are packed.
+	idx, err := os.ReadFile(filepath.Join(dir, atomIndexName))
+	Ck(err)
+	Tassert(t, len(idx) == 0, "index written before flush: %q", idx)
+	Ck(store.Flush())
+	check("flushed")
+	Ck(packs.Repack())
+	Tassert(t, packs.Packs() == 1, "expected 1 pack after repack, got %d", packs.Packs())
+	check("repacked")
+
+	Ck(store.Close())
+	Ck(packs.Close())
+	packs, err = NewPackStore(dir)
+	Ck(err)
+	defer packs.Close()
+	store, err = NewAtomStore(packs)
+	Ck(err)
+	defer store.Close()
+	check("reopened")
+}
+
+// TestPackStoreGit checks that git accepts the packs PackStore writes
+// and that PackStore reads the packs git writes.
+func TestPackStoreGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Alice", "GIT_AUTHOR_EMAIL=alice@example.com",
+			"GIT_COMMITTER_NAME=Alice", "GIT_COMMITTER_EMAIL=alice@example.com")
+		out, err := cmd.CombinedOutput()
+		Tassert(t, err == nil, "git %v: %v\n%s", args, err, out)
+		return string(bytes.TrimSpace(out))
+	}
+	git("init", "-q")
+	packDir := filepath.Join(dir, ".git", "objects", "pack")
+
+	// git reads our pack.
+	store, err := NewPackStore(packDir)
+	Ck(err)
+	var hashes []string
+	for _, b := range similarBlobs(20) {
+		h, err := store.Put(b)
+		Ck(err)
+		hashes = append(hashes, h)
+	}
+	Ck(store.Close())
+	idx, _ := filepath.Glob(filepath.Join(packDir, "*.idx"))
+	Tassert(t, len(idx) == 1, "expected one index, got %v", idx)
+	git("verify-pack", idx[0])
+	Tassert(t, bytes.HasPrefix([]byte(git("cat-file", "-p", hashes[7])), []byte("version 7\n")), "git cannot read blob")
+
+	// We read git's pack, including its deltas.
+	for i := 0; i < 5; i++ {
+		Ck(os.WriteFile(filepath.Join(dir, "f.txt"), similarBlobs(5)[i].Content, 0644))
+		git("add", "f.txt")
+		git("commit", "-q", "-m", fmt.Sprintf("commit %d", i))
+	}
+	git("repack", "-a", "-d", "-q")
+	head := git("rev-parse", "HEAD")
+	store, err = NewPackStore(packDir)
+	Ck(err)
+	defer store.Close()
+	for h := head; ; {
+		obj, err := store.Get(h)
+		Tassert(t, err == nil, "%v", err)
+		commit := obj.(*Commit)
+		_, err = store.Get(commit.Tree)
+		Tassert(t, err == nil, "%v", err)
+		if len(commit.Parents) == 0 {
+			break
+		}
+		h = commit.Parents[0]
+	}
+}
@@ -0,0 +1,401 @@
+package interfaces_git
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Pack object type numbers, as git writes them.
+const (
+	packCommit   = 1
+	packTree     = 2
+	packBlob     = 3
+	packTag      = 4
+	packOfsDelta = 6
+	packRefDelta = 7
+)
+
+var packTypes = map[string]byte{
+	CommitTagName: packCommit,
+	TreeTagName:   packTree,
+	BlobTagName:   packBlob,
+	TagTagName:    packTag,
+}
+
+var packTypeNames = map[byte]string{
+	packCommit: CommitTagName,
+	packTree:   TreeTagName,
+	packBlob:   BlobTagName,
+	packTag:    TagTagName,
+}
+
+// rawObject is an object's type, content and git hash.
+type rawObject struct {
+	typ     string
+	content []byte
+	hash    string
+}
+
+// packFile is an open pack and its index.  The index is held in
+// memory as a sorted list of hashes, so lookups are a binary search.
+type packFile struct {
+	name    string // path without the .pack or .idx suffix
+	f       *os.File
+	hashes  [][sha1.Size]byte
+	offsets []int64
+}
+
+// lookup returns the offset of an object in the pack.
+func (p *packFile) lookup(hash [sha1.Size]byte) (int64, bool) {
+	i := sort.Search(len(p.hashes), func(i int) bool {
+		return bytes.Compare(p.hashes[i][:], hash[:]) >= 0
+	})
+	if i < len(p.hashes) && p.hashes[i] == hash {
+		return p.offsets[i], true
+	}
+	return 0, false
+}
+
+// read returns the type and content of the object at offset,
+// resolving deltas.  resolve finds REF_DELTA bases, which may be in
+// other packs.
+func (p *packFile) read(offset int64, resolve func(string) (string, []byte, error)) (string, []byte, error) {
+	br := bufio.NewReader(io.NewSectionReader(p.f, offset, 1<<62))
+	c, err := br.ReadByte()
+	if err != nil {
+		return "", nil, err
+	}
+	typ := (c >> 4) & 7
+	size := uint64(c & 0x0f)
+	for shift := 4; c&0x80 != 0; shift += 7 {
+		c, err = br.ReadByte()
+		if err != nil {
+			return "", nil, err
+		}
+		size |= uint64(c&0x7f) << shift
+	}
+
+	var baseTyp string
+	var base []byte
+	switch typ {
+	case packOfsDelta:
+		c, err = br.ReadByte()
+		if err != nil {
+			return "", nil, err
+		}
+		rel := int64(c & 0x7f)
+		for c&0x80 != 0 {
+			c, err = br.ReadByte()
+			if err != nil {
+				return "", nil, err
+			}
+			rel = ((rel + 1) << 7) | int64(c&0x7f)
+		}
+		baseTyp, base, err = p.read(offset-rel, resolve)
+	case packRefDelta:
+		var h [sha1.Size]byte
+		_, err = io.ReadFull(br, h[:])
+		if err != nil {
+			return "", nil, err
+		}
+		baseTyp, base, err = resolve(hex.EncodeToString(h[:]))
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	zr, err := zlib.NewReader(br)
+	if err != nil {
+		return "", nil, err
+	}
+	data := make([]byte, size)
+	_, err = io.ReadFull(zr, data)
+	if err != nil {
+		return "", nil, err
+	}
+	if base == nil {
+		name, ok := packTypeNames[typ]
+		if !ok {
+			return "", nil, fmt.Errorf("unknown pack object type %d", typ)
+		}
+		return name, data, nil
+	}
+	content, err := applyDelta(base, data)
+	return baseTyp, content, err
+}
+
+// openPack opens a pack and reads its version 2 index.
+func openPack(name string) (*packFile, error) {
+	idx, err := os.ReadFile(name + ".idx")
+	if err != nil {
+		return nil, err
+	}
+	if len(idx) < 8+256*4 || !bytes.Equal(idx[:8], []byte{0xff, 't', 'O', 'c', 0, 0, 0, 2}) {
+		return nil, fmt.Errorf("%s.idx: not a version 2 pack index", name)
+	}
+	sum := sha1.Sum(idx[:len(idx)-sha1.Size])
+	if !bytes.Equal(sum[:], idx[len(idx)-sha1.Size:]) {
+		return nil, fmt.Errorf("%s.idx: checksum mismatch", name)
+	}
+	n := int(binary.BigEndian.Uint32(idx[8+255*4:]))
+	namesAt := 8 + 256*4
+	offsetsAt := namesAt + n*(sha1.Size+4)
+	largeAt := offsetsAt + n*4
+	if len(idx) < largeAt+2*sha1.Size {
+		return nil, fmt.Errorf("%s.idx: truncated", name)
+	}
+	p := &packFile{
+		name:    name,
+		hashes:  make([][sha1.Size]byte, n),
+		offsets: make([]int64, n),
+	}
+	for i := 0; i < n; i++ {
+		copy(p.hashes[i][:], idx[namesAt+i*sha1.Size:])
+		off := binary.BigEndian.Uint32(idx[offsetsAt+i*4:])
+		if off&0x80000000 != 0 {
+			at := largeAt + int(off&0x7fffffff)*8
+			if at+8 > len(idx)-2*sha1.Size {
+				return nil, fmt.Errorf("%s.idx: bad large offset", name)
+			}
+			p.offsets[i] = int64(binary.BigEndian.Uint64(idx[at:]))
+		} else {
+			p.offsets[i] = int64(off)
+		}
+	}
+	p.f, err = os.Open(name + ".pack")
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// close closes the pack file.
+func (p *packFile) close() error {
+	return p.f.Close()
+}
+
+// remove closes and deletes the pack and its index.
+func (p *packFile) remove() error {
+	p.close()
+	err := os.Remove(p.name + ".idx")
+	if err != nil {
+		return err
+	}
+	return os.Remove(p.name + ".pack")
+}
+
+// packEntry is an object placed in a pack being written.
+type packEntry struct {
+	obj    rawObject
+	base   *packEntry // delta base, if any
+	delta  []byte
+	depth  int
+	offset int64
+	crc    uint32
+}
+
+// chooseDeltas picks a delta base for each object from the window
+// objects before it of the same type.  Objects are ordered by type
+// and then by decreasing size, as git does, so that bases are the
+// larger objects and come first in the pack.  A delta is kept only
+// if it is less than half the size of the object.
+func chooseDeltas(objs []rawObject, window, maxDepth int) []*packEntry {
+	entries := make([]*packEntry, len(objs))
+	for i := range objs {
+		entries[i] = &packEntry{obj: objs[i]}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i].obj, entries[j].obj
+		if a.typ != b.typ {
+			return a.typ < b.typ
+		}
+		return len(a.content) > len(b.content)
+	})
+	for i, e := range entries {
+		best := len(e.obj.content) / 2
+		for j := i - 1; j >= 0 && j >= i-window; j-- {
+			b := entries[j]
+			if b.obj.typ != e.obj.typ || b.depth >= maxDepth {
+				continue
+			}
+			d := makeDelta(b.obj.content, e.obj.content)
+			if len(d) < best {
+				best = len(d)
+				e.base, e.delta, e.depth = b, d, b.depth+1
+			}
+		}
+	}
+	return entries
+}
+
+// writePack writes objects into a new pack and index in dir, using
+// OFS_DELTA entries where a delta helps, and returns the pack's name.
+func writePack(dir string, objs []rawObject, window, maxDepth int) (string, error) {
+	entries := chooseDeltas(objs, window, maxDepth)
+
+	tmp, err := os.CreateTemp(dir, "tmp_pack_")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	sum := sha1.New()
+	w := &countingWriter{w: io.MultiWriter(tmp, sum)}
+	var hdr [12]byte
+	copy(hdr[:], "PACK")
+	binary.BigEndian.PutUint32(hdr[4:], 2)
+	binary.BigEndian.PutUint32(hdr[8:], uint32(len(entries)))
+	_, err = w.Write(hdr[:])
+	if err != nil {
+		return "", err
+	}
+
+	for _, e := range entries {
+		e.offset = w.n
+		typ, data := packTypes[e.obj.typ], e.obj.content
+		if e.base != nil {
+			typ, data = packOfsDelta, e.delta
+		}
+		var buf bytes.Buffer
+		size := uint64(len(data))
+		c := typ<<4 | byte(size&0x0f)
+		size >>= 4
+		for size > 0 {
+			buf.WriteByte(c | 0x80)
+			c = byte(size & 0x7f)
+			size >>= 7
+		}
+		buf.WriteByte(c)
+		if e.base != nil {
+			rel := e.offset - e.base.offset
+			ofs := []byte{byte(rel & 0x7f)}
+			for rel >>= 7; rel > 0; rel >>= 7 {
+				rel--
+				ofs = append([]byte{byte(0x80 | rel&0x7f)}, ofs...)
+			}
+			buf.Write(ofs)
+		}
+		zw := zlib.NewWriter(&buf)
+		zw.Write(data)
+		err = zw.Close()
+		if err != nil {
+			return "", err
+		}
+		e.crc = crc32.ChecksumIEEE(buf.Bytes())
+		_, err = w.Write(buf.Bytes())
+		if err != nil {
+			return "", err
+		}
+	}
+	packSum := sum.Sum(nil)
+	_, err = tmp.Write(packSum)
+	if err != nil {
+		return "", err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return "", err
+	}
+
+	name := filepath.Join(dir, "pack-"+hex.EncodeToString(packSum))
+	if _, err := os.Stat(name + ".pack"); err == nil {
+		// The same objects were packed the same way before.
+		return name, nil
+	}
+	err = os.WriteFile(name+".idx", makeIndex(entries, packSum), 0444)
+	if err != nil {
+		return "", err
+	}
+	err = os.Rename(tmp.Name(), name+".pack")
+	if err != nil {
+		os.Remove(name + ".idx")
+		return "", err
+	}
+	return name, nil
+}
+
+// makeIndex returns a version 2 pack index for entries.
+func makeIndex(entries []*packEntry, packSum []byte) []byte {
+	type item struct {
+		hash   []byte
+		crc    uint32
+		offset int64
+	}
+	items := make([]item, len(entries))
+	for i, e := range entries {
+		h, _ := hex.DecodeString(e.obj.hash)
+		items[i] = item{h, e.crc, e.offset}
+	}
+	sort.Slice(items, func(i, j int) bool { return bytes.Compare(items[i].hash, items[j].hash) < 0 })
+
+	var buf bytes.Buffer
+	buf.Write([]byte{0xff, 't', 'O', 'c', 0, 0, 0, 2})
+	var fanout [256]uint32
+	for _, it := range items {
+		fanout[it.hash[0]]++
+	}
+	var total uint32
+	for i := range fanout {
+		total += fanout[i]
+		binary.Write(&buf, binary.BigEndian, total)
+	}
+	for _, it := range items {
+		buf.Write(it.hash)
+	}
+	for _, it := range items {
+		binary.Write(&buf, binary.BigEndian, it.crc)
+	}
+	var large []uint64
+	for _, it := range items {
+		if it.offset < 0x80000000 {
+			binary.Write(&buf, binary.BigEndian, uint32(it.offset))
+			continue
+		}
+		binary.Write(&buf, binary.BigEndian, uint32(0x80000000|len(large)))
+		large = append(large, uint64(it.offset))
+	}
+	for _, off := range large {
+		binary.Write(&buf, binary.BigEndian, off)
+	}
+	buf.Write(packSum)
+	idxSum := sha1.Sum(buf.Bytes())
+	buf.Write(idxSum[:])
+	return buf.Bytes()
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// listPacks returns the names of the packs in dir.
+func listPacks(dir string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "pack-*.idx"))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(matches))
+	for i, m := range matches {
+		names[i] = strings.TrimSuffix(m, ".idx")
+	}
+	return names, nil
+}
@@ -0,0 +1,149 @@
+// Command gridcar packs loose grid files, such as git-cbor output or
+// descriptors written with -o, into a CAR file, and unpacks a CAR file
+// into one file per block.
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/multiformats/go-multihash"
+	ipldcar "github.com/stevegt/grid-poc/x/ipld-car"
+	ipldstore "github.com/stevegt/grid-poc/x/ipld-store"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <subcommand> [options]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Subcommands:\n")
+	fmt.Fprintf(os.Stderr, "  pack [-v 1|2] [-o out.car] <file>...\n")
+	fmt.Fprintf(os.Stderr, "  unpack [-d dir] <in.car>\n")
+	os.Exit(1)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch os.Args[1] {
+	case "pack":
+		err = pack(os.Args[2:])
+	case "unpack":
+		err = unpack(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// pack stores each file as one block, DAG-CBOR if it decodes as such
+// and raw otherwise, and writes a CAR with the files as roots.  Links
+// between the files are followed, so a file linked from another is
+// written once.
+func pack(args []string) error {
+	fs := flag.NewFlagSet("pack", flag.ExitOnError)
+	version := fs.Int("v", ipldcar.V1, "CAR version")
+	outPath := fs.String("o", "-", "output file, - for stdout")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		usage()
+	}
+
+	store := ipldstore.NewMemStore()
+	storage := &ipldstore.Storage{Store: store}
+	var roots []cid.Cid
+	for _, path := range fs.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		codec := uint64(cid.DagCBOR)
+		if dagcbor.Decode(basicnode.Prototype.Any.NewBuilder(), bytes.NewReader(data)) != nil {
+			codec = cid.Raw
+		}
+		c, err := cid.Prefix{
+			Version:  1,
+			Codec:    codec,
+			MhType:   multihash.SHA2_256,
+			MhLength: -1,
+		}.Sum(data)
+		if err != nil {
+			return err
+		}
+		err = storage.Put(context.Background(), c.KeyString(), data)
+		if err != nil {
+			return err
+		}
+		roots = append(roots, c)
+	}
+
+	out := os.Stdout
+	if *outPath != "-" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	return ipldcar.ExportStore(context.Background(), store, out, *version, roots...)
+}
+
+// unpack imports a CAR, checking every block, and writes each block to
+// a file named by its CID, printing the roots.
+func unpack(args []string) error {
+	fs := flag.NewFlagSet("unpack", flag.ExitOnError)
+	dir := fs.String("d", ".", "output directory")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+
+	in, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	files := &dirStorage{dir: *dir}
+	roots, err := ipldcar.Import(context.Background(), in, files)
+	if err != nil {
+		return err
+	}
+	for _, root := range roots {
+		fmt.Println(root)
+	}
+	return nil
+}
+
+// dirStorage is IPLD write storage keeping each block in a file named
+// by its CID.
+type dirStorage struct {
+	dir string
+}
+
+func (d *dirStorage) Has(ctx context.Context, key string) (bool, error) {
+	_, c, err := cid.CidFromBytes([]byte(key))
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(filepath.Join(d.dir, c.String()))
+	return err == nil, nil
+}
+
+func (d *dirStorage) Put(ctx context.Context, key string, content []byte) error {
+	_, c, err := cid.CidFromBytes([]byte(key))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(d.dir, c.String()), content, 0644)
+}
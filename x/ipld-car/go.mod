@@ -0,0 +1,44 @@
+module github.com/stevegt/grid-poc/x/ipld-car
+
+go 1.24.0
+
+replace (
+	github.com/stevegt/grid-poc => ../..
+	github.com/stevegt/grid-poc/x/ipld-explore => ../ipld-explore
+	github.com/stevegt/grid-poc/x/ipld-store => ../ipld-store
+)
+
+require (
+	github.com/ipfs/go-cid v0.5.0
+	github.com/ipld/go-car/v2 v2.14.2
+	github.com/ipld/go-ipld-prime v0.21.0
+	github.com/multiformats/go-multihash v0.2.3
+	github.com/stevegt/grid-poc v0.0.0-00010101000000-000000000000
+	github.com/stevegt/grid-poc/x/ipld-explore v0.0.0-00010101000000-000000000000
+	github.com/stevegt/grid-poc/x/ipld-store v0.0.0-00010101000000-000000000000
+)
+
+require (
+	github.com/ipfs/go-block-format v0.2.0 // indirect
+	github.com/ipfs/go-ipfs-util v0.0.3 // indirect
+	github.com/ipfs/go-ipld-cbor v0.1.0 // indirect
+	github.com/ipfs/go-ipld-format v0.6.0 // indirect
+	github.com/klauspost/cpuid/v2 v2.2.8 // indirect
+	github.com/minio/sha256-simd v1.0.1 // indirect
+	github.com/mr-tron/base58 v1.2.0 // indirect
+	github.com/multiformats/go-base32 v0.1.0 // indirect
+	github.com/multiformats/go-base36 v0.2.0 // indirect
+	github.com/multiformats/go-multibase v0.2.0 // indirect
+	github.com/multiformats/go-multicodec v0.9.0 // indirect
+	github.com/multiformats/go-varint v0.0.7 // indirect
+	github.com/petar/GoLLRB v0.0.0-20210522233825-ae3b015fd3e9 // indirect
+	github.com/polydawn/refmt v0.89.0 // indirect
+	github.com/spaolacci/murmur3 v1.1.0 // indirect
+	github.com/whyrusleeping/cbor v0.0.0-20171005072247-63513f603b11 // indirect
+	github.com/whyrusleeping/cbor-gen v0.1.2 // indirect
+	golang.org/x/crypto v0.31.0 // indirect
+	golang.org/x/exp v0.0.0-20240719175910-8a7402abbf56 // indirect
+	golang.org/x/sys v0.28.0 // indirect
+	golang.org/x/xerrors v0.0.0-20240903120638-7835f813f4da // indirect
+	lukechampine.com/blake3 v1.3.0 // indirect
+)
@@ -0,0 +1,100 @@
+// Package ipldcar moves grid data in and out of CAR (Content
+// Addressable aRchive) files, the offline transfer format of IPFS.
+//
+// Export writes every block reachable from one or more roots in any
+// IPLD storage, or any grid Store through ipldstore, as a CARv1 or a
+// CARv2 with an index.  Import reads either version back into a store,
+// checking that every block hashes to its CID.  kubo reads and writes
+// the same files with `ipfs dag export` and `ipfs dag import`.
+package ipldcar
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	car "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage"
+	grid "github.com/stevegt/grid-poc"
+	ipldexplore "github.com/stevegt/grid-poc/x/ipld-explore"
+	ipldstore "github.com/stevegt/grid-poc/x/ipld-store"
+)
+
+// CAR format versions.
+const (
+	V1 = 1
+	V2 = 2
+)
+
+// Export writes a CAR of the given version to out, holding roots and
+// every block reachable from them in ls, each block once.  A CARv2 is
+// indexed, and is built in memory before it is written.  A block that
+// cannot be loaded fails the export.
+func Export(ctx context.Context, ls linking.LinkSystem, out io.Writer, version int, roots ...cid.Cid) error {
+	if len(roots) == 0 {
+		return errors.New("no roots")
+	}
+	links := make([]datamodel.Link, len(roots))
+	for i, root := range roots {
+		links[i] = cidlink.Link{Cid: root}
+	}
+	w := &ipldexplore.Walker{LinkSystem: ls}
+
+	switch version {
+	case V1:
+		return w.WriteCAR(ctx, out, links...)
+	case V2:
+		var v1 bytes.Buffer
+		err := w.WriteCAR(ctx, &v1, links...)
+		if err != nil {
+			return err
+		}
+		return car.WrapV1(bytes.NewReader(v1.Bytes()), out)
+	default:
+		return fmt.Errorf("unsupported CAR version %d", version)
+	}
+}
+
+// ExportStore is Export from a grid Store.
+func ExportStore(ctx context.Context, store grid.Store, out io.Writer, version int, roots ...cid.Cid) error {
+	return Export(ctx, ipldstore.NewLinkSystem(store), out, version, roots...)
+}
+
+// Import reads a CARv1 or CARv2 from r and puts each of its blocks in
+// dst, returning the CAR's roots.  Every block is hashed and checked
+// against its CID before it is stored; a mismatch fails the import,
+// leaving the blocks before it stored.
+func Import(ctx context.Context, r io.Reader, dst storage.WritableStorage) ([]cid.Cid, error) {
+	br, err := car.NewBlockReader(r, car.WithTrustedCAR(false))
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		blk, err := br.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		err = dst.Put(ctx, blk.Cid().KeyString(), blk.RawData())
+		if err != nil {
+			return nil, fmt.Errorf("block %s: %w", blk.Cid(), err)
+		}
+	}
+	return br.Roots, nil
+}
+
+// ImportStore is Import into a grid Store.
+func ImportStore(ctx context.Context, r io.Reader, store grid.Store) ([]cid.Cid, error) {
+	return Import(ctx, r, &ipldstore.Storage{Store: store})
+}
@@ -0,0 +1,183 @@
+package ipldcar
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	car "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/multiformats/go-multihash"
+	ipldstore "github.com/stevegt/grid-poc/x/ipld-store"
+)
+
+var cborLink = cidlink.LinkPrototype{Prefix: cid.Prefix{
+	Version:  1,
+	Codec:    cid.DagCBOR,
+	MhType:   multihash.SHA2_256,
+	MhLength: -1,
+}}
+
+// storeState stores a map node with a name and links to parents.
+func storeState(t *testing.T, ls *linking.LinkSystem, name string, parents ...ipld.Link) cid.Cid {
+	t.Helper()
+	n, err := qp.BuildMap(basicnode.Prototype.Any, 2, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "name", qp.String(name))
+		qp.MapEntry(ma, "parents", qp.List(int64(len(parents)), func(la datamodel.ListAssembler) {
+			for _, p := range parents {
+				qp.ListEntry(la, qp.Link(p))
+			}
+		}))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lnk, err := ls.Store(linking.LinkContext{}, cborLink, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lnk.(cidlink.Link).Cid
+}
+
+// testStore returns a store holding a -> b, a -> c, b -> d, c -> d and
+// an unrelated block e, and the CIDs by name.
+func testStore(t *testing.T) (*ipldstore.MemStore, map[string]cid.Cid) {
+	t.Helper()
+	store := ipldstore.NewMemStore()
+	ls := ipldstore.NewLinkSystem(store)
+	c := make(map[string]cid.Cid)
+	link := func(name string) ipld.Link { return cidlink.Link{Cid: c[name]} }
+	c["d"] = storeState(t, &ls, "d")
+	c["b"] = storeState(t, &ls, "b", link("d"))
+	c["c"] = storeState(t, &ls, "c", link("d"))
+	c["a"] = storeState(t, &ls, "a", link("b"), link("c"))
+	c["e"] = storeState(t, &ls, "e")
+	return store, c
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	src, c := testStore(t)
+	for _, version := range []int{V1, V2} {
+		var buf bytes.Buffer
+		err := ExportStore(ctx, src, &buf, version, c["a"], c["e"])
+		if err != nil {
+			t.Fatalf("v%d: %v", version, err)
+		}
+
+		br, err := car.NewBlockReader(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("v%d: %v", version, err)
+		}
+		if int(br.Version) != version {
+			t.Errorf("wrote v%d, want v%d", br.Version, version)
+		}
+
+		dst := ipldstore.NewMemStore()
+		roots, err := ImportStore(ctx, &buf, dst)
+		if err != nil {
+			t.Fatalf("v%d: %v", version, err)
+		}
+		if len(roots) != 2 || roots[0] != c["a"] || roots[1] != c["e"] {
+			t.Errorf("v%d: roots %v", version, roots)
+		}
+		if dst.Len() != 5 {
+			t.Errorf("v%d: imported %d blocks, want 5", version, dst.Len())
+		}
+		for name, want := range c {
+			got := dst.Get(want.Hash())
+			if got == nil || !bytes.Equal(got.Data(), src.Get(want.Hash()).Data()) {
+				t.Errorf("v%d: block %s not imported", version, name)
+			}
+		}
+	}
+}
+
+func TestExportIndexed(t *testing.T) {
+	src, c := testStore(t)
+	var buf bytes.Buffer
+	err := ExportStore(context.Background(), src, &buf, V2, c["a"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := car.NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Header.HasIndex() {
+		t.Error("CARv2 has no index")
+	}
+	ir, err := r.IndexReader()
+	if err != nil || ir == nil {
+		t.Fatalf("index reader: %v", err)
+	}
+}
+
+func TestExportSharedOnce(t *testing.T) {
+	src, c := testStore(t)
+	var buf bytes.Buffer
+	err := ExportStore(context.Background(), src, &buf, V1, c["a"], c["b"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	br, err := car.NewBlockReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[cid.Cid]int)
+	for {
+		blk, err := br.Next()
+		if err != nil {
+			break
+		}
+		seen[blk.Cid()]++
+	}
+	if len(seen) != 4 {
+		t.Errorf("got %d blocks, want 4", len(seen))
+	}
+	for k, n := range seen {
+		if n != 1 {
+			t.Errorf("block %s written %d times", k, n)
+		}
+	}
+}
+
+func TestExportErrors(t *testing.T) {
+	ctx := context.Background()
+	src, c := testStore(t)
+	var buf bytes.Buffer
+	if err := ExportStore(ctx, src, &buf, 3, c["a"]); err == nil {
+		t.Error("exported CARv3")
+	}
+	if err := ExportStore(ctx, src, &buf, V1); err == nil {
+		t.Error("exported without roots")
+	}
+	missing, _ := cborLink.Prefix.Sum([]byte("missing"))
+	if err := ExportStore(ctx, src, &buf, V1, missing); err == nil {
+		t.Error("exported a missing block")
+	}
+}
+
+func TestImportTampered(t *testing.T) {
+	ctx := context.Background()
+	src, c := testStore(t)
+	var buf bytes.Buffer
+	err := ExportStore(ctx, src, &buf, V1, c["e"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rename the block, which is last, from "e" to "f".
+	data := buf.Bytes()
+	data[bytes.LastIndex(data, []byte{0x61, 'e'})+1] = 'f'
+	_, err = ImportStore(ctx, bytes.NewReader(data), ipldstore.NewMemStore())
+	if err == nil || !strings.Contains(err.Error(), "integrity") {
+		t.Errorf("got %v, want an integrity error", err)
+	}
+}
@@ -5,6 +5,8 @@ go 1.24.0
 require (
 	github.com/ipfs/go-cid v0.5.0
 	github.com/ipld/go-ipld-prime v0.21.0
+	github.com/stevegt/grid-poc/x/ipld-path v0.0.0-00010101000000-000000000000
+	github.com/stevegt/grid-poc/x/ipld-store v0.0.0-00010101000000-000000000000
 )
 
 require (
@@ -18,7 +20,14 @@ require (
 	github.com/multiformats/go-varint v0.0.7 // indirect
 	github.com/polydawn/refmt v0.89.0 // indirect
 	github.com/spaolacci/murmur3 v1.1.0 // indirect
+	github.com/stevegt/grid-poc v0.0.0-00010101000000-000000000000 // indirect
 	golang.org/x/crypto v0.31.0 // indirect
 	golang.org/x/sys v0.28.0 // indirect
 	lukechampine.com/blake3 v1.1.6 // indirect
 )
+
+replace (
+	github.com/stevegt/grid-poc => ../..
+	github.com/stevegt/grid-poc/x/ipld-path => ../ipld-path
+	github.com/stevegt/grid-poc/x/ipld-store => ../ipld-store
+)
@@ -1,17 +1,17 @@
 package ipldpath
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-ipld-prime"
 	"github.com/ipld/go-ipld-prime/codec/dagjson"
-	"github.com/ipld/go-ipld-prime/datamodel"
 	"github.com/ipld/go-ipld-prime/linking"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
-	"github.com/ipld/go-ipld-prime/node/basicnode"
 	"github.com/ipld/go-ipld-prime/node/bindnode"
-	"github.com/ipld/go-ipld-prime/storage/memstore"
+	resolver "github.com/stevegt/grid-poc/x/ipld-path"
+	ipldstore "github.com/stevegt/grid-poc/x/ipld-store"
 )
 
 // UserData represents a root node containing links to other data blocks
@@ -33,11 +33,9 @@ type Settings struct {
 
 // RunDemo sets up and demonstrates cross-block path traversal
 func RunDemo() {
-	// Initialize in-memory storage and linking system
-	store := &memstore.Store{}
-	ls := cidlink.DefaultLinkSystem()
-	ls.SetReadStorage(store)
-	ls.SetWriteStorage(store)
+	// Initialize a linking system over an in-memory grid store
+	store := ipldstore.NewMemStore()
+	ls := ipldstore.NewLinkSystem(store)
 
 	// Create and store profile block
 	profile := &Profile{Name: "Alice", Age: 30}
@@ -69,7 +67,7 @@ func storeNode(ls linking.LinkSystem, data interface{}) ipld.Link {
 			Prefix: cid.Prefix{
 				Version:  1,
 				Codec:    0x0129, // dag-json multicodec
-				MhType:   0x13,    // sha3-384
+				MhType:   0x13,   // sha3-384
 				MhLength: 32,
 			},
 		},
@@ -81,37 +79,22 @@ func storeNode(ls linking.LinkSystem, data interface{}) ipld.Link {
 	return link
 }
 
-// navigate demonstrates path traversal across linked blocks
+// navigate demonstrates path traversal across linked blocks, printing
+// the blocks crossed on the way
 func navigate(ls linking.LinkSystem, startLink ipld.Link, pathStr string) {
 	fmt.Printf("Navigating: %s\n", pathStr)
-	path := datamodel.ParsePath(pathStr)
-
-	node, err := ls.Load(ipld.LinkContext{}, startLink, basicnode.Prototype.Any)
-	if err != nil {
-		panic(err)
+	r := &resolver.Resolver{LinkSystem: ls, Proof: true}
+	res, err := r.ResolveString(context.Background(), startLink, pathStr)
+	for _, c := range res.Blocks {
+		fmt.Printf("  via %s\n", c)
 	}
-
-	for path.Len() > 0 {
-		seg, remaining := path.Shift()
-		path = remaining
-
-		// Resolve links automatically during traversal
-		if node.Kind() == ipld.Kind_Link {
-			link, _ := node.AsLink()
-			node, _ = ls.Load(ipld.LinkContext{}, link, basicnode.Prototype.Any)
-		}
-
-		switch node.Kind() {
-		case ipld.Kind_Map:
-			node, _ = node.LookupByString(seg.String())
-		case ipld.Kind_List:
-			idx, _ := seg.Index()
-			node, _ = node.LookupByIndex(idx)
-		}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
 	}
 
 	fmt.Print("Found value: ")
-	_ = dagjson.Encode(node, &consoleWriter{})
+	_ = dagjson.Encode(res.Node, &consoleWriter{})
 	fmt.Println()
 }
 
@@ -0,0 +1,170 @@
+package ipldexplore
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+)
+
+// WriteCAR writes a CARv1 archive of roots and every block the Walker
+// reaches from them, each block once, in the order visited.  A block
+// that cannot be loaded fails the export.
+func (w *Walker) WriteCAR(ctx context.Context, out io.Writer, roots ...datamodel.Link) error {
+	header, err := qp.BuildMap(basicnode.Prototype.Map, 2, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "roots", qp.List(int64(len(roots)), func(la datamodel.ListAssembler) {
+			for _, root := range roots {
+				qp.ListEntry(la, qp.Link(root))
+			}
+		}))
+		qp.MapEntry(ma, "version", qp.Int(1))
+	})
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	err = dagcbor.Encode(header, &buf)
+	if err != nil {
+		return err
+	}
+	err = writeSection(out, buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	for _, root := range roots {
+		err = w.Walk(ctx, root, func(b Block) error {
+			if b.Err != nil {
+				return fmt.Errorf("block %s at %q: %w", b.Link, b.Path, b.Err)
+			}
+			cl, ok := b.Link.(cidlink.Link)
+			if !ok {
+				return fmt.Errorf("block %s: not a CID link", b.Link)
+			}
+			data, err := w.LinkSystem.LoadRaw(linking.LinkContext{Ctx: ctx}, b.Link)
+			if err != nil {
+				return err
+			}
+			return writeSection(out, cl.Cid.Bytes(), data)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSection writes a CAR section: the total length of parts as a
+// varint, then the parts.
+func writeSection(out io.Writer, parts ...[]byte) error {
+	n := 0
+	for _, p := range parts {
+		n += len(p)
+	}
+	_, err := out.Write(binary.AppendUvarint(nil, uint64(n)))
+	if err != nil {
+		return err
+	}
+	for _, p := range parts {
+		_, err = out.Write(p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteDOT writes the DAG below root as a Graphviz digraph, one node
+// per block and one edge per link, labelled with the link's path in
+// its block.  Links the walk does not follow, such as links past
+// MaxDepth, still get an edge and a plain node.
+func (w *Walker) WriteDOT(ctx context.Context, out io.Writer, root datamodel.Link) error {
+	fmt.Fprintln(out, "digraph DAG {")
+	fmt.Fprintln(out, "  graph [rankdir=LR];")
+	fmt.Fprintln(out, "  node [fontname=\"Helvetica\", shape=rectangle];")
+	err := w.Walk(ctx, root, func(b Block) error {
+		id := dotID(b.Link)
+		if b.Err != nil {
+			fmt.Fprintf(out, "  %s [label=\"%s\\nmissing\", style=dashed];\n", id, shortLink(b.Link))
+			return nil
+		}
+		fmt.Fprintf(out, "  %s [label=\"%s\\n%s\", style=filled, color=lightgray];\n", id, shortLink(b.Link), summary(b.Node))
+		return EachLink(b.Node, func(local datamodel.Path, lnk datamodel.Link) error {
+			_, err := fmt.Fprintf(out, "  %s -> %s [label=\"%s\"];\n", id, dotID(lnk), escapeDOT(local.String()))
+			return err
+		})
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, "}")
+	return err
+}
+
+// WriteTree writes the DAG below root as an indented text tree, one
+// line per block, giving each block's path in its parent, CID and
+// summary.  The walk is made depth first whatever the Walker's Order,
+// so that children follow their parents.
+func (w *Walker) WriteTree(ctx context.Context, out io.Writer, root datamodel.Link) error {
+	dfs := *w
+	dfs.Order = DepthFirst
+	err := dfs.Walk(ctx, root, func(b Block) error {
+		indent := strings.Repeat("  ", b.Depth)
+		name := b.Link.String()
+		if b.Depth > 0 {
+			name = b.Local.String() + " -> " + name
+		}
+		if b.Err != nil {
+			_, err := fmt.Fprintf(out, "%s%s (missing: %v)\n", indent, name, b.Err)
+			return err
+		}
+		_, err := fmt.Fprintf(out, "%s%s %s\n", indent, name, summary(b.Node))
+		return err
+	})
+	w.Visited = dfs.Visited
+	return err
+}
+
+// summary describes a node in a few words.
+func summary(n datamodel.Node) string {
+	switch n.Kind() {
+	case datamodel.Kind_Map:
+		return fmt.Sprintf("map (%d entries)", n.Length())
+	case datamodel.Kind_List:
+		return fmt.Sprintf("list (%d items)", n.Length())
+	case datamodel.Kind_Bytes:
+		b, _ := n.AsBytes()
+		return fmt.Sprintf("bytes (%d)", len(b))
+	default:
+		return n.Kind().String()
+	}
+}
+
+// shortLink returns the last eight characters of a link's string form,
+// which, unlike the first ones, differ between CIDs of the same kind.
+func shortLink(lnk datamodel.Link) string {
+	s := lnk.String()
+	if len(s) > 8 {
+		s = "…" + s[len(s)-8:]
+	}
+	return s
+}
+
+// dotID returns a DOT node ID for a link.
+func dotID(lnk datamodel.Link) string {
+	return "\"" + escapeDOT(lnk.String()) + "\""
+}
+
+// escapeDOT escapes a string for use inside double quotes in DOT.
+func escapeDOT(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
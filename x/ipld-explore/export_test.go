@@ -0,0 +1,122 @@
+package ipldexplore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+)
+
+func TestWriteCAR(t *testing.T) {
+	ls, links := testDAG(t)
+	w := &Walker{LinkSystem: ls}
+	var buf bytes.Buffer
+	err := w.WriteCAR(context.Background(), &buf, links["root"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := bufio.NewReader(&buf)
+	section := func() []byte {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(r, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	nb := basicnode.Prototype.Any.NewBuilder()
+	err = dagcbor.Decode(nb, bytes.NewReader(section()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := nb.Build()
+	version, _ := header.LookupByString("version")
+	if v, _ := version.AsInt(); v != 1 {
+		t.Errorf("version %d", v)
+	}
+	roots, _ := header.LookupByString("roots")
+	root, _ := roots.LookupByIndex(0)
+	if lnk, _ := root.AsLink(); roots.Length() != 1 || lnk != links["root"] {
+		t.Errorf("roots %v", lnk)
+	}
+
+	want := []string{"root", "a", "c", "b"}
+	for i := 0; ; i++ {
+		s := section()
+		if s == nil {
+			if i != len(want) {
+				t.Errorf("got %d blocks, want %d", i, len(want))
+			}
+			break
+		}
+		n, c, err := cid.CidFromBytes(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i < len(want) && (cidlink.Link{Cid: c}) != links[want[i]] {
+			t.Errorf("block %d is %s, want %s", i, c, want[i])
+		}
+		stored, err := ls.LoadRaw(linking.LinkContext{}, cidlink.Link{Cid: c})
+		if err != nil || !bytes.Equal(stored, s[n:]) {
+			t.Errorf("block %d data does not match the store: %v", i, err)
+		}
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	ls, links := testDAG(t)
+	w := &Walker{LinkSystem: ls}
+	var buf bytes.Buffer
+	err := w.WriteDOT(context.Background(), &buf, links["root"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.HasPrefix(out, "digraph DAG {\n") || !strings.HasSuffix(out, "}\n") {
+		t.Errorf("not a digraph:\n%s", out)
+	}
+	for _, edge := range [][2]string{{"root", "a"}, {"root", "b"}, {"a", "c"}, {"b", "c"}} {
+		want := dotID(links[edge[0]]) + " -> " + dotID(links[edge[1]])
+		if !strings.Contains(out, want) {
+			t.Errorf("no edge %s -> %s", edge[0], edge[1])
+		}
+	}
+	if n := strings.Count(out, "style=filled"); n != 4 {
+		t.Errorf("%d nodes, want 4", n)
+	}
+}
+
+func TestWriteTree(t *testing.T) {
+	ls, links := testDAG(t)
+	w := &Walker{LinkSystem: ls, Order: BreadthFirst}
+	var buf bytes.Buffer
+	err := w.WriteTree(context.Background(), &buf, links["root"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := links["root"].String() + " map (2 entries)\n" +
+		"  Children/0 -> " + links["a"].String() + " map (2 entries)\n" +
+		"    Children/0 -> " + links["c"].String() + " map (1 entries)\n" +
+		"  Children/1 -> " + links["b"].String() + " map (2 entries)\n"
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+	if w.Order != BreadthFirst {
+		t.Error("WriteTree changed the Walker's order")
+	}
+}
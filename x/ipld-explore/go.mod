@@ -1,10 +1,12 @@
-module ipld-path-cross-explore-patch
+module github.com/stevegt/grid-poc/x/ipld-explore
 
 go 1.24.0
 
 require (
 	github.com/ipfs/go-cid v0.5.0
 	github.com/ipld/go-ipld-prime v0.21.0
+	github.com/multiformats/go-multihash v0.2.3
+	github.com/stevegt/grid-poc/x/ipld-store v0.0.0-00010101000000-000000000000
 )
 
 require (
@@ -14,11 +16,16 @@ require (
 	github.com/multiformats/go-base32 v0.0.3 // indirect
 	github.com/multiformats/go-base36 v0.1.0 // indirect
 	github.com/multiformats/go-multibase v0.2.0 // indirect
-	github.com/multiformats/go-multihash v0.2.3 // indirect
 	github.com/multiformats/go-varint v0.0.7 // indirect
 	github.com/polydawn/refmt v0.89.0 // indirect
 	github.com/spaolacci/murmur3 v1.1.0 // indirect
+	github.com/stevegt/grid-poc v0.0.0-00010101000000-000000000000 // indirect
 	golang.org/x/crypto v0.31.0 // indirect
 	golang.org/x/sys v0.28.0 // indirect
 	lukechampine.com/blake3 v1.1.6 // indirect
 )
+
+replace (
+	github.com/stevegt/grid-poc => ../..
+	github.com/stevegt/grid-poc/x/ipld-store => ../ipld-store
+)
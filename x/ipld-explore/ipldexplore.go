@@ -2,8 +2,8 @@ package ipldexplore
 
 import (
 	"bytes"
+	"context"
 	"fmt"
-	"io"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-ipld-prime"
@@ -12,15 +12,14 @@ import (
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/ipld/go-ipld-prime/node/basicnode"
 	"github.com/multiformats/go-multihash"
+	ipldstore "github.com/stevegt/grid-poc/x/ipld-store"
 )
 
 // RunDemo creates and explores a simple hierarchical IPLD structure
 func RunDemo() {
-	// Initialize in-memory storage and link system
-	store := NewMemStore()
-	ls := cidlink.DefaultLinkSystem()
-	ls.StorageReadOpener = store.OpenRead
-	ls.StorageWriteOpener = store.OpenWrite
+	// Initialize a link system over an in-memory grid store
+	store := ipldstore.NewMemStore()
+	ls := ipldstore.NewLinkSystem(store)
 
 	// Create leaf nodes
 	leaf1 := createNode(&ls, "Leaf 1", nil)
@@ -31,40 +30,9 @@ func RunDemo() {
 
 	// Begin recursive exploration from root
 	fmt.Println("IPLD Node Structure:")
-	exploreNode(ls, parent, 0)
-}
-
-// MemStore implements simple in-memory block storage
-type MemStore struct {
-	blocks map[cid.Cid][]byte
-}
-
-func NewMemStore() *MemStore {
-	return &MemStore{blocks: make(map[cid.Cid][]byte)}
-}
-
-func (m *MemStore) OpenRead(_ linking.LinkContext, l ipld.Link) (io.Reader, error) {
-	c, err := cid.Parse([]byte(l.Binary()))
-	if err != nil {
-		return nil, err
+	if err := exploreNode(ls, parent); err != nil {
+		fmt.Println("explore failed:", err)
 	}
-	data, exists := m.blocks[c]
-	if !exists {
-		return nil, fmt.Errorf("block not found")
-	}
-	return bytes.NewReader(data), nil
-}
-
-func (m *MemStore) OpenWrite(_ linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
-	buf := bytes.NewBuffer(nil)
-	return buf, func(lnk ipld.Link) error {
-		c, err := cid.Parse([]byte(lnk.Binary()))
-		if err != nil {
-			return err
-		}
-		m.blocks[c] = buf.Bytes()
-		return nil
-	}, nil
 }
 
 // createNode constructs and stores a node with name and optional children links
@@ -112,28 +80,18 @@ func createNode(ls *linking.LinkSystem, name string, children []ipld.Link) ipld.
 	return lnk
 }
 
-// exploreNode recursively traverses and prints IPLD nodes
-func exploreNode(ls linking.LinkSystem, link ipld.Link, depth int) {
-	// Load node from link system
-	node, err := ls.Load(linking.LinkContext{}, link, basicnode.Prototype.Any)
-	if err != nil {
-		panic("load failed: " + err.Error())
-	}
-
-	printNode(node, depth)
-
-	// Process child links if present
-	if children, err := node.LookupByString("Children"); err == nil {
-		iter := children.ListIterator()
-		for !iter.Done() {
-			_, item, _ := iter.Next()
-			if item.Kind() == ipld.Kind_Link {
-				if childLink, err := item.AsLink(); err == nil {
-					exploreNode(ls, childLink, depth+1)
-				}
-			}
+// exploreNode walks the DAG below link and prints every block,
+// indented by its depth.
+func exploreNode(ls linking.LinkSystem, link ipld.Link) error {
+	w := &Walker{LinkSystem: ls}
+	return w.Walk(context.Background(), link, func(b Block) error {
+		if b.Err != nil {
+			fmt.Printf("%*s%s: %v\n", b.Depth*2, "", b.Link, b.Err)
+			return nil
 		}
-	}
+		printNode(b.Node, b.Depth)
+		return nil
+	})
 }
 
 // printNode displays node content with proper indentation
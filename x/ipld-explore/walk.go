@@ -0,0 +1,278 @@
+package ipldexplore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+)
+
+// Order is the order in which a Walker visits blocks.
+type Order int
+
+const (
+	// DepthFirst visits a block, then everything below its first
+	// link, then everything below its second link, and so on.
+	DepthFirst Order = iota
+	// BreadthFirst visits all blocks one link below the root, then
+	// all blocks two links below, and so on.
+	BreadthFirst
+)
+
+// SkipLinks can be returned by a VisitFunc to keep the walk from
+// following the links in the block just visited.
+var SkipLinks = errors.New("skip links")
+
+// Block is a block reached by a walk.
+type Block struct {
+	Link datamodel.Link
+	// Node is the decoded block, or nil if it could not be loaded.
+	Node datamodel.Node
+	// Err is why the block could not be loaded.
+	Err error
+	// Depth is the number of links between the root and the block.
+	Depth int
+	// Parent is the block holding the link to this one; nil for the
+	// root.
+	Parent datamodel.Link
+	// Path is the path to the block from the root of the walk, and
+	// Local the path of the link within the Parent block.
+	Path  datamodel.Path
+	Local datamodel.Path
+}
+
+// VisitFunc is called for each block a walk reaches.  A block that
+// could not be loaded has Err set; returning nil skips it and carries
+// on.  Returning SkipLinks skips the links in the block; returning
+// any other error stops the walk with that error.
+type VisitFunc func(b Block) error
+
+// Walker walks the DAG below a root, following every link in every
+// block it loads, whatever the block's shape.  Each block is visited
+// once, however many links reach it.
+type Walker struct {
+	LinkSystem linking.LinkSystem
+	Order      Order
+	// MaxDepth, if positive, is the depth below which links are not
+	// followed.
+	MaxDepth int
+	// Selector, if set, limits the walk to the blocks an IPLD selector
+	// reaches.  Selector walks are depth first.
+	Selector datamodel.Node
+	// Visited holds the binary form of every link visited.  It is
+	// created by the first walk if nil; set it to share one set
+	// between walks, or to skip blocks already handled.
+	Visited map[string]bool
+}
+
+// Walk visits the blocks below root, starting with root itself.
+func (w *Walker) Walk(ctx context.Context, root datamodel.Link, fn VisitFunc) error {
+	if w.Visited == nil {
+		w.Visited = make(map[string]bool)
+	}
+	if w.Selector != nil {
+		if w.Order != DepthFirst {
+			return fmt.Errorf("selector walks are depth first")
+		}
+		return w.walkSelector(ctx, root, fn)
+	}
+
+	pending := []Block{{Link: root}}
+	for len(pending) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var b Block
+		if w.Order == BreadthFirst {
+			b, pending = pending[0], pending[1:]
+		} else {
+			b, pending = pending[len(pending)-1], pending[:len(pending)-1]
+		}
+		key := b.Link.Binary()
+		if w.Visited[key] {
+			continue
+		}
+		w.Visited[key] = true
+
+		b.Node, b.Err = w.LinkSystem.Load(linking.LinkContext{Ctx: ctx, LinkPath: b.Path}, b.Link, basicnode.Prototype.Any)
+		err := fn(b)
+		if errors.Is(err, SkipLinks) || b.Err != nil && err == nil {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if w.MaxDepth > 0 && b.Depth >= w.MaxDepth {
+			continue
+		}
+
+		var children []Block
+		err = EachLink(b.Node, func(local datamodel.Path, lnk datamodel.Link) error {
+			children = append(children, Block{
+				Link:   lnk,
+				Depth:  b.Depth + 1,
+				Parent: b.Link,
+				Path:   b.Path.Join(local),
+				Local:  local,
+			})
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("block %s: %w", b.Link, err)
+		}
+		if w.Order == BreadthFirst {
+			pending = append(pending, children...)
+			continue
+		}
+		// Push in reverse so the first link is visited first.
+		for i := len(children) - 1; i >= 0; i-- {
+			pending = append(pending, children[i])
+		}
+	}
+	return nil
+}
+
+// walkSelector walks the blocks sel reaches with a go-ipld-prime
+// traversal.  The traversal's reads go through a wrapper that skips
+// visited blocks and blocks past MaxDepth, and reports failed loads to
+// fn; blocks are reported when the traversal reaches their root node.
+func (w *Walker) walkSelector(ctx context.Context, root datamodel.Link, fn VisitFunc) error {
+	sel, err := selector.CompileSelector(w.Selector)
+	if err != nil {
+		return err
+	}
+
+	// roots maps the path of each block reached to the block, so that
+	// a block's parent is the one at the longest prefix of its path.
+	roots := map[string]Block{"": {Link: root}}
+	parentOf := func(path datamodel.Path) Block {
+		for p := path.Pop(); ; p = p.Pop() {
+			if b, ok := roots[p.String()]; ok {
+				return b
+			}
+			if p.Len() == 0 {
+				return roots[""]
+			}
+		}
+	}
+	blockAt := func(path datamodel.Path, lnk datamodel.Link) Block {
+		parent := parentOf(path)
+		return Block{
+			Link:   lnk,
+			Depth:  parent.Depth + 1,
+			Parent: parent.Link,
+			Path:   path,
+			Local:  datamodel.NewPath(path.Segments()[parent.Path.Len():]),
+		}
+	}
+
+	ls := w.LinkSystem
+	open := ls.StorageReadOpener
+	ls.StorageReadOpener = func(lctx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+		if lctx.LinkPath.Len() == 0 {
+			return open(lctx, lnk)
+		}
+		b := blockAt(lctx.LinkPath, lnk)
+		if w.Visited[lnk.Binary()] || w.MaxDepth > 0 && b.Depth > w.MaxDepth {
+			return nil, traversal.SkipMe{}
+		}
+		r, err := open(lctx, lnk)
+		if err != nil {
+			w.Visited[lnk.Binary()] = true
+			b.Err = err
+			err = fn(b)
+			if err == nil || errors.Is(err, SkipLinks) {
+				return nil, traversal.SkipMe{}
+			}
+			return nil, err
+		}
+		return r, nil
+	}
+
+	w.Visited[root.Binary()] = true
+	node, err := ls.Load(linking.LinkContext{Ctx: ctx}, root, basicnode.Prototype.Any)
+	rootBlock := Block{Link: root, Node: node, Err: err}
+	err = fn(rootBlock)
+	if errors.Is(err, SkipLinks) || rootBlock.Err != nil && err == nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	prog := traversal.Progress{Cfg: &traversal.Config{
+		Ctx:                            ctx,
+		LinkSystem:                     ls,
+		LinkTargetNodePrototypeChooser: basicnode.Chooser,
+	}}
+	return prog.WalkAdv(node, sel, func(p traversal.Progress, n datamodel.Node, _ traversal.VisitReason) error {
+		if p.LastBlock.Link == nil || p.LastBlock.Path.String() != p.Path.String() {
+			return nil
+		}
+		if _, ok := roots[p.Path.String()]; ok {
+			return nil
+		}
+		b := blockAt(p.Path, p.LastBlock.Link)
+		b.Node = n
+		roots[p.Path.String()] = b
+		w.Visited[b.Link.Binary()] = true
+		err := fn(b)
+		if errors.Is(err, SkipLinks) {
+			return traversal.SkipMe{}
+		}
+		return err
+	})
+}
+
+// EachLink calls fn for every link in n, depth first, with the path of
+// the link within n.  An error from fn stops the enumeration and is
+// returned.  It is the link enumeration Walker uses, for callers that
+// walk blocks their own way.
+func EachLink(n datamodel.Node, fn func(datamodel.Path, datamodel.Link) error) error {
+	return eachLink(n, datamodel.Path{}, fn)
+}
+
+// eachLink is EachLink for a node at path.
+func eachLink(n datamodel.Node, path datamodel.Path, fn func(datamodel.Path, datamodel.Link) error) error {
+	switch n.Kind() {
+	case datamodel.Kind_Link:
+		lnk, err := n.AsLink()
+		if err != nil {
+			return err
+		}
+		return fn(path, lnk)
+	case datamodel.Kind_Map:
+		for it := n.MapIterator(); !it.Done(); {
+			k, v, err := it.Next()
+			if err != nil {
+				return err
+			}
+			ks, err := k.AsString()
+			if err != nil {
+				return err
+			}
+			err = eachLink(v, path.AppendSegmentString(ks), fn)
+			if err != nil {
+				return err
+			}
+		}
+	case datamodel.Kind_List:
+		for it := n.ListIterator(); !it.Done(); {
+			i, v, err := it.Next()
+			if err != nil {
+				return err
+			}
+			err = eachLink(v, path.AppendSegmentInt(i), fn)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
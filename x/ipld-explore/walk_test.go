@@ -0,0 +1,204 @@
+package ipldexplore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/linking"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	ipldstore "github.com/stevegt/grid-poc/x/ipld-store"
+)
+
+// testDAG builds root -> {a, b}, a -> c, b -> c.
+func testDAG(t *testing.T) (linking.LinkSystem, map[string]ipld.Link) {
+	t.Helper()
+	ls := ipldstore.NewLinkSystem(ipldstore.NewMemStore())
+	links := make(map[string]ipld.Link)
+	links["c"] = createNode(&ls, "c", nil)
+	links["a"] = createNode(&ls, "a", []ipld.Link{links["c"]})
+	links["b"] = createNode(&ls, "b", []ipld.Link{links["c"]})
+	links["root"] = createNode(&ls, "root", []ipld.Link{links["a"], links["b"]})
+	return ls, links
+}
+
+// names walks with w and returns the Name of each block visited.
+func names(t *testing.T, w *Walker, root ipld.Link) []string {
+	t.Helper()
+	var got []string
+	err := w.Walk(context.Background(), root, func(b Block) error {
+		if b.Err != nil {
+			got = append(got, "missing")
+			return nil
+		}
+		n, err := b.Node.LookupByString("Name")
+		if err != nil {
+			return err
+		}
+		s, err := n.AsString()
+		if err != nil {
+			return err
+		}
+		got = append(got, s)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestWalkOrder(t *testing.T) {
+	ls, links := testDAG(t)
+	tests := []struct {
+		name string
+		w    Walker
+		want []string
+	}{
+		{"depth first", Walker{LinkSystem: ls}, []string{"root", "a", "c", "b"}},
+		{"breadth first", Walker{LinkSystem: ls, Order: BreadthFirst}, []string{"root", "a", "b", "c"}},
+		{"max depth", Walker{LinkSystem: ls, MaxDepth: 1}, []string{"root", "a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := names(t, &tt.w, links["root"])
+			if !equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWalkBlock(t *testing.T) {
+	ls, links := testDAG(t)
+	w := &Walker{LinkSystem: ls}
+	var blocks []Block
+	err := w.Walk(context.Background(), links["root"], func(b Block) error {
+		blocks = append(blocks, b)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := blocks[2]
+	if c.Link != links["c"] || c.Parent != links["a"] || c.Depth != 2 {
+		t.Errorf("got %+v", c)
+	}
+	if c.Path.String() != "Children/0/Children/0" || c.Local.String() != "Children/0" {
+		t.Errorf("got path %q, local %q", c.Path, c.Local)
+	}
+	if len(w.Visited) != 4 {
+		t.Errorf("visited %d blocks, want 4", len(w.Visited))
+	}
+}
+
+func TestWalkSkipLinks(t *testing.T) {
+	ls, links := testDAG(t)
+	w := &Walker{LinkSystem: ls}
+	var got []ipld.Link
+	err := w.Walk(context.Background(), links["root"], func(b Block) error {
+		got = append(got, b.Link)
+		if b.Link == links["a"] {
+			return SkipLinks
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ipld.Link{links["root"], links["a"], links["b"], links["c"]}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("block %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestWalkStop(t *testing.T) {
+	ls, links := testDAG(t)
+	stop := errors.New("stop")
+	w := &Walker{LinkSystem: ls}
+	n := 0
+	err := w.Walk(context.Background(), links["root"], func(b Block) error {
+		n++
+		return stop
+	})
+	if !errors.Is(err, stop) || n != 1 {
+		t.Errorf("got %v after %d blocks", err, n)
+	}
+}
+
+func TestWalkMissing(t *testing.T) {
+	ls, links := testDAG(t)
+	other := ipldstore.NewLinkSystem(ipldstore.NewMemStore())
+	lost := createNode(&other, "lost", nil)
+	root := createNode(&ls, "top", []ipld.Link{lost, links["c"]})
+
+	for _, w := range []*Walker{
+		{LinkSystem: ls},
+		{LinkSystem: ls, Selector: selectorparse.CommonSelector_ExploreAllRecursively},
+	} {
+		got := names(t, w, root)
+		want := []string{"top", "missing", "c"}
+		if !equal(got, want) {
+			t.Errorf("selector %v: got %v, want %v", w.Selector != nil, got, want)
+		}
+	}
+}
+
+func TestWalkSelector(t *testing.T) {
+	ls, links := testDAG(t)
+
+	w := &Walker{LinkSystem: ls, Selector: selectorparse.CommonSelector_ExploreAllRecursively}
+	got := names(t, w, links["root"])
+	want := []string{"root", "a", "c", "b"}
+	if !equal(got, want) {
+		t.Errorf("all: got %v, want %v", got, want)
+	}
+
+	// Follow only the second child of the root and its first child.
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+	sel := ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+		efsb.Insert("Children", ssb.ExploreIndex(1,
+			ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+				efsb.Insert("Children", ssb.ExploreIndex(0, ssb.Matcher()))
+			})))
+	}).Node()
+	w = &Walker{LinkSystem: ls, Selector: sel}
+	got = names(t, w, links["root"])
+	want = []string{"root", "b", "c"}
+	if !equal(got, want) {
+		t.Errorf("path: got %v, want %v", got, want)
+	}
+
+	w = &Walker{LinkSystem: ls, Selector: selectorparse.CommonSelector_ExploreAllRecursively, MaxDepth: 1}
+	got = names(t, w, links["root"])
+	want = []string{"root", "a", "b"}
+	if !equal(got, want) {
+		t.Errorf("max depth: got %v, want %v", got, want)
+	}
+
+	w = &Walker{LinkSystem: ls, Selector: sel, Order: BreadthFirst}
+	err := w.Walk(context.Background(), links["root"], func(Block) error { return nil })
+	if err == nil {
+		t.Error("breadth-first selector walk succeeded")
+	}
+}
@@ -7,6 +7,9 @@ import (
 
 	"github.com/ipld/go-ipld-prime"
 	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
 	"github.com/ipld/go-ipld-prime/node/bindnode"
 	"github.com/ipld/go-ipld-prime/schema"
 )
@@ -14,6 +17,7 @@ import (
 // Schema definitions for demonstration purposes.
 // schemaV1 uses a tuple representation (compact order-dependent format).
 // schemaV2 uses a map representation (flexible key/value format with an additional optional field).
+// schemaV3 replaces the single optional email with a list of emails.
 const (
 	schemaV1 = `
 		type Person struct {
@@ -28,6 +32,13 @@ const (
 			email optional String
 		} representation map
 	`
+	schemaV3 = `
+		type Person struct {
+			name   String
+			age    Int
+			emails [String]
+		} representation map
+	`
 )
 
 // PersonV1 corresponds to the original tuple schema.
@@ -43,12 +54,24 @@ type PersonV2 struct {
 	Email *string `ipld:"email,omitempty"`
 }
 
+// PersonV3 corresponds to the map schema with a list of emails.
+type PersonV3 struct {
+	Name   string   `ipld:"name"`
+	Age    int64    `ipld:"age"`
+	Emails []string `ipld:"emails"`
+}
+
 // Main is provided as an example of how to perform a migration.
 // In an actual application, this might be replaced or integrated into a larger system.
 func Main() {
-	// Initialize the schema type systems for both versions.
-	tsV1 := initSchema(schemaV1, "Person")
-	tsV2 := initSchema(schemaV2, "Person")
+	reg, err := personRegistry()
+	if err != nil {
+		log.Fatal(err)
+	}
+	tsV1, err := initSchema(schemaV1, "Person")
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Create an instance of Person using the original v1 structure.
 	original := &PersonV1{
@@ -57,75 +80,123 @@ func Main() {
 	}
 
 	// Serialize the PersonV1 data using the tuple representation.
-	encodedData := serializePerson(original, tsV1)
+	encodedData, err := serializePerson(original, tsV1, "Person")
+	if err != nil {
+		log.Fatal(err)
+	}
 	fmt.Println("Encoded IPLD Data:", string(encodedData))
 
-	// Migrate the data from the original format (v1) to the new format (v2).
-	migrated := performMigration(encodedData, tsV1, tsV2)
+	// Migrate the data from the original format (v1) through v2 to v3.
+	migrated, err := performMigration(encodedData, reg)
+	if err != nil {
+		log.Fatal(err)
+	}
 	fmt.Printf("Migrated Person: %+v\n", migrated)
 }
 
+// personRegistry returns a Registry holding the three Person versions
+// and the migrations between them.
+func personRegistry() (*Registry, error) {
+	reg := NewRegistry()
+	_, err := reg.AddSchema("v1", schemaV1, "Person", (*PersonV1)(nil), nil)
+	if err != nil {
+		return nil, err
+	}
+	// v1 to v2: same fields; the new email is left out.
+	_, err = reg.AddSchema("v2", schemaV2, "Person", (*PersonV2)(nil), func(old datamodel.Node) (datamodel.Node, error) {
+		name, err := old.LookupByString("name")
+		if err != nil {
+			return nil, err
+		}
+		age, err := old.LookupByString("age")
+		if err != nil {
+			return nil, err
+		}
+		return qp.BuildMap(basicnode.Prototype.Map, 2, func(ma datamodel.MapAssembler) {
+			qp.MapEntry(ma, "name", qp.Node(name))
+			qp.MapEntry(ma, "age", qp.Node(age))
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	// v2 to v3: the email, if any, becomes the only entry in emails.
+	_, err = reg.AddSchema("v3", schemaV3, "Person", (*PersonV3)(nil), func(old datamodel.Node) (datamodel.Node, error) {
+		p, ok := bindnode.Unwrap(old).(*PersonV2)
+		if !ok {
+			return nil, fmt.Errorf("not a v2 Person")
+		}
+		return qp.BuildMap(basicnode.Prototype.Map, 3, func(ma datamodel.MapAssembler) {
+			qp.MapEntry(ma, "name", qp.String(p.Name))
+			qp.MapEntry(ma, "age", qp.Int(p.Age))
+			qp.MapEntry(ma, "emails", qp.List(-1, func(la datamodel.ListAssembler) {
+				if p.Email != nil {
+					qp.ListEntry(la, qp.String(*p.Email))
+				}
+			}))
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reg, nil
+}
+
 // initSchema loads and validates an IPLD schema from a schema string.
 // It ensures the required type exists.
-func initSchema(schemaText, typeName string) *schema.TypeSystem {
+func initSchema(schemaText, typeName string) (*schema.TypeSystem, error) {
 	ts, err := ipld.LoadSchemaBytes([]byte(schemaText))
 	if err != nil {
-		log.Fatalf("Failed to load schema: %v", err)
+		return nil, fmt.Errorf("failed to load schema: %w", err)
 	}
 	if ts.TypeByName(typeName) == nil {
-		log.Fatalf("Schema missing required type %q", typeName)
+		return nil, fmt.Errorf("schema missing required type %q", typeName)
 	}
-	return ts
+	return ts, nil
 }
 
-// serializePerson encodes a Person using the provided schema.
-// This uses the original v1 tuple representation.
-func serializePerson(data interface{}, ts *schema.TypeSystem) []byte {
-	nodeType := ts.TypeByName("Person")
+// serializePerson encodes data as typeName using the provided schema's
+// representation, e.g. the v1 tuple representation.
+func serializePerson(data interface{}, ts *schema.TypeSystem, typeName string) ([]byte, error) {
+	nodeType := ts.TypeByName(typeName)
 	if nodeType == nil {
-		log.Fatalf("Schema missing type Person")
+		return nil, fmt.Errorf("schema missing type %q", typeName)
 	}
 
-	// proto := bindnode.Prototype(data, nodeType)
 	node := bindnode.Wrap(data, nodeType)
 	reprNode := node.Representation()
 
 	var buf bytes.Buffer
 	if err := dagjson.Encode(reprNode, &buf); err != nil {
-		log.Fatalf("Failed to encode person: %v", err)
+		return nil, fmt.Errorf("failed to encode %s: %w", typeName, err)
 	}
-	return buf.Bytes()
+	return buf.Bytes(), nil
 }
 
-// performMigration tries to decode encoded data using the new schema (v2).
-// If decoding with v2 fails, it falls back to decoding with the v1 schema and then
-// converting the data to the v2 structure.
-func performMigration(encoded []byte, tsOld, tsNew *schema.TypeSystem) *PersonV2 {
-	// Attempt decoding directly using the new schema (map representation).
-	if pNew, ok := tryParse[PersonV2](encoded, tsNew, true); ok {
-		return pNew
+// performMigration decodes DAG-JSON data of any Person version in reg
+// and migrates it to the latest version.
+func performMigration(encoded []byte, reg *Registry) (*PersonV3, error) {
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := dagjson.Decode(nb, bytes.NewReader(encoded)); err != nil {
+		return nil, err
 	}
-
-	// Fallback: decode using the old schema (tuple representation).
-	pOld, ok := tryParse[PersonV1](encoded, tsOld, true)
-	if !ok {
-		log.Fatalf("Data incompatible with both schema versions. Raw data: %s", string(encoded))
+	from, node, err := reg.Migrate(nb.Build())
+	if err != nil {
+		return nil, fmt.Errorf("cannot migrate %s: %w", encoded, err)
 	}
-
-	// Transform from v1 to v2; new fields get default values.
-	return &PersonV2{
-		Name:  pOld.Name,
-		Age:   pOld.Age,
-		Email: nil,
+	p, ok := bindnode.Unwrap(node).(*PersonV3)
+	if !ok {
+		return nil, fmt.Errorf("migrated %s data is not a v3 Person", from.Name)
 	}
+	return p, nil
 }
 
-// tryParse is a generic helper that attempts to decode IPLD-encoded data into the desired Go type.
+// tryParse is a generic helper that attempts to decode IPLD-encoded data as typeName into the desired Go type.
 // The useRepr flag controls whether decoding should use the schema's representation (e.g. tuple or map).
-func tryParse[T any](encoded []byte, ts *schema.TypeSystem, useRepr bool) (*T, bool) {
-	nodeType := ts.TypeByName("Person")
+func tryParse[T any](encoded []byte, ts *schema.TypeSystem, typeName string, useRepr bool) (*T, bool) {
+	nodeType := ts.TypeByName(typeName)
 	if nodeType == nil {
-		log.Println("Schema missing type Person")
+		log.Printf("Schema missing type %q", typeName)
 		return nil, false
 	}
 	proto := bindnode.Prototype((*T)(nil), nodeType)
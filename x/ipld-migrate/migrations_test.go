@@ -2,6 +2,7 @@ package migrations
 
 import (
 	"bytes"
+	"errors"
 	"strings"
 	"testing"
 
@@ -12,24 +13,33 @@ import (
 // TestInitSchema tests that initSchema correctly loads a valid schema and that the required type is present.
 func TestInitSchema(t *testing.T) {
 	// Use schemaV1 from migrations.go
-	ts := initSchema(schemaV1, "Person")
-	if ts == nil {
+	ts, err := initSchema(schemaV1, "Person")
+	if err != nil || ts == nil {
 		t.Fatal("initSchema returned nil for a valid schema")
 	}
 	if ts.TypeByName("Person") == nil {
 		t.Fatal("Schema does not contain type 'Person'")
 	}
+	if _, err := initSchema(schemaV1, "Robot"); err == nil {
+		t.Fatal("initSchema accepted a schema without the required type")
+	}
 }
 
 // TestSerializePerson tests that serializePerson returns a non-empty encoded output.
 func TestSerializePerson(t *testing.T) {
-	ts := initSchema(schemaV1, "Person")
+	ts, err := initSchema(schemaV1, "Person")
+	if err != nil {
+		t.Fatal(err)
+	}
 	original := &PersonV1{
 		Name: "Bob",
 		Age:  25,
 	}
 
-	encoded := serializePerson(original, ts)
+	encoded, err := serializePerson(original, ts, "Person")
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(encoded) == 0 {
 		t.Fatal("serializePerson returned an empty result")
 	}
@@ -43,23 +53,31 @@ func TestSerializePerson(t *testing.T) {
 	}
 }
 
-// TestPerformMigration tests that performMigration correctly transforms a PersonV1 into a PersonV2.
+// TestPerformMigration tests that performMigration correctly transforms a PersonV1 through v2 into a PersonV3.
 func TestPerformMigration(t *testing.T) {
-	// Prepare both schema type systems.
-	tsV1 := initSchema(schemaV1, "Person")
-	tsV2 := initSchema(schemaV2, "Person")
+	reg, err := personRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tsV1, err := initSchema(schemaV1, "Person")
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	// Create a PersonV1 instance and serialize using schemaV1.
 	original := &PersonV1{
 		Name: "Charlie",
 		Age:  40,
 	}
-	encoded := serializePerson(original, tsV1)
+	encoded, err := serializePerson(original, tsV1, "Person")
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	// Perform migration.
-	migrated := performMigration(encoded, tsV1, tsV2)
-	if migrated == nil {
-		t.Fatal("performMigration returned nil")
+	migrated, err := performMigration(encoded, reg)
+	if err != nil {
+		t.Fatal(err)
 	}
 
 	// Validate that the migrated data matches the original for shared fields.
@@ -69,16 +87,24 @@ func TestPerformMigration(t *testing.T) {
 	if migrated.Age != original.Age {
 		t.Errorf("Expected age %d but got %d", original.Age, migrated.Age)
 	}
-	// Email is new in v2 and should be nil by default.
-	if migrated.Email != nil {
-		t.Errorf("Expected email to be nil but got %v", migrated.Email)
+	// v1 had no email, so v3 has none.
+	if len(migrated.Emails) != 0 {
+		t.Errorf("Expected no emails but got %v", migrated.Emails)
+	}
+
+	// Data matching no version is an error, not a fatal exit.
+	if _, err := performMigration([]byte(`{"name": 1}`), reg); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("Expected ErrUnknownVersion but got %v", err)
 	}
 }
 
 // TestTryParseDirectV2 tests that tryParse succeeds when encoding directly with the v2 schema representation.
 func TestTryParseDirectV2(t *testing.T) {
 	// Prepare the v2 schema.
-	ts := initSchema(schemaV2, "Person")
+	ts, err := initSchema(schemaV2, "Person")
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	// Create a PersonV2 instance with all fields.
 	email := "dave@example.com"
@@ -100,7 +126,7 @@ func TestTryParseDirectV2(t *testing.T) {
 	encoded := buf.Bytes()
 
 	// Try decoding directly as PersonV2.
-	res, ok := tryParse[PersonV2](encoded, ts, true)
+	res, ok := tryParse[PersonV2](encoded, ts, "Person", true)
 	if !ok || res == nil {
 		t.Fatal("tryParse failed for a valid PersonV2 encoding")
 	}
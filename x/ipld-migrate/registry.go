@@ -0,0 +1,366 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/node/bindnode"
+	"github.com/ipld/go-ipld-prime/schema"
+	ipldexplore "github.com/stevegt/grid-poc/x/ipld-explore"
+)
+
+// ErrUnknownVersion means data matches none of a Registry's versions.
+var ErrUnknownVersion = errors.New("data matches no registered schema version")
+
+// Version is one version of a schema: the type its data is stored as,
+// and the bindnode prototype used to read and build it.
+type Version struct {
+	Name      string
+	Type      schema.Type
+	Prototype schema.TypedPrototype
+}
+
+// Migration converts data of one version to the next.  It is given the
+// type-level node of the older version and returns data the newer
+// version's type accepts; any node with the right fields will do.
+type Migration func(old datamodel.Node) (datamodel.Node, error)
+
+// Registry holds the versions of a schema, oldest first, and the
+// migrations between neighbours, so that data of any version can be
+// brought up to the latest one through the chain v1→v2→…→vN.
+type Registry struct {
+	versions []*Version
+	// up[i] migrates versions[i-1] to versions[i]; up[0] is unused.
+	up []Migration
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Add registers the next version, whose data is typeName in ts.
+// ptrType is a pointer to the Go type to bind to, as for
+// bindnode.Prototype, or nil to have one inferred.  up migrates data
+// from the previous version; it must be nil for the first version and
+// non-nil after that.
+func (r *Registry) Add(name string, ts *schema.TypeSystem, typeName string, ptrType interface{}, up Migration) (v *Version, err error) {
+	for _, old := range r.versions {
+		if old.Name == name {
+			return nil, fmt.Errorf("version %q already registered", name)
+		}
+	}
+	if (len(r.versions) == 0) != (up == nil) {
+		return nil, fmt.Errorf("version %q: only the first version has no migration", name)
+	}
+	typ := ts.TypeByName(typeName)
+	if typ == nil {
+		return nil, fmt.Errorf("version %q: schema missing type %q", name, typeName)
+	}
+	// bindnode panics on a Go type that does not fit the schema.
+	defer func() {
+		if p := recover(); p != nil {
+			v, err = nil, fmt.Errorf("version %q: %v", name, p)
+		}
+	}()
+	v = &Version{Name: name, Type: typ, Prototype: bindnode.Prototype(ptrType, typ)}
+	r.versions = append(r.versions, v)
+	r.up = append(r.up, up)
+	return v, nil
+}
+
+// AddSchema is Add given the schema as DSL text.
+func (r *Registry) AddSchema(name, schemaText, typeName string, ptrType interface{}, up Migration) (*Version, error) {
+	ts, err := initSchema(schemaText, typeName)
+	if err != nil {
+		return nil, fmt.Errorf("version %q: %w", name, err)
+	}
+	return r.Add(name, ts, typeName, ptrType, up)
+}
+
+// Versions returns the registered versions, oldest first.
+func (r *Registry) Versions() []*Version {
+	return r.versions
+}
+
+// Latest returns the newest version, or nil if there are none.
+func (r *Registry) Latest() *Version {
+	if len(r.versions) == 0 {
+		return nil
+	}
+	return r.versions[len(r.versions)-1]
+}
+
+// Detect returns the newest version whose representation accepts n,
+// and n as data of that version.  Newer versions are tried first, so
+// data that several versions accept is taken to be the newest.
+func (r *Registry) Detect(n datamodel.Node) (*Version, schema.TypedNode, error) {
+	for i := len(r.versions) - 1; i >= 0; i-- {
+		v := r.versions[i]
+		nb := v.Prototype.Representation().NewBuilder()
+		if err := nb.AssignNode(n); err != nil {
+			continue
+		}
+		return v, nb.Build().(schema.TypedNode), nil
+	}
+	return nil, nil, ErrUnknownVersion
+}
+
+// Migrate detects the version of n and migrates it to the latest
+// version.  It returns the version n was found to be and the migrated
+// data, which is n itself if n is already the latest.
+func (r *Registry) Migrate(n datamodel.Node) (*Version, schema.TypedNode, error) {
+	from, cur, err := r.Detect(n)
+	if err != nil {
+		return nil, nil, err
+	}
+	i := 0
+	for r.versions[i] != from {
+		i++
+	}
+	for i++; i < len(r.versions); i++ {
+		prev, next := r.versions[i-1], r.versions[i]
+		out, err := r.up[i](cur)
+		if err != nil {
+			return from, nil, fmt.Errorf("%s to %s: %w", prev.Name, next.Name, err)
+		}
+		nb := next.Prototype.NewBuilder()
+		if err := nb.AssignNode(out); err != nil {
+			return from, nil, fmt.Errorf("%s to %s: result does not fit %s: %w", prev.Name, next.Name, next.Name, err)
+		}
+		cur = nb.Build().(schema.TypedNode)
+	}
+	return from, cur, nil
+}
+
+// Report lists what MigrateDAG did with each block it reached.
+type Report struct {
+	// Root is the root of the migrated DAG, or the original root if
+	// nothing below it changed.
+	Root     datamodel.Link
+	Migrated []Migrated
+	Relinked []Relinked
+	Skipped  []Skipped
+	Failed   []Failed
+}
+
+// Migrated is a block that was migrated and stored anew.
+type Migrated struct {
+	Old, New datamodel.Link
+	// From is the version the old block was found to be.
+	From string
+}
+
+// Relinked is a block that needed no migration itself but was stored
+// anew because blocks it links to were.
+type Relinked struct {
+	Old, New datamodel.Link
+}
+
+// Skipped is a block that was left alone: either it is already the
+// latest version, or it matches no version at all, in which case
+// Version is empty.
+type Skipped struct {
+	Link    datamodel.Link
+	Version string
+}
+
+// Failed is a block that could not be loaded, migrated or stored.
+type Failed struct {
+	Link datamodel.Link
+	Err  error
+}
+
+func (rep *Report) String() string {
+	return fmt.Sprintf("%d migrated, %d relinked, %d skipped, %d failed", len(rep.Migrated), len(rep.Relinked), len(rep.Skipped), len(rep.Failed))
+}
+
+// MigrateDAG migrates every block reachable from root to the latest
+// version, storing each migrated block through ls with the same link
+// prototype as the original.  Data is never changed in place: the
+// links to a migrated block are rewritten in new copies of the blocks
+// above it, up to a new root, which is returned in Report.Root.
+// Blocks are migrated after the blocks they link to, so a migration
+// sees links to already migrated data.
+//
+// A failure on one block does not stop the others, though the blocks
+// below a block that cannot be loaded are not reached, and the blocks
+// above one that fails keep linking to the original.  The error is
+// only set if ctx is done.
+func (r *Registry) MigrateDAG(ctx context.Context, ls linking.LinkSystem, root datamodel.Link) (*Report, error) {
+	m := &dagMigration{
+		reg:  r,
+		ctx:  ctx,
+		ls:   ls,
+		rep:  &Report{},
+		done: make(map[string]datamodel.Link),
+	}
+	newRoot, err := m.migrate(root)
+	m.rep.Root = newRoot
+	return m.rep, err
+}
+
+// dagMigration is the state of one MigrateDAG call.
+type dagMigration struct {
+	reg *Registry
+	ctx context.Context
+	ls  linking.LinkSystem
+	rep *Report
+	// done maps the binary form of each link handled to the link
+	// that replaces it, which is itself if nothing changed.
+	done map[string]datamodel.Link
+}
+
+// migrate migrates the blocks below lnk, then the block at lnk, and
+// returns the link that replaces lnk.
+func (m *dagMigration) migrate(lnk datamodel.Link) (datamodel.Link, error) {
+	if err := m.ctx.Err(); err != nil {
+		return lnk, err
+	}
+	if newLnk, ok := m.done[lnk.Binary()]; ok {
+		return newLnk, nil
+	}
+	newLnk, err := m.migrateBlock(lnk)
+	if err != nil {
+		return lnk, err
+	}
+	m.done[lnk.Binary()] = newLnk
+	return newLnk, nil
+}
+
+// migrateBlock does the work of migrate for a block not yet handled.
+func (m *dagMigration) migrateBlock(lnk datamodel.Link) (datamodel.Link, error) {
+	rep := m.rep
+	lctx := linking.LinkContext{Ctx: m.ctx}
+	n, err := m.ls.Load(lctx, lnk, basicnode.Prototype.Any)
+	if err != nil {
+		rep.Failed = append(rep.Failed, Failed{Link: lnk, Err: err})
+		return lnk, nil
+	}
+	var children []datamodel.Link
+	err = ipldexplore.EachLink(n, func(_ datamodel.Path, child datamodel.Link) error {
+		children = append(children, child)
+		return nil
+	})
+	if err != nil {
+		rep.Failed = append(rep.Failed, Failed{Link: lnk, Err: err})
+		return lnk, nil
+	}
+	replace := make(map[string]datamodel.Link)
+	for _, child := range children {
+		newChild, err := m.migrate(child)
+		if err != nil {
+			return lnk, err
+		}
+		if newChild.Binary() != child.Binary() {
+			replace[child.Binary()] = newChild
+		}
+	}
+	if len(replace) > 0 {
+		n, err = relink(n, replace)
+		if err != nil {
+			rep.Failed = append(rep.Failed, Failed{Link: lnk, Err: err})
+			return lnk, nil
+		}
+	}
+
+	from, out, err := m.reg.Migrate(n)
+	var version string
+	switch {
+	case errors.Is(err, ErrUnknownVersion):
+	case err != nil:
+		rep.Failed = append(rep.Failed, Failed{Link: lnk, Err: err})
+		return lnk, nil
+	case from == m.reg.Latest():
+		version = from.Name
+	default:
+		newLnk, err := m.ls.Store(lctx, lnk.Prototype(), out.Representation())
+		if err != nil {
+			rep.Failed = append(rep.Failed, Failed{Link: lnk, Err: err})
+			return lnk, nil
+		}
+		rep.Migrated = append(rep.Migrated, Migrated{Old: lnk, New: newLnk, From: from.Name})
+		return newLnk, nil
+	}
+	if len(replace) == 0 {
+		rep.Skipped = append(rep.Skipped, Skipped{Link: lnk, Version: version})
+		return lnk, nil
+	}
+	newLnk, err := m.ls.Store(lctx, lnk.Prototype(), n)
+	if err != nil {
+		rep.Failed = append(rep.Failed, Failed{Link: lnk, Err: err})
+		return lnk, nil
+	}
+	rep.Relinked = append(rep.Relinked, Relinked{Old: lnk, New: newLnk})
+	return newLnk, nil
+}
+
+// relink returns a copy of n with every link that is a key of replace
+// swapped for its value.
+func relink(n datamodel.Node, replace map[string]datamodel.Link) (datamodel.Node, error) {
+	switch n.Kind() {
+	case datamodel.Kind_Link:
+		lnk, err := n.AsLink()
+		if err != nil {
+			return nil, err
+		}
+		if newLnk, ok := replace[lnk.Binary()]; ok {
+			return basicnode.NewLink(newLnk), nil
+		}
+		return n, nil
+	case datamodel.Kind_Map:
+		nb := basicnode.Prototype.Map.NewBuilder()
+		ma, err := nb.BeginMap(n.Length())
+		if err != nil {
+			return nil, err
+		}
+		for it := n.MapIterator(); !it.Done(); {
+			k, v, err := it.Next()
+			if err != nil {
+				return nil, err
+			}
+			v, err = relink(v, replace)
+			if err != nil {
+				return nil, err
+			}
+			if err := ma.AssembleKey().AssignNode(k); err != nil {
+				return nil, err
+			}
+			if err := ma.AssembleValue().AssignNode(v); err != nil {
+				return nil, err
+			}
+		}
+		if err := ma.Finish(); err != nil {
+			return nil, err
+		}
+		return nb.Build(), nil
+	case datamodel.Kind_List:
+		nb := basicnode.Prototype.List.NewBuilder()
+		la, err := nb.BeginList(n.Length())
+		if err != nil {
+			return nil, err
+		}
+		for it := n.ListIterator(); !it.Done(); {
+			_, v, err := it.Next()
+			if err != nil {
+				return nil, err
+			}
+			v, err = relink(v, replace)
+			if err != nil {
+				return nil, err
+			}
+			if err := la.AssembleValue().AssignNode(v); err != nil {
+				return nil, err
+			}
+		}
+		if err := la.Finish(); err != nil {
+			return nil, err
+		}
+		return nb.Build(), nil
+	}
+	return n, nil
+}
@@ -0,0 +1,223 @@
+package migrations
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/node/bindnode"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+)
+
+var testLinkProto = cidlink.LinkPrototype{Prefix: cid.Prefix{
+	Version:  1,
+	Codec:    0x0129, // DAG-JSON multicodec
+	MhType:   0x12,   // sha2-256
+	MhLength: 32,
+}}
+
+func fromJSON(t *testing.T, js string) datamodel.Node {
+	t.Helper()
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := dagjson.Decode(nb, bytes.NewBufferString(js)); err != nil {
+		t.Fatal(err)
+	}
+	return nb.Build()
+}
+
+func TestRegistryDetect(t *testing.T) {
+	reg, err := personRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		json    string
+		version string
+	}{
+		{`["Alice", 30]`, "v1"},
+		{`{"name": "Alice", "age": 30}`, "v2"},
+		{`{"name": "Alice", "age": 30, "email": "a@example.com"}`, "v2"},
+		{`{"name": "Alice", "age": 30, "emails": []}`, "v3"},
+	}
+	for _, c := range cases {
+		v, _, err := reg.Detect(fromJSON(t, c.json))
+		if err != nil {
+			t.Errorf("%s: %v", c.json, err)
+			continue
+		}
+		if v.Name != c.version {
+			t.Errorf("%s: detected %s, want %s", c.json, v.Name, c.version)
+		}
+	}
+	if _, _, err := reg.Detect(fromJSON(t, `{"title": "x"}`)); err != ErrUnknownVersion {
+		t.Errorf("got %v, want ErrUnknownVersion", err)
+	}
+}
+
+func TestRegistryChain(t *testing.T) {
+	reg, err := personRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	from, out, err := reg.Migrate(fromJSON(t, `{"name": "Dave", "age": 28, "email": "dave@example.com"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from.Name != "v2" {
+		t.Errorf("migrated from %s, want v2", from.Name)
+	}
+	p := bindnode.Unwrap(out).(*PersonV3)
+	if p.Name != "Dave" || p.Age != 28 || len(p.Emails) != 1 || p.Emails[0] != "dave@example.com" {
+		t.Errorf("got %+v", p)
+	}
+	var buf bytes.Buffer
+	if err := dagjson.Encode(out.Representation(), &buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != `{"age":28,"emails":["dave@example.com"],"name":"Dave"}` {
+		t.Errorf("encoded %s", got)
+	}
+}
+
+func TestRegistryAddErrors(t *testing.T) {
+	reg := NewRegistry()
+	noop := func(old datamodel.Node) (datamodel.Node, error) { return old, nil }
+	if _, err := reg.AddSchema("v1", schemaV1, "Person", nil, noop); err == nil {
+		t.Error("first version accepted a migration")
+	}
+	if _, err := reg.AddSchema("v1", schemaV1, "Person", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reg.AddSchema("v1", schemaV2, "Person", nil, noop); err == nil {
+		t.Error("duplicate version accepted")
+	}
+	if _, err := reg.AddSchema("v2", schemaV2, "Person", nil, nil); err == nil {
+		t.Error("later version accepted without a migration")
+	}
+	if _, err := reg.AddSchema("v2", schemaV2, "Robot", nil, noop); err == nil {
+		t.Error("missing type accepted")
+	}
+	if _, err := reg.AddSchema("v2", schemaV2, "Person", (*PersonV1)(nil), noop); err == nil {
+		t.Error("mismatched Go type accepted")
+	}
+	if len(reg.Versions()) != 1 || reg.Latest().Name != "v1" {
+		t.Errorf("failed adds changed the registry: %d versions", len(reg.Versions()))
+	}
+}
+
+func TestMigrateDAG(t *testing.T) {
+	reg, err := personRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &memstore.Store{}
+	ls := cidlink.DefaultLinkSystem()
+	ls.SetReadStorage(store)
+	ls.SetWriteStorage(store)
+	put := func(js string) datamodel.Link {
+		lnk, err := ls.Store(linking.LinkContext{}, testLinkProto, fromJSON(t, js))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lnk
+	}
+	gone, err := testLinkProto.Prefix.Sum([]byte("never stored"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := put(`["Alice", 30]`)
+	bob := put(`{"name": "Bob", "age": 41, "email": "bob@example.com"}`)
+	carol := put(`{"name": "Carol", "age": 52, "emails": []}`)
+	root := put(`{"people": [{"/": "` + alice.String() + `"}, {"/": "` + bob.String() + `"}, {"/": "` + carol.String() + `"}, {"/": "` + alice.String() + `"}], "gone": {"/": "` + gone.String() + `"}}`)
+
+	rep, err := reg.MigrateDAG(context.Background(), ls, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.String() != "2 migrated, 1 relinked, 1 skipped, 1 failed" {
+		t.Fatalf("report: %s", rep)
+	}
+
+	want := map[datamodel.Link]string{
+		alice: `{"age":30,"emails":[],"name":"Alice"}`,
+		bob:   `{"age":41,"emails":["bob@example.com"],"name":"Bob"}`,
+	}
+	for _, m := range rep.Migrated {
+		n, err := ls.Load(linking.LinkContext{}, m.New, basicnode.Prototype.Any)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := dagjson.Encode(n, &buf); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != want[m.Old] {
+			t.Errorf("%s (%s) migrated to %s", m.Old, m.From, buf.String())
+		}
+	}
+
+	skipped := map[datamodel.Link]string{}
+	for _, s := range rep.Skipped {
+		skipped[s.Link] = s.Version
+	}
+	if v, ok := skipped[carol]; !ok || v != "v3" {
+		t.Errorf("carol skipped as %q, %v", v, ok)
+	}
+	if len(rep.Relinked) != 1 || rep.Relinked[0].Old != root || rep.Relinked[0].New != rep.Root {
+		t.Errorf("relinked: %+v, root %s", rep.Relinked, rep.Root)
+	}
+	if rep.Failed[0].Link != (cidlink.Link{Cid: gone}) || rep.Failed[0].Err == nil {
+		t.Errorf("failed: %+v", rep.Failed[0])
+	}
+
+	// The new root reaches the migrated people, and still links to
+	// the one that was already current and the one that is gone.
+	resolve := func(lnk datamodel.Link, path ...interface{}) datamodel.Node {
+		t.Helper()
+		n, err := ls.Load(linking.LinkContext{}, lnk, basicnode.Prototype.Any)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, seg := range path {
+			switch seg := seg.(type) {
+			case string:
+				n, err = n.LookupByString(seg)
+			case int:
+				n, err = n.LookupByIndex(int64(seg))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return n
+	}
+	link := func(n datamodel.Node) datamodel.Link {
+		t.Helper()
+		lnk, err := n.AsLink()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lnk
+	}
+	for i, want := range []string{"Alice", "Bob", "Carol", "Alice"} {
+		person := link(resolve(rep.Root, "people", i))
+		emails := resolve(person, "emails")
+		name, _ := resolve(person, "name").AsString()
+		if name != want || emails.Kind() != datamodel.Kind_List {
+			t.Errorf("people/%d is %s, not migrated %s", i, person, want)
+		}
+	}
+	if link(resolve(rep.Root, "people", 2)) != carol {
+		t.Errorf("carol was relinked")
+	}
+	if link(resolve(rep.Root, "gone")) != (cidlink.Link{Cid: gone}) {
+		t.Errorf("gone was relinked")
+	}
+}
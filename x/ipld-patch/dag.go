@@ -0,0 +1,289 @@
+package ipldpatch
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/ipld/go-ipld-prime/traversal/patch"
+	ipldexplore "github.com/stevegt/grid-poc/x/ipld-explore"
+	ipldpath "github.com/stevegt/grid-poc/x/ipld-path"
+)
+
+// DAGPatcher applies IPLD Patch operations to a DAG that spans many
+// blocks.  Paths are resolved across links, so "a/b/c" may go through
+// a link at "a" into another block.  Blocks are never changed in
+// place: each block an operation touches is rewritten, and the new
+// CIDs are carried up through every parent to a new root, leaving the
+// old DAG intact.  This gives copy-on-write edits to world-line state.
+type DAGPatcher struct {
+	LinkSystem linking.LinkSystem
+}
+
+// DAGResult is the outcome of a patch.
+type DAGResult struct {
+	// Root is the root of the patched DAG.
+	Root datamodel.Link
+	// New are the blocks written that the new root reaches, in the
+	// order they were written.
+	New []datamodel.Link
+	// Obsolete are the blocks that were replaced and that the new root
+	// no longer reaches: blocks of the old DAG, and blocks written for
+	// one operation and replaced by a later one.
+	Obsolete []datamodel.Link
+}
+
+// Apply applies ops in order to the DAG at root.  "move", "copy" and
+// "test" may refer to paths in different blocks.  On error nothing is
+// returned, though blocks written before the failing operation stay
+// in storage.
+func (p *DAGPatcher) Apply(ctx context.Context, root datamodel.Link, ops []patch.Operation) (*DAGResult, error) {
+	var written, replaced []datamodel.Link
+	cur := root
+	for i, op := range ops {
+		steps, err := p.expand(ctx, cur, op)
+		if err != nil {
+			return nil, fmt.Errorf("op %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+		for _, step := range steps {
+			cur, err = p.applyAt(ctx, cur, step.Path.Segments(), step, &written, &replaced)
+			if err != nil {
+				return nil, fmt.Errorf("op %d (%s %s): %w", i, op.Op, op.Path, err)
+			}
+		}
+	}
+
+	reachable, err := p.reachable(ctx, cur)
+	if err != nil {
+		return nil, err
+	}
+	res := &DAGResult{Root: cur}
+	for _, l := range written {
+		if reachable[l.Binary()] {
+			res.New = append(res.New, l)
+		}
+	}
+	seen := make(map[string]bool)
+	for _, l := range replaced {
+		if !reachable[l.Binary()] && !seen[l.Binary()] {
+			seen[l.Binary()] = true
+			res.Obsolete = append(res.Obsolete, l)
+		}
+	}
+	return res, nil
+}
+
+// expand turns an operation into the add, remove and replace steps
+// that applyAt understands.  Moves and copies read their source
+// across links first; tests are checked here and produce no steps.
+func (p *DAGPatcher) expand(ctx context.Context, root datamodel.Link, op patch.Operation) ([]patch.Operation, error) {
+	switch op.Op {
+	case patch.Op_Add, patch.Op_Remove, patch.Op_Replace:
+		return []patch.Operation{op}, nil
+	case patch.Op_Copy, patch.Op_Move:
+		v, err := p.get(ctx, root, op.From)
+		if err != nil {
+			return nil, err
+		}
+		add := patch.Operation{Op: patch.Op_Add, Path: op.Path, Value: v}
+		if op.Op == patch.Op_Copy {
+			return []patch.Operation{add}, nil
+		}
+		return []patch.Operation{{Op: patch.Op_Remove, Path: op.From}, add}, nil
+	case patch.Op_Test:
+		v, err := p.get(ctx, root, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		if !ipld.DeepEqual(v, op.Value) {
+			return nil, fmt.Errorf("test failed")
+		}
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown operation %q", op.Op)
+	}
+}
+
+// get returns the value at path without loading it if it is a link,
+// so that a copied link stays a link.
+func (p *DAGPatcher) get(ctx context.Context, root datamodel.Link, path datamodel.Path) (datamodel.Node, error) {
+	if path.Len() == 0 {
+		return basicnode.NewLink(root), nil
+	}
+	r := &ipldpath.Resolver{LinkSystem: p.LinkSystem}
+	res, err := r.Resolve(ctx, root, path.Pop())
+	if err != nil {
+		return nil, err
+	}
+	seg := path.Last()
+	switch res.Node.Kind() {
+	case datamodel.Kind_Map:
+		return res.Node.LookupBySegment(seg)
+	case datamodel.Kind_List:
+		idx, err := seg.Index()
+		if err != nil {
+			return nil, err
+		}
+		return res.Node.LookupByIndex(idx)
+	default:
+		return nil, &ipldpath.WrongKindError{Reached: path.Pop(), Segment: seg, Kind: res.Node.Kind()}
+	}
+}
+
+// applyAt applies op, whose path segs is relative to the block at
+// lnk, and returns the link to the rewritten block.  If the path
+// passes through a link inside the block, the rest of the path is
+// applied to the linked block first and the link is then replaced.
+func (p *DAGPatcher) applyAt(ctx context.Context, lnk datamodel.Link, segs []datamodel.PathSegment, op patch.Operation, written, replaced *[]datamodel.Link) (datamodel.Link, error) {
+	lctx := linking.LinkContext{Ctx: ctx}
+	node, err := p.LinkSystem.Load(lctx, lnk, basicnode.Prototype.Any)
+	if err != nil {
+		return nil, err
+	}
+
+	// Find the first link on the way to the target.  A link at the
+	// target itself is a value to act on, not a block to enter.
+	cur := node
+	for i, seg := range segs {
+		next, err := lookup(cur, seg)
+		if err != nil {
+			// Adding a new key or index: the target is in this block.
+			break
+		}
+		if next.Kind() == datamodel.Kind_Link && i < len(segs)-1 {
+			child, err := next.AsLink()
+			if err != nil {
+				return nil, err
+			}
+			newChild, err := p.applyAt(ctx, child, segs[i+1:], op, written, replaced)
+			if err != nil {
+				return nil, err
+			}
+			op = patch.Operation{
+				Op:    patch.Op_Replace,
+				Path:  datamodel.NewPath(segs[:i+1]),
+				Value: basicnode.NewLink(newChild),
+			}
+			segs = segs[:i+1]
+			break
+		}
+		cur = next
+	}
+
+	op.Path = datamodel.NewPath(segs)
+	patched, err := evalOne(node, op)
+	if err != nil {
+		return nil, err
+	}
+	newLnk, err := p.LinkSystem.Store(lctx, lnk.Prototype(), patched)
+	if err != nil {
+		return nil, err
+	}
+	if newLnk.Binary() != lnk.Binary() {
+		*written = append(*written, newLnk)
+		*replaced = append(*replaced, lnk)
+	}
+	return newLnk, nil
+}
+
+// lookup returns the child of n named by seg.
+func lookup(n datamodel.Node, seg datamodel.PathSegment) (datamodel.Node, error) {
+	switch n.Kind() {
+	case datamodel.Kind_Map:
+		return n.LookupBySegment(seg)
+	case datamodel.Kind_List:
+		idx, err := seg.Index()
+		if err != nil {
+			return nil, err
+		}
+		return n.LookupByIndex(idx)
+	default:
+		return nil, fmt.Errorf("cannot look up %q in a %s", seg, n.Kind())
+	}
+}
+
+// evalOne applies one operation within a block.  Adds and removes in
+// lists are done here, since patch.EvalOne cannot remove list
+// elements and misplaces inserts; everything else is left to it.
+func evalOne(n datamodel.Node, op patch.Operation) (datamodel.Node, error) {
+	if (op.Op != patch.Op_Add && op.Op != patch.Op_Remove) || op.Path.Len() == 0 {
+		return patch.EvalOne(n, op)
+	}
+	parentPath := op.Path.Pop()
+	parent, err := traversal.Get(n, parentPath)
+	if err != nil || parent.Kind() != datamodel.Kind_List {
+		return patch.EvalOne(n, op)
+	}
+	return traversal.FocusedTransform(n, parentPath, func(_ traversal.Progress, list datamodel.Node) (datamodel.Node, error) {
+		return editList(list, op)
+	}, false)
+}
+
+// editList returns list with op.Value inserted before the index that
+// ends op.Path ("-" appends), or with that index removed.
+func editList(list datamodel.Node, op patch.Operation) (datamodel.Node, error) {
+	seg := op.Path.Last()
+	idx := list.Length()
+	if op.Op == patch.Op_Remove || seg.String() != "-" {
+		var err error
+		idx, err = seg.Index()
+		last := list.Length()
+		if op.Op == patch.Op_Remove {
+			last--
+		}
+		if err != nil || idx < 0 || idx > last {
+			return nil, fmt.Errorf("invalid list index %q", seg)
+		}
+	}
+	nb := list.Prototype().NewBuilder()
+	la, err := nb.BeginList(list.Length() + 1)
+	if err != nil {
+		return nil, err
+	}
+	for i := int64(0); i <= list.Length(); i++ {
+		if i == idx && op.Op == patch.Op_Add {
+			err = la.AssembleValue().AssignNode(op.Value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if i == list.Length() {
+			break
+		}
+		if i == idx && op.Op == patch.Op_Remove {
+			continue
+		}
+		v, err := list.LookupByIndex(i)
+		if err != nil {
+			return nil, err
+		}
+		err = la.AssembleValue().AssignNode(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = la.Finish()
+	if err != nil {
+		return nil, err
+	}
+	return nb.Build(), nil
+}
+
+// reachable walks the whole DAG at root and returns the binary form
+// of every link it reaches.  A replaced block may still be reached
+// through a part of the DAG that was not patched, so nothing short of
+// a full walk can tell whether it is obsolete.
+func (p *DAGPatcher) reachable(ctx context.Context, root datamodel.Link) (map[string]bool, error) {
+	w := &ipldexplore.Walker{LinkSystem: p.LinkSystem}
+	err := w.Walk(ctx, root, func(b ipldexplore.Block) error {
+		return b.Err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return w.Visited, nil
+}
@@ -0,0 +1,234 @@
+package ipldpatch
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal/patch"
+	"github.com/multiformats/go-multihash"
+	ipldstore "github.com/stevegt/grid-poc/x/ipld-store"
+)
+
+var jsonLink = cidlink.LinkPrototype{Prefix: cid.Prefix{
+	Version:  1,
+	Codec:    cid.DagJSON,
+	MhType:   multihash.SHA2_256,
+	MhLength: 32,
+}}
+
+// dagFixture holds a three-level DAG:
+//
+//	root:   {"name": "world", "state": link(state), "log": [link(entry)]}
+//	state:  {"count": 1, "user": link(user)}
+//	user:   {"name": "Alice"}
+//	entry:  {"msg": "hello"}
+type dagFixture struct {
+	t     *testing.T
+	store *ipldstore.MemStore
+	ls    linking.LinkSystem
+	links map[string]datamodel.Link
+}
+
+func newDAGFixture(t *testing.T) *dagFixture {
+	f := &dagFixture{t: t, store: ipldstore.NewMemStore(), links: make(map[string]datamodel.Link)}
+	f.ls = ipldstore.NewLinkSystem(f.store)
+	f.put("user", `{"name": "Alice"}`)
+	f.put("entry", `{"msg": "hello"}`)
+	f.put("state", `{"count": 1, "user": {"/": "`+f.cid("user")+`"}}`)
+	f.put("root", `{"name": "world", "state": {"/": "`+f.cid("state")+`"}, "log": [{"/": "`+f.cid("entry")+`"}]}`)
+	return f
+}
+
+func (f *dagFixture) cid(name string) string {
+	return f.links[name].String()
+}
+
+// put stores a block given as DAG-JSON.
+func (f *dagFixture) put(name, js string) {
+	f.t.Helper()
+	f.links[name] = f.store1(js)
+}
+
+func (f *dagFixture) store1(js string) datamodel.Link {
+	f.t.Helper()
+	nb := basicnode.Prototype.Any.NewBuilder()
+	err := dagjson.Decode(nb, bytes.NewBufferString(js))
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	lnk, err := f.ls.Store(linking.LinkContext{}, jsonLink, nb.Build())
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return lnk
+}
+
+// json returns the block at lnk as DAG-JSON.
+func (f *dagFixture) json(lnk datamodel.Link) string {
+	f.t.Helper()
+	n, err := f.ls.Load(linking.LinkContext{}, lnk, basicnode.Prototype.Any)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = dagjson.Encode(n, &buf)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func op(o patch.Op, path string, value string) patch.Operation {
+	p := patch.Operation{Op: o, Path: datamodel.ParsePath(path)}
+	if value != "" {
+		p.Value = fromJSONString(value)
+	}
+	return p
+}
+
+func TestDAGPatchAcrossLinks(t *testing.T) {
+	f := newDAGFixture(t)
+	p := &DAGPatcher{LinkSystem: f.ls}
+	res, err := p.Apply(context.Background(), f.links["root"], []patch.Operation{
+		op(patch.Op_Replace, "state/user/name", `"Bob"`),
+		op(patch.Op_Add, "state/user/age", `31`),
+		op(patch.Op_Replace, "state/count", `2`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The old DAG is untouched.
+	if got := f.json(f.links["user"]); got != `{"name":"Alice"}` {
+		t.Errorf("old user block changed: %s", got)
+	}
+
+	// The new DAG has new user, state and root blocks; the log entry
+	// is shared.
+	user := f.store1(`{"age": 31, "name": "Bob"}`)
+	state := f.store1(`{"count": 2, "user": {"/": "` + user.String() + `"}}`)
+	root := f.store1(`{"log": [{"/": "` + f.cid("entry") + `"}], "name": "world", "state": {"/": "` + state.String() + `"}}`)
+	if res.Root != root {
+		t.Fatalf("root %s = %s, want %s", res.Root, f.json(res.Root), f.json(root))
+	}
+	want := map[datamodel.Link]bool{user: true, state: true, root: true}
+	if len(res.New) != 3 {
+		t.Errorf("new blocks %v, want 3", res.New)
+	}
+	for _, l := range res.New {
+		if !want[l] {
+			t.Errorf("unexpected new block %s: %s", l, f.json(l))
+		}
+	}
+
+	// The old user, state and root blocks are obsolete, and so are the
+	// intermediate blocks written by the first two operations.
+	obsolete := make(map[datamodel.Link]bool)
+	for _, l := range res.Obsolete {
+		obsolete[l] = true
+	}
+	for _, name := range []string{"root", "state", "user"} {
+		if !obsolete[f.links[name]] {
+			t.Errorf("old %s block not obsolete", name)
+		}
+	}
+	if obsolete[f.links["entry"]] || obsolete[root] {
+		t.Errorf("live block listed as obsolete")
+	}
+	if len(res.Obsolete) != 3+5 {
+		t.Errorf("got %d obsolete blocks, want 8", len(res.Obsolete))
+	}
+}
+
+func TestDAGPatchLists(t *testing.T) {
+	f := newDAGFixture(t)
+	p := &DAGPatcher{LinkSystem: f.ls}
+	ctx := context.Background()
+	res, err := p.Apply(ctx, f.links["root"], []patch.Operation{
+		// Through a link inside a list.
+		op(patch.Op_Replace, "log/0/msg", `"hi"`),
+		// Append and insert.
+		op(patch.Op_Add, "log/-", `{"msg": "last"}`),
+		op(patch.Op_Add, "log/0", `{"msg": "first"}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := f.store1(`{"msg": "hi"}`)
+	want := `{"log":[{"msg":"first"},{"/":"` + entry.String() + `"},{"msg":"last"}],"name":"world","state":{"/":"` + f.cid("state") + `"}}`
+	if got := f.json(res.Root); got != want {
+		t.Fatalf("got %s\nwant %s", got, want)
+	}
+
+	res, err = p.Apply(ctx, res.Root, []patch.Operation{op(patch.Op_Remove, "log/1", "")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.json(res.Root); got != `{"log":[{"msg":"first"},{"msg":"last"}],"name":"world","state":{"/":"`+f.cid("state")+`"}}` {
+		t.Fatalf("got %s", got)
+	}
+	obsolete := make(map[datamodel.Link]bool)
+	for _, l := range res.Obsolete {
+		obsolete[l] = true
+	}
+	if obsolete[entry] {
+		t.Error("removing a link must not make its target obsolete; only rewritten blocks are listed")
+	}
+}
+
+func TestDAGPatchMoveCopyTest(t *testing.T) {
+	f := newDAGFixture(t)
+	p := &DAGPatcher{LinkSystem: f.ls}
+	res, err := p.Apply(context.Background(), f.links["root"], []patch.Operation{
+		op(patch.Op_Test, "state/user/name", `"Alice"`),
+		// Copy a link: it stays a link.
+		{Op: patch.Op_Copy, From: datamodel.ParsePath("state/user"), Path: datamodel.ParsePath("owner")},
+		// Move a value from one block to another.
+		{Op: patch.Op_Move, From: datamodel.ParsePath("state/count"), Path: datamodel.ParsePath("log/0/count")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := f.ls.Load(linking.LinkContext{}, res.Root, basicnode.Prototype.Any)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner, err := n.LookupByString("owner")
+	if err != nil || owner.Kind() != datamodel.Kind_Link {
+		t.Fatalf("owner = %v, %v", owner, err)
+	}
+	if l, _ := owner.AsLink(); l != f.links["user"] {
+		t.Errorf("owner links to %s", l)
+	}
+	state := f.store1(`{"user": {"/": "` + f.cid("user") + `"}}`)
+	entry := f.store1(`{"count": 1, "msg": "hello"}`)
+	for _, want := range []datamodel.Link{state, entry} {
+		found := false
+		for _, l := range res.New {
+			found = found || l == want
+		}
+		if !found {
+			t.Errorf("missing new block %s", f.json(want))
+		}
+	}
+
+	_, err = p.Apply(context.Background(), f.links["root"], []patch.Operation{
+		op(patch.Op_Test, "state/user/name", `"Bob"`),
+	})
+	if err == nil {
+		t.Error("expected failed test")
+	}
+	_, err = p.Apply(context.Background(), f.links["root"], []patch.Operation{
+		op(patch.Op_Replace, "state/missing/x", `1`),
+	})
+	if err == nil {
+		t.Error("expected error for a missing path")
+	}
+}
@@ -11,18 +11,16 @@ import (
 	"github.com/ipld/go-ipld-prime/linking"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/ipld/go-ipld-prime/node/basicnode"
-	"github.com/ipld/go-ipld-prime/storage/memstore"
 	"github.com/ipld/go-ipld-prime/traversal/patch"
 	"github.com/multiformats/go-multihash"
+	ipldstore "github.com/stevegt/grid-poc/x/ipld-store"
 )
 
 // RunDemo demonstrates patching an IPLD node and showing before/after states
 func RunDemo() {
-	// Setup linking system with in-memory storage
-	store := &memstore.Store{}
-	ls := cidlink.DefaultLinkSystem()
-	ls.SetReadStorage(store)
-	ls.SetWriteStorage(store)
+	// Setup linking system over an in-memory grid store
+	store := ipldstore.NewMemStore()
+	ls := ipldstore.NewLinkSystem(store)
 
 	// Create initial node with basic fields
 	originalNode := createSimpleNode(&ls, "Alice", 30)
@@ -70,7 +68,7 @@ func createSimpleNode(ls *linking.LinkSystem, name string, age int64) ipld.Node
 // printNode displays node content with proper indentation
 func printNode(n ipld.Node, depth int) {
 	indent := fmt.Sprintf("%*s", depth*2, "")
-	
+
 	switch n.Kind() {
 	case ipld.Kind_Link:
 		link, _ := n.AsLink()
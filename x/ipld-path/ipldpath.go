@@ -1,17 +1,16 @@
 package ipldpath
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-ipld-prime"
 	"github.com/ipld/go-ipld-prime/codec/dagjson"
-	"github.com/ipld/go-ipld-prime/datamodel"
 	"github.com/ipld/go-ipld-prime/linking"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
-	"github.com/ipld/go-ipld-prime/node/basicnode"
 	"github.com/ipld/go-ipld-prime/node/bindnode"
-	"github.com/ipld/go-ipld-prime/storage/memstore"
+	ipldstore "github.com/stevegt/grid-poc/x/ipld-store"
 )
 
 // UserData represents a root node linking to Profile and Settings.
@@ -33,11 +32,9 @@ type Settings struct {
 
 // RunDemo executes a demonstration of basic IPLD path traversal.
 func RunDemo() {
-	// Setup linking system with in-memory store.
-	store := &memstore.Store{}
-	ls := cidlink.DefaultLinkSystem()
-	ls.SetReadStorage(store)
-	ls.SetWriteStorage(store)
+	// Setup linking system over an in-memory grid store.
+	store := ipldstore.NewMemStore()
+	ls := ipldstore.NewLinkSystem(store)
 
 	// Create profile block.
 	profile := &Profile{Name: "Alice", Age: 30}
@@ -105,49 +102,19 @@ func RunDemo() {
 	navigate(ls, rootLink, "Settings/Active") // Shows true
 }
 
+// navigate resolves pathStr from startLink and prints the value found,
+// or how far the path got.
 func navigate(ls linking.LinkSystem, startLink ipld.Link, pathStr string) {
 	fmt.Printf("Navigating: %s\n", pathStr)
-	path := datamodel.ParsePath(pathStr)
-
-	// Load initial node with prototype
-	node, err := ls.Load(ipld.LinkContext{}, startLink, basicnode.Prototype.Any)
+	r := &Resolver{LinkSystem: ls}
+	res, err := r.ResolveString(context.Background(), startLink, pathStr)
 	if err != nil {
-		panic(err)
-	}
-
-	for path.Len() > 0 {
-		seg, remaining := path.Shift()
-		path = remaining
-
-		// Handle links before processing segments
-		if node.Kind() == ipld.Kind_Link {
-			link, err := node.AsLink()
-			if err != nil {
-				panic(err)
-			}
-			node, err = ls.Load(ipld.LinkContext{}, link, basicnode.Prototype.Any)
-			if err != nil {
-				panic(err)
-			}
-		}
-
-		switch node.Kind() {
-		case ipld.Kind_Map:
-			node, err = node.LookupByString(seg.String())
-		case ipld.Kind_List:
-			idx, err := seg.Index()
-			if err != nil {
-				panic(err)
-			}
-			node, err = node.LookupByIndex(idx)
-		}
-		if err != nil {
-			panic(err)
-		}
+		fmt.Printf("Error: %v\n", err)
+		return
 	}
 
 	fmt.Print("Found value: ")
-	_ = dagjson.Encode(node, &noCloseWriter{})
+	_ = dagjson.Encode(res.Node, &noCloseWriter{})
 	fmt.Println()
 }
 
@@ -0,0 +1,170 @@
+package ipldpath
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+)
+
+// Resolver walks paths through IPLD data, loading blocks through a
+// LinkSystem whenever it meets a link, whether the link is a map
+// value, a list element or the node a path ends on.  Errors are typed
+// and say how far the path got.
+type Resolver struct {
+	LinkSystem linking.LinkSystem
+	// Chooser picks the prototype for each loaded block.  Nil means
+	// basicnode.Chooser.
+	Chooser func(datamodel.Link, linking.LinkContext) (datamodel.NodePrototype, error)
+	// Proof records the CID of every block loaded, in order, in the
+	// Result.
+	Proof bool
+}
+
+// Result is where a resolution got to.  On error it holds the last
+// node reached, so callers can report or resume from it.
+type Result struct {
+	// Node is the node at the end of Reached.
+	Node datamodel.Node
+	// Reached is the part of the path that was resolved.
+	Reached datamodel.Path
+	// Blocks are the CIDs of the blocks loaded, in order, if the
+	// Resolver records proofs.  The first is the starting block when
+	// resolution starts from a link.
+	Blocks []cid.Cid
+}
+
+// MissingSegmentError means a map has no such key or a list no such
+// index.
+type MissingSegmentError struct {
+	Reached datamodel.Path
+	Segment datamodel.PathSegment
+	Kind    datamodel.Kind
+}
+
+func (e *MissingSegmentError) Error() string {
+	return fmt.Sprintf("at %q: %s has no %q", e.Reached, e.Kind, e.Segment)
+}
+
+// WrongKindError means a path continues past a node that is neither a
+// map nor a list.
+type WrongKindError struct {
+	Reached datamodel.Path
+	Segment datamodel.PathSegment
+	Kind    datamodel.Kind
+}
+
+func (e *WrongKindError) Error() string {
+	return fmt.Sprintf("at %q: cannot look up %q in a %s", e.Reached, e.Segment, e.Kind)
+}
+
+// LinkError means a link could not be loaded.
+type LinkError struct {
+	Reached datamodel.Path
+	Link    datamodel.Link
+	Err     error
+}
+
+func (e *LinkError) Error() string {
+	return fmt.Sprintf("at %q: cannot load %s: %v", e.Reached, e.Link, e.Err)
+}
+
+func (e *LinkError) Unwrap() error {
+	return e.Err
+}
+
+// Resolve loads the block at start and resolves path from it.
+func (r *Resolver) Resolve(ctx context.Context, start datamodel.Link, path datamodel.Path) (*Result, error) {
+	res := &Result{}
+	node, err := r.load(ctx, res, start)
+	if err != nil {
+		return res, err
+	}
+	return r.walk(ctx, res, node, path)
+}
+
+// ResolveNode resolves path from a node already in hand.
+func (r *Resolver) ResolveNode(ctx context.Context, start datamodel.Node, path datamodel.Path) (*Result, error) {
+	return r.walk(ctx, &Result{Node: start}, start, path)
+}
+
+// ResolveString is Resolve with a slash-separated path.
+func (r *Resolver) ResolveString(ctx context.Context, start datamodel.Link, path string) (*Result, error) {
+	return r.Resolve(ctx, start, datamodel.ParsePath(path))
+}
+
+// walk follows path from node, loading links as it meets them.
+func (r *Resolver) walk(ctx context.Context, res *Result, node datamodel.Node, path datamodel.Path) (*Result, error) {
+	var err error
+	for _, seg := range path.Segments() {
+		node, err = r.follow(ctx, res, node)
+		if err != nil {
+			return res, err
+		}
+		var next datamodel.Node
+		switch node.Kind() {
+		case datamodel.Kind_Map:
+			next, err = node.LookupBySegment(seg)
+		case datamodel.Kind_List:
+			var idx int64
+			idx, err = seg.Index()
+			if err == nil {
+				next, err = node.LookupByIndex(idx)
+			}
+		default:
+			return res, &WrongKindError{Reached: res.Reached, Segment: seg, Kind: node.Kind()}
+		}
+		if err != nil {
+			return res, &MissingSegmentError{Reached: res.Reached, Segment: seg, Kind: node.Kind()}
+		}
+		node = next
+		res.Node = node
+		res.Reached = res.Reached.AppendSegment(seg)
+	}
+	// Load a link the path ends on.
+	_, err = r.follow(ctx, res, node)
+	return res, err
+}
+
+// follow loads node's target if node is a link, and returns node
+// otherwise.
+func (r *Resolver) follow(ctx context.Context, res *Result, node datamodel.Node) (datamodel.Node, error) {
+	if node.Kind() != datamodel.Kind_Link {
+		return node, nil
+	}
+	lnk, err := node.AsLink()
+	if err != nil {
+		return nil, &LinkError{Reached: res.Reached, Err: err}
+	}
+	return r.load(ctx, res, lnk)
+}
+
+// load loads a block and records it.
+func (r *Resolver) load(ctx context.Context, res *Result, lnk datamodel.Link) (datamodel.Node, error) {
+	lctx := linking.LinkContext{Ctx: ctx, LinkPath: res.Reached}
+	chooser := r.Chooser
+	if chooser == nil {
+		chooser = basicnode.Chooser
+	}
+	proto, err := chooser(lnk, lctx)
+	if err != nil {
+		return nil, &LinkError{Reached: res.Reached, Link: lnk, Err: err}
+	}
+	node, err := r.LinkSystem.Load(lctx, lnk, proto)
+	if err != nil {
+		return nil, &LinkError{Reached: res.Reached, Link: lnk, Err: err}
+	}
+	if r.Proof {
+		cl, ok := lnk.(cidlink.Link)
+		if !ok {
+			return nil, &LinkError{Reached: res.Reached, Link: lnk, Err: fmt.Errorf("not a CID link")}
+		}
+		res.Blocks = append(res.Blocks, cl.Cid)
+	}
+	res.Node = node
+	return node, nil
+}
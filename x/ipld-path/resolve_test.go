@@ -0,0 +1,165 @@
+package ipldpath
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	ipldstore "github.com/stevegt/grid-poc/x/ipld-store"
+)
+
+var testLinkProto = cidlink.LinkPrototype{Prefix: cid.Prefix{
+	Version:  1,
+	Codec:    0x0129, // DAG-JSON multicodec
+	MhType:   0x12,   // sha2-256
+	MhLength: 32,
+}}
+
+// testData stores
+//
+//	root: {"name": "root", "items": [link(a), {"inline": 1}], "b": link(b)}
+//	a:    {"value": "a", "next": link(b)}
+//	b:    {"value": "b"}
+//
+// and a link to a block that was never stored, under "gone".
+func testData(t *testing.T) (linking.LinkSystem, map[string]ipld.Link) {
+	ls := ipldstore.NewLinkSystem(ipldstore.NewMemStore())
+	links := make(map[string]ipld.Link)
+	put := func(name string, n datamodel.Node) {
+		lnk, err := ls.Store(ipld.LinkContext{}, testLinkProto, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		links[name] = lnk
+	}
+	build := func(fn func(ma datamodel.MapAssembler)) datamodel.Node {
+		n, err := qp.BuildMap(basicnode.Prototype.Any, -1, fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	put("b", build(func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "value", qp.String("b"))
+	}))
+	put("a", build(func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "value", qp.String("a"))
+		qp.MapEntry(ma, "next", qp.Link(links["b"]))
+	}))
+	gone, err := testLinkProto.Prefix.Sum([]byte("never stored"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	links["gone"] = cidlink.Link{Cid: gone}
+	put("root", build(func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "name", qp.String("root"))
+		qp.MapEntry(ma, "items", qp.List(-1, func(la datamodel.ListAssembler) {
+			qp.ListEntry(la, qp.Link(links["a"]))
+			qp.ListEntry(la, qp.Map(-1, func(ma datamodel.MapAssembler) {
+				qp.MapEntry(ma, "inline", qp.Int(1))
+			}))
+		}))
+		qp.MapEntry(ma, "b", qp.Link(links["b"]))
+		qp.MapEntry(ma, "gone", qp.Link(links["gone"]))
+	}))
+	return ls, links
+}
+
+func TestResolve(t *testing.T) {
+	ls, links := testData(t)
+	r := &Resolver{LinkSystem: ls, Proof: true}
+	ctx := context.Background()
+
+	cases := []struct {
+		path   string
+		want   string
+		blocks []string
+	}{
+		{"name", "root", []string{"root"}},
+		{"items/0/value", "a", []string{"root", "a"}},
+		{"items/0/next/value", "b", []string{"root", "a", "b"}},
+		{"items/1/inline", "", []string{"root"}},
+		{"b/value", "b", []string{"root", "b"}},
+	}
+	for _, c := range cases {
+		res, err := r.ResolveString(ctx, links["root"], c.path)
+		if err != nil {
+			t.Fatalf("%s: %v", c.path, err)
+		}
+		if res.Reached.String() != c.path {
+			t.Errorf("%s: reached %q", c.path, res.Reached)
+		}
+		if c.want != "" {
+			s, err := res.Node.AsString()
+			if err != nil || s != c.want {
+				t.Errorf("%s: got %v, %v", c.path, res.Node, err)
+			}
+		}
+		if len(res.Blocks) != len(c.blocks) {
+			t.Fatalf("%s: loaded %v, want %v", c.path, res.Blocks, c.blocks)
+		}
+		for i, name := range c.blocks {
+			if res.Blocks[i] != links[name].(cidlink.Link).Cid {
+				t.Errorf("%s: block %d is %s, want %s", c.path, i, res.Blocks[i], name)
+			}
+		}
+	}
+
+	// A path ending on a link loads the block.
+	res, err := r.ResolveString(ctx, links["root"], "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Node.Kind() != datamodel.Kind_Map || len(res.Blocks) != 2 {
+		t.Fatalf("got %s after loading %v", res.Node.Kind(), res.Blocks)
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	ls, links := testData(t)
+	r := &Resolver{LinkSystem: ls}
+	ctx := context.Background()
+
+	res, err := r.ResolveString(ctx, links["root"], "items/0/missing/x")
+	var missing *MissingSegmentError
+	if !errors.As(err, &missing) {
+		t.Fatalf("got %v, want MissingSegmentError", err)
+	}
+	if missing.Reached.String() != "items/0" || missing.Segment.String() != "missing" || res.Reached.String() != "items/0" {
+		t.Errorf("unexpected error %v, reached %q", err, res.Reached)
+	}
+
+	for _, path := range []string{"items/2", "items/x"} {
+		_, err = r.ResolveString(ctx, links["root"], path)
+		if !errors.As(err, &missing) || missing.Kind != datamodel.Kind_List {
+			t.Errorf("%s: got %v, want MissingSegmentError on a list", path, err)
+		}
+	}
+
+	_, err = r.ResolveString(ctx, links["root"], "name/x")
+	var wrong *WrongKindError
+	if !errors.As(err, &wrong) || wrong.Kind != datamodel.Kind_String || wrong.Reached.String() != "name" {
+		t.Errorf("got %v, want WrongKindError at name", err)
+	}
+
+	res, err = r.ResolveString(ctx, links["root"], "gone/x")
+	var lerr *LinkError
+	if !errors.As(err, &lerr) || lerr.Link != links["gone"] || lerr.Reached.String() != "gone" {
+		t.Errorf("got %v, want LinkError at gone", err)
+	}
+	if res.Node == nil || res.Node.Kind() != datamodel.Kind_Link {
+		t.Errorf("partial result should end on the link, got %v", res.Node)
+	}
+
+	_, err = r.ResolveString(ctx, links["gone"], "")
+	if !errors.As(err, &lerr) || lerr.Reached.Len() != 0 {
+		t.Errorf("got %v, want LinkError at the start", err)
+	}
+}
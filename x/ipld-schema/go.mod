@@ -1,23 +1,23 @@
-module main
+module github.com/stevegt/grid-poc/x/ipld-schema
 
 go 1.24.0
 
 require (
 	github.com/fxamacker/cbor/v2 v2.8.0
+	github.com/ipfs/go-cid v0.4.1
 	github.com/ipld/go-ipld-prime v0.21.0
+	github.com/multiformats/go-multihash v0.2.3
 	// github.com/stevegt/cbordiag v0.0.0-20250403043148-94da7e0cb7b0
 	github.com/stevegt/cbordiag v0.0.0-20250403045229-6a649cf05fb6
 )
 
 require (
-	github.com/ipfs/go-cid v0.4.1 // indirect
 	github.com/klauspost/cpuid/v2 v2.0.9 // indirect
 	github.com/minio/sha256-simd v1.0.0 // indirect
 	github.com/mr-tron/base58 v1.2.0 // indirect
 	github.com/multiformats/go-base32 v0.0.3 // indirect
 	github.com/multiformats/go-base36 v0.1.0 // indirect
 	github.com/multiformats/go-multibase v0.0.3 // indirect
-	github.com/multiformats/go-multihash v0.2.3 // indirect
 	github.com/multiformats/go-varint v0.0.6 // indirect
 	github.com/polydawn/refmt v0.89.0 // indirect
 	github.com/spaolacci/murmur3 v1.1.0 // indirect
@@ -0,0 +1,214 @@
+// Package gridschema holds IPLD schemas for the grid's wire types and
+// Go types bound to them with bindnode.
+//
+// The schemas are the .ipldsch files in this directory.  Together they
+// make up one schema, which implementations in other languages can load
+// to validate the same bytes; testdata holds DAG-CBOR conformance
+// vectors for each type, some of them written by the Go programs that
+// produce that data.
+package gridschema
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io/fs"
+	"reflect"
+
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/node/bindnode"
+	"github.com/ipld/go-ipld-prime/schema"
+)
+
+//go:embed *.ipldsch
+var schemaFiles embed.FS
+
+// Schema returns the text of every schema file, in name order.
+func Schema() []byte {
+	names, err := fs.Glob(schemaFiles, "*.ipldsch")
+	if err != nil {
+		panic(err)
+	}
+	var buf bytes.Buffer
+	for _, name := range names {
+		b, err := schemaFiles.ReadFile(name)
+		if err != nil {
+			panic(err)
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// typeSystem is the loaded schema.  The schema files are compiled in,
+// so an error here is a bug.
+var typeSystem = func() *schema.TypeSystem {
+	ts, err := ipld.LoadSchemaBytes(Schema())
+	if err != nil {
+		panic(fmt.Sprintf("gridschema: %v", err))
+	}
+	return ts
+}()
+
+// bound lists the Go types bound to schema types, by schema type name.
+var bound = map[string]interface{}{
+	"Envelope":             (*Envelope)(nil),
+	"ScenarioTree":         (*ScenarioTree)(nil),
+	"EditPayload":          (*EditPayload)(nil),
+	"Claims":               (*Claims)(nil),
+	"Claim":                (*Claim)(nil),
+	"CallRequest":          (*CallRequest)(nil),
+	"CallResponse":         (*CallResponse)(nil),
+	"CallError":            (*CallError)(nil),
+	"PromiseGridMessage":   (*PromiseGridMessage)(nil),
+	"ExecutableDescriptor": (*ExecutableDescriptor)(nil),
+	"CommitData":           (*CommitData)(nil),
+	"TreeData":             (*TreeData)(nil),
+	"TreeEntry":            (*TreeEntry)(nil),
+	"BlobData":             (*BlobData)(nil),
+	"TagData":              (*TagData)(nil),
+}
+
+// prototypes holds the bindnode prototype of each bound type, by
+// schema type name and by Go type.
+var (
+	prototypes   = make(map[string]schema.TypedPrototype)
+	prototypesGo = make(map[reflect.Type]schema.TypedPrototype)
+)
+
+func init() {
+	for name, ptr := range bound {
+		typ := typeSystem.TypeByName(name)
+		if typ == nil {
+			panic(fmt.Sprintf("gridschema: no schema type %s", name))
+		}
+		proto := bindnode.Prototype(ptr, typ)
+		prototypes[name] = proto
+		prototypesGo[reflect.TypeOf(ptr)] = proto
+	}
+}
+
+// TypeSystem returns the schema.
+func TypeSystem() *schema.TypeSystem {
+	return typeSystem
+}
+
+// prototypeOf returns the prototype for v, a pointer to a bound type.
+func prototypeOf(v interface{}) (schema.TypedPrototype, error) {
+	proto, ok := prototypesGo[reflect.TypeOf(v)]
+	if !ok {
+		return nil, fmt.Errorf("%T is not bound to a schema type", v)
+	}
+	return proto, nil
+}
+
+// Marshal encodes v, a pointer to one of the types in this package, as
+// DAG-CBOR in its schema representation.
+func Marshal(v interface{}) ([]byte, error) {
+	proto, err := prototypeOf(v)
+	if err != nil {
+		return nil, err
+	}
+	node := bindnode.Wrap(v, proto.Type())
+	return ipld.Encode(node.Representation(), dagcbor.Encode)
+}
+
+// Unmarshal decodes DAG-CBOR data into v, a pointer to one of the types
+// in this package.  It fails if the data does not match the schema.
+func Unmarshal(data []byte, v interface{}) error {
+	proto, err := prototypeOf(v)
+	if err != nil {
+		return err
+	}
+	nb := proto.Representation().NewBuilder()
+	err = dagcbor.Decode(nb, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	got := bindnode.Unwrap(nb.Build())
+	reflect.ValueOf(v).Elem().Set(reflect.ValueOf(got).Elem())
+	return nil
+}
+
+// Validate checks that data is DAG-CBOR matching the schema type name.
+func Validate(name string, data []byte) error {
+	proto, ok := prototypes[name]
+	if !ok {
+		return fmt.Errorf("no schema type %s", name)
+	}
+	nb := proto.Representation().NewBuilder()
+	err := dagcbor.Decode(nb, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// EnvelopeTag is the CBOR tag number of the grid envelope, "grid" in
+// ASCII.
+const EnvelopeTag = 0x67726964
+
+// envelopeHead is the CBOR head of EnvelopeTag.
+var envelopeHead = []byte{0xda, 'g', 'r', 'i', 'd'}
+
+// MarshalEnvelope encodes e as a tagged grid envelope.
+func MarshalEnvelope(e *Envelope) ([]byte, error) {
+	content, err := Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return append(bytes.Clone(envelopeHead), content...), nil
+}
+
+// UnmarshalEnvelope decodes a tagged grid envelope.
+func UnmarshalEnvelope(data []byte) (*Envelope, error) {
+	if !bytes.HasPrefix(data, envelopeHead) {
+		return nil, fmt.Errorf("not a grid envelope")
+	}
+	e := &Envelope{}
+	err := Unmarshal(data[len(envelopeHead):], e)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Limits of the scenario-tree protocol.
+const (
+	// MaxWeight is the largest weight, 2.0x.
+	MaxWeight = 0x4000
+	// MaxScenarioDepth is how deeply branches may nest.
+	MaxScenarioDepth = 256
+)
+
+// Validate checks the rules of the scenario-tree protocol that the
+// schema cannot express: weights are at most MaxWeight, branches nest
+// at most MaxScenarioDepth deep and are sorted by event CID bytes, and
+// every CID is defined.  Probabilities fit in a uint16 by type.
+func (t *ScenarioTree) Validate() error {
+	return t.validate(1)
+}
+
+func (t *ScenarioTree) validate(depth int) error {
+	if depth > MaxScenarioDepth {
+		return fmt.Errorf("scenario tree deeper than %d", MaxScenarioDepth)
+	}
+	if !t.Event.Defined() || !t.State.Defined() {
+		return fmt.Errorf("undefined CID at depth %d", depth)
+	}
+	if t.Weight > MaxWeight {
+		return fmt.Errorf("weight %d above %d at depth %d", t.Weight, MaxWeight, depth)
+	}
+	for i := range t.Branches {
+		if i > 0 && bytes.Compare(t.Branches[i-1].Event.Bytes(), t.Branches[i].Event.Bytes()) > 0 {
+			return fmt.Errorf("branches not sorted by event CID at depth %d", depth)
+		}
+		err := t.Branches[i].validate(depth + 1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
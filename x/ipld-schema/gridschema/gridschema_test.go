@@ -0,0 +1,371 @@
+package gridschema
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/schema"
+	"github.com/multiformats/go-multihash"
+)
+
+var update = flag.Bool("update", false, "rewrite the conformance vectors built by the tests")
+
+func testCID(t *testing.T, codec uint64, s string) cid.Cid {
+	t.Helper()
+	mh, err := multihash.Sum([]byte(s), multihash.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cid.NewCidV1(codec, mh)
+}
+
+func readVector(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// TestSchemaLoads checks that every schema type has a bound Go type.
+func TestSchemaLoads(t *testing.T) {
+	for name, typ := range TypeSystem().GetTypes() {
+		switch typ.TypeKind() {
+		case schema.TypeKind_Struct:
+			if _, ok := bound[string(name)]; !ok {
+				t.Errorf("struct %s has no Go type", name)
+			}
+		}
+	}
+}
+
+// TestConformance decodes each vector with its Go type, re-encodes it
+// and checks the result.  Canonical vectors must come back byte for
+// byte; the others were written by encoders that do not sort map keys,
+// so only the decoded data must survive.
+func TestConformance(t *testing.T) {
+	vectors := []struct {
+		file      string
+		typ       string
+		v         interface{}
+		canonical bool
+	}{
+		// x/wire NewMessage, without the grid tag.
+		{"envelope.cbor", "Envelope", &Envelope{}, true},
+		{"scenariotree.cbor", "ScenarioTree", &ScenarioTree{}, true},
+		{"editpayload.cbor", "EditPayload", &EditPayload{}, true},
+		{"callrequest.cbor", "CallRequest", &CallRequest{}, true},
+		{"callresponse.cbor", "CallResponse", &CallResponse{}, true},
+		{"callerror.cbor", "CallError", &CallError{}, true},
+		// x/descriptors example -o and embed hello.sh -o.
+		{"promisegridmessage.cbor", "PromiseGridMessage", &PromiseGridMessage{}, false},
+		{"executabledescriptor.cbor", "ExecutableDescriptor", &ExecutableDescriptor{}, false},
+		// x/git-cbor git2cbor of a two-commit repository.
+		{"commitdata.cbor", "CommitData", &CommitData{}, true},
+	}
+	for _, vec := range vectors {
+		data := readVector(t, vec.file)
+		if vec.typ == "Envelope" {
+			data = data[len(envelopeHead):]
+		}
+		err := Validate(vec.typ, data)
+		if err != nil {
+			t.Errorf("%s: %v", vec.file, err)
+			continue
+		}
+		err = Unmarshal(data, vec.v)
+		if err != nil {
+			t.Errorf("%s: %v", vec.file, err)
+			continue
+		}
+		out, err := Marshal(vec.v)
+		if err != nil {
+			t.Errorf("%s: %v", vec.file, err)
+			continue
+		}
+		if vec.canonical && !bytes.Equal(out, data) {
+			t.Errorf("%s: re-encoded to\n%x\nwant\n%x", vec.file, out, data)
+		}
+		if !vec.canonical {
+			err = Validate(vec.typ, out)
+			if err != nil {
+				t.Errorf("%s: re-encoded data invalid: %v", vec.file, err)
+			}
+		}
+	}
+}
+
+func TestVectorContents(t *testing.T) {
+	e, err := UnmarshalEnvelope(readVector(t, "envelope.cbor"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := e.Payload.AsBytes()
+	if err != nil || string(payload) != "hello" || e.Signature != nil {
+		t.Errorf("envelope payload %q, %v, signature %v", payload, err, e.Signature)
+	}
+	if _, _, err := cid.CidFromBytes(e.PCID); err != nil {
+		t.Errorf("envelope pcid: %v", err)
+	}
+
+	var msg PromiseGridMessage
+	if err := Unmarshal(readVector(t, "promisegridmessage.cbor"), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.ProtocolTag != "grid" || len(msg.CwtPayload.Keys) != 4 {
+		t.Errorf("message %+v", msg)
+	}
+	if iat, err := msg.CwtPayload.Values["iat"].AsInt(); err != nil || iat != 1704067200 {
+		t.Errorf("iat %d, %v", iat, err)
+	}
+
+	var desc ExecutableDescriptor
+	if err := Unmarshal(readVector(t, "executabledescriptor.cbor"), &desc); err != nil {
+		t.Fatal(err)
+	}
+	if desc.Name != "hello.sh" || desc.Size != int64(len(desc.Executable)) {
+		t.Errorf("descriptor %s of %d bytes holds %d", desc.Name, desc.Size, len(desc.Executable))
+	}
+
+	var commit CommitData
+	if err := Unmarshal(readVector(t, "commitdata.cbor"), &commit); err != nil {
+		t.Fatal(err)
+	}
+	if commit.Message != "second\n" || len(commit.Parents) != 1 || commit.Hash.Prefix().Codec != cid.GitRaw {
+		t.Errorf("commit %+v", commit)
+	}
+	if commit.Branches == nil || commit.Branches.Values["main"] != commit.Hash {
+		t.Errorf("branches %+v", commit.Branches)
+	}
+	if commit.MergeTag != nil || commit.TreeNode != nil || len(commit.Trees) != 2 {
+		t.Errorf("commit %+v", commit)
+	}
+}
+
+// TestBuiltVectors checks the vectors for payloads that no Go program
+// in the tree writes yet against values built here from the examples
+// in x/wire/wire.md and x/rfc/draft-promisegrid.md.  Run with -update
+// to rewrite them.
+func TestBuiltVectors(t *testing.T) {
+	detail := "Welcome message"
+	built := map[string]interface{}{
+		"scenariotree.cbor": &ScenarioTree{
+			Event:       testCID(t, cid.DagCBOR, "event"),
+			State:       testCID(t, cid.DagCBOR, "state"),
+			Probability: 42598,
+			Weight:      7864,
+			Branches: []ScenarioTree{{
+				Event:       testCID(t, cid.DagCBOR, "event 2"),
+				State:       testCID(t, cid.DagCBOR, "state 2"),
+				Probability: 58982,
+				Weight:      8192,
+				Branches:    []ScenarioTree{},
+			}},
+		},
+		"editpayload.cbor": &EditPayload{
+			Op:        OpInsert,
+			Agent:     "Alice",
+			Timestamp: "2023-10-01T10:05:00Z",
+			Target:    "worldline123",
+			Payload: Claims{Claims: []Claim{{
+				Description: "Insert event 'Hello' as an initial greeting",
+				Detail:      &detail,
+			}}},
+			PrevHashes: []cid.Cid{
+				testCID(t, cid.DagCBOR, "internalNode1"),
+				testCID(t, cid.DagCBOR, "internalNode2"),
+			},
+			Signature: []byte("AliceSignatureABC123"),
+		},
+		"callrequest.cbor": &CallRequest{
+			MType: 0,
+			FCID:  testCID(t, cid.Raw, "function"),
+			Args:  []datamodel.Node{basicnode.NewString("arg1"), basicnode.NewInt(2)},
+		},
+		"callresponse.cbor": &CallResponse{
+			MType:   1,
+			CallID:  7,
+			Results: []datamodel.Node{basicnode.NewInt(3)},
+		},
+		"callerror.cbor": &CallError{
+			MType:   2,
+			CallID:  7,
+			Code:    1,
+			Message: "unknown function",
+		},
+	}
+	for file, v := range built {
+		out, err := Marshal(v)
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		path := filepath.Join("testdata", file)
+		if *update {
+			err = os.WriteFile(path, out, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if want := readVector(t, file); !bytes.Equal(out, want) {
+			t.Errorf("%s: built\n%x\nwant\n%x", file, out, want)
+		}
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	cases := []struct {
+		name string
+		typ  string
+		v    interface{}
+	}{
+		{"unknown op", "EditPayload", map[string]interface{}{
+			"op": "update", "agent": "a", "timestamp": "t", "target": "w",
+			"payload": map[string]interface{}{"claims": []interface{}{}},
+		}},
+		{"missing claims", "EditPayload", map[string]interface{}{
+			"op": "query", "agent": "a", "timestamp": "t", "target": "w",
+			"payload": map[string]interface{}{},
+		}},
+		{"short scenario tree", "ScenarioTree", []interface{}{"x", "y", 1, 2}},
+		{"descriptor as a list", "ExecutableDescriptor", []interface{}{"a", "b", 1, []byte{}, []byte{}}},
+		{"string fcid", "CallRequest", []interface{}{0, "bafy", []interface{}{}}},
+	}
+	for _, c := range cases {
+		data := encodeAny(t, c.v)
+		if err := Validate(c.typ, data); err == nil {
+			t.Errorf("%s: accepted", c.name)
+		}
+	}
+
+	// The integer-keyed descriptors x/descriptors used to write are
+	// not DAG-CBOR.
+	old := []byte{0xa1, 0x00, 0x68, 'h', 'e', 'l', 'l', 'o', '.', 's', 'h'}
+	if err := Validate("ExecutableDescriptor", old); err == nil {
+		t.Error("integer keys accepted")
+	}
+	if err := Validate("NoSuchType", readVector(t, "callrequest.cbor")); err == nil {
+		t.Error("unknown type accepted")
+	}
+	if _, err := UnmarshalEnvelope(readVector(t, "callrequest.cbor")); err == nil {
+		t.Error("untagged envelope accepted")
+	}
+}
+
+// encodeAny encodes plain Go maps, lists, strings, ints and bytes as
+// DAG-CBOR.
+func encodeAny(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	nb := basicnode.Prototype.Any.NewBuilder()
+	buildAny(t, nb, v)
+	var buf bytes.Buffer
+	if err := dagcbor.Encode(nb.Build(), &buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func buildAny(t *testing.T, na datamodel.NodeAssembler, v interface{}) {
+	t.Helper()
+	var err error
+	switch v := v.(type) {
+	case string:
+		err = na.AssignString(v)
+	case int:
+		err = na.AssignInt(int64(v))
+	case []byte:
+		err = na.AssignBytes(v)
+	case []interface{}:
+		var la datamodel.ListAssembler
+		la, err = na.BeginList(int64(len(v)))
+		if err == nil {
+			for _, e := range v {
+				buildAny(t, la.AssembleValue(), e)
+			}
+			err = la.Finish()
+		}
+	case map[string]interface{}:
+		var ma datamodel.MapAssembler
+		ma, err = na.BeginMap(int64(len(v)))
+		if err == nil {
+			for k, e := range v {
+				if err = ma.AssembleKey().AssignString(k); err != nil {
+					break
+				}
+				buildAny(t, ma.AssembleValue(), e)
+			}
+			err = ma.Finish()
+		}
+	default:
+		t.Fatalf("cannot build %T", v)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	e := &Envelope{
+		PCID:      testCID(t, cid.Raw, "spec").Bytes(),
+		Payload:   basicnode.NewBytes([]byte("payload")),
+		Signature: basicnode.NewBytes([]byte("signature")),
+	}
+	data, err := MarshalEnvelope(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte{0xda, 0x67, 0x72, 0x69, 0x64, 0x83}) {
+		t.Fatalf("got %x", data)
+	}
+	got, err := UnmarshalEnvelope(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := got.Signature.AsBytes()
+	if err != nil || string(sig) != "signature" {
+		t.Errorf("signature %q, %v", sig, err)
+	}
+}
+
+func TestScenarioTreeValidate(t *testing.T) {
+	var tree ScenarioTree
+	if err := Unmarshal(readVector(t, "scenariotree.cbor"), &tree); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	heavy := tree
+	heavy.Weight = MaxWeight + 1
+	if heavy.Validate() == nil {
+		t.Error("weight above the limit accepted")
+	}
+
+	unsorted := tree
+	unsorted.Branches = []ScenarioTree{tree.Branches[0], tree.Branches[0]}
+	unsorted.Branches[0].Event = testCID(t, cid.DagCBOR, "z")
+	unsorted.Branches[1].Event = testCID(t, cid.DagCBOR, "a")
+	if bytes.Compare(unsorted.Branches[0].Event.Bytes(), unsorted.Branches[1].Event.Bytes()) < 0 {
+		unsorted.Branches[0], unsorted.Branches[1] = unsorted.Branches[1], unsorted.Branches[0]
+	}
+	if unsorted.Validate() == nil {
+		t.Error("unsorted branches accepted")
+	}
+
+	deep := &ScenarioTree{Event: tree.Event, State: tree.State}
+	for i := 0; i < MaxScenarioDepth; i++ {
+		deep = &ScenarioTree{Event: tree.Event, State: tree.State, Branches: []ScenarioTree{*deep}}
+	}
+	if deep.Validate() == nil {
+		t.Error("tree deeper than the limit accepted")
+	}
+}
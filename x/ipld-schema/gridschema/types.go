@@ -0,0 +1,176 @@
+package gridschema
+
+import (
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+)
+
+// The Go types below are bound to the schema types of the same name
+// with bindnode, which matches fields by name: schema field fooBar is
+// Go field FooBar.  Maps are bound as bindnode requires, to a struct
+// holding the keys in order and the values.
+
+// Envelope is the common envelope of every PromiseGrid message.
+type Envelope struct {
+	PCID      []byte
+	Payload   datamodel.Node
+	Signature datamodel.Node
+}
+
+// ScenarioTree is the scenario-tree payload.
+type ScenarioTree struct {
+	Event       cid.Cid
+	State       cid.Cid
+	Probability uint16
+	Weight      uint16
+	Branches    []ScenarioTree
+}
+
+// OperationCode values.
+const (
+	OpInsert    = "insert"
+	OpDelete    = "delete"
+	OpReorder   = "reorder"
+	OpQuery     = "query"
+	OpSubscribe = "subscribe"
+)
+
+// EditPayload is the DAG edit operations payload.
+type EditPayload struct {
+	Op         string
+	Agent      string
+	Timestamp  string
+	Target     string
+	Payload    Claims
+	PrevHashes []cid.Cid
+	Signature  []byte
+}
+
+// Claims detail an edit.
+type Claims struct {
+	Criteria *StringMap
+	Claims   []Claim
+}
+
+// Claim is one claim about an edit.
+type Claim struct {
+	Description string
+	Detail      *string
+}
+
+// CallRequest is a capability-call request.
+type CallRequest struct {
+	MType  int64
+	FCID   cid.Cid
+	Args   []datamodel.Node
+	CallID *int64
+}
+
+// CallResponse is the reply to a successful capability call.
+type CallResponse struct {
+	MType   int64
+	CallID  int64
+	Results []datamodel.Node
+}
+
+// CallError is the reply to a failed capability call.
+type CallError struct {
+	MType   int64
+	CallID  int64
+	Code    int64
+	Message string
+}
+
+// PromiseGridMessage is the five-element message of x/descriptors.
+type PromiseGridMessage struct {
+	ProtocolTag string
+	ProtocolCID string
+	GridCID     string
+	CwtPayload  AnyMap
+	Signature   []byte
+}
+
+// ExecutableDescriptor describes an embedded executable.
+type ExecutableDescriptor struct {
+	Name        string
+	ContentType string
+	Size        int64
+	Executable  []byte
+	Checksum    []byte
+}
+
+// CommitData is a commit in the x/git-cbor commit stream.
+type CommitData struct {
+	Hash           cid.Cid
+	Tree           cid.Cid
+	Parents        []cid.Cid
+	AuthorName     string
+	AuthorEmail    string
+	AuthorDate     string
+	CommitterName  string
+	CommitterEmail string
+	CommitterDate  string
+	Message        string
+	Trees          []TreeData
+	Blobs          []BlobData
+	Branches       *LinkMap
+	Tags           *LinkMap
+	MergeTag       *string
+	PgpSignature   *string
+	Encoding       *string
+	Raw            []byte
+	TagObjects     []TagData
+	TreeNode       *cid.Cid
+}
+
+// TreeData is a git tree.
+type TreeData struct {
+	Hash    cid.Cid
+	Entries []TreeEntry
+}
+
+// TreeEntry is an entry of a git tree.
+type TreeEntry struct {
+	Mode string
+	Name string
+	Hash cid.Cid
+	Node *cid.Cid
+}
+
+// BlobData is a git blob.
+type BlobData struct {
+	Hash    cid.Cid
+	Content []byte
+}
+
+// TagData is an annotated git tag.
+type TagData struct {
+	Hash         cid.Cid
+	Name         string
+	TaggerName   string
+	TaggerEmail  string
+	TaggerDate   string
+	Message      string
+	PgpSignature *string
+	TargetType   string
+	Target       cid.Cid
+	Raw          []byte
+}
+
+// StringMap is a {String:String} map.
+type StringMap struct {
+	Keys   []string
+	Values map[string]string
+}
+
+// LinkMap is a {String:Link} map.
+type LinkMap struct {
+	Keys   []string
+	Values map[string]cid.Cid
+}
+
+// AnyMap is a {String:Any} map.
+type AnyMap struct {
+	Keys   []string
+	Values map[string]datamodel.Node
+}
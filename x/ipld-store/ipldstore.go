@@ -0,0 +1,309 @@
+// Package ipldstore connects grid Stores to go-ipld-prime.  Storage
+// exposes any grid Store as the read and write storage behind an IPLD
+// LinkSystem, so that States, Functions and Promises can be loaded,
+// traversed, selected and patched with go-ipld-prime tools.  GridStore
+// goes the other way and exposes any IPLD storage as a grid Store.
+//
+// A grid Store keys Atoms by multihash, while IPLD storage keys blocks
+// by CID, which adds a codec to the multihash.  The multihash is the
+// part both agree on: Storage drops the codec, and GridStore stores
+// Atoms under raw CIDs and looks them up under each of Codecs.
+package ipldstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	// Register the codecs grid data is written in.
+	_ "github.com/ipld/go-ipld-prime/codec/dagcbor"
+	_ "github.com/ipld/go-ipld-prime/codec/dagjson"
+	_ "github.com/ipld/go-ipld-prime/codec/raw"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage"
+	"github.com/multiformats/go-multihash"
+	grid "github.com/stevegt/grid-poc"
+)
+
+// Block is an Atom holding one IPLD block, or any other bytes that
+// need no Atom of their own.
+type Block struct {
+	data   []byte
+	hashes map[uint64]multihash.Multihash
+	mru    multihash.Multihash
+}
+
+// NewBlock returns a Block holding data, with no hashes yet.
+func NewBlock(data []byte) *Block {
+	return &Block{data: data, hashes: make(map[uint64]multihash.Multihash)}
+}
+
+// HashMRU returns the most recently computed multihash of the Block.
+func (b *Block) HashMRU() multihash.Multihash {
+	return b.mru
+}
+
+// HashAdd adds and returns the multihash of the Block given a
+// multihash code, or nil if the code is not supported.
+func (b *Block) HashAdd(code uint64) multihash.Multihash {
+	return b.HashAddLength(code, -1)
+}
+
+// HashAddLength is HashAdd with the digest truncated to length bytes,
+// or left whole if length is -1.
+func (b *Block) HashAddLength(code uint64, length int) multihash.Multihash {
+	mh, err := multihash.Sum(b.data, code, length)
+	if err != nil {
+		return nil
+	}
+	b.hashes[code] = mh
+	b.mru = mh
+	return mh
+}
+
+// HashAddName is HashAdd given a multihash name.
+func (b *Block) HashAddName(name string) multihash.Multihash {
+	code, ok := multihash.Names[name]
+	if !ok {
+		return nil
+	}
+	return b.HashAdd(code)
+}
+
+// HashGet returns the multihash of the Block given a multihash code,
+// or nil if it has not been added.
+func (b *Block) HashGet(code uint64) multihash.Multihash {
+	return b.hashes[code]
+}
+
+// HashGetName is HashGet given a multihash name.
+func (b *Block) HashGetName(name string) multihash.Multihash {
+	code, ok := multihash.Names[name]
+	if !ok {
+		return nil
+	}
+	return b.HashGet(code)
+}
+
+// Data returns the block's bytes.
+func (b *Block) Data() []byte {
+	return b.data
+}
+
+// Verify returns a Block holding data, hashed the way mh is, with the
+// same function and digest length.  It returns an error if data does
+// not hash to mh.
+func Verify(data []byte, mh multihash.Multihash) (*Block, error) {
+	dec, err := multihash.Decode(mh)
+	if err != nil {
+		return nil, err
+	}
+	block := NewBlock(data)
+	if !bytes.Equal(block.HashAddLength(dec.Code, dec.Length), mh) {
+		return nil, fmt.Errorf("block does not hash to %s", mh.B58String())
+	}
+	return block, nil
+}
+
+// hashOf returns the most recent multihash of an Atom, computing a
+// sha2-256 one if it has none.
+func hashOf(atom grid.Atom) multihash.Multihash {
+	if mh := atom.HashMRU(); mh != nil {
+		return mh
+	}
+	return atom.HashAdd(multihash.SHA2_256)
+}
+
+// MemStore is an in-memory grid Store keyed by each Atom's most recent
+// multihash.  It is safe for concurrent use.
+type MemStore struct {
+	mu    sync.RWMutex
+	atoms map[string]grid.Atom
+}
+
+// NewMemStore returns an empty MemStore.
+func NewMemStore() *MemStore {
+	return &MemStore{atoms: make(map[string]grid.Atom)}
+}
+
+// Put stores an Atom and returns its multihash.
+func (s *MemStore) Put(atom grid.Atom) multihash.Multihash {
+	mh := hashOf(atom)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.atoms[string(mh)] = atom
+	return mh
+}
+
+// Get returns the Atom with the given multihash, or nil.
+func (s *MemStore) Get(mh multihash.Multihash) grid.Atom {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.atoms[string(mh)]
+}
+
+// Len returns the number of Atoms stored.
+func (s *MemStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.atoms)
+}
+
+// Storage exposes a grid Store as go-ipld-prime storage.  Keys are
+// binary CIDs, as a cidlink LinkSystem uses them.
+type Storage struct {
+	Store grid.Store
+}
+
+var (
+	_ storage.ReadableStorage = (*Storage)(nil)
+	_ storage.WritableStorage = (*Storage)(nil)
+)
+
+// NewLinkSystem returns a cidlink LinkSystem that reads and writes
+// blocks in store.
+func NewLinkSystem(store grid.Store) linking.LinkSystem {
+	s := &Storage{Store: store}
+	ls := cidlink.DefaultLinkSystem()
+	ls.SetReadStorage(s)
+	ls.SetWriteStorage(s)
+	return ls
+}
+
+// keyHash returns the multihash in a binary CID key.
+func keyHash(key string) (multihash.Multihash, error) {
+	_, c, err := cid.CidFromBytes([]byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %w", err)
+	}
+	return c.Hash(), nil
+}
+
+// Has reports whether the store holds the block with the given key.
+func (s *Storage) Has(ctx context.Context, key string) (bool, error) {
+	mh, err := keyHash(key)
+	if err != nil {
+		return false, err
+	}
+	return s.Store.Get(mh) != nil, nil
+}
+
+// Get returns the block with the given key.
+func (s *Storage) Get(ctx context.Context, key string) ([]byte, error) {
+	mh, err := keyHash(key)
+	if err != nil {
+		return nil, err
+	}
+	atom := s.Store.Get(mh)
+	if atom == nil {
+		return nil, fmt.Errorf("block %s: %w", mh.B58String(), os.ErrNotExist)
+	}
+	return atom.Data(), nil
+}
+
+// Put stores a block under the given key.  The block is hashed with
+// the key's hash function and digest length first, so that a Store keyed by each Atom's
+// most recent hash files it where Get will look.
+func (s *Storage) Put(ctx context.Context, key string, content []byte) error {
+	mh, err := keyHash(key)
+	if err != nil {
+		return err
+	}
+	block, err := Verify(bytes.Clone(content), mh)
+	if err != nil {
+		return err
+	}
+	got := s.Store.Put(block)
+	if !bytes.Equal(got, mh) {
+		return fmt.Errorf("store filed block %s under %s", mh.B58String(), got.B58String())
+	}
+	return nil
+}
+
+// Codecs are the codecs GridStore tries, in order, when looking up an
+// Atom by multihash.
+var Codecs = []uint64{cid.Raw, cid.DagCBOR, cid.DagJSON, cid.DagProtobuf}
+
+// GridStore exposes go-ipld-prime storage as a grid Store.  Atoms are
+// stored as raw blocks; lookups also find blocks written through a
+// LinkSystem under any of Codecs.  A grid Store cannot report errors,
+// so the most recent one is kept in Err.
+type GridStore struct {
+	Read  storage.ReadableStorage
+	Write storage.WritableStorage
+
+	mu  sync.Mutex
+	err error
+}
+
+var _ grid.Store = (*GridStore)(nil)
+
+// NewGridStore returns a GridStore that reads from r and writes to w.
+// w may be nil for a read-only store.
+func NewGridStore(r storage.ReadableStorage, w storage.WritableStorage) *GridStore {
+	return &GridStore{Read: r, Write: w}
+}
+
+// setErr records an error.
+func (g *GridStore) setErr(err error) {
+	g.mu.Lock()
+	g.err = err
+	g.mu.Unlock()
+}
+
+// Err returns the most recent error, if any.
+func (g *GridStore) Err() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.err
+}
+
+// Put stores an Atom as a raw block and returns its multihash, or nil
+// on error.
+func (g *GridStore) Put(atom grid.Atom) multihash.Multihash {
+	if g.Write == nil {
+		g.setErr(fmt.Errorf("store is read-only"))
+		return nil
+	}
+	mh := hashOf(atom)
+	key := cid.NewCidV1(cid.Raw, mh).KeyString()
+	err := g.Write.Put(context.Background(), key, atom.Data())
+	if err != nil {
+		g.setErr(err)
+		return nil
+	}
+	return mh
+}
+
+// Get returns the Atom with the given multihash, or nil if there is
+// none.
+func (g *GridStore) Get(mh multihash.Multihash) grid.Atom {
+	ctx := context.Background()
+	for _, codec := range Codecs {
+		key := cid.NewCidV1(codec, mh).KeyString()
+		ok, err := g.Read.Has(ctx, key)
+		if err != nil {
+			g.setErr(err)
+			return nil
+		}
+		if !ok {
+			continue
+		}
+		data, err := g.Read.Get(ctx, key)
+		if err != nil {
+			g.setErr(err)
+			return nil
+		}
+		block, err := Verify(data, mh)
+		if err != nil {
+			g.setErr(err)
+			return nil
+		}
+		return block
+	}
+	return nil
+}
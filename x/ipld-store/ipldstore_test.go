@@ -0,0 +1,215 @@
+package ipldstore
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	selectorbuilder "github.com/ipld/go-ipld-prime/traversal/selector/builder"
+	"github.com/multiformats/go-multihash"
+	grid "github.com/stevegt/grid-poc"
+)
+
+var _ grid.Atom = (*Block)(nil)
+var _ grid.Store = (*MemStore)(nil)
+
+var cborLink = cidlink.LinkPrototype{Prefix: cid.Prefix{
+	Version:  1,
+	Codec:    cid.DagCBOR,
+	MhType:   multihash.SHA2_256,
+	MhLength: -1,
+}}
+
+// storeState stores a map node with a name and links to parents.
+func storeState(t *testing.T, ls *linking.LinkSystem, name string, parents ...ipld.Link) ipld.Link {
+	t.Helper()
+	n, err := qp.BuildMap(basicnode.Prototype.Any, 2, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "name", qp.String(name))
+		qp.MapEntry(ma, "parents", qp.List(int64(len(parents)), func(la datamodel.ListAssembler) {
+			for _, p := range parents {
+				qp.ListEntry(la, qp.Link(p))
+			}
+		}))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lnk, err := ls.Store(linking.LinkContext{}, cborLink, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lnk
+}
+
+func TestLinkSystemOverGridStore(t *testing.T) {
+	store := NewMemStore()
+	ls := NewLinkSystem(store)
+
+	a := storeState(t, &ls, "a")
+	b := storeState(t, &ls, "b", a)
+	c := storeState(t, &ls, "c", a, b)
+	if store.Len() != 3 {
+		t.Fatalf("got %d atoms, want 3", store.Len())
+	}
+
+	// The Atoms are keyed by the links' multihashes.
+	atom := store.Get(c.(cidlink.Link).Hash())
+	if atom == nil {
+		t.Fatal("state c not in store")
+	}
+	n, err := ls.Load(linking.LinkContext{}, c, basicnode.Prototype.Any)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, _ := n.LookupByString("name")
+	if s, _ := name.AsString(); s != "c" {
+		t.Fatalf("got name %q", s)
+	}
+
+	// Select every name reachable from c, following links.
+	ssb := selectorbuilder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+	spec := ssb.ExploreRecursive(selector.RecursionLimitNone(),
+		ssb.ExploreFields(func(efsb selectorbuilder.ExploreFieldsSpecBuilder) {
+			efsb.Insert("name", ssb.Matcher())
+			efsb.Insert("parents", ssb.ExploreAll(ssb.ExploreRecursiveEdge()))
+		}))
+	sel, err := spec.Selector()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]int)
+	err = traversal.Progress{Cfg: &traversal.Config{
+		LinkSystem:                     ls,
+		LinkTargetNodePrototypeChooser: basicnode.Chooser,
+	}}.WalkMatching(n, sel, func(p traversal.Progress, n datamodel.Node) error {
+		s, err := n.AsString()
+		names[s]++
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names["a"] != 2 || names["b"] != 1 || names["c"] != 1 {
+		t.Fatalf("unexpected names: %v", names)
+	}
+
+	// A missing block is an error, not a panic.
+	missing, _ := cborLink.Prefix.Sum([]byte("nope"))
+	_, err = ls.Load(linking.LinkContext{}, cidlink.Link{Cid: missing}, basicnode.Prototype.Any)
+	if err == nil {
+		t.Fatal("expected error loading a missing block")
+	}
+}
+
+func TestGridStoreOverStorage(t *testing.T) {
+	mem := &memstore.Store{}
+	g := NewGridStore(mem, mem)
+
+	atom := NewBlock([]byte("hello"))
+	mh := g.Put(atom)
+	if mh == nil {
+		t.Fatal(g.Err())
+	}
+	got := g.Get(mh)
+	if got == nil || !bytes.Equal(got.Data(), []byte("hello")) {
+		t.Fatalf("got %v", got)
+	}
+	if g.Get(NewBlock([]byte("other")).HashAdd(multihash.SHA2_256)) != nil {
+		t.Fatal("found a block that was never stored")
+	}
+
+	// Blocks written through a LinkSystem are found by multihash.
+	ls := cidlink.DefaultLinkSystem()
+	ls.SetReadStorage(mem)
+	ls.SetWriteStorage(mem)
+	lnk := storeState(t, &ls, "a")
+	got = g.Get(lnk.(cidlink.Link).Hash())
+	if got == nil {
+		t.Fatal("dag-cbor block not found")
+	}
+
+	// Round trip: a LinkSystem over the GridStore over the storage.
+	ls2 := NewLinkSystem(g)
+	n, err := ls2.Load(linking.LinkContext{}, lnk, basicnode.Prototype.Any)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, _ := n.LookupByString("name")
+	if s, _ := name.AsString(); s != "a" {
+		t.Fatalf("got name %q", s)
+	}
+
+	// A read-only GridStore refuses writes.
+	ro := NewGridStore(mem, nil)
+	if ro.Put(NewBlock([]byte("x"))) != nil || ro.Err() == nil {
+		t.Fatal("expected read-only error")
+	}
+}
+
+func TestStorageRejectsMismatch(t *testing.T) {
+	ctx := context.Background()
+	s := &Storage{Store: NewMemStore()}
+	c, _ := cborLink.Prefix.Sum([]byte("right"))
+	err := s.Put(ctx, c.KeyString(), []byte("wrong"))
+	if err == nil {
+		t.Fatal("expected hash mismatch error")
+	}
+	ok, err := s.Has(ctx, c.KeyString())
+	if err != nil || ok {
+		t.Fatalf("Has = %v, %v", ok, err)
+	}
+}
+
+// TestTruncatedHash round-trips a block whose link truncates its
+// digest, through a LinkSystem over a grid Store and through a
+// GridStore over storage.
+func TestTruncatedHash(t *testing.T) {
+	truncated := cidlink.LinkPrototype{Prefix: cid.Prefix{
+		Version:  1,
+		Codec:    cid.DagCBOR,
+		MhType:   multihash.SHA2_512,
+		MhLength: 32,
+	}}
+	n, err := qp.BuildMap(basicnode.Prototype.Any, 1, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "name", qp.String("short"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mem := &memstore.Store{}
+	for _, store := range []grid.Store{NewMemStore(), NewGridStore(mem, mem)} {
+		ls := NewLinkSystem(store)
+		lnk, err := ls.Store(linking.LinkContext{}, truncated, n)
+		if err != nil {
+			t.Fatalf("%T: %v", store, err)
+		}
+		mh := lnk.(cidlink.Link).Hash()
+		dec, err := multihash.Decode(mh)
+		if err != nil || dec.Length != 32 {
+			t.Fatalf("%T: link %s is not truncated", store, lnk)
+		}
+		got, err := ls.Load(linking.LinkContext{}, lnk, basicnode.Prototype.Any)
+		if err != nil {
+			t.Fatalf("%T: %v", store, err)
+		}
+		name, _ := got.LookupByString("name")
+		if s, _ := name.AsString(); s != "short" {
+			t.Fatalf("%T: got name %q", store, s)
+		}
+		atom := store.Get(mh)
+		if atom == nil || !bytes.Equal(atom.HashMRU(), mh) {
+			t.Fatalf("%T: atom not found under %s", store, mh.B58String())
+		}
+	}
+}
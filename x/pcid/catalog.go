@@ -0,0 +1,179 @@
+package pcid
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+)
+
+// ErrNotFound is returned when a catalog has no specification for a
+// pCID.
+var ErrNotFound = errors.New("pCID not in catalog")
+
+// Catalog is a local directory of specification documents, one file
+// per document named by its pCID.
+type Catalog struct {
+	Dir string
+}
+
+// Entry describes a catalogued specification.
+type Entry struct {
+	PCID cid.Cid
+	// Title is the document's first non-blank line, without markdown
+	// heading marks, or a canonical specification's title or name.
+	Title string
+	Size  int
+}
+
+// Add stores a specification document and returns its pCID.  Adding a
+// document already in the catalog is harmless.
+func (c *Catalog) Add(doc []byte) (cid.Cid, error) {
+	p, err := FromSpec(doc)
+	if err != nil {
+		return cid.Undef, err
+	}
+	err = c.Put(p, doc)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return p, nil
+}
+
+// Put stores spec under the declared pCID p, after verifying it, so
+// that canonical specifications can be catalogued by their DAG-CBOR
+// pCIDs.
+func (c *Catalog) Put(p cid.Cid, spec []byte) error {
+	err := Verify(p, spec)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(c.Dir, 0755)
+	if err != nil {
+		return err
+	}
+	// Write then rename, so that a reader never sees part of a file.
+	tmp, err := os.CreateTemp(c.Dir, ".add-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(spec)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path(p))
+}
+
+// Get returns the specification for pCID p, verified against p.
+func (c *Catalog) Get(p cid.Cid) ([]byte, error) {
+	spec, err := os.ReadFile(c.path(p))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", p, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = Verify(p, spec)
+	if err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// List returns the catalog's entries in pCID order.  Files that are
+// not named by a pCID, or whose content does not match their name, are
+// skipped.
+func (c *Catalog) List() ([]Entry, error) {
+	files, err := os.ReadDir(c.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		p, err := cid.Decode(f.Name())
+		if err != nil {
+			continue
+		}
+		spec, err := c.Get(p)
+		if errors.Is(err, ErrMismatch) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		e := Entry{PCID: p, Size: len(spec)}
+		if p.Type() == cid.DagCBOR {
+			e.Title = nodeTitle(spec)
+		} else {
+			e.Title = Title(spec)
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].PCID.String() < entries[j].PCID.String()
+	})
+	return entries, nil
+}
+
+func (c *Catalog) path(p cid.Cid) string {
+	return filepath.Join(c.Dir, p.String())
+}
+
+// nodeTitle returns the "title" or else "name" field of a canonical
+// specification, or "" if it has neither.
+func nodeTitle(spec []byte) string {
+	nb := basicnode.Prototype.Any.NewBuilder()
+	err := dagcbor.Decode(nb, bytes.NewReader(spec))
+	if err != nil {
+		return ""
+	}
+	n := nb.Build()
+	for _, key := range []string{"title", "name"} {
+		v, err := n.LookupByString(key)
+		if err != nil {
+			continue
+		}
+		s, err := v.AsString()
+		if err == nil {
+			return s
+		}
+	}
+	return ""
+}
+
+// Title returns a document's first non-blank line, without markdown
+// heading marks, or "" if the document is not text.
+func Title(doc []byte) string {
+	if bytes.IndexByte(doc, 0) >= 0 {
+		return ""
+	}
+	sc := bufio.NewScanner(bytes.NewReader(doc))
+	for sc.Scan() {
+		line := strings.TrimSpace(strings.TrimLeft(sc.Text(), "#"))
+		if line != "" {
+			return line
+		}
+	}
+	return ""
+}
@@ -0,0 +1,82 @@
+package pcid
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+)
+
+func TestCatalog(t *testing.T) {
+	c := &Catalog{Dir: filepath.Join(t.TempDir(), "catalog")}
+	entries, err := c.List()
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("new catalog: %v, %v", entries, err)
+	}
+
+	p, err := c.Add([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := c.Add([]byte(doc)); err != nil || !again.Equals(p) {
+		t.Fatalf("re-add: %s, %v", again, err)
+	}
+	got, err := c.Get(p)
+	if err != nil || string(got) != doc {
+		t.Fatalf("get: %q, %v", got, err)
+	}
+
+	n := specNode(t, 1)
+	np, err := FromNode(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := dagcbor.Encode(n, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put(np, buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put(np, []byte(doc)); !errors.Is(err, ErrMismatch) {
+		t.Fatalf("put under wrong pCID: %v", err)
+	}
+
+	// Stray and tampered files are not listed.
+	if err := os.WriteFile(filepath.Join(c.Dir, "README"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tampered := MustFromSpec([]byte("# Tampered\n"))
+	if err := os.WriteFile(filepath.Join(c.Dir, tampered.String()), []byte("# Changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(tampered); !errors.Is(err, ErrMismatch) {
+		t.Fatalf("get tampered: %v", err)
+	}
+
+	entries, err = c.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	titles := make(map[string]string)
+	for _, e := range entries {
+		titles[e.PCID.String()] = e.Title
+	}
+	want := map[string]string{p.String(): "Hello protocol", np.String(): "hello"}
+	if len(titles) != len(want) {
+		t.Fatalf("entries %v, want %v", titles, want)
+	}
+	for k, v := range want {
+		if titles[k] != v {
+			t.Fatalf("title of %s is %q, want %q", k, titles[k], v)
+		}
+	}
+
+	_, err = c.Get(MustFromSpec([]byte("absent")))
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("get absent: %v", err)
+	}
+}
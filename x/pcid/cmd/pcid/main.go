@@ -0,0 +1,206 @@
+// Command pcid computes protocol CIDs from specification files,
+// verifies declared pCIDs against them, and keeps a local catalog of
+// specifications by pCID.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/stevegt/grid-poc/x/pcid"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <subcommand> [options]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Subcommands:\n")
+	fmt.Fprintf(os.Stderr, "  compute [-dagjson] <spec>...\n")
+	fmt.Fprintf(os.Stderr, "  verify [-dagjson] <pcid> <spec>\n")
+	fmt.Fprintf(os.Stderr, "  add [-d dir] [-dagjson] <spec>...\n")
+	fmt.Fprintf(os.Stderr, "  ls [-d dir]\n")
+	fmt.Fprintf(os.Stderr, "  show [-d dir] <pcid>\n")
+	fmt.Fprintf(os.Stderr, "The catalog dir defaults to $GRID_CATALOG, or ~/.grid/catalog.\n")
+	os.Exit(1)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch os.Args[1] {
+	case "compute":
+		err = compute(os.Args[2:])
+	case "verify":
+		err = verify(os.Args[2:])
+	case "add":
+		err = add(os.Args[2:])
+	case "ls":
+		err = ls(os.Args[2:])
+	case "show":
+		err = show(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// readSpec reads a specification file.  With canonical set, the file
+// is DAG-JSON, and the specification is its DAG-CBOR encoding, so
+// that reformatting the file does not change its pCID.
+func readSpec(path string, canonical bool) (cid.Cid, []byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	if !canonical {
+		p, err := pcid.FromSpec(data)
+		return p, data, err
+	}
+	nb := basicnode.Prototype.Any.NewBuilder()
+	err = dagjson.Decode(nb, bytes.NewReader(data))
+	if err != nil {
+		return cid.Undef, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	n := nb.Build()
+	p, err := pcid.FromNode(n)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	var buf bytes.Buffer
+	err = dagcbor.Encode(n, &buf)
+	return p, buf.Bytes(), err
+}
+
+func compute(args []string) error {
+	fs := flag.NewFlagSet("compute", flag.ExitOnError)
+	canonical := fs.Bool("dagjson", false, "specs are DAG-JSON, hashed as DAG-CBOR")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		usage()
+	}
+	for _, path := range fs.Args() {
+		p, _, err := readSpec(path, *canonical)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s  %s\n", p, path)
+	}
+	return nil
+}
+
+func verify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	canonical := fs.Bool("dagjson", false, "spec is DAG-JSON, hashed as DAG-CBOR")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		usage()
+	}
+	declared, err := cid.Decode(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	_, spec, err := readSpec(fs.Arg(1), *canonical)
+	if err != nil {
+		return err
+	}
+	err = pcid.Verify(declared, spec)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s matches %s\n", declared, fs.Arg(1))
+	return nil
+}
+
+// catalogFlags adds the catalog dir flag to fs.
+func catalogFlags(fs *flag.FlagSet) *pcid.Catalog {
+	dir := os.Getenv("GRID_CATALOG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err == nil {
+			dir = filepath.Join(home, ".grid", "catalog")
+		}
+	}
+	c := &pcid.Catalog{}
+	fs.StringVar(&c.Dir, "d", dir, "catalog dir")
+	return c
+}
+
+func add(args []string) error {
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	c := catalogFlags(fs)
+	canonical := fs.Bool("dagjson", false, "specs are DAG-JSON, stored as DAG-CBOR")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		usage()
+	}
+	for _, path := range fs.Args() {
+		p, spec, err := readSpec(path, *canonical)
+		if err != nil {
+			return err
+		}
+		err = c.Put(p, spec)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s  %s\n", p, path)
+	}
+	return nil
+}
+
+func ls(args []string) error {
+	fs := flag.NewFlagSet("ls", flag.ExitOnError)
+	c := catalogFlags(fs)
+	fs.Parse(args)
+	entries, err := c.List()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		fmt.Printf("%s  %7d  %s\n", e.PCID, e.Size, e.Title)
+	}
+	return nil
+}
+
+func show(args []string) error {
+	fs := flag.NewFlagSet("show", flag.ExitOnError)
+	c := catalogFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+	p, err := cid.Decode(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	spec, err := c.Get(p)
+	if errors.Is(err, pcid.ErrNotFound) {
+		return fmt.Errorf("%w; add it with %s add", err, os.Args[0])
+	}
+	if err != nil {
+		return err
+	}
+	if p.Type() == cid.DagCBOR {
+		// Canonical specifications are shown as DAG-JSON.
+		nb := basicnode.Prototype.Any.NewBuilder()
+		err = dagcbor.Decode(nb, bytes.NewReader(spec))
+		if err != nil {
+			return err
+		}
+		err = dagjson.Encode(nb.Build(), os.Stdout)
+		fmt.Println()
+		return err
+	}
+	_, err = os.Stdout.Write(spec)
+	return err
+}
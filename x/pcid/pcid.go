@@ -0,0 +1,107 @@
+// Package pcid computes protocol CIDs.  As x/wire/wire.md puts it, a
+// pCID is the content hash of the protocol's specification document,
+// or of a canonical representation of it, so protocols need no shared
+// numbering authority.
+//
+// FromSpec hashes a specification document as it is shipped, making a
+// raw CIDv1; FromNode hashes an IPLD node as canonical DAG-CBOR.
+// Verify checks a handler's declared pCID against the specification it
+// ships, and a Catalog keeps specifications on disk by pCID.
+package pcid
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/multiformats/go-multihash"
+)
+
+// ErrMismatch is returned when a declared pCID is not the CID of the
+// specification it is declared for.
+var ErrMismatch = errors.New("pCID does not match specification")
+
+// SpecPrefix is the prefix of pCIDs of specification documents.
+var SpecPrefix = cid.Prefix{
+	Version:  1,
+	Codec:    cid.Raw,
+	MhType:   multihash.SHA2_256,
+	MhLength: -1,
+}
+
+// NodePrefix is the prefix of pCIDs of canonical specifications.
+var NodePrefix = cid.Prefix{
+	Version:  1,
+	Codec:    cid.DagCBOR,
+	MhType:   multihash.SHA2_256,
+	MhLength: -1,
+}
+
+// FromSpec returns the pCID of a specification document.
+func FromSpec(doc []byte) (cid.Cid, error) {
+	return SpecPrefix.Sum(doc)
+}
+
+// MustFromSpec is like FromSpec but panics on error.  It is meant for
+// package-level pCIDs of embedded specifications.
+func MustFromSpec(doc []byte) cid.Cid {
+	c, err := FromSpec(doc)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// FromFile returns the pCID of the specification document in a file.
+func FromFile(path string) (cid.Cid, error) {
+	doc, err := os.ReadFile(path)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return FromSpec(doc)
+}
+
+// FromNode returns the pCID of a canonical specification, an IPLD node
+// encoded as DAG-CBOR.
+func FromNode(n datamodel.Node) (cid.Cid, error) {
+	var buf bytes.Buffer
+	err := dagcbor.Encode(n, &buf)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return NodePrefix.Sum(buf.Bytes())
+}
+
+// Verify checks that declared is the CID of spec, hashing spec as
+// declared's prefix says, so that pCIDs of documents and of canonical
+// DAG-CBOR specifications can both be checked.
+func Verify(declared cid.Cid, spec []byte) error {
+	if !declared.Defined() {
+		return fmt.Errorf("undefined pCID: %w", ErrMismatch)
+	}
+	c, err := declared.Prefix().Sum(spec)
+	if err != nil {
+		return err
+	}
+	if !c.Equals(declared) {
+		return fmt.Errorf("declared %s, specification is %s: %w", declared, c, ErrMismatch)
+	}
+	return nil
+}
+
+// Protocol is implemented by protocol handlers that declare their pCID
+// and ship their specification.
+type Protocol interface {
+	PCID() cid.Cid
+	Spec() []byte
+}
+
+// Check verifies a protocol handler's declared pCID against its
+// specification.
+func Check(p Protocol) error {
+	return Verify(p.PCID(), p.Spec())
+}
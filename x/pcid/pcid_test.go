@@ -0,0 +1,105 @@
+package pcid
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+)
+
+const doc = "# Hello protocol\n\nPayloads are UTF-8 text.\n"
+
+func TestFromSpec(t *testing.T) {
+	// The CID of no bytes is well known.
+	p, err := FromSpec(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.String() != "bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku" {
+		t.Fatalf("empty spec pCID %s", p)
+	}
+
+	p = MustFromSpec([]byte(doc))
+	if p.Type() != cid.Raw || p.Version() != 1 {
+		t.Fatalf("pCID %s is not a raw CIDv1", p)
+	}
+	path := filepath.Join(t.TempDir(), "hello.md")
+	err = os.WriteFile(path, []byte(doc), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := FromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.Equals(p) {
+		t.Fatalf("FromFile %s, FromSpec %s", f, p)
+	}
+	if _, err := FromFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("missing file gave no error")
+	}
+}
+
+func specNode(t *testing.T, version int64) datamodel.Node {
+	t.Helper()
+	n, err := qp.BuildMap(basicnode.Prototype.Map, 2, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "title", qp.String("hello"))
+		qp.MapEntry(ma, "version", qp.Int(version))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestFromNode(t *testing.T) {
+	a, err := FromNode(specNode(t, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Type() != cid.DagCBOR {
+		t.Fatalf("pCID %s is not DAG-CBOR", a)
+	}
+	b, err := FromNode(specNode(t, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.Equals(b) {
+		t.Fatalf("equal specs gave %s and %s", a, b)
+	}
+	c, err := FromNode(specNode(t, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Equals(c) {
+		t.Fatal("different specs gave the same pCID")
+	}
+}
+
+type protocol struct {
+	pcid cid.Cid
+	spec []byte
+}
+
+func (p protocol) PCID() cid.Cid { return p.pcid }
+func (p protocol) Spec() []byte  { return p.spec }
+
+func TestVerify(t *testing.T) {
+	p := MustFromSpec([]byte(doc))
+	if err := Check(protocol{p, []byte(doc)}); err != nil {
+		t.Fatal(err)
+	}
+	err := Check(protocol{p, []byte(doc + "edited\n")})
+	if !errors.Is(err, ErrMismatch) {
+		t.Fatalf("edited spec: %v", err)
+	}
+	err = Verify(cid.Undef, []byte(doc))
+	if !errors.Is(err, ErrMismatch) {
+		t.Fatalf("undefined pCID: %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package hello1
 
 import (
 	"context"
+	_ "embed"
 	"fmt"
 	"log"
 	"strings"
@@ -13,7 +14,14 @@ import (
 	"github.com/ipfs/go-cid"
 )
 
-const helloProtocolStr = "bafkreibm6jg3ux5qumhcn2b3flc3tyu6dmlb4xa7u5bf44ydelk6a2mhny"
+//go:embed hello1.md
+var helloSpec []byte
+
+// helloCid is the hello1 protocol's pCID, the CID of its specification
+// as `pcid compute hello1/hello1.md` prints it.  It is pinned rather
+// than computed: an edit to hello1.md makes a different protocol, and
+// registration fails until the pCID is updated to match.
+var helloCid = cid.MustParse("bafkreibaae5ze5w6dkc3bzsgmwace3rlo5vhqvibzltszkqe6ebclgyply")
 
 // Agent represents a consolidated hello1 agent.
 type Agent struct {
@@ -32,18 +40,21 @@ func NewAgent(k *kernel.Kernel, name string) *Agent {
 	}
 }
 
+// PCID returns the hello1 protocol's pCID.
+func (a *Agent) PCID() cid.Cid {
+	return helloCid
+}
+
+// Spec returns the hello1 protocol's specification.
+func (a *Agent) Spec() []byte {
+	return helloSpec
+}
+
 // Run starts the hello1 agent, registering the hello protocol and sending
 // hello messages every second.
 func (a *Agent) Run(ctx context.Context) {
-	helloCid, err := cid.Decode(helloProtocolStr)
-	if err != nil {
-		log.Printf("Agent %s: invalid hello protocol CID: %v",
-			a.agentName, err)
-		return
-	}
-
 	// Register the hello protocol to receive and respond to messages.
-	a.k.Register(helloCid, func(msg wire.Message) {
+	err := a.k.RegisterProtocol(a, func(msg wire.Message) {
 		text := string(msg.Payload)
 		fmt.Printf("Agent %s received: %s\n", a.agentName, text)
 		if strings.HasPrefix(text, "hello from ") {
@@ -65,6 +76,11 @@ func (a *Agent) Run(ctx context.Context) {
 			}
 		}
 	})
+	if err != nil {
+		log.Printf("Agent %s: cannot register hello protocol: %v",
+			a.agentName, err)
+		return
+	}
 
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
@@ -0,0 +1,32 @@
+package hello1
+
+import (
+	"errors"
+	"testing"
+
+	"sim1/kernel"
+	"sim1/wire"
+
+	"github.com/stevegt/grid-poc/x/pcid"
+)
+
+// TestSpecEdit checks that the pinned pCID matches the shipped spec,
+// and that registration fails once the spec is edited.
+func TestSpecEdit(t *testing.T) {
+	k := kernel.NewKernel()
+	defer k.Stop()
+	a := NewAgent(k, "agent1")
+	handler := func(wire.Message) {}
+	err := k.RegisterProtocol(a, handler)
+	if err != nil {
+		t.Fatalf("shipped spec: %v", err)
+	}
+
+	orig := helloSpec
+	defer func() { helloSpec = orig }()
+	helloSpec = append(append([]byte(nil), orig...), "\nAn edit.\n"...)
+	err = k.RegisterProtocol(a, handler)
+	if !errors.Is(err, pcid.ErrMismatch) {
+		t.Errorf("edited spec: got %v, want %v", err, pcid.ErrMismatch)
+	}
+}
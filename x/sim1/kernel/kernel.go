@@ -11,6 +11,7 @@ import (
 	"sim1/wire"
 
 	"github.com/ipfs/go-cid"
+	"github.com/stevegt/grid-poc/x/pcid"
 )
 
 // Agent defines the interface that each agent must implement.
@@ -169,6 +170,18 @@ func (k *Kernel) Register(protocol cid.Cid, handler func(wire.Message)) {
 	k.subscriptions[protocol.String()] = handler
 }
 
+// RegisterProtocol registers handler for the pCID a protocol declares,
+// after verifying that it is the CID of the specification the protocol
+// ships.
+func (k *Kernel) RegisterProtocol(p pcid.Protocol, handler func(wire.Message)) error {
+	err := pcid.Check(p)
+	if err != nil {
+		return err
+	}
+	k.Register(p.PCID(), handler)
+	return nil
+}
+
 func (k *Kernel) Deregister(protocol cid.Cid) {
 	k.mu.Lock()
 	defer k.mu.Unlock()
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Kernel is the exchange that matches orders from buyers and sellers.
+// It carries over the sim3.5 matching rules -- a BID matches an ASK
+// with the same Symbol, GoodSymbol and GoodQty whose Amount is no
+// higher than the bid, and the trade executes at the bid price as a
+// balanced double-entry swap of personal currencies -- but runs
+// inside a Sim: confirmations travel back to agents through the
+// latency model, the seller's delivery of goods is a promise that
+// settles later, and every trade is recorded in the Metrics.
+type Kernel struct {
+	sim                *Sim
+	agents             map[string]*Agent
+	bids               []Message
+	asks               []Message
+	personalCurrencies map[string]bool
+}
+
+// NewKernel creates a new Kernel (exchange) for the given simulation.
+func NewKernel(s *Sim) *Kernel {
+	return &Kernel{
+		sim:                s,
+		agents:             make(map[string]*Agent),
+		personalCurrencies: make(map[string]bool),
+	}
+}
+
+// RegisterAgent registers an agent with the exchange. It enforces that
+// each agent's personal currency is unique.
+func (k *Kernel) RegisterAgent(agent *Agent) error {
+	if k.personalCurrencies[agent.PersonalCurrency] {
+		return fmt.Errorf("personal currency %s already registered",
+			agent.PersonalCurrency)
+	}
+	k.personalCurrencies[agent.PersonalCurrency] = true
+	k.agents[agent.ID] = agent
+	return nil
+}
+
+// Bids returns a copy of the resting bid orders in arrival order.
+func (k *Kernel) Bids() []Message {
+	return append([]Message(nil), k.bids...)
+}
+
+// Asks returns a copy of the resting ask orders in arrival order.
+func (k *Kernel) Asks() []Message {
+	return append([]Message(nil), k.asks...)
+}
+
+// SubmitOrder processes an order (BID or ASK) that has arrived at the
+// exchange.  The order is matched against the opposite side of the
+// book in arrival order; if nothing matches it rests in the book until
+// it is matched or its TTL expires.  Invalid orders are counted as
+// rejected.
+func (k *Kernel) SubmitOrder(order Message) {
+	s := k.sim
+	if order.GoodSymbol == "" || order.GoodQty == 0 || k.agents[order.From] == nil {
+		s.metrics.OrdersRejected++
+		return
+	}
+
+	switch order.Type {
+	case "BID":
+		for _, ask := range k.asks {
+			if matches(order, ask) {
+				k.removeAskOrder(ask.OrderID)
+				k.execute(order, ask)
+				return
+			}
+		}
+		k.bids = append(k.bids, order)
+	case "ASK":
+		for _, bid := range k.bids {
+			if matches(bid, order) {
+				k.removeBidOrder(bid.OrderID)
+				k.execute(bid, order)
+				return
+			}
+		}
+		k.asks = append(k.asks, order)
+	default:
+		s.metrics.OrdersRejected++
+		return
+	}
+
+	if order.TTL > 0 {
+		s.Schedule(order.TTL, func() {
+			if k.removeBidOrder(order.OrderID) || k.removeAskOrder(order.OrderID) {
+				s.metrics.OrdersExpired++
+			}
+		})
+	}
+}
+
+// matches reports whether a bid and an ask can trade.
+func matches(bid, ask Message) bool {
+	return bid.Symbol == ask.Symbol &&
+		bid.GoodSymbol == ask.GoodSymbol &&
+		bid.GoodQty == ask.GoodQty &&
+		bid.Amount >= ask.Amount &&
+		bid.From != ask.From
+}
+
+// execute performs the double-entry bookkeeping for a matched pair of
+// orders, records the trade, sends CONFIRM messages to both parties
+// and schedules settlement of the seller's promise to deliver goods.
+func (k *Kernel) execute(bid, ask Message) {
+	s := k.sim
+	buyer := k.agents[bid.From]
+	seller := k.agents[ask.From]
+	tradePrice := bid.Amount
+
+	// For the buyer:
+	//   Debit asset: seller's personal currency.
+	//   Credit liability: buyer's personal currency.
+	buyer.Assets[ask.Symbol] += tradePrice
+	buyer.Liabilities[buyer.PersonalCurrency] += tradePrice
+	// For the seller:
+	//   Debit asset: buyer's personal currency.
+	//   Credit liability: seller's personal currency.
+	seller.Assets[buyer.PersonalCurrency] += tradePrice
+	seller.Liabilities[seller.PersonalCurrency] += tradePrice
+
+	trade := Trade{
+		Time:   Duration(s.Now),
+		Buyer:  buyer.ID,
+		Seller: seller.ID,
+		BidID:  bid.OrderID,
+		AskID:  ask.OrderID,
+		Symbol: ask.Symbol,
+		Good:   ask.GoodSymbol,
+		Qty:    ask.GoodQty,
+		Amount: tradePrice,
+		Price:  tradePrice / ask.GoodQty,
+	}
+	s.metrics.recordTrade(s, trade)
+
+	confirmMsg := Message{
+		Type:       "CONFIRM",
+		Amount:     tradePrice,
+		Symbol:     ask.Symbol,
+		From:       ExchangeID,
+		GoodSymbol: ask.GoodSymbol,
+		GoodQty:    ask.GoodQty,
+	}
+	for _, party := range []struct {
+		agent   *Agent
+		orderID string
+	}{{buyer, bid.OrderID}, {seller, ask.OrderID}} {
+		agent := party.agent
+		msg := confirmMsg
+		msg.OrderID = party.orderID
+		s.send(ExchangeID, agent.ID, func() {
+			agent.Strategy.Confirm(s, agent, msg)
+		})
+	}
+
+	// The seller has promised to deliver the goods.  Whether the
+	// promise is kept is decided when settlement comes due; a seller
+	// who no longer holds the goods breaks it.
+	s.Schedule(s.settlement, func() {
+		kept := s.Rand.Float64() < seller.Reliability
+		kept = kept && seller.Goods[trade.Good] >= trade.Qty
+		if kept {
+			buyer.Goods[trade.Good] += trade.Qty
+			seller.Goods[trade.Good] -= trade.Qty
+		}
+		s.metrics.recordPromise(s, trade, kept)
+	})
+}
+
+// removeBidOrder removes a bid order from the order book identified by
+// the given order ID.  It reports whether the order was found.
+func (k *Kernel) removeBidOrder(orderID string) bool {
+	for i, bid := range k.bids {
+		if bid.OrderID == orderID {
+			k.bids = append(k.bids[:i], k.bids[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// removeAskOrder removes an ask order from the order book identified by
+// the given order ID.  It reports whether the order was found.
+func (k *Kernel) removeAskOrder(orderID string) bool {
+	for i, ask := range k.asks {
+		if ask.OrderID == orderID {
+			k.asks = append(k.asks[:i], k.asks[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,3 @@
+module sim4
+
+go 1.24.0
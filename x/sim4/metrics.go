@@ -0,0 +1,143 @@
+package main
+
+// Metrics is the structured result of a simulation run.  It is plain
+// data so that tests can compare runs and main can print it as JSON.
+type Metrics struct {
+	Scenario string `json:"scenario,omitempty"`
+	Seed     int64  `json:"seed"`
+	// Elapsed is the virtual time of the last event processed.
+	Elapsed Duration `json:"elapsed"`
+
+	OrdersSubmitted int `json:"orders_submitted"`
+	OrdersRejected  int `json:"orders_rejected"`
+	OrdersExpired   int `json:"orders_expired"`
+	// OrdersResting is the number of orders left in the book when
+	// the run ended.
+	OrdersResting int `json:"orders_resting"`
+
+	Trades []Trade `json:"trades"`
+	// Volume is the total trade amount across all trades.
+	Volume float64 `json:"volume"`
+	// GoodsVolume is the total quantity traded for each good.
+	GoodsVolume map[string]float64 `json:"goods_volume"`
+	// Prices is the series of unit prices for each good, one point
+	// per trade.
+	Prices map[string][]PricePoint `json:"prices"`
+
+	PromisesKept   int       `json:"promises_kept"`
+	PromisesFailed int       `json:"promises_failed"`
+	FailedPromises []Promise `json:"failed_promises"`
+	// PromisesPending is the number of trades whose settlement had
+	// not come due when the run ended.
+	PromisesPending int `json:"promises_pending"`
+
+	// Equity is each agent's equity at the end of the run.
+	Equity map[string]float64 `json:"equity"`
+	// EquitySeries is each agent's equity after every trade or
+	// settlement that involved it.
+	EquitySeries map[string][]EquityPoint `json:"equity_series"`
+
+	lastPrice map[string]float64
+}
+
+// Trade is a matched pair of orders.
+type Trade struct {
+	Time   Duration `json:"time"`
+	Buyer  string   `json:"buyer"`
+	Seller string   `json:"seller"`
+	BidID  string   `json:"bid_id"`
+	AskID  string   `json:"ask_id"`
+	Symbol string   `json:"symbol"`
+	Good   string   `json:"good"`
+	Qty    float64  `json:"qty"`
+	Amount float64  `json:"amount"`
+	// Price is the unit price of the good, Amount / Qty.
+	Price float64 `json:"price"`
+}
+
+// PricePoint is one observation in a price series.
+type PricePoint struct {
+	Time  Duration `json:"time"`
+	Price float64  `json:"price"`
+	Qty   float64  `json:"qty"`
+}
+
+// Promise is a seller's obligation to deliver the goods of a trade.
+type Promise struct {
+	Due    Duration `json:"due"`
+	Seller string   `json:"seller"`
+	Buyer  string   `json:"buyer"`
+	AskID  string   `json:"ask_id"`
+	Good   string   `json:"good"`
+	Qty    float64  `json:"qty"`
+}
+
+// EquityPoint is one observation in an agent's equity series.
+type EquityPoint struct {
+	Time   Duration `json:"time"`
+	Equity float64  `json:"equity"`
+}
+
+// newMetrics creates an empty Metrics for a run with the given seed.
+func newMetrics(seed int64) *Metrics {
+	return &Metrics{
+		Seed:         seed,
+		Trades:       []Trade{},
+		GoodsVolume:  make(map[string]float64),
+		Prices:       make(map[string][]PricePoint),
+		Equity:       make(map[string]float64),
+		EquitySeries: make(map[string][]EquityPoint),
+		lastPrice:    make(map[string]float64),
+	}
+}
+
+// recordTrade adds a trade to the trade log, volume and price series.
+func (m *Metrics) recordTrade(s *Sim, t Trade) {
+	m.Trades = append(m.Trades, t)
+	m.Volume += t.Amount
+	m.GoodsVolume[t.Good] += t.Qty
+	m.Prices[t.Good] = append(m.Prices[t.Good],
+		PricePoint{Time: t.Time, Price: t.Price, Qty: t.Qty})
+	m.lastPrice[t.Good] = t.Price
+	m.sampleEquity(s, t.Buyer, t.Seller)
+}
+
+// recordPromise records the settlement of the promise made by a
+// trade.
+func (m *Metrics) recordPromise(s *Sim, t Trade, kept bool) {
+	if kept {
+		m.PromisesKept++
+	} else {
+		m.PromisesFailed++
+		m.FailedPromises = append(m.FailedPromises, Promise{
+			Due:    Duration(s.Now),
+			Seller: t.Seller,
+			Buyer:  t.Buyer,
+			AskID:  t.AskID,
+			Good:   t.Good,
+			Qty:    t.Qty,
+		})
+	}
+	m.sampleEquity(s, t.Buyer, t.Seller)
+}
+
+// sampleEquity appends the current equity of the given agents to
+// their equity series.
+func (m *Metrics) sampleEquity(s *Sim, ids ...string) {
+	for _, id := range ids {
+		m.EquitySeries[id] = append(m.EquitySeries[id], EquityPoint{
+			Time:   Duration(s.Now),
+			Equity: s.Agent(id).Equity(m.lastPrice),
+		})
+	}
+}
+
+// finish fills in the end-of-run totals.
+func (m *Metrics) finish(s *Sim) {
+	m.Elapsed = Duration(s.Now)
+	m.OrdersResting = len(s.Exchange.bids) + len(s.Exchange.asks)
+	m.PromisesPending = len(m.Trades) - m.PromisesKept - m.PromisesFailed
+	for _, agent := range s.agents {
+		m.Equity[agent.ID] = agent.Equity(m.lastPrice)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Duration is a time.Duration that is written in JSON as a Go
+// duration string such as "1.5s".  When reading, a bare number is
+// taken as seconds.
+type Duration time.Duration
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(buf []byte) error {
+	var v any
+	err := json.Unmarshal(buf, &v)
+	if err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case float64:
+		*d = Duration(v * float64(time.Second))
+	case string:
+		dur, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration(dur)
+	default:
+		return fmt.Errorf("invalid duration %s", buf)
+	}
+	return nil
+}
+
+// Scenario describes a reproducible market experiment: the agents and
+// their strategies, the network latency between them, any scripted
+// orders, and how long to run.  Scenarios are usually loaded from
+// JSON files with LoadScenario.
+type Scenario struct {
+	Name       string        `json:"name"`
+	Seed       int64         `json:"seed"`
+	Duration   Duration      `json:"duration"`
+	Settlement Duration      `json:"settlement"`
+	Latency    FixedLatency  `json:"latency"`
+	Agents     []AgentConfig `json:"agents"`
+	Orders     []OrderConfig `json:"orders"`
+}
+
+// AgentConfig describes one agent in a Scenario.  Currency defaults
+// to the agent's ID and Reliability defaults to 1.  Goods is the
+// agent's starting inventory; a seller can only deliver goods it
+// holds.
+type AgentConfig struct {
+	ID          string             `json:"id"`
+	Currency    string             `json:"currency"`
+	Reliability *float64           `json:"reliability"`
+	Goods       map[string]float64 `json:"goods"`
+	Strategy    string             `json:"strategy"`
+	Params      Params             `json:"params"`
+}
+
+// OrderConfig is an order that the scenario submits on an agent's
+// behalf at a fixed virtual time.
+type OrderConfig struct {
+	At      Duration `json:"at"`
+	From    string   `json:"from"`
+	OrderID string   `json:"order_id"`
+	Type    string   `json:"type"`
+	Amount  float64  `json:"amount"`
+	Symbol  string   `json:"symbol"`
+	Good    string   `json:"good"`
+	Qty     float64  `json:"qty"`
+	TTL     Duration `json:"ttl"`
+}
+
+// LoadScenario reads a Scenario from a JSON file.
+func LoadScenario(fn string) (*Scenario, error) {
+	buf, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	sc := &Scenario{}
+	err = json.Unmarshal(buf, sc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+	if sc.Name == "" {
+		sc.Name = fn
+	}
+	return sc, nil
+}
+
+// Build creates a Sim that is ready to Run.  Each call returns a
+// fresh simulation, so a Scenario can be run many times, for example
+// with WithSeed to sweep over seeds.
+func (sc *Scenario) Build() (*Sim, error) {
+	s := NewSim(sc.Seed, time.Duration(sc.Duration))
+	s.metrics.Scenario = sc.Name
+	s.SetLatency(sc.Latency)
+	s.SetSettlement(time.Duration(sc.Settlement))
+	for _, ac := range sc.Agents {
+		strategy, err := newStrategy(ac.Strategy, ac.Params)
+		if err != nil {
+			return nil, fmt.Errorf("agent %s: %w", ac.ID, err)
+		}
+		if _, idle := strategy.(Idle); !idle && sc.Duration <= 0 {
+			return nil, fmt.Errorf("agent %s: strategy %s needs a scenario duration",
+				ac.ID, ac.Strategy)
+		}
+		currency := ac.Currency
+		if currency == "" {
+			currency = ac.ID
+		}
+		agent := NewAgent(ac.ID, currency, strategy)
+		if ac.Reliability != nil {
+			agent.Reliability = *ac.Reliability
+		}
+		for good, qty := range ac.Goods {
+			agent.Goods[good] = qty
+		}
+		err = s.AddAgent(agent)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, oc := range sc.Orders {
+		agent := s.Agent(oc.From)
+		if agent == nil {
+			return nil, fmt.Errorf("order from unknown agent %s", oc.From)
+		}
+		order := Message{
+			OrderID:    oc.OrderID,
+			Type:       oc.Type,
+			Amount:     oc.Amount,
+			Symbol:     oc.Symbol,
+			GoodSymbol: oc.Good,
+			GoodQty:    oc.Qty,
+			TTL:        time.Duration(oc.TTL),
+		}
+		s.Schedule(time.Duration(oc.At), func() {
+			agent.SubmitOrder(s, order)
+		})
+	}
+	return s, nil
+}
+
+// WithSeed returns a copy of the scenario that uses a different seed.
+func (sc *Scenario) WithSeed(seed int64) *Scenario {
+	c := *sc
+	c.Seed = seed
+	return &c
+}
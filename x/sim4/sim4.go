@@ -0,0 +1,339 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+)
+
+// Sim is a deterministic discrete-event simulation of an open market.
+// Unlike sim2, sim3 and sim3.5, which run a single hand-scripted
+// scenario in wall-clock order, a Sim advances a virtual clock from
+// event to event.  Every source of nondeterminism -- agent strategy
+// decisions, network latency jitter, promise defaults -- draws from a
+// single seeded random number generator, so two runs of the same
+// Scenario with the same seed produce identical Metrics.
+type Sim struct {
+	// Now is the current virtual time, measured from the start of
+	// the simulation.
+	Now time.Duration
+	// Rand is the seeded random source shared by the whole
+	// simulation.  Strategies must use it rather than the global
+	// math/rand functions.
+	Rand *rand.Rand
+	// Exchange is the order-matching kernel.
+	Exchange *Kernel
+
+	agents     []*Agent
+	byID       map[string]*Agent
+	queue      eventQueue
+	seq        uint64
+	latency    Latency
+	settlement time.Duration
+	duration   time.Duration
+	metrics    *Metrics
+	orderSeq   int
+}
+
+// ExchangeID is the address agents use when sending orders to the
+// exchange kernel.  It is also the From field of CONFIRM messages.
+const ExchangeID = "Exchange"
+
+// event is a single entry in the simulation's event queue.  Events
+// scheduled for the same virtual time run in the order they were
+// scheduled, which keeps the simulation deterministic.
+type event struct {
+	at  time.Duration
+	seq uint64
+	fn  func()
+}
+
+// eventQueue is a min-heap of events ordered by time and then by
+// scheduling sequence.
+type eventQueue []*event
+
+func (q eventQueue) Len() int { return len(q) }
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+	return q[i].seq < q[j].seq
+}
+func (q eventQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *eventQueue) Push(x any)   { *q = append(*q, x.(*event)) }
+func (q *eventQueue) Pop() any {
+	old := *q
+	n := len(old)
+	ev := old[n-1]
+	*q = old[:n-1]
+	return ev
+}
+
+// NewSim creates an empty simulation seeded with seed.  Agents are
+// added with AddAgent and the simulation is started with Run.  A
+// duration of zero runs until the event queue is empty, so only Idle
+// agents may be added to such a simulation: the other strategies
+// reschedule themselves forever.
+func NewSim(seed int64, duration time.Duration) *Sim {
+	s := &Sim{
+		Rand:     rand.New(rand.NewSource(seed)),
+		byID:     make(map[string]*Agent),
+		latency:  FixedLatency{},
+		duration: duration,
+		metrics:  newMetrics(seed),
+	}
+	s.Exchange = NewKernel(s)
+	return s
+}
+
+// SetLatency replaces the simulation's latency model.  The default
+// model delivers every message instantly.
+func (s *Sim) SetLatency(l Latency) {
+	s.latency = l
+}
+
+// SetSettlement sets the delay between a trade and the seller's
+// delivery of the traded goods.  Each trade is a promise by the
+// seller to deliver; the promise is kept or broken when the
+// settlement delay expires.
+func (s *Sim) SetSettlement(d time.Duration) {
+	s.settlement = d
+}
+
+// AddAgent registers an agent with the simulation and the exchange.
+// It returns an error if the agent's ID or personal currency is
+// already in use, or if the agent is not Idle and the simulation has
+// no duration.
+func (s *Sim) AddAgent(agent *Agent) error {
+	if _, exists := s.byID[agent.ID]; exists || agent.ID == ExchangeID {
+		return fmt.Errorf("agent %s already registered", agent.ID)
+	}
+	if agent.Strategy == nil {
+		agent.Strategy = Idle{}
+	}
+	if _, idle := agent.Strategy.(Idle); !idle && s.duration <= 0 {
+		return fmt.Errorf("agent %s: strategy %T needs a simulation duration",
+			agent.ID, agent.Strategy)
+	}
+	err := s.Exchange.RegisterAgent(agent)
+	if err != nil {
+		return err
+	}
+	s.agents = append(s.agents, agent)
+	s.byID[agent.ID] = agent
+	return nil
+}
+
+// Agent returns the agent with the given ID, or nil.
+func (s *Sim) Agent(id string) *Agent {
+	return s.byID[id]
+}
+
+// Agents returns all agents in the order they were added.
+func (s *Sim) Agents() []*Agent {
+	return s.agents
+}
+
+// Schedule arranges for fn to run after delay units of virtual time.
+func (s *Sim) Schedule(delay time.Duration, fn func()) {
+	if delay < 0 {
+		delay = 0
+	}
+	s.seq++
+	heap.Push(&s.queue, &event{at: s.Now + delay, seq: s.seq, fn: fn})
+}
+
+// send delivers a message from one address to another after the
+// delay chosen by the latency model.
+func (s *Sim) send(from, to string, fn func()) {
+	s.Schedule(s.latency.Delay(s.Rand, from, to), fn)
+}
+
+// NextOrderID returns a unique order ID for the given agent.
+func (s *Sim) NextOrderID(agentID string) string {
+	s.orderSeq++
+	return fmt.Sprintf("%s-%d", agentID, s.orderSeq)
+}
+
+// Run starts every agent's strategy and then processes events in
+// virtual time order until the queue is empty or the configured
+// duration has elapsed.  It returns the metrics collected during the
+// run.
+func (s *Sim) Run() *Metrics {
+	for _, agent := range s.agents {
+		agent.Strategy.Start(s, agent)
+	}
+	for s.queue.Len() > 0 {
+		ev := heap.Pop(&s.queue).(*event)
+		if s.duration > 0 && ev.at > s.duration {
+			break
+		}
+		s.Now = ev.at
+		ev.fn()
+	}
+	s.metrics.finish(s)
+	return s.metrics
+}
+
+// Message represents an order or trade confirmation in the exchange.
+// It carries the same fields as the sim3.5 Message, plus an optional
+// time-to-live after which an unmatched order is withdrawn from the
+// order book.
+type Message struct {
+	OrderID    string        `json:"order_id"`
+	Type       string        `json:"type"`   // "BID", "ASK", or "CONFIRM"
+	Amount     float64       `json:"amount"` // Order amount or confirmed trade price
+	Symbol     string        `json:"symbol"` // Target personal currency (e.g., seller's currency)
+	From       string        `json:"from"`   // Agent ID that submitted the order (or "Exchange")
+	GoodSymbol string        `json:"good"`   // The good or service being exchanged
+	GoodQty    float64       `json:"qty"`    // Quantity of the good or service
+	TTL        time.Duration `json:"-"`
+}
+
+// String returns a string representation of the Message.
+func (m Message) String() string {
+	return fmt.Sprintf("OrderID: %s, Type: %s, Amount: %.2f, Symbol: %s, "+
+		"From: %s, GoodSymbol: %s, GoodQty: %.2f",
+		m.OrderID, m.Type, m.Amount, m.Symbol, m.From, m.GoodSymbol, m.GoodQty)
+}
+
+// Agent represents a market participant.  As in sim3.5, each agent
+// issues its own personal currency and keeps a double-entry balance
+// sheet.  Its behavior is supplied by a pluggable Strategy.
+type Agent struct {
+	ID               string
+	PersonalCurrency string
+	Assets           map[string]float64 // Ledger of assets by account name.
+	Liabilities      map[string]float64 // Ledger of liabilities by account name.
+	Goods            map[string]float64 // Ledger of goods and services.
+	// Reliability is the probability that the agent keeps a
+	// promise to deliver goods it has sold.
+	Reliability float64
+	Strategy    Strategy
+}
+
+// NewAgent creates an agent whose personal currency is currency.  The
+// agent starts with empty ledgers, perfect reliability and the given
+// strategy.
+func NewAgent(id, currency string, strategy Strategy) *Agent {
+	return &Agent{
+		ID:               id,
+		PersonalCurrency: currency,
+		Assets:           make(map[string]float64),
+		Liabilities:      make(map[string]float64),
+		Goods:            make(map[string]float64),
+		Reliability:      1.0,
+		Strategy:         strategy,
+	}
+}
+
+// SubmitOrder sends an order to the exchange.  The order arrives
+// after the latency between the agent and the exchange.  If the order
+// has no OrderID, a unique one is assigned and returned.
+func (a *Agent) SubmitOrder(s *Sim, order Message) string {
+	if order.OrderID == "" {
+		order.OrderID = s.NextOrderID(a.ID)
+	}
+	order.From = a.ID
+	s.metrics.OrdersSubmitted++
+	s.send(a.ID, ExchangeID, func() {
+		s.Exchange.SubmitOrder(order)
+	})
+	return order.OrderID
+}
+
+// Equity returns the agent's assets minus its liabilities, with goods
+// valued at the most recent trade price for each good.  Accounts are
+// summed in sorted order so the result is bit-for-bit reproducible.
+func (a *Agent) Equity(prices map[string]float64) float64 {
+	equity := 0.0
+	for _, acct := range sortedKeys(a.Assets) {
+		equity += a.Assets[acct]
+	}
+	for _, acct := range sortedKeys(a.Liabilities) {
+		equity -= a.Liabilities[acct]
+	}
+	for _, good := range sortedKeys(a.Goods) {
+		equity += a.Goods[good] * prices[good]
+	}
+	return equity
+}
+
+// sortedKeys returns the keys of m in sorted order.
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// RunSimulation runs the sim3.5 scenario on the discrete-event
+// harness: Alice bids for 10 units of Dave's personal currency and
+// Dave, who holds 10 units, asks for the same.  Bob and Carol are
+// idle.
+func RunSimulation() (*Sim, *Metrics) {
+	s := NewSim(1, 0)
+	for _, id := range []string{"Alice", "Bob", "Carol", "Dave"} {
+		err := s.AddAgent(NewAgent(id, id, Idle{}))
+		if err != nil {
+			panic(err)
+		}
+	}
+	alice := s.Agent("Alice")
+	dave := s.Agent("Dave")
+	dave.Goods["Dave"] = 10.0
+	alice.SubmitOrder(s, Message{
+		OrderID:    "BID1",
+		Type:       "BID",
+		Amount:     10.0,
+		Symbol:     "Dave",
+		GoodSymbol: "Dave",
+		GoodQty:    10.0,
+	})
+	dave.SubmitOrder(s, Message{
+		OrderID:    "ASK1",
+		Type:       "ASK",
+		Amount:     10.0,
+		Symbol:     "Dave",
+		GoodSymbol: "Dave",
+		GoodQty:    10.0,
+	})
+	return s, s.Run()
+}
+
+// main runs each scenario file named on the command line and prints
+// its metrics as JSON.  With no arguments it runs the built-in sim3.5
+// scenario.
+func main() {
+	var results []*Metrics
+	if len(os.Args) < 2 {
+		_, m := RunSimulation()
+		results = append(results, m)
+	}
+	for _, fn := range os.Args[1:] {
+		sc, err := LoadScenario(fn)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		s, err := sc.Build()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		results = append(results, s.Run())
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(results)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestSimulationTrade verifies that the sim3.5 scenario produces the
+// same balance sheets on the discrete-event harness: Alice's asset in
+// Dave's currency and Dave's asset in Alice's currency both increase
+// by 10, as do their liabilities in their own currencies.
+func TestSimulationTrade(t *testing.T) {
+	s, m := RunSimulation()
+	alice := s.Agent("Alice")
+	dave := s.Agent("Dave")
+
+	expectedValue := 10.0
+	checks := []struct {
+		name string
+		got  float64
+	}{
+		{"Alice asset Dave", alice.Assets["Dave"]},
+		{"Alice liability Alice", alice.Liabilities["Alice"]},
+		{"Dave asset Alice", dave.Assets["Alice"]},
+		{"Dave liability Dave", dave.Liabilities["Dave"]},
+		{"Alice goods Dave", alice.Goods["Dave"]},
+	}
+	for _, c := range checks {
+		if c.got != expectedValue {
+			t.Errorf("Expected %s to be %.2f, got %.2f", c.name, expectedValue, c.got)
+		}
+	}
+	if len(m.Trades) != 1 || m.Volume != expectedValue {
+		t.Errorf("Expected one trade of %.2f, got %d trades, volume %.2f",
+			expectedValue, len(m.Trades), m.Volume)
+	}
+	if m.PromisesKept != 1 || m.PromisesFailed != 0 {
+		t.Errorf("Expected one kept promise, got %d kept, %d failed",
+			m.PromisesKept, m.PromisesFailed)
+	}
+}
+
+// TestScenarioFile verifies that the sim3.5 scenario file reproduces
+// the built-in scenario.
+func TestScenarioFile(t *testing.T) {
+	sc, err := LoadScenario("testdata/sim3_5.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := sc.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := s.Run()
+	_, want := RunSimulation()
+	want.Scenario = got.Scenario
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("scenario file metrics differ from RunSimulation:\n%+v\n%+v", got, want)
+	}
+}
+
+// TestDeterminism verifies that runs with the same seed produce
+// identical metrics, down to the JSON encoding, and that a different
+// seed produces a different market.
+func TestDeterminism(t *testing.T) {
+	sc, err := LoadScenario("testdata/market.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func(sc *Scenario) []byte {
+		s, err := sc.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, err := json.Marshal(s.Run())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf
+	}
+	a := run(sc)
+	b := run(sc)
+	if string(a) != string(b) {
+		t.Fatalf("same seed produced different metrics")
+	}
+	c := run(sc.WithSeed(2))
+	if string(a) == string(c) {
+		t.Fatalf("different seeds produced identical metrics")
+	}
+}
+
+// TestExperiments runs the market scenario over many seeds, as a
+// market experiment would, and checks invariants that must hold in
+// every run: trades balance, every promise settles or is pending, and
+// the price series stays within the traders' spreads.
+func TestExperiments(t *testing.T) {
+	sc, err := LoadScenario("testdata/market.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 1000
+	if testing.Short() {
+		n = 50
+	}
+	var failed, trades int
+	for seed := int64(0); seed < int64(n); seed++ {
+		s, err := sc.WithSeed(seed).Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := s.Run()
+		if time.Duration(m.Elapsed) > time.Hour {
+			t.Fatalf("seed %d: ran past its duration: %v", seed, m.Elapsed)
+		}
+		kept, bad, pending := m.PromisesKept, m.PromisesFailed, m.PromisesPending
+		if kept+bad+pending != len(m.Trades) {
+			t.Fatalf("seed %d: promises do not add up", seed)
+		}
+		total := 0.0
+		for _, e := range m.Equity {
+			total += e
+		}
+		// Currency swaps and goods delivered from seller to buyer
+		// cancel out, so the market as a whole is worth Dave's
+		// starting inventory at the last price.
+		inventory := 0.0
+		if prices := m.Prices["widget"]; len(prices) > 0 {
+			inventory = 1000 * prices[len(prices)-1].Price
+		}
+		if d := total - inventory; d > 1e-6 || d < -1e-6 {
+			t.Fatalf("seed %d: total equity %f is not the inventory's %f", seed, total, inventory)
+		}
+		if dave := s.Agent("Dave"); dave.Goods["widget"] < 0 {
+			t.Fatalf("seed %d: Dave holds %f widgets", seed, dave.Goods["widget"])
+		}
+		// Trades execute at the bid price, which is never above
+		// Alice's highest bid or below Dave's lowest ask.
+		for _, p := range m.Prices["widget"] {
+			if p.Price < 10*0.8 || p.Price > 10*1.2 {
+				t.Fatalf("seed %d: price %f out of range", seed, p.Price)
+			}
+		}
+		failed += bad
+		trades += len(m.Trades)
+	}
+	if trades == 0 || failed == 0 {
+		t.Errorf("expected trades and failed promises across %d runs, got %d and %d",
+			n, trades, failed)
+	}
+}
+
+// TestLatency verifies that a slow link delays an agent's orders so
+// that a faster competitor wins the trade.
+func TestLatency(t *testing.T) {
+	s := NewSim(1, 0)
+	s.SetLatency(FixedLatency{
+		Base:  Duration(10 * time.Millisecond),
+		Links: map[string]Duration{"Alice>Exchange": Duration(time.Second)},
+	})
+	for _, id := range []string{"Alice", "Bob", "Dave"} {
+		err := s.AddAgent(NewAgent(id, id, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	bid := Message{Type: "BID", Amount: 10, Symbol: "Dave", GoodSymbol: "x", GoodQty: 1}
+	s.Agent("Alice").SubmitOrder(s, bid)
+	s.Agent("Bob").SubmitOrder(s, bid)
+	ask := bid
+	ask.Type = "ASK"
+	s.Agent("Dave").SubmitOrder(s, ask)
+	m := s.Run()
+	if len(m.Trades) != 1 || m.Trades[0].Buyer != "Bob" {
+		t.Fatalf("expected Bob to win the trade, got %+v", m.Trades)
+	}
+	if m.Trades[0].Time != Duration(10*time.Millisecond) {
+		t.Errorf("expected trade at 10ms, got %v", m.Trades[0].Time)
+	}
+	if m.OrdersResting != 1 {
+		t.Errorf("expected Alice's bid to rest in the book, got %d resting", m.OrdersResting)
+	}
+}
+
+// TestUnboundedStrategy verifies that a strategy that trades forever
+// cannot be added to a simulation without a duration, which would
+// never end.
+func TestUnboundedStrategy(t *testing.T) {
+	strategy, err := NewPeriodic(Params{Side: "BID", Symbol: "Dave", Good: "x", Qty: 1, Price: 1, Interval: Duration(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewSim(1, 0).AddAgent(NewAgent("Alice", "Alice", strategy)); err == nil {
+		t.Error("added a periodic agent to a simulation without a duration")
+	}
+	if err := NewSim(1, time.Hour).AddAgent(NewAgent("Alice", "Alice", strategy)); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestUndeliverable verifies that a seller who does not hold the goods
+// it sold breaks its promise rather than going short.
+func TestUndeliverable(t *testing.T) {
+	s := NewSim(1, 0)
+	for _, id := range []string{"Alice", "Dave"} {
+		err := s.AddAgent(NewAgent(id, id, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	dave := s.Agent("Dave")
+	dave.Goods["x"] = 1
+	order := Message{Type: "BID", Amount: 10, Symbol: "Dave", GoodSymbol: "x", GoodQty: 2}
+	s.Agent("Alice").SubmitOrder(s, order)
+	order.Type = "ASK"
+	dave.SubmitOrder(s, order)
+	m := s.Run()
+	if len(m.Trades) != 1 || m.PromisesFailed != 1 {
+		t.Fatalf("expected one trade with a broken promise, got %d trades, %d broken",
+			len(m.Trades), m.PromisesFailed)
+	}
+	if dave.Goods["x"] != 1 || s.Agent("Alice").Goods["x"] != 0 {
+		t.Errorf("goods moved: Dave %f, Alice %f", dave.Goods["x"], s.Agent("Alice").Goods["x"])
+	}
+}
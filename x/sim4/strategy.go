@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Strategy is the pluggable behavior of an agent.  Start is called
+// once when the simulation begins; a strategy that trades
+// continuously schedules its own wakeups with Sim.Schedule.  Confirm
+// is called when a CONFIRM message for one of the agent's orders
+// arrives.  Strategies must draw any randomness from Sim.Rand.
+type Strategy interface {
+	Start(s *Sim, agent *Agent)
+	Confirm(s *Sim, agent *Agent, msg Message)
+}
+
+// Params configures the built-in strategies.  Not every strategy uses
+// every field.
+type Params struct {
+	// Side is "BID", "ASK" or "BOTH".
+	Side string `json:"side"`
+	// Symbol is the personal currency a buyer bids for.  Sellers
+	// always ask in their own currency.
+	Symbol string `json:"symbol"`
+	// Good and Qty describe the good or service traded.
+	Good string  `json:"good"`
+	Qty  float64 `json:"qty"`
+	// Price is the reference price per unit of the good.
+	Price float64 `json:"price"`
+	// Spread is the maximum fractional deviation from Price.
+	Spread float64 `json:"spread"`
+	// Interval is the mean time between orders.
+	Interval Duration `json:"interval"`
+	// TTL is how long an unmatched order rests in the book.  Zero
+	// means forever.
+	TTL Duration `json:"ttl"`
+}
+
+// StrategyFunc builds a Strategy from its parameters.
+type StrategyFunc func(p Params) (Strategy, error)
+
+// strategies is the registry of strategies that scenario files can
+// refer to by name.
+var strategies = map[string]StrategyFunc{
+	"idle": func(p Params) (Strategy, error) {
+		return Idle{}, nil
+	},
+	"random": func(p Params) (Strategy, error) {
+		return NewRandom(p)
+	},
+	"periodic": func(p Params) (Strategy, error) {
+		return NewPeriodic(p)
+	},
+}
+
+// RegisterStrategy adds a named strategy to the registry so that
+// scenario files can use it.
+func RegisterStrategy(name string, fn StrategyFunc) {
+	strategies[name] = fn
+}
+
+// StrategyNames returns the names of all registered strategies in
+// sorted order.
+func StrategyNames() []string {
+	var names []string
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newStrategy looks up a strategy by name and builds it.
+func newStrategy(name string, p Params) (Strategy, error) {
+	if name == "" {
+		name = "idle"
+	}
+	fn, ok := strategies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown strategy %q", name)
+	}
+	return fn(p)
+}
+
+// Idle is a strategy that never submits orders of its own.  It is
+// used for agents whose orders are scripted by the scenario.
+type Idle struct{}
+
+// Start does nothing.
+func (Idle) Start(s *Sim, agent *Agent) {}
+
+// Confirm does nothing.
+func (Idle) Confirm(s *Sim, agent *Agent, msg Message) {}
+
+// checkParams validates the fields shared by the trading strategies.
+func checkParams(p Params) error {
+	switch p.Side {
+	case "BID", "ASK", "BOTH":
+	default:
+		return fmt.Errorf("side must be BID, ASK or BOTH, got %q", p.Side)
+	}
+	if p.Side != "ASK" && p.Symbol == "" {
+		return fmt.Errorf("a bidding strategy needs a symbol")
+	}
+	if p.Good == "" || p.Qty <= 0 {
+		return fmt.Errorf("strategy needs a good and a positive qty")
+	}
+	if p.Interval <= 0 {
+		return fmt.Errorf("strategy needs a positive interval")
+	}
+	return nil
+}
+
+// order builds an order of the given type for agent at unit price.
+func (p Params) order(agent *Agent, typ string, price float64) Message {
+	symbol := p.Symbol
+	if typ == "ASK" {
+		symbol = agent.PersonalCurrency
+	}
+	return Message{
+		Type:       typ,
+		Amount:     price * p.Qty,
+		Symbol:     symbol,
+		GoodSymbol: p.Good,
+		GoodQty:    p.Qty,
+		TTL:        time.Duration(p.TTL),
+	}
+}
+
+// Random is a zero-intelligence trader.  At exponentially distributed
+// intervals it submits a bid or ask priced uniformly within Spread of
+// the reference Price.
+type Random struct {
+	Params
+}
+
+// NewRandom creates a Random strategy.
+func NewRandom(p Params) (*Random, error) {
+	err := checkParams(p)
+	if err != nil {
+		return nil, err
+	}
+	return &Random{Params: p}, nil
+}
+
+// Start schedules the first order.
+func (r *Random) Start(s *Sim, agent *Agent) {
+	r.wake(s, agent)
+}
+
+// wake schedules the next order after a random delay.
+func (r *Random) wake(s *Sim, agent *Agent) {
+	delay := time.Duration(s.Rand.ExpFloat64() * float64(r.Interval))
+	s.Schedule(delay, func() {
+		typ := r.Side
+		if typ == "BOTH" {
+			typ = pick(s.Rand, "BID", "ASK")
+		}
+		price := r.Price * (1 + r.Spread*(2*s.Rand.Float64()-1))
+		agent.SubmitOrder(s, r.order(agent, typ, price))
+		r.wake(s, agent)
+	})
+}
+
+// Confirm does nothing; a zero-intelligence trader does not learn.
+func (r *Random) Confirm(s *Sim, agent *Agent, msg Message) {}
+
+// pick returns one of choices chosen uniformly at random.
+func pick(rng *rand.Rand, choices ...string) string {
+	return choices[rng.Intn(len(choices))]
+}
+
+// Periodic submits the same order at the reference price every
+// Interval.  With Side "BOTH" it alternates bids and asks.
+type Periodic struct {
+	Params
+	next string
+}
+
+// NewPeriodic creates a Periodic strategy.
+func NewPeriodic(p Params) (*Periodic, error) {
+	err := checkParams(p)
+	if err != nil {
+		return nil, err
+	}
+	next := p.Side
+	if next == "BOTH" {
+		next = "BID"
+	}
+	return &Periodic{Params: p, next: next}, nil
+}
+
+// Start schedules the first order one interval into the run.
+func (p *Periodic) Start(s *Sim, agent *Agent) {
+	s.Schedule(time.Duration(p.Interval), func() {
+		agent.SubmitOrder(s, p.order(agent, p.next, p.Price))
+		if p.Side == "BOTH" {
+			p.next = map[string]string{"BID": "ASK", "ASK": "BID"}[p.next]
+		}
+		p.Start(s, agent)
+	})
+}
+
+// Confirm does nothing.
+func (p *Periodic) Confirm(s *Sim, agent *Agent, msg Message) {}
+
+// Latency models the network delay of a message between two
+// addresses.  Implementations must draw any randomness from rng.
+type Latency interface {
+	Delay(rng *rand.Rand, from, to string) time.Duration
+}
+
+// FixedLatency delivers every message after Base plus a uniformly
+// distributed jitter of up to Jitter.  Links overrides the base delay
+// for individual "from>to" pairs.
+type FixedLatency struct {
+	Base   Duration            `json:"base"`
+	Jitter Duration            `json:"jitter"`
+	Links  map[string]Duration `json:"links"`
+}
+
+// Delay returns the delay for a message from one address to another.
+func (l FixedLatency) Delay(rng *rand.Rand, from, to string) time.Duration {
+	d := time.Duration(l.Base)
+	if link, ok := l.Links[from+">"+to]; ok {
+		d = time.Duration(link)
+	}
+	if l.Jitter > 0 {
+		d += time.Duration(rng.Int63n(int64(l.Jitter)))
+	}
+	return d
+}